@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// models the JSON body for POST /accounts, currency defaults to USD and
+// type to checking
+type createAccountRequest struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Balance  Money  `json:"balance"`
+	Currency string `json:"currency"`
+}
+
+// models the JSON response for GET /accounts
+type accountPage struct {
+	Accounts   []Account `json:"accounts"`
+	Total      int       `json:"total"`
+	NextCursor *string   `json:"next_cursor"`
+}
+
+// handles GET /accounts listing accounts ordered by id unless sorted
+// otherwise, a page at a time (see query.go), admin only
+func listAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r.Context()) {
+		forbidden(w)
+		return
+	}
+	lq, err := parseListQuery(r, accountList)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	list, err := storeFor(r.Context()).All()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not list accounts")
+		return
+	}
+	page := pageOf(list, lq, accountList)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(accountPage{page.items, page.total, page.nextCursor})
+}
+
+// handles POST /accounts opening an account, admin only. the initial
+// balance enters the ledger as money coming from outside the system
+func createAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r.Context()) {
+		forbidden(w)
+		return
+	}
+	var req createAccountRequest
+	if !decodeBody(w, r, "CreateAccountRequest", &req) {
+		return
+	}
+	if err := checkAccountID(req.ID); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if req.Balance < 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidAmount, "balance must not be negative")
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = defaultCurrency
+	}
+	if !validCurrency(req.Currency) {
+		writeError(w, http.StatusBadRequest, codeInvalidCurrency, "currency must be a 3 letter ISO 4217 code")
+		return
+	}
+
+	acct := Account{ID: req.ID, Type: accountType(req.Type), Balance: req.Balance, Currency: req.Currency, Version: 1}
+	create := func(s Store) error { return s.Create(acct) }
+	var err error
+	if req.Balance > 0 {
+		// the opening balance came in from outside, booked with the account
+		_, err = book(r.Context(), create, ledgerEntry{To: acct.ID, Amount: acct.Balance, Currency: acct.Currency, Status: statusCompleted})
+	} else {
+		err = create(storeFor(r.Context()))
+	}
+	if errors.Is(err, ErrAccountExists) {
+		writeError(w, http.StatusConflict, codeAccountExists, "account already exists")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not create account")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(acct.Version))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(acct)
+}
+
+// handles GET /accounts/{account}, the account with its type and status.
+// closed accounts are kept and still shown, status closed
+func accountHandler(w http.ResponseWriter, r *http.Request) {
+	acct, err := getBalance(r.Context(), r.PathValue("account"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if notModified(r, acct.Version) {
+		w.Header().Set("ETag", etag(acct.Version))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeAccount(w, acct)
+}
+
+// answers with acct and its ETag, for handlers changing an account
+func writeAccount(w http.ResponseWriter, acct Account) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(acct.Version))
+	json.NewEncoder(w).Encode(acct)
+}
+
+// handles DELETE /accounts/{account}, admin only. closing is refused
+// while money is still on it. the account and its history stay, marked
+// closed, so nothing can be paid in or out of it again
+func closeAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r.Context()) {
+		forbidden(w)
+		return
+	}
+	err := storeFor(r.Context()).Delete(r.PathValue("account"))
+	switch {
+	case errors.Is(err, ErrAccountNotFound):
+		writeError(w, http.StatusNotFound, codeAccountNotFound, "account not found")
+	case errors.Is(err, ErrNonZeroBalance):
+		writeError(w, http.StatusConflict, codeAccountNotEmpty, "account balance must be zero to close")
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not close account")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccountLifecycle(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100)})
+
+	steps := []struct {
+		method, path, body string
+		code               int
+	}{
+		{"POST", "/v1/accounts", `{"id":"carol","balance":25}`, http.StatusCreated},
+		{"POST", "/v1/accounts", `{"id":"carol","balance":5}`, http.StatusConflict},
+		{"POST", "/v1/accounts", `{"id":"dave","balance":-1}`, http.StatusBadRequest},
+		{"POST", "/v1/accounts", `{"id":"erin"}`, http.StatusCreated},
+		{"DELETE", "/v1/accounts/alice", "", http.StatusConflict},
+		{"DELETE", "/v1/accounts/dave", "", http.StatusNotFound},
+		{"DELETE", "/v1/accounts/erin", "", http.StatusNoContent},
+		{"DELETE", "/v1/accounts/erin", "", http.StatusNoContent},
+		// the id stays taken and nothing moves in or out any more
+		{"POST", "/v1/accounts", `{"id":"erin"}`, http.StatusConflict},
+		{"POST", "/v1/transfer", `{"from":"alice","to":"erin","amount":1}`, http.StatusUnprocessableEntity},
+		{"POST", "/v1/accounts/erin/deposit", `{"amount":1}`, http.StatusUnprocessableEntity},
+		{"POST", "/v1/accounts/erin/freeze", "", http.StatusUnprocessableEntity},
+	}
+	for _, s := range steps {
+		req := httptest.NewRequest(s.method, s.path, strings.NewReader(s.body))
+		w := httptest.NewRecorder()
+		serveAPI(w, req)
+		if w.Code != s.code {
+			t.Fatalf("%s %s %s: expected %d, got %d", s.method, s.path, s.body, s.code, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/accounts", nil))
+	var got struct{ Accounts []Account }
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []Account{
+		{"alice", units(100), "USD", 1, accountActive, 0, 0, accountChecking},
+		{"carol", units(25), "USD", 1, accountActive, 0, 0, accountChecking},
+		{"erin", 0, "USD", 2, accountClosed, 0, 0, accountChecking},
+	}
+	if len(got.Accounts) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got.Accounts)
+	}
+	for i := range want {
+		if got.Accounts[i] != want[i] {
+			t.Errorf("expected %v, got %v", want[i], got.Accounts[i])
+		}
+	}
+}
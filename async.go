@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// POST /transfer?async=true accepts a transfer once it passes the checks
+// a synchronous one does and answers 202 with its ledger id. the pending
+// entry is the outbox: the stores keeping the ledger keep it too, so an
+// accepted transfer outlives a restart and is made when the worker gets
+// to it. GET /transactions/{id} tells how it went
+
+// wakes the outbox worker when a transfer was accepted
+var outboxWake = make(chan struct{}, 1)
+
+// records req as pending for the worker to make. fails like
+// transferFunds does for a transfer that can't go through as asked, the
+// risk checks run now too, a parked transfer waits for its review
+// instead of the worker
+func acceptTransfer(ctx context.Context, req transferRequest) (ledgerEntry, error) {
+	transfersAttempted.Inc()
+	currency, err := checkTransfer(ctx, req)
+	if err != nil {
+		return ledgerEntry{}, err
+	}
+	if f := assessRisk(ctx, req); f != nil {
+		return flagTransfer(ctx, req, currency, f)
+	}
+	e, err := record(ctx, ledgerEntry{From: req.From, To: req.To, Amount: req.Amount, Currency: currency, Status: statusPending})
+	if err != nil {
+		transfersFailed.WithLabelValues(reasonInternal).Inc()
+		return ledgerEntry{}, failure(codeInternal, "could not accept transfer")
+	}
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+	return e, nil
+}
+
+// the entries still waiting for the worker, oldest first
+func pendingEntries() []ledgerEntry {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+	var out []ledgerEntry
+	for _, e := range ledger {
+		if e.Status == statusPending {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// makes every pending transfer in the order they were accepted. each is
+// marked processing, and that persisted, before its money moves so a
+// crash mid-way never makes it twice. if marking fails it stays pending
+// for the next round. a transfer that goes through is completed in the
+// same write as its money moves
+func runPendingTransfers() {
+	for _, e := range pendingEntries() {
+		tenant := e.tenant()
+		e = e.local()
+		// each transfer starts a trace of its own, its request is long gone
+		ctx, span := tracer.Start(scheduleContext(tenant, e.From), "async transfer",
+			trace.WithAttributes(attribute.Int64("transaction.id", e.ID)))
+		stuck := false
+		auditWork(ctx, "async_transfer", fmt.Sprintf("%s/transactions/%d", apiPrefix, e.ID), func(ctx context.Context) error {
+			if _, err := updateEntry(ctx, e.ID, statusProcessing, ""); err != nil {
+				stuck = true
+				return err
+			}
+			req := transferRequest{From: e.From, To: e.To, Amount: e.Amount, Currency: e.Currency}
+			currency, err := checkTransfer(ctx, req)
+			if err == nil {
+				// the outcome goes into e rather than an entry of its own
+				_, err = runTransfer(ctx, req, currency, e.ID)
+			}
+			if err != nil {
+				updateEntry(ctx, e.ID, statusFailed, err.Error())
+			}
+			return err
+		})
+		span.End()
+		if stuck {
+			return
+		}
+	}
+}
+
+// fails every entry of list a restart interrupted while processing, and
+// returns list with them failed. a transfer is completed in the write
+// moving its money, so one still processing never moved any. called
+// with bookMu held as the ledger is loaded
+func failInterrupted(ls ledgerStore, list []ledgerEntry) ([]ledgerEntry, error) {
+	var failed []ledgerEntry
+	for _, e := range list {
+		if e.Status == statusProcessing {
+			e.Status, e.Error = statusFailed, "interrupted by a restart before its money moved"
+			failed = append(failed, e)
+		}
+	}
+	if len(failed) == 0 {
+		return list, nil
+	}
+	failed, err := ls.Book(context.Background(), nil, failed)
+	if err != nil {
+		return nil, fmt.Errorf("fail interrupted transfers: %w", err)
+	}
+	for _, e := range failed {
+		list[e.ID-1] = e
+	}
+	return list, nil
+}
+
+// makes pending transfers as they are accepted, and every interval in
+// case a round gave up early, until ctx is cancelled. whatever was left
+// pending at startup goes first
+func runOutbox(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		runPendingTransfers()
+		select {
+		case <-ctx.Done():
+			return
+		case <-outboxWake:
+		case <-t.C:
+		}
+	}
+}
+
+// answers POST /transfer?async=true
+func acceptAsyncTransfer(w http.ResponseWriter, r *http.Request, req transferRequest) {
+	if req.Version != 0 {
+		// by the time it runs the account has moved on anyway
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "If-Match can't be used with async transfers")
+		return
+	}
+	e, err := acceptTransfer(r.Context(), req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.Header().Set("Location", apiPrefix+"/transactions/"+strconv.FormatInt(e.ID, 10))
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, `{"status": "%s", "id": %d}`, e.Status, e.ID)
+}
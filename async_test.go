@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sends body to POST /v1/transfer?async=true
+func postAsync(body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/transfer?async=true", strings.NewReader(body)))
+	return w
+}
+
+// accepts an async transfer and returns its ledger id
+func asyncID(t *testing.T, body string) int64 {
+	t.Helper()
+	w := postAsync(body)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Status string
+		ID     int64
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Status != statusPending || w.Header().Get("Location") != "/v1/transactions/"+strconv.FormatInt(resp.ID, 10) {
+		t.Errorf("expected a pending transfer and its location, got %+v %q", resp, w.Header().Get("Location"))
+	}
+	return resp.ID
+}
+
+// reads GET /v1/transactions/{id}
+func getTransaction(t *testing.T, id int64) ledgerEntry {
+	t.Helper()
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/transactions/"+strconv.FormatInt(id, 10), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body)
+	}
+	var e ledgerEntry
+	json.NewDecoder(w.Body).Decode(&e)
+	return e
+}
+
+func TestAsyncTransfer(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+
+	ok := asyncID(t, `{"from":"alice","to":"bob","amount":40}`)
+	short := asyncID(t, `{"from":"alice","to":"bob","amount":70}`)
+	if e := getTransaction(t, ok); e.Status != statusPending || len(e.Postings) != 0 {
+		t.Errorf("expected a pending entry without postings, got %+v", e)
+	}
+	if a := balance(t, "alice"); a != units(100) {
+		t.Errorf("expected nothing to move before the worker ran, alice has %v", a)
+	}
+
+	runPendingTransfers()
+	if e := getTransaction(t, ok); e.Status != statusCompleted || len(e.Postings) != 2 {
+		t.Errorf("expected the first transfer completed, got %+v", e)
+	}
+	if e := getTransaction(t, short); e.Status != statusFailed || e.Error != "insufficient funds" {
+		t.Errorf("expected the second to fail for lack of funds, got %+v", e)
+	}
+	if a, b := balance(t, "alice"), balance(t, "bob"); a != units(60) || b != units(40) {
+		t.Errorf("expected alice=60 bob=40, got %v %v", a, b)
+	}
+	if n := len(entries(t.Context(), "")); n != 2 {
+		t.Errorf("expected each transfer to stay a single entry, got %d", n)
+	}
+	if tb := trialBalanceOf(t); !tb.Balanced {
+		t.Errorf("expected the ledger to balance, got %+v", tb)
+	}
+
+	// refused up front like a synchronous transfer
+	if w := postAsync(`{"from":"alice","to":"cash:USD","amount":1}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a reserved recipient to be refused, got %d", w.Code)
+	}
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/transfer?async=maybe", strings.NewReader(`{"from":"alice","to":"bob","amount":1}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected a bad async flag to be refused, got %d", w.Code)
+	}
+	req := httptest.NewRequest("POST", "/v1/transfer?async=true", strings.NewReader(`{"from":"alice","to":"bob","amount":1}`))
+	req.Header.Set("If-Match", etag(1))
+	w = httptest.NewRecorder()
+	serveAPI(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected If-Match with async to be refused, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/transactions/99", nil))
+	if w.Code != http.StatusNotFound || decodeError(t, w).Code != codeTransactionNotFound {
+		t.Errorf("expected 404 for an unknown transaction, got %d", w.Code)
+	}
+}
+
+func TestAsyncTransferWorker(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runOutbox(ctx, time.Hour)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	id := asyncID(t, `{"from":"alice","to":"bob","amount":10}`)
+	deadline := time.Now().Add(5 * time.Second)
+	for getTransaction(t, id).Status != statusCompleted {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the worker to make the transfer, got %+v", getTransaction(t, id))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// the ledger is the outbox, what was accepted is made after a restart
+func TestAsyncTransferSurvivesRestart(t *testing.T) {
+	defer func() { store = newMemoryStore(seedBalances()) }()
+	for _, kind := range []string{"sqlite", "eventlog"} {
+		t.Run(kind, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), kind)
+			s, err := openStore(kind, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store = s
+			if err := resetLedger(); err != nil {
+				t.Fatal(err)
+			}
+			pending := asyncID(t, `{"from":"alice","to":"bob","amount":30}`)
+			interrupted := asyncID(t, `{"from":"alice","to":"bob","amount":5}`)
+			// as if the process died while this one was being made
+			updateEntry(context.Background(), interrupted, statusProcessing, "")
+			closeStore()
+
+			ledgerMu.Lock()
+			ledger, openedAt = nil, time.Time{}
+			ledgerMu.Unlock()
+			s, err = openStore(kind, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store = s
+			defer closeStore()
+			if err := loadLedger(); err != nil {
+				t.Fatal(err)
+			}
+
+			if e, _ := entryByID(t.Context(), interrupted); e.Status != statusFailed || e.Error == "" {
+				t.Errorf("expected the interrupted transfer failed with a reason, got %+v", e)
+			}
+			runPendingTransfers()
+			if e, _ := entryByID(t.Context(), pending); e.Status != statusCompleted {
+				t.Errorf("expected the pending transfer to be made after the restart, got %+v", e)
+			}
+			if a := balance(t, "alice"); a != units(70) {
+				t.Errorf("expected alice at 70, has %v", a)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// goes through observe too, the action audited is the route it names
+func callAs(key, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	observe(newRouter()).ServeHTTP(w, req)
+	return w
+}
+
+func TestAuditLog(t *testing.T) {
+	keys, _ := parseAPIKeys("k-ops=ops:admin;k-alice=alice:user:alice")
+	apiKeys = keys
+	defer func() { apiKeys = nil }()
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	resetAudit()
+	defer resetAudit()
+
+	if w := callAs("k-alice", "POST", "/v1/transfer", `{"from":"alice","to":"bob","amount":10}`); w.Code != http.StatusOK {
+		t.Fatalf("transfer: %d %s", w.Code, w.Body)
+	}
+	callAs("k-ops", "POST", "/v1/accounts/bob/freeze", "")
+	// refused, still audited
+	callAs("k-alice", "POST", "/v1/transfer", `{"from":"alice","to":"bob","amount":500}`)
+	callAs("k-alice", "GET", "/v1/accounts/alice/balance", "")
+
+	get := func(path string, out any) {
+		t.Helper()
+		w := callAs("k-ops", "GET", path, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d %s", path, w.Code, w.Body)
+		}
+		if err := json.NewDecoder(w.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var page auditPage
+	get("/v1/admin/audit", &page)
+	if page.Total != 3 {
+		t.Fatalf("expected the 3 calls changing something, got %+v", page)
+	}
+	first := page.Entries[0]
+	if first.Caller != "alice" || first.Action != "transfer" || first.Status != http.StatusOK || first.PrevHash != "" || len(first.Balances) != 2 {
+		t.Errorf("unexpected first entry %+v", first)
+	}
+	for _, b := range first.Balances {
+		before, after := map[string]Money{"alice": units(100), "bob": 0}[b.Account], map[string]Money{"alice": units(90), "bob": units(10)}[b.Account]
+		if b.Before == nil || b.After == nil || *b.Before != before || *b.After != after {
+			t.Errorf("%s: expected %v before and %v after, got %v %v", b.Account, before, after, b.Before, b.After)
+		}
+	}
+	if e := page.Entries[1]; e.Caller != "ops" || e.Action != "freeze" || e.PrevHash != first.Hash {
+		t.Errorf("expected ops' freeze chained onto the transfer, got %+v", e)
+	}
+	if e := page.Entries[2]; e.Status != http.StatusUnprocessableEntity || e.moved() != 0 || e.RequestID == "" {
+		t.Errorf("expected the refused transfer with no balance changed, got %+v", e)
+	}
+
+	get("/v1/admin/audit?caller=ops", &page)
+	if page.Total != 1 || page.Entries[0].Action != "freeze" {
+		t.Errorf("expected the caller filter to find the freeze, got %+v", page)
+	}
+	get("/v1/admin/audit?account=alice&min_amount=5", &page)
+	if page.Total != 1 || page.Entries[0].Seq != 1 {
+		t.Errorf("expected only the transfer to have moved alice's money, got %+v", page)
+	}
+	if w := callAs("k-alice", "GET", "/v1/admin/audit", ""); w.Code != http.StatusForbidden {
+		t.Errorf("expected the audit log closed to users, got %d", w.Code)
+	}
+
+	var v auditVerification
+	get("/v1/admin/audit/verify", &v)
+	if !v.Valid || v.Entries != 3 || v.Head == "" {
+		t.Errorf("expected an intact chain, got %+v", v)
+	}
+	auditMu.Lock()
+	*auditLog[1].Balances[0].After = units(1000)
+	auditMu.Unlock()
+	get("/v1/admin/audit/verify", &v)
+	if v.Valid || v.BrokenAt != 2 {
+		t.Errorf("expected the changed entry found, got %+v", v)
+	}
+}
+
+func TestAuditChain(t *testing.T) {
+	resetAudit()
+	defer resetAudit()
+	for _, action := range []string{"a", "b", "c"} {
+		appendAudit(auditEntry{Action: action, Balances: []auditBalance{}})
+	}
+	list := func() []auditEntry {
+		return append([]auditEntry(nil), auditLog...)
+	}
+	if v := verifyAudit(list()); !v.Valid || v.Head != auditLog[2].Hash {
+		t.Fatalf("expected an intact chain, got %+v", v)
+	}
+	dropped := list()
+	dropped = append(dropped[:1], dropped[2:]...)
+	if v := verifyAudit(dropped); v.Valid || v.BrokenAt != 2 {
+		t.Errorf("expected a dropped entry found, got %+v", v)
+	}
+	// rehashing the changed entry doesn't help, the next one points at
+	// the old hash
+	changed := list()
+	changed[0].Caller = "mallory"
+	changed[0].Hash = changed[0].digest()
+	if v := verifyAudit(changed); v.Valid || v.BrokenAt != 2 {
+		t.Errorf("expected a rehashed entry found, got %+v", v)
+	}
+}
+
+func TestAuditLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	resetAudit()
+	defer resetAudit()
+	if err := loadAudit(path); err != nil {
+		t.Fatal(err)
+	}
+	appendAudit(auditEntry{Action: "a", Balances: []auditBalance{}})
+	appendAudit(auditEntry{Action: "b", Balances: []auditBalance{}})
+	if auditSynced != 2 {
+		t.Errorf("expected both entries fsynced, synced up to %d", auditSynced)
+	}
+	resetAudit()
+
+	if err := loadAudit(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(auditLog) != 2 || !verifyAudit(auditLog).Valid {
+		t.Fatalf("expected both entries read back intact, got %+v", auditLog)
+	}
+	// appending goes on from where the file ended
+	appendAudit(auditEntry{Action: "c", Balances: []auditBalance{}})
+	resetAudit()
+
+	b, _ := os.ReadFile(path)
+	os.WriteFile(path, []byte(strings.Replace(string(b), `"action":"b"`, `"action":"x"`, 1)), 0o644)
+	if err := loadAudit(path); err != nil {
+		t.Fatal(err)
+	}
+	if v := verifyAudit(auditLog); v.Valid || v.Entries != 3 || v.BrokenAt != 2 {
+		t.Errorf("expected the edited line found, got %+v", v)
+	}
+}
+
+// the workers' changes are audited like the calls', as the system
+func TestAuditWorkers(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	resetAudit()
+	defer resetAudit()
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return start }
+	defer func() { now = time.Now }()
+
+	asyncID(t, `{"from":"alice","to":"bob","amount":40}`)
+	asyncID(t, `{"from":"alice","to":"bob","amount":500}`)
+	h := placeTestHold(t, `{"from":"alice","to":"bob","amount":10,"ttl":"1h"}`)
+	runPendingTransfers()
+	now = func() time.Time { return start.Add(2 * time.Hour) }
+	expireHolds(context.Background())
+
+	var work []auditEntry
+	for _, e := range auditLog {
+		if e.Method == "WORKER" {
+			work = append(work, e)
+		}
+	}
+	if len(work) != 3 {
+		t.Fatalf("expected 2 async transfers and an expired hold audited, got %+v", work)
+	}
+	moved := work[0]
+	if moved.Caller != "system" || moved.Action != "async_transfer" || moved.Path != "/v1/transactions/1" || moved.Error != "" || len(moved.Balances) != 2 {
+		t.Errorf("unexpected async transfer entry %+v", moved)
+	}
+	for _, b := range moved.Balances {
+		before, after := map[string]Money{"alice": units(100), "bob": 0}[b.Account], map[string]Money{"alice": units(60), "bob": units(40)}[b.Account]
+		if b.Before == nil || b.After == nil || *b.Before != before || *b.After != after {
+			t.Errorf("%s: expected %v before and %v after, got %v %v", b.Account, before, after, b.Before, b.After)
+		}
+	}
+	if e := work[1]; e.Path != "/v1/transactions/2" || e.Error != "insufficient funds" || e.moved() != 0 {
+		t.Errorf("expected the failed async transfer with its error, got %+v", e)
+	}
+	if e := work[2]; e.Action != "expire_hold" || e.Path != "/v1/holds/"+h.ID || e.Error != "" {
+		t.Errorf("expected the hold's expiry, got %+v", e)
+	}
+	if !verifyAudit(auditLog).Valid {
+		t.Error("expected the workers' entries chained with the rest")
+	}
+}
+
+// concurrent transfers off one account each see the balance the one
+// before left
+func TestAuditConcurrentBalances(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return newMemoryStore(map[string]Money{"alice": units(100), "bob": 0}) },
+		"eventlog": func(t *testing.T) Store {
+			s, err := openEventStore(filepath.Join(t.TempDir(), "events.jsonl"), map[string]Money{"alice": units(100), "bob": 0})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+	}
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			store = open(t)
+			resetLedger()
+			resetAudit()
+			defer resetAudit()
+
+			var wg sync.WaitGroup
+			for range 8 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range 5 {
+						w := httptest.NewRecorder()
+						serveAPI(w, httptest.NewRequest("POST", "/v1/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":1}`)))
+						if w.Code != http.StatusOK {
+							t.Errorf("transfer: %d %s", w.Code, w.Body)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			if len(auditLog) != 40 {
+				t.Fatalf("expected 40 entries, got %d", len(auditLog))
+			}
+			seen := map[Money]bool{}
+			for _, e := range auditLog {
+				for _, b := range e.Balances {
+					if b.Before == nil || b.After == nil || *b.After-*b.Before != map[string]Money{"alice": -units(1), "bob": units(1)}[b.Account] {
+						t.Fatalf("entry %d: expected %s moved by 1, got %v to %v", e.Seq, b.Account, b.Before, b.After)
+					}
+					if b.Account == "alice" {
+						seen[*b.Before] = true
+					}
+				}
+			}
+			if len(seen) != 40 {
+				t.Errorf("expected each transfer to start from a balance of its own, got %d", len(seen))
+			}
+		})
+	}
+}
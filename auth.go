@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// the caller an API key belongs to. admins can read every account,
+// everyone can only move money out of the accounts they own. a key of a
+// tenant only sees that tenant's accounts, see tenant.go
+type principal struct {
+	name     string
+	admin    bool
+	accounts map[string]bool
+	tenant   string
+}
+
+// API keys by their SHA-256 so lookups don't compare raw secrets, loaded
+// from API_KEYS at startup. nil leaves the API open as before
+var apiKeys map[[sha256.Size]byte]*principal
+
+type principalKey struct{}
+
+// parses API_KEYS, a ; separated list of key=name:role[:account,...]
+// entries, e.g. "k1=ops:admin;k2=alice:user:alice,savings". a name like
+// acme/alice puts the key in tenant acme, its accounts are acme's
+func parseAPIKeys(s string) (map[[sha256.Size]byte]*principal, error) {
+	keys := make(map[[sha256.Size]byte]*principal)
+	err := parseCallers(s, func(key string, p *principal) {
+		keys[sha256.Sum256([]byte(key))] = p
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// calls add for every id=name:role[:account,...] entry of the ; separated
+// list s, API_KEYS and CLIENT_CERTS both look like that
+func parseCallers(s string, add func(id string, p *principal)) error {
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, spec, ok := strings.Cut(entry, "=")
+		parts := strings.Split(spec, ":")
+		if !ok || id == "" || len(parts) < 2 || parts[0] == "" {
+			return fmt.Errorf("malformed entry %q", entry)
+		}
+		p := &principal{name: parts[0], accounts: make(map[string]bool)}
+		if tenant, _, ok := strings.Cut(p.name, "/"); ok {
+			if !tenantPattern.MatchString(tenant) {
+				return fmt.Errorf("invalid tenant %q for %s", tenant, p.name)
+			}
+			p.tenant = tenant
+		}
+		switch parts[1] {
+		case "admin":
+			p.admin = true
+		case "user":
+		default:
+			return fmt.Errorf("unknown role %q for %s", parts[1], p.name)
+		}
+		if len(parts) > 2 {
+			for _, acct := range strings.Split(parts[2], ",") {
+				p.accounts[acct] = true
+			}
+		}
+		add(id, p)
+	}
+	return nil
+}
+
+// reports whether callers have to say who they are, with an API key or
+// a client certificate. until then the API is open
+func authEnabled() bool {
+	return apiKeys != nil || clientCerts != nil
+}
+
+// wraps a handler so it only runs for requests carrying a known API key
+// as "Authorization: Bearer <key>" or "X-API-Key: <key>", or without any
+// over a client certificate CLIENT_CERTS knows, acting in the tenant of
+// the caller or the one X-Tenant-ID asks for
+func authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authEnabled() {
+			scopeTenant(w, r, next)
+			return
+		}
+		key := r.Header.Get("X-API-Key")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			key = bearer
+		}
+		p, ok := lookupKey(key)
+		if !ok && key == "" {
+			p, ok = lookupCert(r.TLS)
+		}
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "missing or invalid API key")
+			return
+		}
+		scopeTenant(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)), next)
+	}
+}
+
+// returns the authenticated caller, nil when authentication is disabled
+func caller(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	return p
+}
+
+// name of the caller for logs, "" when authentication is off
+func callerName(ctx context.Context) string {
+	if p := caller(ctx); p != nil {
+		return p.name
+	}
+	return ""
+}
+
+// looks up the principal an API key belongs to, the HTTP and gRPC
+// servers read the key from different places
+func lookupKey(key string) (*principal, bool) {
+	p, ok := apiKeys[sha256.Sum256([]byte(key))]
+	return p, ok && key != ""
+}
+
+// reports whether the caller may see account's balance and history
+func mayRead(ctx context.Context, account string) bool {
+	p := caller(ctx)
+	return !authEnabled() || p != nil && (p.admin || p.accounts[account])
+}
+
+// reports whether the caller may move money out of account, being an
+// admin is not enough for that
+func mayDebit(ctx context.Context, account string) bool {
+	p := caller(ctx)
+	return !authEnabled() || p != nil && p.accounts[account]
+}
+
+// reports whether the caller has the admin role
+func isAdmin(ctx context.Context) bool {
+	p := caller(ctx)
+	return !authEnabled() || p != nil && p.admin
+}
+
+func forbidden(w http.ResponseWriter) {
+	writeError(w, http.StatusForbidden, codeForbidden, "forbidden")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthentication(t *testing.T) {
+	keys, err := parseAPIKeys("k-ops=ops:admin; k-alice=alice:user:alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKeys = keys
+	defer func() { apiKeys = nil }()
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": units(50)})
+
+	transfer := authenticate(transferHandler)
+	balanceOf := http.HandlerFunc(serveAPI)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		path    string
+		body    string
+		key     string
+		code    int
+	}{
+		{"no key", transfer, "POST", "/transfer", `{"from":"alice","to":"bob","amount":1}`, "", http.StatusUnauthorized},
+		{"unknown key", transfer, "POST", "/transfer", `{"from":"alice","to":"bob","amount":1}`, "nope", http.StatusUnauthorized},
+		{"owner debits", transfer, "POST", "/transfer", `{"from":"alice","to":"bob","amount":1}`, "k-alice", http.StatusOK},
+		{"debit someone else", transfer, "POST", "/transfer", `{"from":"bob","to":"alice","amount":1}`, "k-alice", http.StatusForbidden},
+		{"admin can't debit", transfer, "POST", "/transfer", `{"from":"bob","to":"alice","amount":1}`, "k-ops", http.StatusForbidden},
+		{"owner reads", balanceOf, "GET", "/v1/accounts/alice/balance", "", "k-alice", http.StatusOK},
+		{"read someone else", balanceOf, "GET", "/v1/accounts/bob/balance", "", "k-alice", http.StatusForbidden},
+		{"admin reads any", balanceOf, "GET", "/v1/accounts/bob/balance", "", "k-ops", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.key != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+			w := httptest.NewRecorder()
+			tt.handler(w, req)
+			if w.Code != tt.code {
+				t.Errorf("expected %d, got %d", tt.code, w.Code)
+			}
+		})
+	}
+	if got := balance(t, "bob"); got != units(51) {
+		t.Errorf("expected only alice's transfer to go through, bob has %v", got)
+	}
+}
+
+func TestParseAPIKeysRejectsMalformed(t *testing.T) {
+	for _, s := range []string{"k1", "k1=alice", "k1=alice:root", "=alice:user"} {
+		if _, err := parseAPIKeys(s); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
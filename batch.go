@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// largest batch accepted in one request
+const maxBatchSize = 1000
+
+// models the JSON body for POST /transfers/batch
+type batchRequest struct {
+	Transfers []TransferItem `json:"transfers"`
+}
+
+// the outcome of one item, Error is only set on the item that made the
+// batch fail, the others are reported rolled_back
+type batchItemResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	ID     int64  `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Status  string            `json:"status"`
+	Results []batchItemResult `json:"results"`
+}
+
+// handles POST /transfers/batch, e.g. a payroll run. either every
+// transfer is applied or none is, a 422 BATCH_FAILED error carries the
+// per item results telling which one stopped it
+func batchTransferHandler(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if !decodeBody(w, r, "BatchRequest", &req) {
+		return
+	}
+	if len(req.Transfers) == 0 || len(req.Transfers) > maxBatchSize {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "batch must hold between 1 and 1000 transfers")
+		return
+	}
+	for i, it := range req.Transfers {
+		if err := validateTransfer(it.From, it.To, it.Amount); err != nil {
+			se := err.(*serviceError)
+			writeErrorDetails(w, httpStatus[se.code], se.code,
+				fmt.Sprintf("transfer %d: %s", i, se.message), se.details)
+			return
+		}
+		if !mayDebit(r.Context(), it.From) {
+			forbidden(w)
+			return
+		}
+		if err := checkRecipient(r.Context(), it.To); err != nil {
+			se := err.(*serviceError)
+			writeErrorDetails(w, httpStatus[se.code], se.code,
+				fmt.Sprintf("transfer %d: %s", i, se.message), se.details)
+			return
+		}
+		// a batch is all-or-nothing, it can't wait on one item's review
+		if f := assessRisk(r.Context(), transferRequest{From: it.From, To: it.To, Amount: it.Amount}); f != nil {
+			transfersFailed.WithLabelValues(reasonRiskRejected).Inc()
+			writeErrorDetails(w, http.StatusUnprocessableEntity, codeRiskRejected,
+				fmt.Sprintf("transfer %d: rejected by the risk checks", i), f)
+			return
+		}
+	}
+
+	// limits are booked for the whole batch up front and given back if
+	// it doesn't go through
+	var undos []func()
+	undoAll := func() {
+		for _, undo := range undos {
+			undo()
+		}
+	}
+	for i, it := range req.Transfers {
+		undo, err := reserveLimit(r.Context(), it.From, it.Amount)
+		if err != nil {
+			undoAll()
+			se := err.(*serviceError)
+			writeErrorDetails(w, http.StatusUnprocessableEntity, se.code,
+				fmt.Sprintf("transfer %d: %s", i, se.message), se.details)
+			return
+		}
+		undos = append(undos, undo)
+	}
+
+	entries := make([]ledgerEntry, len(req.Transfers))
+	for i, it := range req.Transfers {
+		entries[i] = ledgerEntry{From: it.From, To: it.To, Amount: it.Amount, Currency: defaultCurrency, Status: statusCompleted}
+		if src, err := storeFor(r.Context()).Get(it.From); err == nil {
+			entries[i].Currency = src.Currency
+		}
+	}
+
+	resp := batchResponse{Status: "ok", Results: make([]batchItemResult, len(req.Transfers))}
+	booked, err := book(r.Context(), func(s Store) error { return s.TransferBatch(req.Transfers) }, entries...)
+	var batchErr *BatchError
+	if err != nil {
+		undoAll()
+	}
+	if err != nil && !errors.As(err, &batchErr) {
+		writeError(w, http.StatusInternalServerError, codeInternal, "batch failed")
+		return
+	}
+	if batchErr != nil {
+		// none of them moved any money, each is recorded failed
+		for i := range entries {
+			entries[i].Status = statusFailed
+		}
+		booked, _ = book(r.Context(), nil, entries...)
+	}
+	for i := range entries {
+		res := batchItemResult{Index: i, Status: statusCompleted}
+		switch {
+		case batchErr == nil:
+		case batchErr.Index == i:
+			res.Status = statusFailed
+			res.Error = batchErr.Err.Error()
+		default:
+			res.Status = "rolled_back"
+		}
+		if i < len(booked) {
+			res.ID = booked[i].ID
+		}
+		resp.Results[i] = res
+	}
+
+	if batchErr != nil {
+		writeErrorDetails(w, http.StatusUnprocessableEntity, codeBatchFailed,
+			fmt.Sprintf("transfer %d failed: %v", batchErr.Index, batchErr.Err),
+			map[string]any{"results": resp.Results})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBatchTransferAllOrNothing(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"payroll": units(100), "alice": 0, "bob": 0})
+
+	// a failed batch reports its results in the error details
+	post := func(body string) (int, batchResponse) {
+		w := httptest.NewRecorder()
+		batchTransferHandler(w, httptest.NewRequest("POST", "/transfers/batch", strings.NewReader(body)))
+		var resp struct {
+			batchResponse
+			Error struct {
+				Code    string        `json:"code"`
+				Details batchResponse `json:"details"`
+			} `json:"error"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp.Error.Code != "" {
+			resp.Error.Details.Status = resp.Error.Code
+			return w.Code, resp.Error.Details
+		}
+		return w.Code, resp.batchResponse
+	}
+
+	// the second payment would overdraw, so the first must not stick either
+	code, resp := post(`{"transfers":[
+		{"from":"payroll","to":"alice","amount":60},
+		{"from":"payroll","to":"bob","amount":60}]}`)
+	if code != http.StatusUnprocessableEntity || resp.Status != codeBatchFailed {
+		t.Fatalf("expected failed batch with 422, got %d %+v", code, resp)
+	}
+	if r := resp.Results; len(r) != 2 || r[0].Status != "rolled_back" || r[1].Status != statusFailed || r[1].Error == "" {
+		t.Errorf("unexpected results %+v", r)
+	}
+	if p, a := balance(t, "payroll"), balance(t, "alice"); p != units(100) || a != 0 {
+		t.Errorf("expected nothing to move, got payroll=%v alice=%v", p, a)
+	}
+
+	code, resp = post(`{"transfers":[
+		{"from":"payroll","to":"alice","amount":60},
+		{"from":"payroll","to":"bob","amount":40}]}`)
+	if code != http.StatusOK || resp.Status != "ok" {
+		t.Fatalf("expected 200, got %d %+v", code, resp)
+	}
+	if p, a, b := balance(t, "payroll"), balance(t, "alice"), balance(t, "bob"); p != 0 || a != units(60) || b != units(40) {
+		t.Errorf("expected payroll=0 alice=60 bob=40, got %v %v %v", p, a, b)
+	}
+}
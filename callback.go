@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// header carrying the hex encoded HMAC-SHA256 of the raw request body,
+// optionally prefixed with "sha256=" like most payment providers send it
+const signatureHeader = "X-Signature"
+
+// callbacks larger than this are rejected before hashing
+const maxCallbackBody = 1 << 20
+
+// shared secret used to verify inbound callbacks, loaded from
+// CALLBACK_SECRET at startup. empty means every callback is rejected
+var callbackSecret []byte
+
+// how far a callback's created_at may be from our clock. older ones are
+// refused so a captured request can't be replayed once its id has been
+// forgotten, ids are only remembered for this long
+const callbackTolerance = 5 * time.Minute
+
+// ids of the callbacks applied within the tolerance window, by when
+// they were created
+var (
+	callbacksMu   sync.Mutex
+	seenCallbacks = make(map[string]time.Time)
+)
+
+// models the JSON body for POST /callback sent by the payment provider
+// once an incoming payment has settled
+type callbackRequest struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Account   string    `json:"account"`
+	Amount    Money     `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// wraps a handler so it only runs when the request body carries a valid
+// HMAC signature for the given secret, anything else gets 401
+func requireSignature(secret []byte, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(secret) == 0 {
+			writeError(w, http.StatusUnauthorized, codeInvalidSignature, "signature verification not configured")
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxCallbackBody))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "could not read body")
+			return
+		}
+		if !validSignature(secret, body, r.Header.Get(signatureHeader)) {
+			writeError(w, http.StatusUnauthorized, codeInvalidSignature, "invalid signature")
+			return
+		}
+		// the body was consumed for hashing so hand the handler a fresh copy
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
+
+// reports whether sig is the HMAC-SHA256 of body under secret, the
+// comparison is constant time so it doesn't leak how many bytes matched
+func validSignature(secret, body []byte, sig string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(sig, "sha256="))
+	if err != nil || len(got) == 0 {
+		return false
+	}
+	return hmac.Equal(got, sign(secret, body))
+}
+
+// computes the HMAC-SHA256 of body under secret
+func sign(secret, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// claims a callback id, false when it was already applied. ids that
+// fell out of the tolerance window are dropped on the way
+func claimCallback(id string, created time.Time) bool {
+	callbacksMu.Lock()
+	defer callbacksMu.Unlock()
+	for seen, at := range seenCallbacks {
+		if now().Sub(at) > callbackTolerance {
+			delete(seenCallbacks, seen)
+		}
+	}
+	if _, ok := seenCallbacks[id]; ok {
+		return false
+	}
+	seenCallbacks[id] = created
+	return true
+}
+
+// forgets a claimed id again, for callbacks that could not be applied
+// and may be retried by the provider
+func releaseCallback(id string) {
+	callbacksMu.Lock()
+	delete(seenCallbacks, id)
+	callbacksMu.Unlock()
+}
+
+// handles POST /callback, only reached once the signature was verified.
+// a confirmed payment credits the account it was made out to, once: the
+// signed body carries an id and a timestamp so replays are refused
+func callbackHandler(w http.ResponseWriter, r *http.Request) {
+	var req callbackRequest
+	if !decodeBody(w, r, "CallbackRequest", &req) {
+		return
+	}
+	if req.Event != "payment.confirmed" {
+		writeError(w, http.StatusBadRequest, codeUnsupportedEvent, "unsupported event")
+		return
+	}
+	if req.ID == "" || req.Account == "" || req.Amount <= 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "id, account and positive amount required")
+		return
+	}
+	if req.Tenant != "" && !tenantPattern.MatchString(req.Tenant) {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "tenant must be lowercase letters, digits, - and _")
+		return
+	}
+	// the provider names the tenant whose account it paid into
+	r = r.WithContext(withTenant(r.Context(), req.Tenant))
+	if d := now().Sub(req.CreatedAt); d > callbackTolerance || d < -callbackTolerance {
+		writeError(w, http.StatusBadRequest, codeStaleCallback, "created_at is outside the accepted window")
+		return
+	}
+	if !claimCallback(req.ID, req.CreatedAt) {
+		writeError(w, http.StatusConflict, codeDuplicateCallback, "callback was already applied")
+		return
+	}
+
+	if err := checkCredit(r.Context(), req.Account); err != nil {
+		releaseCallback(req.ID)
+		writeServiceError(w, err)
+		return
+	}
+	currency := defaultCurrency
+	if a, err := storeFor(r.Context()).Get(req.Account); err == nil {
+		currency = a.Currency
+	}
+	entry := ledgerEntry{To: req.Account, Amount: req.Amount, Currency: currency, Status: statusCompleted}
+	if _, err := book(r.Context(), func(s Store) error { return s.Credit(req.Account, req.Amount) }, entry); err != nil {
+		releaseCallback(req.ID)
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not credit account")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"status": "ok"}`)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a payment.confirmed body for 10 to alice created at the given time
+func callbackBody(id string, created time.Time) string {
+	return fmt.Sprintf(`{"id":%q,"event":"payment.confirmed","account":"alice","amount":10,"created_at":%q}`, id, created.Format(time.RFC3339))
+}
+
+// posts body signed with secret through handler
+func postCallback(handler http.HandlerFunc, secret []byte, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/callback", strings.NewReader(body))
+	req.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(sign(secret, []byte(body))))
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func TestCallbackSignature(t *testing.T) {
+	secret := []byte("test-secret")
+	handler := requireSignature(secret, callbackHandler)
+	body := callbackBody("evt-signature", time.Now())
+	seenCallbacks = make(map[string]time.Time)
+
+	tests := []struct {
+		name    string
+		sig     string
+		code    int
+		balance Money
+	}{
+		{"signed", "sha256=" + hex.EncodeToString(sign(secret, []byte(body))), http.StatusOK, units(110)},
+		{"bad signature", "sha256=" + hex.EncodeToString(sign([]byte("wrong"), []byte(body))), http.StatusUnauthorized, units(100)},
+		{"unsigned", "", http.StatusUnauthorized, units(100)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store = newMemoryStore(map[string]Money{"alice": units(100)})
+
+			req := httptest.NewRequest("POST", "/callback", strings.NewReader(body))
+			if tt.sig != "" {
+				req.Header.Set(signatureHeader, tt.sig)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			if w.Code != tt.code {
+				t.Fatalf("expected %d, got %d", tt.code, w.Code)
+			}
+			if got := balance(t, "alice"); got != tt.balance {
+				t.Errorf("expected alice to have %v, got %v", tt.balance, got)
+			}
+		})
+	}
+}
+
+func TestCallbackReplay(t *testing.T) {
+	secret := []byte("test-secret")
+	handler := requireSignature(secret, callbackHandler)
+	store = newMemoryStore(map[string]Money{"alice": units(100)})
+	seenCallbacks = make(map[string]time.Time)
+
+	body := callbackBody("evt-1", time.Now())
+	if w := postCallback(handler, secret, body); w.Code != http.StatusOK {
+		t.Fatalf("expected the first delivery to be applied, got %d %s", w.Code, w.Body)
+	}
+	for range 2 {
+		w := postCallback(handler, secret, body)
+		if w.Code != http.StatusConflict || decodeError(t, w).Code != codeDuplicateCallback {
+			t.Errorf("expected a replay to be refused with %s, got %d %s", codeDuplicateCallback, w.Code, w.Body)
+		}
+	}
+	if got := balance(t, "alice"); got != units(110) {
+		t.Errorf("expected alice to be credited once, has %v", got)
+	}
+
+	for name, body := range map[string]string{
+		"stale":      callbackBody("evt-2", time.Now().Add(-time.Hour)),
+		"future":     callbackBody("evt-3", time.Now().Add(time.Hour)),
+		"no id":      callbackBody("", time.Now()),
+		"no created": `{"id":"evt-4","event":"payment.confirmed","account":"alice","amount":10}`,
+	} {
+		if w := postCallback(handler, secret, body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d %s", name, w.Code, w.Body)
+		}
+	}
+	if got := balance(t, "alice"); got != units(110) {
+		t.Errorf("expected alice to stay at 110, has %v", got)
+	}
+
+	// a callback that could not be applied may be delivered again
+	frozenAcceptsCredits = false
+	defer func() { frozenAcceptsCredits = true }()
+	store.SetStatus("alice", accountFrozen)
+	body = callbackBody("evt-5", time.Now())
+	if w := postCallback(handler, secret, body); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected a frozen account to refuse the credit, got %d", w.Code)
+	}
+	store.SetStatus("alice", accountActive)
+	if w := postCallback(handler, secret, body); w.Code != http.StatusOK {
+		t.Errorf("expected the retry to be applied, got %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chaos mode makes the store misbehave on purpose, for integration tests
+// and demos of what clients do about it: retries, Idempotency-Key and
+// timeouts. CHAOS is a list like "latency=200ms,errors=0.1,partial=0.05,
+// seed=7":
+//
+//	latency  how long every store call of a request waits before it runs,
+//	         given up on with the request like any other store call
+//	errors   the share of store calls failing with errChaos, moving no money
+//	partial  the share of transfers and conversions that take the money
+//	         from the sender, then fail before paying the recipient
+//	seed     of the random numbers deciding which calls fail, 0 unless
+//	         given. the same seed fails the same calls in the same order
+//
+// partial failures leave money missing the way a backend that isn't
+// atomic would, the supply check (see stats.go) alerts on it. the sqlite
+// and eventlog stores book a transfer and its ledger entry in one write,
+// there a partial failure is undone with the rest and moves nothing. faults
+// hit every call made through storeFor, the workers' included, the
+// supply check and loading the ledger read the store as it is. never
+// turn it on in production
+var chaos *chaosConfig
+
+// injected by chaos mode, what the callers of a store make of any error
+// they don't know
+var (
+	errChaos        = errors.New("chaos: injected store error")
+	errChaosPartial = errors.New("chaos: failed between debit and credit")
+)
+
+type chaosConfig struct {
+	latency time.Duration
+	errors  float64
+	partial float64
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// parses a CHAOS setting, see above
+func parseChaos(s string) (*chaosConfig, error) {
+	c := &chaosConfig{}
+	var seed uint64
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, val, _ := strings.Cut(entry, "=")
+		var err error
+		switch key {
+		case "latency":
+			c.latency, err = time.ParseDuration(val)
+			if err == nil && c.latency < 0 {
+				err = errors.New("negative")
+			}
+		case "errors":
+			c.errors, err = parseShare(val)
+		case "partial":
+			c.partial, err = parseShare(val)
+		case "seed":
+			seed, err = strconv.ParseUint(val, 10, 64)
+		default:
+			return nil, fmt.Errorf("unknown fault %q, use latency, errors, partial or seed", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed %q", entry)
+		}
+	}
+	c.rand = rand.New(rand.NewPCG(seed, seed))
+	return c, nil
+}
+
+// a share of calls, between 0 and 1
+func parseShare(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err == nil && (f < 0 || f > 1) {
+		err = errors.New("out of range")
+	}
+	return f, err
+}
+
+// whether the next call is one of share of them
+func (c *chaosConfig) roll(share float64) bool {
+	if share == 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rand.Float64() < share
+}
+
+// chaosStore is the store of one request in chaos mode
+type chaosStore struct {
+	ctx context.Context
+	c   *chaosConfig
+	Store
+}
+
+// waits out the latency and decides whether the call fails outright
+func (s chaosStore) before() error {
+	if s.c.latency > 0 {
+		t := time.NewTimer(s.c.latency)
+		defer t.Stop()
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case <-t.C:
+		}
+	}
+	if s.c.roll(s.c.errors) {
+		chaosFaults.WithLabelValues("error").Inc()
+		return errChaos
+	}
+	return nil
+}
+
+// runs debit, the sender's half of a transfer, failing once it went
+// through
+func (s chaosStore) partial(debit func() error) error {
+	if err := debit(); err != nil {
+		return err
+	}
+	chaosFaults.WithLabelValues("partial").Inc()
+	return errChaosPartial
+}
+
+func (s chaosStore) Get(account string) (Account, error) {
+	if err := s.before(); err != nil {
+		return Account{}, err
+	}
+	return s.Store.Get(account)
+}
+
+func (s chaosStore) Credit(account string, amount Money) error {
+	if err := s.before(); err != nil {
+		return err
+	}
+	return s.Store.Credit(account, amount)
+}
+
+func (s chaosStore) Debit(account string, amount Money) error {
+	if err := s.before(); err != nil {
+		return err
+	}
+	return s.Store.Debit(account, amount)
+}
+
+func (s chaosStore) DebitIf(account string, amount Money, version int64) error {
+	if err := s.before(); err != nil {
+		return err
+	}
+	return s.Store.DebitIf(account, amount, version)
+}
+
+func (s chaosStore) Transfer(from, to string, amount Money) error {
+	if err := s.before(); err != nil {
+		return err
+	}
+	if s.c.roll(s.c.partial) {
+		return s.partial(func() error { return s.Store.Debit(from, amount) })
+	}
+	return s.Store.Transfer(from, to, amount)
+}
+
+func (s chaosStore) TransferIf(from, to string, amount Money, version int64) error {
+	if err := s.before(); err != nil {
+		return err
+	}
+	if s.c.roll(s.c.partial) {
+		return s.partial(func() error { return s.Store.DebitIf(from, amount, version) })
+	}
+	return s.Store.TransferIf(from, to, amount, version)
+}
+
+func (s chaosStore) TransferBatch(items []TransferItem) error {
+	if err := s.before(); err != nil {
+		return err
+	}
+	return s.Store.TransferBatch(items)
+}
+
+func (s chaosStore) Exchange(from, to string, debit, credit Money) error {
+	if err := s.before(); err != nil {
+		return err
+	}
+	if s.c.roll(s.c.partial) {
+		return s.partial(func() error { return s.Store.Debit(from, debit) })
+	}
+	return s.Store.Exchange(from, to, debit, credit)
+}
+
+func (s chaosStore) All() ([]Account, error) {
+	if err := s.before(); err != nil {
+		return nil, err
+	}
+	return s.Store.All()
+}
+
+func (s chaosStore) Create(acct Account) error {
+	if err := s.before(); err != nil {
+		return err
+	}
+	return s.Store.Create(acct)
+}
+
+func (s chaosStore) Delete(account string) error {
+	if err := s.before(); err != nil {
+		return err
+	}
+	return s.Store.Delete(account)
+}
+
+func (s chaosStore) SetStatus(account, status string) error {
+	if err := s.before(); err != nil {
+		return err
+	}
+	return s.Store.SetStatus(account, status)
+}
+
+func (s chaosStore) SetOverdraft(account string, limit Money) error {
+	if err := s.before(); err != nil {
+		return err
+	}
+	return s.Store.SetOverdraft(account, limit)
+}
+
+func (s chaosStore) Reserve(account string, amount Money) error {
+	if err := s.before(); err != nil {
+		return err
+	}
+	return s.Store.Reserve(account, amount)
+}
+
+func (s chaosStore) Unreserve(account string, amount Money) error {
+	if err := s.before(); err != nil {
+		return err
+	}
+	return s.Store.Unreserve(account, amount)
+}
+
+func (s chaosStore) Settle(from, to string, amount Money) error {
+	if err := s.before(); err != nil {
+		return err
+	}
+	return s.Store.Settle(from, to, amount)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseChaos(t *testing.T) {
+	c, err := parseChaos("latency=20ms, errors=0.5,partial=0,seed=7")
+	if err != nil || c.latency != 20*time.Millisecond || c.errors != 0.5 || c.partial != 0 {
+		t.Fatalf("unexpected %+v %v", c, err)
+	}
+	// the same seed fails the same calls
+	d, _ := parseChaos("errors=0.5,seed=7")
+	for i := range 20 {
+		if c.roll(0.5) != d.roll(0.5) {
+			t.Fatalf("roll %d differs for the same seed", i)
+		}
+	}
+	for _, s := range []string{"errors=2", "partial=-0.1", "latency=-1s", "latency=soon", "seed=x", "jitter=1ms"} {
+		if _, err := parseChaos(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+
+	seed, err := parseSeedAccounts("carol=12.50, dave=0")
+	if err != nil || len(seed) != 2 || seed["carol"] != 1250 {
+		t.Fatalf("unexpected %v %v", seed, err)
+	}
+	seedAccounts = seed
+	defer func() { seedAccounts = nil }()
+	if b := seedBalances(); len(b) != 2 || b["dave"] != 0 {
+		t.Errorf("expected carol and dave to seed the store, got %v", b)
+	}
+	for _, s := range []string{"carol", "carol=lots", "=1", "a/b=1", "cash:USD=1", "carol=-1"} {
+		if _, err := parseSeedAccounts(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+func TestChaosStore(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	supplyDrift = map[string]Money{}
+	defer func() { chaos = nil }()
+
+	chaos, _ = parseChaos("errors=1")
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":10}`)))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected the injected error to answer 500, got %d %s", w.Code, w.Body)
+	}
+	if a, _ := store.Get("alice"); a.Balance != units(100) {
+		t.Errorf("expected no money to move, alice has %v", a.Balance)
+	}
+
+	chaos, _ = parseChaos("partial=1")
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":10}`)))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected the partial failure to answer 500, got %d %s", w.Code, w.Body)
+	}
+	a, _ := store.Get("alice")
+	b, _ := store.Get("bob")
+	if a.Balance != units(90) || b.Balance != 0 {
+		t.Errorf("expected alice debited and bob not credited, got %v and %v", a.Balance, b.Balance)
+	}
+	checkSupply()
+	checkSupply()
+	if supplyDrift["USD"] != -units(10) {
+		t.Errorf("expected the supply check to find 10.00 missing, got %v", supplyDrift)
+	}
+
+	// latency is given up on with the request
+	chaos, _ = parseChaos("latency=1h")
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := storeFor(ctx).Get("alice"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the wait to end with the request, got %v", err)
+	}
+}
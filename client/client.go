@@ -0,0 +1,363 @@
+// Package client is a Go client for the transaction API's HTTP endpoints.
+// Requests that move money carry an Idempotency-Key, so they are retried
+// on network errors, 429s and 5xx without the risk of running twice.
+// Errors the API answers with come back as *Error, match them with
+// errors.Is against the exported Err values.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to one transaction API server, it is safe for concurrent
+// use
+type Client struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+	retries int
+	backoff time.Duration
+}
+
+// Option changes how New sets up a Client
+type Option func(*Client)
+
+// WithAPIKey sends key as a bearer token on every request
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithHTTPClient makes requests through hc instead of a default client
+// with a 30s timeout
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithRetries retries a failed request up to n times, waiting backoff
+// before the first retry and doubling it after each. n 0 disables retries
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) { c.retries, c.backoff = n, backoff }
+}
+
+// New returns a client for the server at baseURL, e.g.
+// "http://localhost:8080". it retries 3 times starting at 200ms unless
+// WithRetries says otherwise
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/") + "/v1",
+		http:    &http.Client{Timeout: 30 * time.Second},
+		retries: 3,
+		backoff: 200 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Balance is what GET /accounts/{account}/balance answers with
+type Balance struct {
+	Account   string `json:"account"`
+	Balance   Money  `json:"balance"`
+	Available Money  `json:"available"`
+	Held      Money  `json:"held"`
+	Overdraft Money  `json:"overdraft"`
+	Currency  string `json:"currency"`
+	Status    string `json:"status"`
+}
+
+// Account is an account as GET /accounts lists it
+type Account struct {
+	ID        string `json:"id"`
+	Balance   Money  `json:"balance"`
+	Currency  string `json:"currency"`
+	Version   int64  `json:"version"`
+	Status    string `json:"status"`
+	Overdraft Money  `json:"overdraft"`
+	Held      Money  `json:"held"`
+	Type      string `json:"type"`
+}
+
+// TransferRequest moves Amount from From to To. Currency may be left
+// empty, when set it must match both accounts
+type TransferRequest struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Amount   Money  `json:"amount"`
+	Currency string `json:"currency,omitempty"`
+}
+
+// Transaction is one ledger entry
+type Transaction struct {
+	ID         int64      `json:"id"`
+	From       string     `json:"from,omitempty"`
+	To         string     `json:"to,omitempty"`
+	Amount     Money      `json:"amount"`
+	Currency   string     `json:"currency"`
+	ToAmount   Money      `json:"to_amount,omitempty"`
+	ToCurrency string     `json:"to_currency,omitempty"`
+	Timestamp  time.Time  `json:"timestamp"`
+	Status     string     `json:"status"`
+	HoldID     string     `json:"hold_id,omitempty"`
+	Reverses   int64      `json:"reverses,omitempty"`
+	ReversedBy int64      `json:"reversed_by,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	SettledAt  *time.Time `json:"settled_at,omitempty"`
+}
+
+// TransactionPage is one page of a transaction listing. NextOffset and
+// NextCursor are nil on the last page
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	Total        int           `json:"total"`
+	NextOffset   *int          `json:"next_offset"`
+	NextCursor   *string       `json:"next_cursor"`
+}
+
+// ListOptions selects what ListTransactions returns. an empty Account
+// lists every account's transactions, zero Limit uses the server's
+// default page size. Cursor, a NextCursor of the page before, is the
+// way to page that holds up while transactions keep coming in, Offset
+// can't be set with it
+type ListOptions struct {
+	Account string
+	Limit   int
+	Offset  int
+	Cursor  string
+}
+
+// GetBalance returns account's balance
+func (c *Client) GetBalance(ctx context.Context, account string) (Balance, error) {
+	var b Balance
+	err := c.do(ctx, "GET", "/accounts/"+url.PathEscape(account)+"/balance", nil, &b)
+	return b, err
+}
+
+// GetAccount returns an account, closed ones included
+func (c *Client) GetAccount(ctx context.Context, account string) (Account, error) {
+	var a Account
+	err := c.do(ctx, "GET", "/accounts/"+url.PathEscape(account), nil, &a)
+	return a, err
+}
+
+// ListAccounts returns every account ordered by id, admin only. it
+// follows the server's pages until the last one
+func (c *Client) ListAccounts(ctx context.Context) ([]Account, error) {
+	var all []Account
+	path := "/accounts"
+	for {
+		var res struct {
+			Accounts   []Account `json:"accounts"`
+			NextCursor *string   `json:"next_cursor"`
+		}
+		if err := c.do(ctx, "GET", path, nil, &res); err != nil {
+			return nil, err
+		}
+		all = append(all, res.Accounts...)
+		if res.NextCursor == nil {
+			return all, nil
+		}
+		path = "/accounts?" + url.Values{"cursor": {*res.NextCursor}}.Encode()
+	}
+}
+
+// CreateAccount opens account id holding balance, admin only. an empty
+// currency opens it in the server's default one. creating isn't
+// idempotent on the server, a retry of a create that went through comes
+// back as ErrAccountExists
+func (c *Client) CreateAccount(ctx context.Context, id string, balance Money, currency string) (Account, error) {
+	req := struct {
+		ID       string `json:"id"`
+		Balance  Money  `json:"balance"`
+		Currency string `json:"currency,omitempty"`
+	}{id, balance, currency}
+	var acct Account
+	err := c.do(ctx, "POST", "/accounts", req, &acct)
+	return acct, err
+}
+
+// Transfer moves money between two accounts and returns the id of its
+// ledger entry. a transfer the risk checks parked for review returns its
+// id too, GetTransaction tells whether it is still pending_review
+func (c *Client) Transfer(ctx context.Context, req TransferRequest) (int64, error) {
+	var res struct {
+		ID int64 `json:"id"`
+	}
+	err := c.do(ctx, "POST", "/transfer", req, &res)
+	return res.ID, err
+}
+
+// Deposit adds amount to account from outside the system, admins may
+// deposit into any account
+func (c *Client) Deposit(ctx context.Context, account string, amount Money) (int64, error) {
+	return c.cash(ctx, account, "deposit", amount)
+}
+
+// Withdraw takes amount out of account and out of the system
+func (c *Client) Withdraw(ctx context.Context, account string, amount Money) (int64, error) {
+	return c.cash(ctx, account, "withdraw", amount)
+}
+
+func (c *Client) cash(ctx context.Context, account, action string, amount Money) (int64, error) {
+	var res struct {
+		ID int64 `json:"id"`
+	}
+	body := map[string]Money{"amount": amount}
+	err := c.do(ctx, "POST", "/accounts/"+url.PathEscape(account)+"/"+action, body, &res)
+	return res.ID, err
+}
+
+// GetTransaction returns the ledger entry with id
+func (c *Client) GetTransaction(ctx context.Context, id int64) (Transaction, error) {
+	var t Transaction
+	err := c.do(ctx, "GET", "/transactions/"+strconv.FormatInt(id, 10), nil, &t)
+	return t, err
+}
+
+// ListTransactions returns one page of transactions, oldest first
+func (c *Client) ListTransactions(ctx context.Context, opts ListOptions) (TransactionPage, error) {
+	path := "/transactions"
+	if opts.Account != "" {
+		path = "/accounts/" + url.PathEscape(opts.Account) + "/transactions"
+	}
+	q := url.Values{}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		q.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	if opts.Cursor != "" {
+		q.Set("cursor", opts.Cursor)
+	}
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	var page TransactionPage
+	err := c.do(ctx, "GET", path, nil, &page)
+	return page, err
+}
+
+// Reverse moves the money of transaction id back and returns the
+// reversing entry
+func (c *Client) Reverse(ctx context.Context, id int64) (Transaction, error) {
+	var t Transaction
+	err := c.do(ctx, "POST", "/transactions/"+strconv.FormatInt(id, 10)+"/reverse", nil, &t)
+	return t, err
+}
+
+// sends one request, retrying it while it fails in a way that may pass,
+// and decodes a 2xx answer into out. every attempt of a POST carries the
+// same Idempotency-Key so the server runs it at most once
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	key := ""
+	if method == "POST" {
+		key = newIdempotencyKey()
+	}
+
+	wait := c.backoff
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := c.send(ctx, method, path, key, body, out)
+		if err == nil || attempt >= c.retries || !retryable(err) {
+			return err
+		}
+		if retryAfter > 0 {
+			wait = max(wait, retryAfter)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// makes a single attempt, returning how long the server asked us to wait
+// when it answered 429
+func (c *Client) send(ctx context.Context, method, path, key string, body []byte, out any) (time.Duration, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, r)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, &netError{err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		secs, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+		return time.Duration(secs) * time.Second, decodeError(res)
+	}
+	if out == nil {
+		return 0, nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return 0, fmt.Errorf("decoding %s %s response: %w", method, path, err)
+	}
+	return 0, nil
+}
+
+// a request that never got an answer, those are always worth retrying
+type netError struct{ err error }
+
+func (e *netError) Error() string { return e.err.Error() }
+func (e *netError) Unwrap() error { return e.err }
+
+// reports whether a request that failed with err may succeed when sent
+// again: it never reached the server, was rate limited, the server had
+// trouble, or another attempt with the same key is still running
+func retryable(err error) bool {
+	var ne *netError
+	if errors.As(err, &ne) {
+		return true
+	}
+	var e *Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500 || e.Code == CodeIdempotencyKeyInUse
+}
+
+// 16 random bytes in hex
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
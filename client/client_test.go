@@ -0,0 +1,164 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a server answering with the responses in order, recording every
+// request it got
+type fakeServer struct {
+	mu        sync.Mutex
+	responses []fakeResponse
+	requests  []*http.Request
+	bodies    []string
+}
+
+type fakeResponse struct {
+	code   int
+	header map[string]string
+	body   string
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, _ := io.ReadAll(r.Body)
+	f.requests = append(f.requests, r)
+	f.bodies = append(f.bodies, string(b))
+	res := f.responses[min(len(f.requests), len(f.responses))-1]
+	for k, v := range res.header {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(res.code)
+	io.WriteString(w, res.body)
+}
+
+func newTestClient(t *testing.T, responses ...fakeResponse) (*Client, *fakeServer) {
+	f := &fakeServer{responses: responses}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return New(srv.URL, WithAPIKey("k1"), WithRetries(3, time.Millisecond)), f
+}
+
+func TestGetBalance(t *testing.T) {
+	c, f := newTestClient(t, fakeResponse{200, nil,
+		`{"account":"alice","balance":100.50,"available":90.50,"held":10.00,"overdraft":0.00,"currency":"USD","status":"active"}`})
+
+	b, err := c.GetBalance(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Balance != 10050 || b.Available != 9050 || b.Held != Units(10) || b.Currency != "USD" {
+		t.Errorf("unexpected balance %+v", b)
+	}
+	r := f.requests[0]
+	if r.URL.Path != "/v1/accounts/alice/balance" || r.Header.Get("Authorization") != "Bearer k1" {
+		t.Errorf("unexpected request %s %v", r.URL.Path, r.Header)
+	}
+	if r.Header.Get("Idempotency-Key") != "" {
+		t.Error("expected reads to carry no idempotency key")
+	}
+}
+
+func TestTransferRetriesWithTheSameKey(t *testing.T) {
+	c, f := newTestClient(t,
+		fakeResponse{503, nil, `{"error":{"code":"INTERNAL_ERROR","message":"try again"}}`},
+		fakeResponse{429, map[string]string{"Retry-After": "0"}, `{"error":{"code":"RATE_LIMITED","message":"too many requests"}}`},
+		fakeResponse{200, nil, `{"status": "ok", "id": 7}`})
+
+	id, err := c.Transfer(context.Background(), TransferRequest{From: "alice", To: "bob", Amount: 1050})
+	if err != nil || id != 7 {
+		t.Fatalf("expected entry 7, got %d %v", id, err)
+	}
+	if len(f.requests) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(f.requests))
+	}
+	key := f.requests[0].Header.Get("Idempotency-Key")
+	for i, r := range f.requests {
+		if k := r.Header.Get("Idempotency-Key"); key == "" || k != key {
+			t.Errorf("attempt %d: expected key %q, got %q", i, key, k)
+		}
+	}
+	if f.bodies[0] != `{"from":"alice","to":"bob","amount":10.50}` {
+		t.Errorf("unexpected body %s", f.bodies[0])
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	c, f := newTestClient(t, fakeResponse{422, nil,
+		`{"error":{"code":"INSUFFICIENT_FUNDS","message":"insufficient funds","details":{"account":"alice"}}}`})
+
+	_, err := c.Transfer(context.Background(), TransferRequest{From: "alice", To: "bob", Amount: Units(1000)})
+	if !errors.Is(err, ErrInsufficientFunds) || errors.Is(err, ErrAccountFrozen) {
+		t.Fatalf("expected insufficient funds, got %v", err)
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != 422 || string(e.Details) != `{"account":"alice"}` {
+		t.Errorf("unexpected error %+v", e)
+	}
+	if len(f.requests) != 1 {
+		t.Errorf("expected a 422 not to be retried, got %d attempts", len(f.requests))
+	}
+
+	// a proxy's plain text answer keeps its status
+	c, _ = newTestClient(t, fakeResponse{502, nil, "bad gateway"})
+	_, err = c.GetBalance(context.Background(), "alice")
+	if !errors.As(err, &e) || e.StatusCode != 502 || e.Message != "bad gateway" {
+		t.Errorf("expected the 502 after retrying, got %v", err)
+	}
+}
+
+func TestGiveUpOnCancel(t *testing.T) {
+	c, f := newTestClient(t, fakeResponse{500, nil, `{"error":{"code":"INTERNAL_ERROR","message":"internal error"}}`})
+	c.backoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.GetBalance(ctx, "alice"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to stop retrying, got %v", err)
+	}
+	if len(f.requests) != 1 {
+		t.Errorf("expected a single attempt, got %d", len(f.requests))
+	}
+}
+
+func TestListTransactions(t *testing.T) {
+	c, f := newTestClient(t, fakeResponse{200, nil,
+		`{"transactions":[{"id":1,"from":"alice","to":"bob","amount":5.00,"currency":"USD","timestamp":"2024-01-02T03:04:05Z","status":"completed"}],"total":3,"next_offset":2}`})
+
+	page, err := c.ListTransactions(context.Background(), ListOptions{Account: "alice", Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Transactions) != 1 || page.Transactions[0].Amount != Units(5) || page.NextOffset == nil || *page.NextOffset != 2 {
+		t.Errorf("unexpected page %+v", page)
+	}
+	if u := f.requests[0].URL; u.Path != "/v1/accounts/alice/transactions" || u.RawQuery != "limit=1&offset=1" {
+		t.Errorf("unexpected request %s", u)
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	for in, want := range map[string]Money{"10": 1000, "10.5": 1050, "-0.25": -25, "0.01": 1} {
+		var m Money
+		if err := m.UnmarshalJSON([]byte(in)); err != nil || m != want {
+			t.Errorf("%s: expected %d, got %d %v", in, want, m, err)
+		}
+	}
+	for _, in := range []string{"1.234", "1e3", "abc", "1.", `"1"`} {
+		var m Money
+		if err := m.UnmarshalJSON([]byte(in)); err == nil {
+			t.Errorf("%s: expected an error", in)
+		}
+	}
+	if s := Money(-1205).String(); s != "-12.05" {
+		t.Errorf("expected -12.05, got %s", s)
+	}
+}
@@ -0,0 +1,161 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The error codes the API answers with in Error.Code, see the server's
+// errors.go for when each one is used
+const (
+	CodeInvalidJSON         = "INVALID_JSON"
+	CodeValidationFailed    = "VALIDATION_FAILED"
+	CodeInvalidRequest      = "INVALID_REQUEST"
+	CodeInvalidAmount       = "INVALID_AMOUNT"
+	CodeInvalidAccountID    = "INVALID_ACCOUNT_ID"
+	CodeInvalidCurrency     = "INVALID_CURRENCY"
+	CodeUnauthorized        = "UNAUTHORIZED"
+	CodeForbidden           = "FORBIDDEN"
+	CodeAccountNotFound     = "ACCOUNT_NOT_FOUND"
+	CodeTransactionNotFound = "TRANSACTION_NOT_FOUND"
+	CodeAccountExists       = "ACCOUNT_EXISTS"
+	CodeAlreadyReversed     = "ALREADY_REVERSED"
+	CodeIdempotencyKeyInUse = "IDEMPOTENCY_KEY_IN_USE"
+	CodePreconditionFailed  = "PRECONDITION_FAILED"
+	CodeAccountFrozen       = "ACCOUNT_FROZEN"
+	CodeAccountClosed       = "ACCOUNT_CLOSED"
+	CodeInsufficientFunds   = "INSUFFICIENT_FUNDS"
+	CodeCurrencyMismatch    = "CURRENCY_MISMATCH"
+	CodeSameAccount         = "SAME_ACCOUNT"
+	CodeRecipientNotFound   = "RECIPIENT_NOT_FOUND"
+	CodeAmountBelowMinimum  = "AMOUNT_BELOW_MINIMUM"
+	CodeNotReversible       = "NOT_REVERSIBLE"
+	CodeLimitExceeded       = "LIMIT_EXCEEDED"
+	CodeRiskRejected        = "RISK_REJECTED"
+	CodeRateLimited         = "RATE_LIMITED"
+	CodeTimeout             = "TIMEOUT"
+	CodeInternal            = "INTERNAL_ERROR"
+)
+
+// Error is an error response of the API. Details is whatever the server
+// put in the envelope's details, undecoded
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Details    json.RawMessage
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s (HTTP %d)", e.Code, e.Message, e.StatusCode)
+}
+
+// Is matches errors carrying the same code, so
+// errors.Is(err, client.ErrInsufficientFunds) holds whatever the message
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// targets for errors.Is, one per code callers commonly branch on
+var (
+	ErrUnauthorized        = &Error{Code: CodeUnauthorized}
+	ErrForbidden           = &Error{Code: CodeForbidden}
+	ErrAccountNotFound     = &Error{Code: CodeAccountNotFound}
+	ErrTransactionNotFound = &Error{Code: CodeTransactionNotFound}
+	ErrAccountExists       = &Error{Code: CodeAccountExists}
+	ErrAlreadyReversed     = &Error{Code: CodeAlreadyReversed}
+	ErrPreconditionFailed  = &Error{Code: CodePreconditionFailed}
+	ErrAccountFrozen       = &Error{Code: CodeAccountFrozen}
+	ErrAccountClosed       = &Error{Code: CodeAccountClosed}
+	ErrInsufficientFunds   = &Error{Code: CodeInsufficientFunds}
+	ErrCurrencyMismatch    = &Error{Code: CodeCurrencyMismatch}
+	ErrSameAccount         = &Error{Code: CodeSameAccount}
+	ErrRecipientNotFound   = &Error{Code: CodeRecipientNotFound}
+	ErrAmountBelowMinimum  = &Error{Code: CodeAmountBelowMinimum}
+	ErrLimitExceeded       = &Error{Code: CodeLimitExceeded}
+	ErrRiskRejected        = &Error{Code: CodeRiskRejected}
+	ErrRateLimited         = &Error{Code: CodeRateLimited}
+)
+
+// reads the {"error":{...}} envelope of a non 2xx response. anything else
+// a proxy in between may answer with keeps its status and body
+func decodeError(res *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	var env struct {
+		Error struct {
+			Code    string          `json:"code"`
+			Message string          `json:"message"`
+			Details json.RawMessage `json:"details"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(b, &env) != nil || env.Error.Code == "" {
+		return &Error{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(b))}
+	}
+	return &Error{StatusCode: res.StatusCode, Code: env.Error.Code, Message: env.Error.Message, Details: env.Error.Details}
+}
+
+// Money is an amount in minor units (cents), on the wire a JSON number
+// with at most two decimal places like the server's
+type Money int64
+
+// Units returns n whole units as Money
+func Units(n int64) Money {
+	return Money(n * 100)
+}
+
+// String formats m with exactly two decimal places, e.g. "-12.05"
+func (m Money) String() string {
+	sign := ""
+	u := uint64(m)
+	if m < 0 {
+		sign = "-"
+		u = uint64(-m)
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, u/100, u%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+var errMoney = errors.New("amount must be a decimal number with at most 2 decimal places")
+
+func (m *Money) UnmarshalJSON(b []byte) error {
+	v, err := ParseMoney(string(b))
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// ParseMoney parses a decimal amount like "10", "10.5" or "-0.25"
+// without going through float64
+func ParseMoney(s string) (Money, error) {
+	neg := strings.HasPrefix(s, "-")
+	whole, frac, hasFrac := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	if whole == "" || hasFrac && frac == "" || len(frac) > 2 || strings.ContainsAny(whole+frac, "+-eE") {
+		return 0, errMoney
+	}
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || w > math.MaxInt64/100-1 {
+		return 0, errMoney
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	f, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return 0, errMoney
+	}
+	m := Money(w*100 + f)
+	if neg {
+		m = -m
+	}
+	return m, nil
+}
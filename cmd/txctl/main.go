@@ -0,0 +1,319 @@
+// txctl operates a transaction API server from the command line, for ops
+// and local testing. it goes through the client package so requests that
+// move money are retried under an idempotency key.
+//
+//	txctl balance alice
+//	txctl transfer -from alice -to bob -amount 10
+//	txctl deposit alice -amount 25.50
+//	txctl withdraw alice -amount 5
+//	txctl accounts list
+//	txctl accounts create -id carol -balance 100 -currency EUR
+//	txctl transactions -account alice -limit 20
+//	txctl transaction 42
+//	txctl reverse 42
+//
+// every command takes -server (TXCTL_SERVER, http://localhost:8080 by
+// default), -api-key (TXCTL_API_KEY) and -o table|json.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rkarmaka98/Transaction_APP/transaction-api/client"
+)
+
+const usage = `usage: txctl <command> [flags]
+
+commands:
+  balance <account>
+  transfer -from <account> -to <account> -amount <amount> [-currency <code>]
+  deposit <account> -amount <amount>
+  withdraw <account> -amount <amount>
+  accounts list
+  accounts create -id <account> [-balance <amount>] [-currency <code>]
+  transactions [-account <account>] [-limit n] [-offset n | -cursor c]
+  transaction <id>
+  reverse <id>
+
+run txctl <command> -h for a command's flags
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Getenv, os.Stdout, os.Stderr))
+}
+
+// the flags every command shares
+type globals struct {
+	server  string
+	apiKey  string
+	output  string
+	timeout time.Duration
+}
+
+// one command being run, its flags are parsed into the fields the command
+// registered before calling parse
+type command struct {
+	fs   *flag.FlagSet
+	g    globals
+	args []string
+}
+
+func newCommand(name string, getenv func(string) string, stderr io.Writer) *command {
+	c := &command{fs: flag.NewFlagSet("txctl "+name, flag.ContinueOnError)}
+	c.fs.SetOutput(stderr)
+	server := getenv("TXCTL_SERVER")
+	if server == "" {
+		server = "http://localhost:8080"
+	}
+	c.fs.StringVar(&c.g.server, "server", server, "base URL of the API")
+	c.fs.StringVar(&c.g.apiKey, "api-key", getenv("TXCTL_API_KEY"), "API key to authenticate with")
+	c.fs.StringVar(&c.g.output, "o", "table", "output format, table or json")
+	c.fs.DurationVar(&c.g.timeout, "timeout", 30*time.Second, "give up after this long, retries included")
+	return c
+}
+
+// parses args taking the first n ones not starting with - as positional
+// arguments, so they may come before or after the flags
+func (c *command) parse(args []string, n int) error {
+	var flags []string
+	for _, a := range args {
+		if len(c.args) < n && !strings.HasPrefix(a, "-") && !c.expectsValue(flags) {
+			c.args = append(c.args, a)
+			continue
+		}
+		flags = append(flags, a)
+	}
+	if err := c.fs.Parse(flags); err != nil {
+		return err
+	}
+	c.args = append(c.args, c.fs.Args()...)
+	if len(c.args) != n {
+		return fmt.Errorf("%s takes %d argument(s), got %d", c.fs.Name(), n, len(c.args))
+	}
+	if c.g.output != "table" && c.g.output != "json" {
+		return fmt.Errorf("-o must be table or json, got %q", c.g.output)
+	}
+	return nil
+}
+
+// reports whether the last of flags is waiting for its value, as in
+// "-amount 10". boolean flags never are
+func (c *command) expectsValue(flags []string) bool {
+	if len(flags) == 0 {
+		return false
+	}
+	name, _, hasValue := strings.Cut(strings.TrimLeft(flags[len(flags)-1], "-"), "=")
+	f := c.fs.Lookup(name)
+	if f == nil || hasValue {
+		return false
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !b.IsBoolFlag()
+}
+
+func (c *command) client() *client.Client {
+	return client.New(c.g.server, client.WithAPIKey(c.g.apiKey))
+}
+
+// runs the command in args and returns the exit code: 0 when it worked,
+// 1 when the API refused or could not be reached, 2 on bad usage
+func run(args []string, getenv func(string) string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "help" {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	name, args := args[0], args[1:]
+	if name == "accounts" {
+		if len(args) == 0 {
+			fmt.Fprint(stderr, usage)
+			return 2
+		}
+		name, args = "accounts "+args[0], args[1:]
+	}
+	c := newCommand(name, getenv, stderr)
+
+	// bad is set when err is the caller's fault rather than the API's
+	var out any
+	var err error
+	var bad bool
+	do := func(n int, f func(ctx context.Context, cl *client.Client) (any, error)) {
+		if err = c.parse(args, n); err != nil {
+			bad = true
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), c.g.timeout)
+		defer cancel()
+		out, err = f(ctx, c.client())
+	}
+
+	switch name {
+	case "balance":
+		do(1, func(ctx context.Context, cl *client.Client) (any, error) {
+			return cl.GetBalance(ctx, c.args[0])
+		})
+	case "transfer":
+		var req client.TransferRequest
+		c.fs.StringVar(&req.From, "from", "", "account to debit")
+		c.fs.StringVar(&req.To, "to", "", "account to credit")
+		c.fs.Func("amount", "amount to move, e.g. 10.50", moneyFlag(&req.Amount))
+		c.fs.StringVar(&req.Currency, "currency", "", "currency both accounts hold, optional")
+		do(0, func(ctx context.Context, cl *client.Client) (any, error) {
+			id, err := cl.Transfer(ctx, req)
+			return result{id}, err
+		})
+	case "deposit", "withdraw":
+		var amount client.Money
+		c.fs.Func("amount", "amount to "+name+", e.g. 10.50", moneyFlag(&amount))
+		do(1, func(ctx context.Context, cl *client.Client) (any, error) {
+			move := cl.Deposit
+			if name == "withdraw" {
+				move = cl.Withdraw
+			}
+			id, err := move(ctx, c.args[0], amount)
+			return result{id}, err
+		})
+	case "accounts list":
+		do(0, func(ctx context.Context, cl *client.Client) (any, error) {
+			return cl.ListAccounts(ctx)
+		})
+	case "accounts create":
+		var id, currency string
+		var balance client.Money
+		c.fs.StringVar(&id, "id", "", "id of the new account")
+		c.fs.Func("balance", "opening balance, 0 by default", moneyFlag(&balance))
+		c.fs.StringVar(&currency, "currency", "", "currency of the account, the server's default when empty")
+		do(0, func(ctx context.Context, cl *client.Client) (any, error) {
+			acct, err := cl.CreateAccount(ctx, id, balance, currency)
+			return []client.Account{acct}, err
+		})
+	case "transactions":
+		var opts client.ListOptions
+		c.fs.StringVar(&opts.Account, "account", "", "only list this account's transactions")
+		c.fs.IntVar(&opts.Limit, "limit", 0, "page size, the server's default when 0")
+		c.fs.IntVar(&opts.Offset, "offset", 0, "transactions to skip")
+		c.fs.StringVar(&opts.Cursor, "cursor", "", "where the page before ended, as printed under it")
+		do(0, func(ctx context.Context, cl *client.Client) (any, error) {
+			return cl.ListTransactions(ctx, opts)
+		})
+	case "transaction", "reverse":
+		do(1, func(ctx context.Context, cl *client.Client) (any, error) {
+			id, err := strconv.ParseInt(c.args[0], 10, 64)
+			if err != nil {
+				bad = true
+				return nil, errors.New("transaction id must be an integer")
+			}
+			var t client.Transaction
+			if name == "reverse" {
+				t, err = cl.Reverse(ctx, id)
+			} else {
+				t, err = cl.GetTransaction(ctx, id)
+			}
+			return []client.Transaction{t}, err
+		})
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", name, usage)
+		return 2
+	}
+
+	if errors.Is(err, flag.ErrHelp) {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "txctl:", err)
+		if bad {
+			return 2
+		}
+		return 1
+	}
+	if err := render(stdout, c.g.output, out); err != nil {
+		fmt.Fprintln(stderr, "txctl:", err)
+		return 1
+	}
+	return 0
+}
+
+// what a command moving money prints, the id of its ledger entry
+type result struct {
+	ID int64 `json:"id"`
+}
+
+// a flag.Func parsing an amount into m
+func moneyFlag(m *client.Money) func(string) error {
+	return func(s string) error {
+		v, err := client.ParseMoney(s)
+		*m = v
+		return err
+	}
+}
+
+// writes v as indented JSON or as a table with a header row
+func render(w io.Writer, format string, v any) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	row := func(cols ...any) {
+		for i, c := range cols {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, c)
+		}
+		fmt.Fprintln(tw)
+	}
+	switch v := v.(type) {
+	case client.Balance:
+		row("ACCOUNT", "BALANCE", "AVAILABLE", "HELD", "OVERDRAFT", "CURRENCY", "STATUS")
+		row(v.Account, v.Balance, v.Available, v.Held, v.Overdraft, v.Currency, v.Status)
+	case result:
+		row("ID", "STATUS")
+		row(v.ID, "ok")
+	case []client.Account:
+		row("ID", "BALANCE", "HELD", "OVERDRAFT", "CURRENCY", "STATUS", "VERSION")
+		for _, a := range v {
+			row(a.ID, a.Balance, a.Held, a.Overdraft, a.Currency, a.Status, a.Version)
+		}
+	case client.TransactionPage:
+		transactionRows(row, v.Transactions)
+		tw.Flush()
+		more := ""
+		switch {
+		case v.NextCursor != nil:
+			more = fmt.Sprintf(", next page with -cursor %s", *v.NextCursor)
+		case v.NextOffset != nil:
+			more = fmt.Sprintf(", next page at -offset %d", *v.NextOffset)
+		}
+		fmt.Fprintf(w, "%d of %d%s\n", len(v.Transactions), v.Total, more)
+		return nil
+	case []client.Transaction:
+		transactionRows(row, v)
+	}
+	return tw.Flush()
+}
+
+func transactionRows(row func(...any), list []client.Transaction) {
+	row("ID", "TIME", "FROM", "TO", "AMOUNT", "CURRENCY", "STATUS")
+	for _, t := range list {
+		row(t.ID, t.Timestamp.Format(time.RFC3339), dash(t.From), dash(t.To), t.Amount, t.Currency, t.Status)
+	}
+}
+
+// deposits have no sender and withdrawals no recipient
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// runs txctl against a server answering every request with code and
+// body, returning the exit code, stdout, stderr and the request made
+func runTxctl(t *testing.T, code int, body string, args ...string) (int, string, string, *http.Request, string) {
+	var got *http.Request
+	var sent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got, sent = r, string(b)
+		w.WriteHeader(code)
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+	env := map[string]string{"TXCTL_SERVER": srv.URL, "TXCTL_API_KEY": "k1"}
+	var stdout, stderr bytes.Buffer
+	exit := run(args, func(k string) string { return env[k] }, &stdout, &stderr)
+	return exit, stdout.String(), stderr.String(), got, sent
+}
+
+func TestBalance(t *testing.T) {
+	body := `{"account":"alice","balance":100.00,"available":100.00,"held":0.00,"overdraft":0.00,"currency":"USD","status":"active"}`
+	exit, out, _, r, _ := runTxctl(t, 200, body, "balance", "alice")
+	if exit != 0 || r.URL.Path != "/v1/accounts/alice/balance" || r.Header.Get("Authorization") != "Bearer k1" {
+		t.Fatalf("unexpected run %d %s %v", exit, r.URL.Path, r.Header)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ACCOUNT") || !strings.Contains(lines[1], "100.00") {
+		t.Errorf("expected a table, got\n%s", out)
+	}
+
+	exit, out, _, _, _ = runTxctl(t, 200, body, "balance", "-o", "json", "alice")
+	if exit != 0 || !strings.Contains(out, `"balance": 100.00`) {
+		t.Errorf("expected JSON, got %d\n%s", exit, out)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	exit, out, _, r, sent := runTxctl(t, 200, `{"status": "ok", "id": 3}`,
+		"transfer", "-from", "alice", "--to", "bob", "-amount", "10.5")
+	if exit != 0 || r.Method != "POST" || r.URL.Path != "/v1/transfer" || r.Header.Get("Idempotency-Key") == "" {
+		t.Fatalf("unexpected run %d %s %s", exit, r.Method, r.URL.Path)
+	}
+	if sent != `{"from":"alice","to":"bob","amount":10.50}` || !strings.Contains(out, "3") {
+		t.Errorf("unexpected body %s or output %s", sent, out)
+	}
+
+	exit, _, errOut, _, _ := runTxctl(t, 422, `{"error":{"code":"INSUFFICIENT_FUNDS","message":"insufficient funds"}}`,
+		"transfer", "-from", "alice", "-to", "bob", "-amount", "1000")
+	if exit != 1 || !strings.Contains(errOut, "INSUFFICIENT_FUNDS") {
+		t.Errorf("expected the API's error, got %d %s", exit, errOut)
+	}
+}
+
+func TestAccountsCreate(t *testing.T) {
+	exit, out, _, r, sent := runTxctl(t, 201, `{"id":"carol","balance":5.00,"currency":"EUR","version":1,"status":"active","overdraft":0.00,"held":0.00}`,
+		"accounts", "create", "-id", "carol", "-balance", "5", "-currency", "EUR")
+	if exit != 0 || r.URL.Path != "/v1/accounts" || sent != `{"id":"carol","balance":5.00,"currency":"EUR"}` {
+		t.Fatalf("unexpected run %d %s %s", exit, r.URL.Path, sent)
+	}
+	if !strings.Contains(out, "carol") || !strings.Contains(out, "EUR") {
+		t.Errorf("expected the new account, got\n%s", out)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"nothing"},
+		{"balance"},
+		{"balance", "alice", "bob"},
+		{"balance", "alice", "-o", "yaml"},
+		{"transfer", "-amount", "1.234"},
+		{"transaction", "abc"},
+		{"accounts"},
+	} {
+		if exit, _, _, _, _ := runTxctl(t, 200, `{}`, args...); exit != 2 {
+			t.Errorf("%v: expected exit code 2, got %d", args, exit)
+		}
+	}
+}
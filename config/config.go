@@ -0,0 +1,326 @@
+// Package config loads the settings of the transaction API. they start
+// from the defaults below, then a JSON file (-config or CONFIG_FILE),
+// then the environment, then flags, each overriding the one before, and
+// are checked as a whole before anything starts so every mistake shows
+// up at once.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration written as "30s" in the file
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Config holds every setting, the JSON names are the keys of the file.
+// amounts are kept as written, the server parses them as money
+type Config struct {
+	Addr            string   `json:"addr"`
+	GRPCAddr        string   `json:"grpc_addr"`
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+	// memory, sqlite or eventlog, StorePath is the file of the last two
+	Store     string `json:"store"`
+	StorePath string `json:"store_path"`
+	// how long transfer responses are replayed for an Idempotency-Key
+	IdempotencyTTL Duration `json:"idempotency_ttl"`
+	// the longest a request may run before it is given up with 504, 0
+	// lets requests run until the server's write timeout cuts them off
+	HandlerTimeout Duration `json:"handler_timeout"`
+	// see parseAPIKeys in the server, empty turns authentication off
+	APIKeys string `json:"api_keys"`
+	// requests per second overall and per API key, 0 is unlimited
+	RateLimit    float64 `json:"rate_limit"`
+	KeyRateLimit float64 `json:"key_rate_limit"`
+	// what may leave an account at once and per day, empty is unlimited,
+	// and the smallest transfer, empty is any positive amount
+	MaxTransferAmount    string `json:"max_transfer_amount"`
+	DailyTransferLimit   string `json:"daily_transfer_limit"`
+	MinTransferAmount    string `json:"min_transfer_amount"`
+	FrozenAcceptsCredits bool   `json:"frozen_accepts_credits"`
+	SchedulePath         string `json:"schedule_path"`
+	// the JSON lines file the audit log of state-changing calls is kept in
+	AuditLog string `json:"audit_log"`
+	// a static table like "EUR/USD=1.1" or a provider URL, not both
+	FXRates        string `json:"fx_rates"`
+	FXRatesURL     string `json:"fx_rates_url"`
+	CallbackSecret string `json:"callback_secret"`
+	// where snapshots named by a file name go, see snapshot.go in the
+	// server. RestoreFrom is restored at startup, SnapshotOnExit written
+	// on a graceful shutdown, each a file name or an http(s) URL
+	SnapshotDir    string `json:"snapshot_dir"`
+	RestoreFrom    string `json:"restore_from"`
+	SnapshotOnExit string `json:"snapshot_on_exit"`
+	// HTTPS and gRPC over TLS, from a PEM certificate and key or from
+	// Let's Encrypt for the comma separated TLSAutocert hosts, caching
+	// what it issues in TLSAutocertDir
+	TLSCert        string `json:"tls_cert"`
+	TLSKey         string `json:"tls_key"`
+	TLSAutocert    string `json:"tls_autocert"`
+	TLSAutocertDir string `json:"tls_autocert_dir"`
+	// PEM CAs client certificates must be signed by, setting it requires
+	// one. ClientCerts maps their common names to callers like API_KEYS
+	// maps keys, see parseClientCerts in the server
+	TLSClientCA string `json:"tls_client_ca"`
+	ClientCerts string `json:"client_certs"`
+	// what savings accounts earn per InterestPeriod, daily or monthly,
+	// as a decimal like "0.004", empty pays no interest
+	InterestRate   string `json:"interest_rate"`
+	InterestPeriod string `json:"interest_period"`
+	// the accounts a fresh store opens with, like "alice=100,bob=50.25",
+	// instead of the demo ones
+	SeedAccounts string `json:"seed_accounts"`
+	// faults injected into the store for testing, like
+	// "latency=100ms,errors=0.1,partial=0.05,seed=1", see chaos.go in the
+	// server. empty runs without
+	Chaos string `json:"chaos"`
+}
+
+// Default is what the server runs with when nothing is configured
+func Default() Config {
+	return Config{
+		Addr:                 ":8080",
+		GRPCAddr:             ":9090",
+		ShutdownTimeout:      Duration(30 * time.Second),
+		Store:                "memory",
+		IdempotencyTTL:       Duration(24 * time.Hour),
+		HandlerTimeout:       Duration(25 * time.Second),
+		FrozenAcceptsCredits: true,
+		InterestPeriod:       "monthly",
+	}
+}
+
+// the environment variable behind each setting, each also a flag named
+// after it in lower case with dashes, STORE_PATH is -store-path.
+// SQLITE_PATH is what STORE_PATH was called when sqlite was the only file
+// backend, the newer name wins when both are set, and has no flag
+var envVars = []struct {
+	name  string
+	usage string
+	// a flag that may be given without a value, like -frozen-accepts-credits
+	boolean bool
+	set     func(c *Config, v string) error
+}{
+	{"ADDR", "address to listen on, :8080 by default", false, func(c *Config, v string) error { c.Addr = v; return nil }},
+	{"GRPC_ADDR", "address for the gRPC API, :9090 by default, empty disables it", false, func(c *Config, v string) error { c.GRPCAddr = v; return nil }},
+	{"SHUTDOWN_TIMEOUT", "how long to wait for in-flight requests on shutdown, 30s by default", false, func(c *Config, v string) error { return setDuration(&c.ShutdownTimeout, v) }},
+	{"STORE", "memory, sqlite or eventlog", false, func(c *Config, v string) error { c.Store = v; return nil }},
+	{"SQLITE_PATH", "", false, func(c *Config, v string) error { c.StorePath = v; return nil }},
+	{"STORE_PATH", "the file of the sqlite or eventlog store", false, func(c *Config, v string) error { c.StorePath = v; return nil }},
+	{"IDEMPOTENCY_TTL", "how long responses are replayed for an Idempotency-Key, 24h by default", false, func(c *Config, v string) error { return setDuration(&c.IdempotencyTTL, v) }},
+	{"HANDLER_TIMEOUT", "the longest a request may run before it gets 504, 25s by default", false, func(c *Config, v string) error { return setDuration(&c.HandlerTimeout, v) }},
+	{"API_KEYS", "key=name:role[:account] entries separated by ;, empty turns authentication off", false, func(c *Config, v string) error { c.APIKeys = v; return nil }},
+	{"RATE_LIMIT", "requests per second overall, 0 is unlimited", false, func(c *Config, v string) error { return setFloat(&c.RateLimit, v) }},
+	{"KEY_RATE_LIMIT", "requests per second per API key, 0 is unlimited", false, func(c *Config, v string) error { return setFloat(&c.KeyRateLimit, v) }},
+	{"MAX_TRANSFER_AMOUNT", "the most one transfer may move", false, func(c *Config, v string) error { c.MaxTransferAmount = v; return nil }},
+	{"DAILY_TRANSFER_LIMIT", "the most that may leave an account per day", false, func(c *Config, v string) error { c.DailyTransferLimit = v; return nil }},
+	{"MIN_TRANSFER_AMOUNT", "the smallest transfer", false, func(c *Config, v string) error { c.MinTransferAmount = v; return nil }},
+	{"FROZEN_ACCEPTS_CREDITS", "whether frozen accounts can still be paid, true by default", true, func(c *Config, v string) error { return setBool(&c.FrozenAcceptsCredits, v) }},
+	{"SCHEDULE_PATH", "file scheduled transfers are kept in", false, func(c *Config, v string) error { c.SchedulePath = v; return nil }},
+	{"AUDIT_LOG", "file the audit log is kept in", false, func(c *Config, v string) error { c.AuditLog = v; return nil }},
+	{"FX_RATES", "static exchange rates like EUR/USD=1.1", false, func(c *Config, v string) error { c.FXRates = v; return nil }},
+	{"FX_RATES_URL", "URL of an exchange rate provider", false, func(c *Config, v string) error { c.FXRatesURL = v; return nil }},
+	{"CALLBACK_SECRET", "HMAC secret inbound callbacks are signed with", false, func(c *Config, v string) error { c.CallbackSecret = v; return nil }},
+	{"SNAPSHOT_DIR", "where snapshots named by a file name go", false, func(c *Config, v string) error { c.SnapshotDir = v; return nil }},
+	{"RESTORE_FROM", "snapshot to restore at startup, a file in the snapshot dir or a URL, also -restore", false, func(c *Config, v string) error { c.RestoreFrom = v; return nil }},
+	{"SNAPSHOT_ON_EXIT", "where to write a snapshot on shutdown", false, func(c *Config, v string) error { c.SnapshotOnExit = v; return nil }},
+	{"TLS_CERT", "PEM certificate to serve HTTPS with", false, func(c *Config, v string) error { c.TLSCert = v; return nil }},
+	{"TLS_KEY", "PEM key of -tls-cert", false, func(c *Config, v string) error { c.TLSKey = v; return nil }},
+	{"TLS_AUTOCERT", "comma separated hosts to get certificates from Let's Encrypt for", false, func(c *Config, v string) error { c.TLSAutocert = v; return nil }},
+	{"TLS_AUTOCERT_DIR", "where certificates from Let's Encrypt are cached", false, func(c *Config, v string) error { c.TLSAutocertDir = v; return nil }},
+	{"TLS_CLIENT_CA", "PEM CAs client certificates must be signed by", false, func(c *Config, v string) error { c.TLSClientCA = v; return nil }},
+	{"CLIENT_CERTS", "client certificate common names mapped to callers like API_KEYS", false, func(c *Config, v string) error { c.ClientCerts = v; return nil }},
+	{"INTEREST_RATE", "what savings accounts earn per period, like 0.004", false, func(c *Config, v string) error { c.InterestRate = v; return nil }},
+	{"INTEREST_PERIOD", "daily or monthly, monthly by default", false, func(c *Config, v string) error { c.InterestPeriod = v; return nil }},
+	{"SEED_ACCOUNTS", "the accounts a fresh store opens with, like alice=100,bob=50.25", false, func(c *Config, v string) error { c.SeedAccounts = v; return nil }},
+	{"CHAOS", "store faults to inject, like latency=100ms,errors=0.1", false, func(c *Config, v string) error { c.Chaos = v; return nil }},
+}
+
+// the flag of the environment variable name
+func flagName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+}
+
+func setDuration(d *Duration, v string) error {
+	p, err := time.ParseDuration(v)
+	if err != nil {
+		return errors.New("must be a duration like 30s")
+	}
+	*d = Duration(p)
+	return nil
+}
+
+func setFloat(f *float64, v string) error {
+	p, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return errors.New("must be a number")
+	}
+	*f = p
+	return nil
+}
+
+func setBool(b *bool, v string) error {
+	p, err := strconv.ParseBool(v)
+	if err != nil {
+		return errors.New("must be true or false")
+	}
+	*b = p
+	return nil
+}
+
+// Load reads the settings for a process started with args, the first
+// being the program name, and getenv to look up the environment
+func Load(args []string, getenv func(string) string) (Config, error) {
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	file := fs.String("config", "", "JSON file with the settings, also CONFIG_FILE")
+	// flags are applied once the file and the environment are, in the
+	// order given. only flags on the command line override, zero defaults
+	// would otherwise always win
+	type given struct {
+		flag, value string
+		set         func(c *Config, v string) error
+	}
+	var flags []given
+	for _, e := range envVars {
+		if e.usage == "" {
+			continue
+		}
+		names := []string{flagName(e.name)}
+		if e.name == "RESTORE_FROM" {
+			names = append(names, "restore")
+		}
+		for _, name := range names {
+			record := func(v string) error {
+				flags = append(flags, given{name, v, e.set})
+				return nil
+			}
+			usage := e.usage + ", also " + e.name
+			if e.boolean {
+				fs.BoolFunc(name, usage, record)
+			} else {
+				fs.Func(name, usage, record)
+			}
+		}
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return Config{}, err
+	}
+
+	c := Default()
+	path := *file
+	if path == "" {
+		path = getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := c.readFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	var errs []error
+	for _, e := range envVars {
+		if v := getenv(e.name); v != "" {
+			if err := e.set(&c, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", e.name, err))
+			}
+		}
+	}
+	for _, f := range flags {
+		if err := f.set(&c, f.value); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", f.flag, err))
+		}
+	}
+	errs = append(errs, c.Validate())
+	return c, errors.Join(errs...)
+}
+
+// overlays the settings in the JSON file at path, keys it doesn't know
+// are an error so a typo doesn't go unnoticed
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every setting that can't work, joined into one error
+func (c Config) Validate() error {
+	var errs []error
+	switch c.Store {
+	case "", "memory", "sqlite", "eventlog":
+	default:
+		errs = append(errs, fmt.Errorf("store: unknown backend %q, use memory, sqlite or eventlog", c.Store))
+	}
+	if c.Addr == "" {
+		errs = append(errs, errors.New("addr: must not be empty"))
+	}
+	if c.ShutdownTimeout < 0 {
+		errs = append(errs, errors.New("shutdown_timeout: must not be negative"))
+	}
+	if c.HandlerTimeout < 0 {
+		errs = append(errs, errors.New("handler_timeout: must not be negative"))
+	}
+	if c.IdempotencyTTL <= 0 {
+		errs = append(errs, errors.New("idempotency_ttl: must be positive"))
+	}
+	if c.RateLimit < 0 {
+		errs = append(errs, errors.New("rate_limit: must not be negative"))
+	}
+	if c.KeyRateLimit < 0 {
+		errs = append(errs, errors.New("key_rate_limit: must not be negative"))
+	}
+	if c.FXRates != "" && c.FXRatesURL != "" {
+		errs = append(errs, errors.New("fx_rates and fx_rates_url: set one of them, not both"))
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, errors.New("tls_cert and tls_key: set both or neither"))
+	}
+	if c.TLSAutocert != "" && c.TLSCert != "" {
+		errs = append(errs, errors.New("tls_autocert and tls_cert: set one of them, not both"))
+	}
+	if c.TLSAutocert != "" && c.TLSAutocertDir == "" {
+		errs = append(errs, errors.New("tls_autocert_dir: must be set with tls_autocert, or every restart asks Let's Encrypt again"))
+	}
+	if c.TLSClientCA != "" && c.TLSCert == "" && c.TLSAutocert == "" {
+		errs = append(errs, errors.New("tls_client_ca: client certificates need tls_cert or tls_autocert"))
+	}
+	if c.ClientCerts != "" && c.TLSClientCA == "" {
+		errs = append(errs, errors.New("client_certs: needs tls_client_ca to verify the certificates against"))
+	}
+	switch c.InterestPeriod {
+	case "daily", "monthly":
+	default:
+		errs = append(errs, fmt.Errorf("interest_period: unknown period %q, use daily or monthly", c.InterestPeriod))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a getenv reading from m
+func env(m map[string]string) func(string) string {
+	return func(k string) string { return m[k] }
+}
+
+func TestLoadDefaults(t *testing.T) {
+	c, err := Load([]string{"api"}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != Default() {
+		t.Errorf("expected the defaults, got %+v", c)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	file := `{"addr": ":7000", "grpc_addr": ":7001", "store": "sqlite", "store_path": "file.db", "rate_limit": 5, "shutdown_timeout": "5s", "frozen_accepts_credits": false}`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load([]string{"api", "-config", path, "-addr", ":9000", "-restore", "flag.json"}, env(map[string]string{
+		"GRPC_ADDR":    ":8001",
+		"SQLITE_PATH":  "old.db",
+		"STORE_PATH":   "new.db",
+		"SNAPSHOT_DIR": "/backups",
+		"RESTORE_FROM": "env.json",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flag over env over file over default
+	if c.Addr != ":9000" || c.GRPCAddr != ":8001" || c.Store != "sqlite" || c.StorePath != "new.db" {
+		t.Errorf("unexpected precedence, got %+v", c)
+	}
+	if c.RateLimit != 5 || time.Duration(c.ShutdownTimeout) != 5*time.Second || c.FrozenAcceptsCredits {
+		t.Errorf("expected the file settings, got %+v", c)
+	}
+	if c.SnapshotDir != "/backups" || c.RestoreFrom != "flag.json" {
+		t.Errorf("expected the snapshot settings, got %+v", c)
+	}
+	if time.Duration(c.IdempotencyTTL) != 24*time.Hour {
+		t.Errorf("expected the default TTL to stay, got %v", time.Duration(c.IdempotencyTTL))
+	}
+
+	c, err = Load([]string{"api"}, env(map[string]string{"CONFIG_FILE": path}))
+	if err != nil || c.Addr != ":7000" {
+		t.Errorf("expected CONFIG_FILE to be read, got %+v %v", c, err)
+	}
+}
+
+// every setting has a flag, and it beats both the file and the
+// environment
+func TestFlagsOverrideEverything(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	file := `{"store": "memory", "store_path": "file.db", "daily_transfer_limit": "100", "schedule_path": "file.json", "audit_log": "file.jsonl", "frozen_accepts_credits": true}`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load([]string{"api", "-config", path,
+		"-store", "sqlite", "-store-path", "flag.db", "-daily-transfer-limit", "300",
+		"-schedule-path", "flag.json", "-audit-log", "flag.jsonl", "-frozen-accepts-credits=false", "-shutdown-timeout", "3s",
+	}, env(map[string]string{
+		"STORE_PATH":             "env.db",
+		"DAILY_TRANSFER_LIMIT":   "200",
+		"AUDIT_LOG":              "env.jsonl",
+		"FROZEN_ACCEPTS_CREDITS": "true",
+		"SHUTDOWN_TIMEOUT":       "2s",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Store != "sqlite" || c.StorePath != "flag.db" || c.DailyTransferLimit != "300" || c.SchedulePath != "flag.json" ||
+		c.AuditLog != "flag.jsonl" || c.FrozenAcceptsCredits || time.Duration(c.ShutdownTimeout) != 3*time.Second {
+		t.Errorf("expected the flags to win, got %+v", c)
+	}
+
+	fs := map[string]bool{}
+	for _, e := range envVars {
+		if e.usage != "" {
+			fs[flagName(e.name)] = true
+		}
+	}
+	for _, want := range []string{"store", "store-path", "rate-limit", "api-keys", "interest-rate", "chaos", "restore-from"} {
+		if !fs[want] {
+			t.Errorf("expected a -%s flag", want)
+		}
+	}
+	if _, err := Load([]string{"api", "-rate-limit", "fast"}, env(nil)); err == nil || !strings.Contains(err.Error(), "-rate-limit") {
+		t.Errorf("expected a bad flag value to be reported, got %v", err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	typo := filepath.Join(dir, "typo.json")
+	os.WriteFile(typo, []byte(`{"adr": ":7000"}`), 0o600)
+	if _, err := Load([]string{"api", "-config", typo}, env(nil)); err == nil || !strings.Contains(err.Error(), "adr") {
+		t.Errorf("expected an unknown key to be reported, got %v", err)
+	}
+	if _, err := Load([]string{"api", "-config", filepath.Join(dir, "missing.json")}, env(nil)); err == nil {
+		t.Error("expected a missing file to be an error")
+	}
+
+	// every problem is reported, not only the first
+	_, err := Load([]string{"api"}, env(map[string]string{
+		"STORE":           "redis",
+		"RATE_LIMIT":      "fast",
+		"IDEMPOTENCY_TTL": "0s",
+		"FX_RATES":        "EUR/USD=1.1",
+		"FX_RATES_URL":    "http://rates",
+		"TLS_KEY":         "server.key",
+		"CLIENT_CERTS":    "ops=ops:admin",
+		"INTEREST_PERIOD": "weekly",
+	}))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"store", "RATE_LIMIT", "idempotency_ttl", "fx_rates", "tls_cert", "client_certs", "interest_period"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %v", want, err)
+		}
+	}
+}
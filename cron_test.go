@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2024, 6, 5, 10, 7, 30, 0, time.UTC)
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 6, 5, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 6, 5, 10, 15, 0, 0, time.UTC)},
+		{"0 9 * * *", time.Date(2024, 6, 6, 9, 0, 0, 0, time.UTC)},
+		{"30 9-17 * * 1-5", time.Date(2024, 6, 5, 10, 30, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2024, 6, 9, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 6, 9, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1,15 * *", time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// either day field matching is enough when both are set
+		{"0 0 20 * 5", time.Date(2024, 6, 7, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		spec, err := parseCron(c.expr)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		if got, ok := spec.next(from); !ok || !got.Equal(c.want) {
+			t.Errorf("%s: expected %v, got %v", c.expr, c.want, got)
+		}
+	}
+
+	spec, _ := parseCron("0 0 30 2 *")
+	if _, ok := spec.next(from); ok {
+		t.Error("expected February 30th never to match")
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
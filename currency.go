@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrNoRate = errors.New("no exchange rate available")
+
+// RateProvider quotes how many units of one currency a unit of another
+// buys. Rates are exact rationals so converting doesn't add float drift.
+// ctx is the request asking, providers that call out pass its trace on
+type RateProvider interface {
+	Rate(ctx context.Context, from, to string) (*big.Rat, error)
+}
+
+// provider used by POST /convert. FX_RATES_URL selects an HTTP provider,
+// otherwise FX_RATES feeds a static table
+var rates RateProvider = staticRates{}
+
+// models the JSON body for POST /convert, amount is in the sending
+// account's currency
+type convertRequest struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount Money  `json:"amount"`
+}
+
+// reports whether code looks like an ISO 4217 currency code
+func validCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// staticRates is a fixed table keyed "FROM/TO". the reverse direction
+// is derived when only one side is listed
+type staticRates map[string]*big.Rat
+
+// parses FX_RATES, a comma separated list like "USD/EUR=0.92,USD/GBP=0.79"
+func parseRates(s string) (staticRates, error) {
+	table := make(staticRates)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pair, val, ok := strings.Cut(entry, "=")
+		from, to, okPair := strings.Cut(pair, "/")
+		r, okRat := new(big.Rat).SetString(val)
+		if !ok || !okPair || !okRat || !validCurrency(from) || !validCurrency(to) || r.Sign() <= 0 {
+			return nil, fmt.Errorf("malformed rate %q", entry)
+		}
+		table[from+"/"+to] = r
+	}
+	return table, nil
+}
+
+func (t staticRates) Rate(_ context.Context, from, to string) (*big.Rat, error) {
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+	if r, ok := t[from+"/"+to]; ok {
+		return r, nil
+	}
+	if r, ok := t[to+"/"+from]; ok {
+		return new(big.Rat).Inv(r), nil
+	}
+	return nil, ErrNoRate
+}
+
+// httpRates asks a rates service, GET {url}?from=USD&to=EUR is expected
+// to answer {"rate": 0.92}
+type httpRates struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPRates(url string) *httpRates {
+	return &httpRates{url: url, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (p *httpRates) Rate(ctx context.Context, from, to string) (*big.Rat, error) {
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url+"?"+url.Values{"from": {from}, "to": {to}}.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetch rate: %w", err)
+	}
+	req, end := traceOutgoing(req, "fetch rate")
+	resp, err := p.client.Do(req)
+	end(resp, err)
+	if err != nil {
+		return nil, fmt.Errorf("fetch rate: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNoRate
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch rate: %s", resp.Status)
+	}
+	var body struct {
+		Rate json.Number `json:"rate"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode rate: %w", err)
+	}
+	r, ok := new(big.Rat).SetString(body.Rate.String())
+	if !ok || r.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid rate %q", body.Rate)
+	}
+	return r, nil
+}
+
+// converts amount at rate, rounding half away from zero to a minor unit
+func convert(amount Money, rate *big.Rat) Money {
+	return roundMoney(new(big.Rat).Mul(new(big.Rat).SetInt64(int64(amount)), rate))
+}
+
+// rounds v, in minor units, half away from zero to a whole one
+func roundMoney(v *big.Rat) Money {
+	// adding a half before truncating rounds positive amounts
+	v = new(big.Rat).Add(v, big.NewRat(1, 2))
+	q := new(big.Int).Quo(v.Num(), v.Denom())
+	return Money(q.Int64())
+}
+
+// handles POST /convert, moving money between accounts holding
+// different currencies at the provider's current rate
+func convertHandler(w http.ResponseWriter, r *http.Request) {
+	var req convertRequest
+	if !decodeBody(w, r, "ConvertRequest", &req) {
+		return
+	}
+	if req.Amount <= 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidAmount, "amount must be positive")
+		return
+	}
+	if !mayDebit(r.Context(), req.From) {
+		forbidden(w)
+		return
+	}
+	if err := checkCredit(r.Context(), req.To); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	src, err := storeFor(r.Context()).Get(req.From)
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeAccountNotFound, "account not found")
+		return
+	}
+	dst, err := storeFor(r.Context()).Get(req.To)
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeAccountNotFound, "account not found")
+		return
+	}
+	rate, err := rates.Rate(r.Context(), src.Currency, dst.Currency)
+	if errors.Is(err, ErrNoRate) {
+		writeError(w, http.StatusUnprocessableEntity, codeNoExchangeRate, "no exchange rate for "+src.Currency+"/"+dst.Currency)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, codeRateUnavailable, "exchange rate unavailable")
+		return
+	}
+	credited := convert(req.Amount, rate)
+	if credited <= 0 {
+		writeError(w, http.StatusUnprocessableEntity, codeAmountTooSmall, "amount too small to convert")
+		return
+	}
+	undo, err := reserveLimit(r.Context(), req.From, req.Amount)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	entry := ledgerEntry{
+		From:       req.From,
+		To:         req.To,
+		Amount:     req.Amount,
+		Currency:   src.Currency,
+		ToAmount:   credited,
+		ToCurrency: dst.Currency,
+		Status:     statusCompleted,
+	}
+	booked, err := book(r.Context(), func(s Store) error {
+		return s.Exchange(req.From, req.To, req.Amount, credited)
+	}, entry)
+	if err != nil {
+		entry.Status = statusFailed
+		record(r.Context(), entry)
+		undo()
+	}
+	if errors.Is(err, ErrInsufficientFunds) {
+		writeError(w, http.StatusUnprocessableEntity, codeInsufficientFunds, "insufficient funds")
+		return
+	}
+	if errors.Is(err, ErrAccountFrozen) {
+		writeServiceError(w, frozen(req.From))
+		return
+	}
+	if errors.Is(err, ErrAccountClosed) {
+		writeServiceError(w, closed(req.From))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "conversion failed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":   "ok",
+		"id":       booked[0].ID,
+		"debited":  req.Amount,
+		"credited": credited,
+		"rate":     rate.FloatString(6),
+	})
+}
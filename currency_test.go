@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100)})
+	store.Create(Account{ID: "alice-eur", Currency: "EUR"})
+	table, err := parseRates("USD/EUR=0.92")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rates = table
+	defer func() { rates = staticRates{} }()
+
+	// plain transfers across currencies are refused
+	w := httptest.NewRecorder()
+	transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"alice","to":"alice-eur","amount":10}`)))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for cross currency transfer, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	convertHandler(w, httptest.NewRequest("POST", "/convert", strings.NewReader(`{"from":"alice","to":"alice-eur","amount":10}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if alice, eur := balance(t, "alice"), balance(t, "alice-eur"); alice != units(90) || eur != 920 {
+		t.Errorf("expected alice=90.00 alice-eur=9.20, got %v and %v", alice, eur)
+	}
+
+	// inverse of the listed rate, 1 / 0.92 rounds to 1.09
+	w = httptest.NewRecorder()
+	convertHandler(w, httptest.NewRequest("POST", "/convert", strings.NewReader(`{"from":"alice-eur","to":"alice","amount":1}`)))
+	var resp struct{ Credited Money }
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.Credited != 109 {
+		t.Errorf("expected 1.09 credited, got %d %v", w.Code, resp.Credited)
+	}
+}
+
+func TestHTTPRates(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("from") != "GBP" || r.URL.Query().Get("to") != "USD" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"rate": 1.25}`))
+	}))
+	defer srv.Close()
+
+	p := newHTTPRates(srv.URL)
+	r, err := p.Rate(context.Background(), "GBP", "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := convert(units(2), r); got != 250 {
+		t.Errorf("expected 2.50, got %v", got)
+	}
+	if _, err := p.Rate(context.Background(), "USD", "JPY"); err != ErrNoRate {
+		t.Errorf("expected ErrNoRate, got %v", err)
+	}
+}
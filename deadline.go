@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// every request runs under a deadline, HANDLER_TIMEOUT or the shorter one
+// a client asks for with X-Request-Timeout. the deadline is on the ctx
+// handlers pass down, so a store call still running when it passes is
+// cancelled, and a request answering with a server error by then gets
+// 504 TIMEOUT instead. store calls are atomic, one cut short moved no
+// money, and nothing is undone for a request that timed out after its
+// money moved. gRPC calls carry their own deadline, HANDLER_TIMEOUT caps
+// it the same way
+
+// the longest a request may run, 0 leaves requests without a deadline
+// unless they ask for one
+var handlerTimeout time.Duration
+
+const requestTimeoutHeader = "X-Request-Timeout"
+
+// the deadline of a request asking for wanted, 0 when it asked for none
+func requestTimeout(wanted time.Duration) time.Duration {
+	if handlerTimeout > 0 && (wanted <= 0 || wanted > handlerTimeout) {
+		return handlerTimeout
+	}
+	return wanted
+}
+
+// wraps a handler so it runs under its request's deadline
+func withDeadline(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var wanted time.Duration
+		if v := r.Header.Get(requestTimeoutHeader); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, requestTimeoutHeader+" must be a positive duration like 5s")
+				return
+			}
+			wanted = d
+		}
+		d := requestTimeout(wanted)
+		if d == 0 {
+			next(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next(&deadlineWriter{ResponseWriter: w, ctx: ctx}, r.WithContext(ctx))
+	}
+}
+
+// deadlineWriter turns the server error a handler answers with once its
+// deadline passed into 504 TIMEOUT, whatever failed did so because of it
+type deadlineWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	timedOut bool
+}
+
+func (w *deadlineWriter) WriteHeader(code int) {
+	if code >= http.StatusInternalServerError && errors.Is(w.ctx.Err(), context.DeadlineExceeded) {
+		w.timedOut = true
+		writeError(w.ResponseWriter, http.StatusGatewayTimeout, codeTimeout, "the request did not finish within its deadline")
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// drops the body of the error replaced by the timeout
+func (w *deadlineWriter) Write(b []byte) (int, error) {
+	if w.timedOut {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// caps the deadline of a unary call at handlerTimeout and reports an
+// internal error it ended with past its deadline as DeadlineExceeded
+func grpcDeadlineUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+	var wanted time.Duration
+	if dl, ok := ctx.Deadline(); ok {
+		wanted = time.Until(dl)
+	}
+	if d := requestTimeout(wanted); d > 0 && d != wanted {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	resp, err := next(ctx, req)
+	if status.Code(err) == codes.Internal && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, grpcError(failure(codeTimeout, "the call did not finish within its deadline"))
+	}
+	return resp, err
+}
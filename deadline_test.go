@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	defer func() { handlerTimeout = 0 }()
+	for _, c := range []struct{ max, wanted, want time.Duration }{
+		{0, 0, 0},
+		{0, time.Second, time.Second},
+		{10 * time.Second, 0, 10 * time.Second},
+		{10 * time.Second, time.Second, time.Second},
+		// asking for more than the maximum gets the maximum
+		{10 * time.Second, time.Minute, 10 * time.Second},
+	} {
+		handlerTimeout = c.max
+		if got := requestTimeout(c.wanted); got != c.want {
+			t.Errorf("max %v, wanted %v: expected %v, got %v", c.max, c.wanted, c.want, got)
+		}
+	}
+}
+
+func TestDeadlineAnswers504(t *testing.T) {
+	handlerTimeout = 20 * time.Millisecond
+	defer func() { handlerTimeout = 0 }()
+	slow := withDeadline(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not read balance")
+	})
+	w := httptest.NewRecorder()
+	slow(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusGatewayTimeout || decodeError(t, w).Code != codeTimeout {
+		t.Errorf("expected 504 %s, got %d %s", codeTimeout, w.Code, w.Body)
+	}
+
+	// answers that aren't server errors are left alone, even past the deadline
+	late := withDeadline(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		writeError(w, http.StatusNotFound, codeAccountNotFound, "account not found")
+	})
+	w = httptest.NewRecorder()
+	late(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected the 404 through, got %d", w.Code)
+	}
+
+	for _, v := range []string{"soon", "0s", "-1s"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(requestTimeoutHeader, v)
+		w := httptest.NewRecorder()
+		slow(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", v, w.Code)
+		}
+	}
+}
+
+// a transfer whose deadline passes before sqlite gets to it moves nothing
+func TestDeadlineCancelsStore(t *testing.T) {
+	s, err := openStore("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store = s
+	defer func() {
+		closeStore()
+		store = newMemoryStore(seedBalances())
+	}()
+	if err := loadLedger(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := storeFor(ctx).Transfer("alice", "bob", units(10)); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the transfer cancelled, got %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/v1/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":10}`))
+	req.Header.Set(requestTimeoutHeader, "1ns")
+	w := httptest.NewRecorder()
+	serveAPI(w, req)
+	if w.Code != http.StatusGatewayTimeout || decodeError(t, w).Code != codeTimeout {
+		t.Errorf("expected 504 %s, got %d %s", codeTimeout, w.Code, w.Body)
+	}
+	if a := balance(t, "alice"); a != units(100) {
+		t.Errorf("expected nothing to move, alice has %v", a)
+	}
+	// without a deadline in the way it goes through
+	transferID(t, `{"from":"alice","to":"bob","amount":10}`)
+}
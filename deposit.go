@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// models the JSON body for POST /accounts/{id}/deposit and /withdraw
+type cashRequest struct {
+	Amount Money `json:"amount"`
+}
+
+// serves POST /accounts/{account}/deposit, money entering the system
+func depositHandler(w http.ResponseWriter, r *http.Request) {
+	cashHandler(w, r, r.PathValue("account"), true)
+}
+
+// serves POST /accounts/{account}/withdraw, money leaving the system
+func withdrawHandler(w http.ResponseWriter, r *http.Request) {
+	cashHandler(w, r, r.PathValue("account"), false)
+}
+
+// shared by deposits and withdrawals, which only differ in direction.
+// both are validated and recorded in the ledger like transfers
+func cashHandler(w http.ResponseWriter, r *http.Request, account string, deposit bool) {
+	var req cashRequest
+	if !decodeBody(w, r, "CashRequest", &req) {
+		return
+	}
+	// If-Match only guards withdrawals, adding money needs no
+	// read-check-write
+	version, ok := ifMatch(r)
+	if !ok && !deposit {
+		badPrecondition(w)
+		return
+	}
+	if req.Amount <= 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidAmount, "amount must be positive")
+		return
+	}
+	// admins may deposit into any account, only owners may withdraw
+	if !mayDebit(r.Context(), account) && !(deposit && isAdmin(r.Context())) {
+		forbidden(w)
+		return
+	}
+
+	acct, err := storeFor(r.Context()).Get(account)
+	if errors.Is(err, ErrAccountNotFound) {
+		writeError(w, http.StatusNotFound, codeAccountNotFound, "account not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not read account")
+		return
+	}
+
+	// withdrawals count towards the limits like any money leaving
+	undo := func() {}
+	if deposit {
+		if err := checkCredit(r.Context(), account); err != nil {
+			writeServiceError(w, err)
+			return
+		}
+	} else if undo, err = reserveLimit(r.Context(), account, req.Amount); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	entry := ledgerEntry{Amount: req.Amount, Currency: acct.Currency, Status: statusCompleted}
+	change := func(s Store) error { return s.DebitIf(account, req.Amount, version) }
+	if deposit {
+		entry.To = account
+		change = func(s Store) error { return s.Credit(account, req.Amount) }
+	} else {
+		entry.From = account
+	}
+	booked, err := book(r.Context(), change, entry)
+	if err != nil {
+		entry.Status = statusFailed
+		record(r.Context(), entry)
+		undo()
+	}
+	switch {
+	case errors.Is(err, ErrAccountFrozen):
+		writeServiceError(w, frozen(account))
+		return
+	case errors.Is(err, ErrAccountClosed):
+		writeServiceError(w, closed(account))
+		return
+	case errors.Is(err, ErrVersionMismatch):
+		writeServiceError(w, versionMismatch(r.Context(), account))
+		return
+	case errors.Is(err, ErrInsufficientFunds):
+		writeError(w, http.StatusUnprocessableEntity, codeInsufficientFunds, "insufficient funds")
+		return
+	case errors.Is(err, ErrAccountNotFound):
+		// closed between the lookup and the debit
+		writeError(w, http.StatusNotFound, codeAccountNotFound, "account not found")
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not update balance")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"status": "ok", "id": %d}`, booked[0].ID)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDepositWithdraw(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(10)})
+	if err := resetLedger(); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		path, body string
+		code       int
+	}{
+		{"/v1/accounts/alice/deposit", `{"amount":5.5}`, http.StatusOK},
+		{"/v1/accounts/alice/withdraw", `{"amount":15}`, http.StatusOK},
+		{"/v1/accounts/alice/withdraw", `{"amount":1}`, http.StatusUnprocessableEntity},
+		{"/v1/accounts/alice/deposit", `{"amount":0}`, http.StatusBadRequest},
+		{"/v1/accounts/alice/deposit", `{"amount":1.005}`, http.StatusBadRequest},
+		{"/v1/accounts/nobody/deposit", `{"amount":1}`, http.StatusNotFound},
+	}
+	for _, s := range steps {
+		w := httptest.NewRecorder()
+		serveAPI(w, httptest.NewRequest("POST", s.path, strings.NewReader(s.body)))
+		if w.Code != s.code {
+			t.Errorf("%s %s: expected %d, got %d", s.path, s.body, s.code, w.Code)
+		}
+	}
+	if got := balance(t, "alice"); got != 50 {
+		t.Errorf("expected alice to have 0.50, got %v", got)
+	}
+
+	list := entries(t.Context(), "alice")
+	if len(list) != 3 {
+		t.Fatalf("expected 3 ledger entries, got %+v", list)
+	}
+	if list[0].From != "" || list[1].To != "" || list[2].Status != statusFailed {
+		t.Errorf("unexpected ledger %+v", list)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Error codes returned in the "code" field of every error response. They
+// are part of the API, clients branch on them so existing codes must
+// never change meaning. The HTTP status each one comes with is listed
+// next to it.
+const (
+	// 400, the body is not valid JSON or has the wrong shape
+	codeInvalidJSON = "INVALID_JSON"
+	// 400, the body doesn't match its schema in openapi.json, details
+	// lists every field that is off
+	codeValidationFailed = "VALIDATION_FAILED"
+	// 400, a required field is missing or malformed
+	codeInvalidRequest = "INVALID_REQUEST"
+	// 400, an amount is not positive, has more than 2 decimal places or
+	// is out of range
+	codeInvalidAmount = "INVALID_AMOUNT"
+	// 400, an account id is not 1 to 64 letters, digits, '.', '_' or '-'
+	// starting with a letter or digit, or is reserved
+	codeInvalidAccountID = "INVALID_ACCOUNT_ID"
+	// 400, a currency is not a 3 letter ISO 4217 code
+	codeInvalidCurrency = "INVALID_CURRENCY"
+	// 400, a timestamp query parameter is not RFC 3339
+	codeInvalidTimestamp = "INVALID_TIMESTAMP"
+	// 400, limit or offset is not a non-negative integer
+	codeInvalidPagination = "INVALID_PAGINATION"
+	// 400, a callback carries an event this service doesn't handle
+	codeUnsupportedEvent = "UNSUPPORTED_EVENT"
+	// 400, a callback's created_at is too far from our clock
+	codeStaleCallback = "STALE_CALLBACK"
+	// 401, no API key or an unknown one
+	codeUnauthorized = "UNAUTHORIZED"
+	// 401, a callback signature is missing or doesn't match
+	codeInvalidSignature = "INVALID_SIGNATURE"
+	// 403, the caller may not act on this account
+	codeForbidden = "FORBIDDEN"
+	// 404, the account doesn't exist (or didn't at the requested time)
+	codeAccountNotFound = "ACCOUNT_NOT_FOUND"
+	// 404, no ledger entry with this id
+	codeTransactionNotFound = "TRANSACTION_NOT_FOUND"
+	// 404, no such route
+	codeNotFound = "NOT_FOUND"
+	// 405, the route exists but not for this method
+	codeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	// 409, an account with this id already exists
+	codeAccountExists = "ACCOUNT_EXISTS"
+	// 409, an account can only be closed once its balance is zero
+	codeAccountNotEmpty = "ACCOUNT_NOT_EMPTY"
+	// 409, a callback with this id was already applied
+	codeDuplicateCallback = "DUPLICATE_CALLBACK"
+	// 404, no hold with this id
+	codeHoldNotFound = "HOLD_NOT_FOUND"
+	// 409, the hold was already captured, released or has expired
+	codeHoldNotActive = "HOLD_NOT_ACTIVE"
+	// 404, no scheduled transfer with this id
+	codeScheduleNotFound = "SCHEDULE_NOT_FOUND"
+	// 409, the scheduled transfer already ran for good or was cancelled
+	codeScheduleNotActive = "SCHEDULE_NOT_ACTIVE"
+	// 409, the transaction was reversed before, details has the reversal
+	codeAlreadyReversed = "ALREADY_REVERSED"
+	// 409, the transaction was already approved or denied, or was never
+	// flagged for review
+	codeNotPendingReview = "NOT_PENDING_REVIEW"
+	// 409, a request with this Idempotency-Key is still running
+	codeIdempotencyKeyInUse = "IDEMPOTENCY_KEY_IN_USE"
+	// 412, the account's version no longer matches If-Match, details
+	// has the current ETag
+	codePreconditionFailed = "PRECONDITION_FAILED"
+	// 422, the account is frozen, nothing may leave it (nor, depending on
+	// FROZEN_ACCEPTS_CREDITS, reach it)
+	codeAccountFrozen = "ACCOUNT_FROZEN"
+	// 422, the account was closed, nothing moves in or out of it
+	codeAccountClosed = "ACCOUNT_CLOSED"
+	// 422, the sending account can't cover the amount
+	codeInsufficientFunds = "INSUFFICIENT_FUNDS"
+	// 422, a transfer's from and to are the same account
+	codeSameAccount = "SAME_ACCOUNT"
+	// 422, the account a transfer pays doesn't exist, transfers don't
+	// open accounts
+	codeRecipientNotFound = "RECIPIENT_NOT_FOUND"
+	// 422, the transfer is under MIN_TRANSFER_AMOUNT, details has it
+	codeAmountBelowMinimum = "AMOUNT_BELOW_MINIMUM"
+	// 422, the accounts hold different currencies, use /convert
+	codeCurrencyMismatch = "CURRENCY_MISMATCH"
+	// 422, no exchange rate is known for the currency pair
+	codeNoExchangeRate = "NO_EXCHANGE_RATE"
+	// 422, the converted amount rounds down to nothing
+	codeAmountTooSmall = "AMOUNT_TOO_SMALL"
+	// 422, an Idempotency-Key was reused with a different body
+	codeIdempotencyKeyReused = "IDEMPOTENCY_KEY_REUSED"
+	// 422, an item of a batch failed so none were applied, details
+	// carries the per item results
+	codeBatchFailed = "BATCH_FAILED"
+	// 422, only completed transfers and conversions can be reversed
+	codeNotReversible = "NOT_REVERSIBLE"
+	// 422, the transfer is over the per transfer or the daily limit,
+	// details says which and when the daily one resets
+	codeLimitExceeded = "LIMIT_EXCEEDED"
+	// 422, a risk rule rejected the transfer, details says which and why
+	codeRiskRejected = "RISK_REJECTED"
+	// 422, a snapshot to restore is of an unknown format or its balances
+	// don't add up to its ledger
+	codeInvalidSnapshot = "INVALID_SNAPSHOT"
+	// 429, too many requests, wait Retry-After seconds
+	codeRateLimited = "RATE_LIMITED"
+	// 502, the exchange rate provider could not be reached
+	codeRateUnavailable = "RATE_PROVIDER_UNAVAILABLE"
+	// 502, a snapshot could not be written to or read from where it was
+	// asked to
+	codeSnapshotStorage = "SNAPSHOT_STORAGE_FAILED"
+	// 504, the request ran past its deadline, HANDLER_TIMEOUT or the
+	// X-Request-Timeout it asked for, and was given up
+	codeTimeout = "TIMEOUT"
+	// 500, something went wrong on our side, retrying may help
+	codeInternal = "INTERNAL_ERROR"
+)
+
+// the HTTP status of each code a *serviceError can carry
+var httpStatus = map[string]int{
+	codeInvalidAmount:       http.StatusBadRequest,
+	codeInvalidRequest:      http.StatusBadRequest,
+	codeInvalidAccountID:    http.StatusBadRequest,
+	codeInvalidPagination:   http.StatusBadRequest,
+	codeInvalidTimestamp:    http.StatusBadRequest,
+	codeForbidden:           http.StatusForbidden,
+	codeAccountNotFound:     http.StatusNotFound,
+	codePreconditionFailed:  http.StatusPreconditionFailed,
+	codeInsufficientFunds:   http.StatusUnprocessableEntity,
+	codeCurrencyMismatch:    http.StatusUnprocessableEntity,
+	codeSameAccount:         http.StatusUnprocessableEntity,
+	codeRecipientNotFound:   http.StatusUnprocessableEntity,
+	codeAmountBelowMinimum:  http.StatusUnprocessableEntity,
+	codeAccountFrozen:       http.StatusUnprocessableEntity,
+	codeAccountClosed:       http.StatusUnprocessableEntity,
+	codeHoldNotActive:       http.StatusConflict,
+	codeTransactionNotFound: http.StatusNotFound,
+	codeAlreadyReversed:     http.StatusConflict,
+	codeNotReversible:       http.StatusUnprocessableEntity,
+	codeLimitExceeded:       http.StatusUnprocessableEntity,
+	codeRiskRejected:        http.StatusUnprocessableEntity,
+	codeNotPendingReview:    http.StatusConflict,
+	codeTimeout:             http.StatusGatewayTimeout,
+	codeInternal:            http.StatusInternalServerError,
+}
+
+// the envelope every error response is wrapped in:
+// {"error":{"code":"INSUFFICIENT_FUNDS","message":"...","details":{...}}}
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// writes an error response without details
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeErrorDetails(w, status, code, message, nil)
+}
+
+// writes an error response, details is marshaled as is
+func writeErrorDetails(w http.ResponseWriter, status int, code, message string, details any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: errorBody{Code: code, Message: message, Details: details}})
+}
+
+// writes err, a *serviceError when it comes from the service layer,
+// anything else is reported as an internal error
+func writeServiceError(w http.ResponseWriter, err error) {
+	var se *serviceError
+	if !errors.As(err, &se) {
+		writeError(w, http.StatusInternalServerError, codeInternal, "internal error")
+		return
+	}
+	status, ok := httpStatus[se.code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	writeErrorDetails(w, status, se.code, se.message, se.details)
+}
+
+// answers a request whose method the route doesn't serve
+func methodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "only "+allowed+" request allowed")
+}
+
+// answers a request for a route that doesn't exist
+func notFound(w http.ResponseWriter) {
+	writeError(w, http.StatusNotFound, codeNotFound, "not found")
+}
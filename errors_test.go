@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// decodes an error envelope, failing the test when the body isn't one
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) errorBody {
+	t.Helper()
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected JSON error, got Content-Type %q", ct)
+	}
+	var resp errorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode error: %v", err)
+	}
+	return resp.Error
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(10), "bob": 0})
+
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		path    string
+		body    string
+		status  int
+		code    string
+	}{
+		{"overdraft", serveAPI, "POST", "/v1/transfer", `{"from":"alice","to":"bob","amount":20}`, http.StatusUnprocessableEntity, codeInsufficientFunds},
+		{"bad json", serveAPI, "POST", "/v1/transfer", `{`, http.StatusBadRequest, codeInvalidJSON},
+		{"precision", serveAPI, "POST", "/v1/transfer", `{"from":"alice","to":"bob","amount":1.005}`, http.StatusBadRequest, codeValidationFailed},
+		{"method", serveAPI, "GET", "/v1/transfer", ``, http.StatusMethodNotAllowed, codeMethodNotAllowed},
+		{"unknown account", serveAPI, "GET", "/v1/accounts/carol/balance", ``, http.StatusNotFound, codeAccountNotFound},
+		{"duplicate account", serveAPI, "POST", "/v1/accounts", `{"id":"alice"}`, http.StatusConflict, codeAccountExists},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c.handler(w, httptest.NewRequest(c.method, c.path, strings.NewReader(c.body)))
+			if w.Code != c.status {
+				t.Fatalf("expected %d, got %d: %s", c.status, w.Code, w.Body)
+			}
+			if e := decodeError(t, w); e.Code != c.code || e.Message == "" {
+				t.Errorf("expected code %s with a message, got %+v", c.code, e)
+			}
+		})
+	}
+}
+
+func TestInsufficientFundsDetails(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(10), "bob": 0})
+
+	w := httptest.NewRecorder()
+	transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":20}`)))
+	details, _ := decodeError(t, w).Details.(map[string]any)
+	if details["account"] != "alice" || details["amount"] != 20.0 {
+		t.Errorf("expected account and amount in details, got %v", details)
+	}
+}
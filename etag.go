@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// the ETag of an account at version, a strong tag since any change to the
+// account moves the version
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// reads the version a request's If-Match header expects. 0 means no
+// condition, either no header or "*". ok is false when the header can't
+// name a version, weak tags included since If-Match compares strongly
+func ifMatch(r *http.Request) (version int64, ok bool) {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" || h == "*" {
+		return 0, true
+	}
+	tag, quoted := strings.CutPrefix(h, `"`)
+	tag, closed := strings.CutSuffix(tag, `"`)
+	if !quoted || !closed {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || v <= 0 {
+		return 0, false
+	}
+	return v, true
+}
+
+// reports whether If-None-Match names the account's current ETag, GETs
+// answer those with 304
+func notModified(r *http.Request, version int64) bool {
+	h := r.Header.Get("If-None-Match")
+	if h == "" {
+		return false
+	}
+	tag := etag(version)
+	for _, t := range strings.Split(h, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// the error for a conditional request whose account moved on, details
+// carry the current ETag so the client can re-read and try again
+func versionMismatch(ctx context.Context, account string) *serviceError {
+	details := map[string]any{"account": account}
+	if acct, err := storeFor(ctx).Get(account); err == nil {
+		details["etag"] = etag(acct.Version)
+	}
+	return &serviceError{
+		code:    codePreconditionFailed,
+		message: "account changed since the ETag in If-Match was read",
+		details: details,
+	}
+}
+
+// writes the 412 for an If-Match header that names no version
+func badPrecondition(w http.ResponseWriter) {
+	writeError(w, http.StatusPreconditionFailed, codePreconditionFailed, `If-Match must be a single ETag like "3" or *`)
+}
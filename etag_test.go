@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func getETag(t *testing.T, account string) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/accounts/"+account+"/balance", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	return w.Header().Get("ETag")
+}
+
+func TestTransferIfMatch(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+
+	tag := getETag(t, "alice")
+	if tag != `"1"` {
+		t.Fatalf(`expected ETag "1", got %q`, tag)
+	}
+	transfer := func(ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":10}`))
+		req.Header.Set("If-Match", ifMatch)
+		w := httptest.NewRecorder()
+		transferHandler(w, req)
+		return w
+	}
+	if w := transfer(tag); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body)
+	}
+
+	// the same read-check-write again is working from a stale read
+	w := transfer(tag)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412, got %d %s", w.Code, w.Body)
+	}
+	e := decodeError(t, w)
+	if e.Code != codePreconditionFailed {
+		t.Errorf("expected %s, got %s", codePreconditionFailed, e.Code)
+	}
+	if d, _ := e.Details.(map[string]any); d["etag"] != `"2"` {
+		t.Errorf(`expected details to carry ETag "2", got %v`, e.Details)
+	}
+	if a := balance(t, "alice"); a != units(90) {
+		t.Errorf("expected the stale transfer not to apply, alice has %v", a)
+	}
+
+	for _, h := range []string{`W/"2"`, "2", `"two"`} {
+		if w := transfer(h); w.Code != http.StatusPreconditionFailed {
+			t.Errorf("%s: expected 412, got %d", h, w.Code)
+		}
+	}
+	if w := transfer("*"); w.Code != http.StatusOK {
+		t.Errorf("expected * to match any version, got %d", w.Code)
+	}
+}
+
+func TestWithdrawIfMatch(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100)})
+	resetLedger()
+
+	withdraw := func(ifMatch string) int {
+		req := httptest.NewRequest("POST", "/v1/accounts/alice/withdraw", strings.NewReader(`{"amount":10}`))
+		req.Header.Set("If-Match", ifMatch)
+		w := httptest.NewRecorder()
+		serveAPI(w, req)
+		return w.Code
+	}
+	tag := getETag(t, "alice")
+	if code := withdraw(tag); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if code := withdraw(tag); code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412, got %d", code)
+	}
+	if code := withdraw(getETag(t, "alice")); code != http.StatusOK {
+		t.Errorf("expected a fresh ETag to work, got %d", code)
+	}
+}
+
+func TestBalanceNotModified(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100)})
+
+	get := func(ifNoneMatch string) int {
+		req := httptest.NewRequest("GET", "/v1/accounts/alice/balance", nil)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		w := httptest.NewRecorder()
+		serveAPI(w, req)
+		return w.Code
+	}
+	tag := getETag(t, "alice")
+	if code := get(tag); code != http.StatusNotModified {
+		t.Errorf("expected 304, got %d", code)
+	}
+	store.Credit("alice", units(1))
+	if code := get(tag); code != http.StatusOK {
+		t.Errorf("expected 200 once the account changed, got %d", code)
+	}
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// kinds of event written to the log
+const (
+	opCreate   = "create"
+	opDelete   = "delete"
+	opDeposit  = "deposit"
+	opWithdraw = "withdraw"
+	opTransfer = "transfer"
+	opBatch    = "batch"
+	opExchange = "exchange"
+	opStatus   = "status"
+	opLimit    = "overdraft"
+	// reservations of holds, opFreeHeld drops them all when the log is
+	// opened since holds don't outlive the process
+	opReserve   = "reserve"
+	opUnreserve = "unreserve"
+	opSettle    = "settle"
+	opFreeHeld  = "free_held"
+	// the ledger kept in the same log, these don't touch any balance.
+	// entries are booked in the event of the change they go with, in one
+	// of opEntries without one. opEntry, opReversed and opUpdated are
+	// only replayed from logs written before that
+	opLedgerOpen = "ledger_open"
+	opEntries    = "entries"
+	opEntry      = "entry"
+	opReversed   = "reversed"
+	opUpdated    = "entry_updated"
+	// a snapshot restored in place of everything before it, balances
+	// and ledger alike
+	opRestore = "restore"
+)
+
+// one line of the event log. only the fields the op needs are set
+type event struct {
+	Seq      int64          `json:"seq"`
+	Time     time.Time      `json:"time"`
+	Op       string         `json:"op"`
+	Account  string         `json:"account,omitempty"`
+	From     string         `json:"from,omitempty"`
+	To       string         `json:"to,omitempty"`
+	Amount   Money          `json:"amount,omitempty"`
+	Credit   Money          `json:"credit,omitempty"`
+	Currency string         `json:"currency,omitempty"`
+	Status   string         `json:"status,omitempty"`
+	Type     string         `json:"type,omitempty"`
+	Limit    Money          `json:"limit,omitempty"`
+	Items    []TransferItem `json:"items,omitempty"`
+	// the version a conditional withdraw or transfer expected, replaying
+	// rebuilds the same versions so the check passes again
+	Version int64 `json:"version,omitempty"`
+	// the entries booked with the event, see Book
+	Entries []ledgerEntry `json:"entries,omitempty"`
+	// the recorded entry for opEntry and opUpdated, for opReversed just
+	// its ID and ReversedBy
+	Entry    *ledgerEntry   `json:"entry,omitempty"`
+	Opening  *ledgerOpening `json:"opening,omitempty"`
+	Snapshot *snapshot      `json:"snapshot,omitempty"`
+}
+
+// eventStore is a memory store whose every change is appended to a log
+// file and fsynced before the caller hears back. balances are rebuilt
+// by replaying the log on open, so nothing acknowledged is lost on
+// restart and the log doubles as a full history of the store.
+//
+// appends are group committed: whoever finds no fsync running becomes
+// the leader and syncs everything written so far, appends arriving in
+// the meantime queue up behind it and are covered by the next fsync. so
+// under load one fsync acknowledges many writers instead of each paying
+// for its own.
+type eventStore struct {
+	*eventLog
+	// set on the view a booking makes its change through, the change is
+	// kept there for Book to log along with the entries
+	change *event
+}
+
+// the log itself, shared by the store and its booking views
+type eventLog struct {
+	inner *memoryStore
+
+	// serializes writers so the log order is the order changes were
+	// applied in, replaying it then reproduces the same state
+	mu  sync.Mutex
+	f   *os.File
+	seq int64
+	// the last seq known to be on disk, written events above it are
+	// waiting for an fsync
+	synced int64
+	// set while a leader runs an fsync with mu released
+	syncing bool
+	// signalled each time a sync finished, with or without success
+	flushed *sync.Cond
+	// flushes the file, f.Sync outside of tests
+	sync func() error
+	// set once an append failed, the store refuses writes after that
+	// because memory is ahead of what is on disk
+	err error
+
+	// the ledger as replayed, handed over by LoadLedger
+	opening ledgerOpening
+	entries []ledgerEntry
+	// how many entries the log holds, the next booked one is numbered after
+	kept int64
+}
+
+// opens the event log at path, replaying whatever it holds. a new or
+// empty log starts out with seed
+func openEventStore(path string, seed map[string]Money) (*eventStore, error) {
+	if path == "" {
+		return nil, errors.New("eventlog store needs a log path")
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &eventStore{eventLog: &eventLog{inner: newMemoryStore(nil), f: f, sync: f.Sync}}
+	s.flushed = sync.NewCond(&s.mu)
+	if err := s.replay(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("replay %s: %w", path, err)
+	}
+	// holds live in memory and are gone now, so is what they reserved.
+	// logged so the next replay frees them at the same point
+	accts, _ := s.inner.All()
+	for _, a := range accts {
+		if a.Held != 0 {
+			if err := s.write(event{Op: opFreeHeld}); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("free reservations: %w", err)
+			}
+			break
+		}
+	}
+	if s.seq == 0 {
+		for acct, bal := range seed {
+			if err := s.Create(Account{ID: acct, Balance: bal, Currency: defaultCurrency}); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("seed accounts: %w", err)
+			}
+		}
+	}
+	return s, nil
+}
+
+// applies every logged event to the empty inner store. a torn final line
+// from a crash mid-append is cut off, anything else unreadable is an error
+func (s *eventStore) replay() error {
+	r := bufio.NewReader(s.f)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				// never fully written, so never acknowledged either
+				if err := s.f.Truncate(offset); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var e event
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fmt.Errorf("event at offset %d: %w", offset, err)
+		}
+		if err := s.apply(e); err != nil {
+			return fmt.Errorf("event %d: %w", e.Seq, err)
+		}
+		if err := s.replayLedger(e); err != nil {
+			return fmt.Errorf("event %d: %w", e.Seq, err)
+		}
+		s.count(e)
+		s.seq = e.Seq
+		offset += int64(len(line))
+	}
+	s.synced = s.seq
+	_, err := s.f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// applies e to the inner store
+func (s *eventStore) apply(e event) error {
+	switch e.Op {
+	case opCreate:
+		return s.inner.Create(Account{ID: e.Account, Type: e.Type, Balance: e.Amount, Currency: e.Currency})
+	case opDelete:
+		return s.inner.Delete(e.Account)
+	case opDeposit:
+		return s.inner.Credit(e.Account, e.Amount)
+	case opWithdraw:
+		return s.inner.DebitIf(e.Account, e.Amount, e.Version)
+	case opTransfer:
+		return s.inner.TransferIf(e.From, e.To, e.Amount, e.Version)
+	case opBatch:
+		return s.inner.TransferBatch(e.Items)
+	case opExchange:
+		return s.inner.Exchange(e.From, e.To, e.Amount, e.Credit)
+	case opStatus:
+		return s.inner.SetStatus(e.Account, e.Status)
+	case opLimit:
+		return s.inner.SetOverdraft(e.Account, e.Limit)
+	case opReserve:
+		return s.inner.Reserve(e.Account, e.Amount)
+	case opUnreserve:
+		return s.inner.Unreserve(e.Account, e.Amount)
+	case opSettle:
+		return s.inner.Settle(e.From, e.To, e.Amount)
+	case opFreeHeld:
+		s.inner.clearHeld()
+		return nil
+	case opRestore:
+		if e.Snapshot == nil {
+			return errors.New("restored snapshot missing")
+		}
+		return s.inner.Restore(*e.Snapshot)
+	case opLedgerOpen, opEntries, opEntry, opReversed, opUpdated:
+		// picked up by replayLedger, nothing to do for the balances
+		return nil
+	default:
+		return fmt.Errorf("unknown op %q", e.Op)
+	}
+}
+
+// collects the ledger events into opening and entries
+func (s *eventStore) replayLedger(e event) error {
+	switch e.Op {
+	case opLedgerOpen:
+		if e.Opening == nil {
+			return errors.New("ledger opening missing")
+		}
+		s.opening, s.entries = *e.Opening, nil
+	case opEntry:
+		if e.Entry == nil || e.Entry.ID != int64(len(s.entries)+1) {
+			return errors.New("ledger entry missing or out of order")
+		}
+		s.entries = append(s.entries, *e.Entry)
+	case opReversed:
+		if e.Entry == nil || e.Entry.ID < 1 || e.Entry.ID > int64(len(s.entries)) {
+			return errors.New("reversal of an unknown ledger entry")
+		}
+		s.entries[e.Entry.ID-1].ReversedBy = e.Entry.ReversedBy
+	case opUpdated:
+		if e.Entry == nil || e.Entry.ID < 1 || e.Entry.ID > int64(len(s.entries)) {
+			return errors.New("update of an unknown ledger entry")
+		}
+		s.entries[e.Entry.ID-1] = *e.Entry
+	case opRestore:
+		s.opening, s.entries = e.Snapshot.Opening, e.Snapshot.Ledger
+	}
+	for _, en := range e.Entries {
+		switch n := int64(len(s.entries)); {
+		case en.ID == n+1:
+			s.entries = append(s.entries, en)
+			if en.Reverses != 0 && en.Status == statusCompleted {
+				if en.Reverses > n {
+					return errors.New("reversal of an unknown ledger entry")
+				}
+				s.entries[en.Reverses-1].ReversedBy = en.ID
+			}
+		case en.ID >= 1 && en.ID <= n:
+			s.entries[en.ID-1] = en
+		default:
+			return errors.New("ledger entry out of order")
+		}
+	}
+	return nil
+}
+
+// keeps count of the entries the log holds once e is in it
+func (s *eventStore) count(e event) {
+	switch e.Op {
+	case opLedgerOpen:
+		s.kept = 0
+	case opEntry:
+		s.kept++
+	case opRestore:
+		s.kept = int64(len(e.Snapshot.Ledger))
+	}
+	for _, en := range e.Entries {
+		s.kept = max(s.kept, en.ID)
+	}
+}
+
+// applies e and, only if that succeeded, makes it durable. rejected
+// operations never reach the log, accepted ones return once an fsync
+// covering them completed. a booking view keeps e for Book instead
+func (s *eventStore) write(e event) error {
+	if s.change != nil {
+		if s.change.Op != "" {
+			return errors.New("a booking makes a single change")
+		}
+		*s.change = e
+		return nil
+	}
+	_, err := s.log(e)
+	return err
+}
+
+// write without the booking view, returning the entries of e as logged.
+// the new ones are numbered after those kept and timestamped then
+func (s *eventStore) log(e event) ([]ledgerEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if err := s.apply(e); err != nil {
+		return nil, err
+	}
+	e.Seq = s.seq + 1
+	e.Time = now().UTC()
+	e.Entries = slices.Clone(e.Entries)
+	n := s.kept
+	for i := range e.Entries {
+		if e.Entries[i].ID == 0 {
+			n++
+			e.Entries[i].ID, e.Entries[i].Timestamp = n, now()
+		}
+	}
+	line, err := json.Marshal(e)
+	if err == nil {
+		_, err = s.f.Write(append(line, '\n'))
+	}
+	if err != nil {
+		return nil, s.fail(err)
+	}
+	s.seq = e.Seq
+	s.count(e)
+	return e.Entries, s.waitSynced(e.Seq)
+}
+
+// blocks until seq is on disk, leading an fsync when none is running.
+// called and returns with mu held
+func (s *eventStore) waitSynced(seq int64) error {
+	for s.synced < seq {
+		if s.err != nil {
+			return s.err
+		}
+		if s.syncing {
+			s.flushed.Wait()
+			continue
+		}
+		// everything written up to here goes out with this fsync
+		s.syncing = true
+		upto := s.seq
+		s.mu.Unlock()
+		err := s.sync()
+		s.mu.Lock()
+		s.syncing = false
+		if err != nil {
+			s.fail(err)
+		} else {
+			s.synced = upto
+		}
+		s.flushed.Broadcast()
+	}
+	return nil
+}
+
+// marks the log unusable after a failed write or fsync, memory is ahead
+// of the disk from here on. called with mu held
+func (s *eventStore) fail(err error) error {
+	if s.err == nil {
+		s.err = fmt.Errorf("event log unusable, restart to recover: %w", err)
+	}
+	return s.err
+}
+
+// closes the log once a running fsync returned
+func (s *eventStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.syncing {
+		s.flushed.Wait()
+	}
+	return s.f.Close()
+}
+
+func (s *eventStore) Get(account string) (Account, error) {
+	return s.inner.Get(account)
+}
+
+func (s *eventStore) All() ([]Account, error) {
+	return s.inner.All()
+}
+
+func (s *eventStore) Credit(account string, amount Money) error {
+	return s.write(event{Op: opDeposit, Account: account, Amount: amount})
+}
+
+func (s *eventStore) Debit(account string, amount Money) error {
+	return s.DebitIf(account, amount, 0)
+}
+
+func (s *eventStore) DebitIf(account string, amount Money, version int64) error {
+	return s.write(event{Op: opWithdraw, Account: account, Amount: amount, Version: version})
+}
+
+func (s *eventStore) Transfer(from, to string, amount Money) error {
+	return s.TransferIf(from, to, amount, 0)
+}
+
+func (s *eventStore) TransferIf(from, to string, amount Money, version int64) error {
+	return s.write(event{Op: opTransfer, From: from, To: to, Amount: amount, Version: version})
+}
+
+func (s *eventStore) TransferBatch(items []TransferItem) error {
+	return s.write(event{Op: opBatch, Items: items})
+}
+
+func (s *eventStore) Exchange(from, to string, debit, credit Money) error {
+	return s.write(event{Op: opExchange, From: from, To: to, Amount: debit, Credit: credit})
+}
+
+func (s *eventStore) Create(acct Account) error {
+	return s.write(event{Op: opCreate, Account: acct.ID, Type: acct.Type, Amount: acct.Balance, Currency: acct.Currency})
+}
+
+func (s *eventStore) Delete(account string) error {
+	return s.write(event{Op: opDelete, Account: account})
+}
+
+func (s *eventStore) SetStatus(account, status string) error {
+	return s.write(event{Op: opStatus, Account: account, Status: status})
+}
+
+func (s *eventStore) SetOverdraft(account string, limit Money) error {
+	return s.write(event{Op: opLimit, Account: account, Limit: limit})
+}
+
+func (s *eventStore) Reserve(account string, amount Money) error {
+	return s.write(event{Op: opReserve, Account: account, Amount: amount})
+}
+
+func (s *eventStore) Unreserve(account string, amount Money) error {
+	return s.write(event{Op: opUnreserve, Account: account, Amount: amount})
+}
+
+func (s *eventStore) Settle(from, to string, amount Money) error {
+	return s.write(event{Op: opSettle, From: from, To: to, Amount: amount})
+}
+
+// hands over the ledger replayed on open, it is only kept until then
+func (s *eventStore) LoadLedger() (ledgerOpening, []ledgerEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, list := s.opening, s.entries
+	s.opening, s.entries = ledgerOpening{}, nil
+	return o, list, nil
+}
+
+func (s *eventStore) OpenLedger(o ledgerOpening) error {
+	return s.write(event{Op: opLedgerOpen, Opening: &o})
+}
+
+// logs op's change with entries in one event, or entries in one of
+// their own when op is nil or changes nothing. it is written and synced
+// like any other, sharing an fsync with whatever is written meanwhile
+func (s *eventStore) Book(_ context.Context, op func(Store) error, entries []ledgerEntry) ([]ledgerEntry, error) {
+	e := event{}
+	if op != nil {
+		if err := op(&eventStore{eventLog: s.eventLog, change: &e}); err != nil {
+			return nil, err
+		}
+	}
+	if e.Op == "" {
+		e.Op = opEntries
+	}
+	e.Entries = entries
+	return s.log(e)
+}
+
+// logs the whole of snap as one event, replaying it starts over from there
+func (s *eventStore) Restore(snap snapshot) error {
+	return s.write(event{Op: opRestore, Snapshot: &snap})
+}
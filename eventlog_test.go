@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEventStoreReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	s, err := openEventStore(path, map[string]Money{"alice": units(100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Transfer("alice", "bob", units(30))
+	s.Credit("bob", units(5))
+	s.Debit("alice", units(500)) // rejected, must not be logged
+	s.Create(Account{ID: "eur", Currency: "EUR"})
+	s.Exchange("bob", "eur", units(10), units(9))
+	s.SetStatus("eur", accountFrozen)
+	s.Close()
+
+	// a crash in the middle of an append leaves a torn line behind
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"seq":99,"op":"depo`)
+	f.Close()
+
+	s, err = openEventStore(path, map[string]Money{"alice": units(100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	want := map[string]Money{"alice": units(70), "bob": units(25), "eur": units(9)}
+	for acct, bal := range want {
+		if a, err := s.Get(acct); err != nil || a.Balance != bal {
+			t.Errorf("%s: expected %v, got %v (%v)", acct, bal, a.Balance, err)
+		}
+	}
+	if a, _ := s.Get("eur"); a.Status != accountFrozen {
+		t.Errorf("expected eur to stay frozen, got %q", a.Status)
+	}
+	if s.seq != 6 {
+		t.Errorf("expected 6 events after replay, got %d", s.seq)
+	}
+
+	// appends after the torn line was cut off replay cleanly again
+	if err := s.Credit("alice", units(1)); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	s, err = openEventStore(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if a, _ := s.Get("alice"); a.Balance != units(71) {
+		t.Errorf("expected alice to have 71, got %v", a.Balance)
+	}
+}
+
+// writers that arrive while an fsync runs must share the next one, and
+// none of them may hear back before the fsync covering it returned
+func TestEventStoreGroupCommit(t *testing.T) {
+	s, err := openEventStore(filepath.Join(t.TempDir(), "events.log"), map[string]Money{"alice": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	release := make(chan struct{})
+	var syncs atomic.Int64
+	var durable atomic.Bool
+	s.sync = func() error {
+		syncs.Add(1)
+		<-release
+		if err := s.f.Sync(); err != nil {
+			return err
+		}
+		durable.Store(true)
+		return nil
+	}
+
+	const writers = 20
+	acked := make(chan bool, writers)
+	for range writers {
+		go func() {
+			if err := s.Credit("alice", 1); err != nil {
+				t.Error(err)
+			}
+			acked <- durable.Load()
+		}()
+	}
+	// every writer has appended once the log has reached its seq, they
+	// are all stuck behind the first fsync
+	for {
+		s.mu.Lock()
+		n := s.seq
+		s.mu.Unlock()
+		if n == writers+1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-acked:
+		t.Fatal("a write was acknowledged before its fsync returned")
+	default:
+	}
+	close(release)
+
+	for range writers {
+		if !<-acked {
+			t.Error("a write was acknowledged before anything was on disk")
+		}
+	}
+	if n := syncs.Load(); n > 2 {
+		t.Errorf("expected the queued writes to share an fsync, got %d for %d writes", n, writers)
+	}
+	if a, _ := s.Get("alice"); a.Balance != writers {
+		t.Errorf("expected alice to have %d, got %v", writers, a.Balance)
+	}
+}
+
+func TestEventStoreSyncFailure(t *testing.T) {
+	s, err := openEventStore(filepath.Join(t.TempDir(), "events.log"), map[string]Money{"alice": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.sync = func() error { return errors.New("disk on fire") }
+	if err := s.Credit("alice", 1); err == nil {
+		t.Fatal("expected the failed fsync to be reported")
+	}
+	s.sync = s.f.Sync
+	if err := s.Credit("alice", 1); err == nil {
+		t.Error("expected the store to refuse writes after a failed fsync")
+	}
+}
+
+// transfers written concurrently, so sharing fsyncs, come back from
+// the log as they were: balances and ledger alike
+func TestEventStoreGroupCommitReplays(t *testing.T) {
+	defer func() { store = newMemoryStore(seedBalances()) }()
+	path := filepath.Join(t.TempDir(), "events.log")
+	balances := map[string]Money{}
+	for i := range 8 {
+		balances[fmt.Sprintf("acct-%d", i)] = units(100)
+	}
+	s, err := openEventStore(path, balances)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store = s
+	if err := loadLedger(); err != nil {
+		t.Fatal(err)
+	}
+	var syncs atomic.Int64
+	s.sync = func() error {
+		syncs.Add(1)
+		// slow enough for writers to queue up behind it
+		time.Sleep(time.Millisecond)
+		return s.f.Sync()
+	}
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 25 {
+				req := transferRequest{From: fmt.Sprintf("acct-%d", i), To: fmt.Sprintf("acct-%d", (i+j%7+1)%8), Amount: units(int64(j%3 + 1))}
+				if _, err := transferFunds(t.Context(), req); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := syncs.Load(); n >= 200 {
+		t.Errorf("expected the 200 transfers to share fsyncs, got %d", n)
+	}
+	before := mustAll(t)
+	kept := entries(t.Context(), "")
+	if err := closeStore(); err != nil {
+		t.Fatal(err)
+	}
+
+	ledgerMu.Lock()
+	ledger = nil
+	ledgerMu.Unlock()
+	s, err = openEventStore(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store = s
+	defer closeStore()
+	if err := loadLedger(); err != nil {
+		t.Fatal(err)
+	}
+	if after := mustAll(t); !reflect.DeepEqual(after, before) {
+		t.Errorf("expected the balances replayed as written, got %+v for %+v", after, before)
+	}
+	want, _ := json.Marshal(kept)
+	if got, _ := json.Marshal(entries(t.Context(), "")); string(got) != string(want) {
+		t.Errorf("expected the %d entries replayed as written, got %s", len(kept), got)
+	}
+	if tb := buildTrialBalance(t.Context(), mustAll(t)); !tb.Balanced {
+		t.Errorf("expected the replayed ledger to explain the balances, got %+v", tb)
+	}
+}
+
+// runs write on 16 goroutines against a fresh log, as it is and with
+// every write holding the log to itself through its fsync, the way it
+// was before group commit. fsyncs/op tells how many writes shared one.
+// setup, if any, runs on the log first
+func benchEventStore(b *testing.B, setup func(s *eventStore) error, write func(s *eventStore, acct string) error) {
+	for _, mode := range []string{"grouped", "ungrouped"} {
+		b.Run(mode, func(b *testing.B) {
+			balances := make(map[string]Money)
+			for i := range 64 {
+				balances[fmt.Sprintf("acct-%d", i)] = units(1_000_000)
+			}
+			s, err := openEventStore(filepath.Join(b.TempDir(), "events.log"), balances)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer s.Close()
+			if setup != nil {
+				if err := setup(s); err != nil {
+					b.Fatal(err)
+				}
+			}
+			var syncs atomic.Int64
+			s.sync = func() error {
+				syncs.Add(1)
+				return s.f.Sync()
+			}
+			var alone sync.Mutex
+			var next atomic.Int64
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				acct := fmt.Sprintf("acct-%d", next.Add(1)%64)
+				for pb.Next() {
+					if mode == "ungrouped" {
+						alone.Lock()
+					}
+					err := write(s, acct)
+					if mode == "ungrouped" {
+						alone.Unlock()
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.ReportMetric(float64(syncs.Load())/float64(b.N), "fsyncs/op")
+		})
+	}
+}
+
+// concurrent appends, each one waits for its fsync like a real caller
+func BenchmarkEventStoreParallel(b *testing.B) {
+	benchEventStore(b, nil, func(s *eventStore, acct string) error {
+		return s.Credit(acct, 1)
+	})
+}
+
+// transfers the way the API makes them, the ledger entry booked with
+// the money moving. one event each, so they group like credits do
+func BenchmarkEventStoreTransferParallel(b *testing.B) {
+	defer func() { store = newMemoryStore(seedBalances()) }()
+	setup := func(s *eventStore) error {
+		store = s
+		if err := s.Create(Account{ID: "sink", Currency: defaultCurrency}); err != nil {
+			return err
+		}
+		return loadLedger()
+	}
+	benchEventStore(b, setup, func(s *eventStore, acct string) error {
+		_, err := transferFunds(context.Background(), transferRequest{From: acct, To: "sink", Amount: 1})
+		return err
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// an account's whole history in one document, for keeping once it is
+// closed. unlike a statement it has every entry the account was party
+// to, failed and pending ones too. the closing balance is replayed from
+// the ledger, it is the account's balance unless the two disagree (see
+// GET /ledger/trial-balance)
+type accountExport struct {
+	Account        Account       `json:"account"`
+	ExportedAt     time.Time     `json:"exported_at"`
+	LedgerOpenedAt time.Time     `json:"ledger_opened_at"`
+	OpeningBalance Money         `json:"opening_balance"`
+	ClosingBalance Money         `json:"closing_balance"`
+	Transactions   []ledgerEntry `json:"transactions"`
+}
+
+// handles GET /accounts/{account}/export, answered as a JSON attachment.
+// closed accounts export like any other
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	account := r.PathValue("account")
+	acct, err := getBalance(r.Context(), account)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	list := entries(r.Context(), account)
+	ledgerMu.Lock()
+	ex := accountExport{
+		Account:        acct,
+		ExportedAt:     now().UTC(),
+		LedgerOpenedAt: openedAt,
+		OpeningBalance: openingBalances[qualify(tenantOf(r.Context()), account)],
+		Transactions:   []ledgerEntry{},
+	}
+	ledgerMu.Unlock()
+	ex.ClosingBalance = ex.OpeningBalance
+	for _, e := range list {
+		ex.ClosingBalance += e.net(account)
+		ex.Transactions = append(ex.Transactions, e)
+	}
+	name := "account-" + account + "-" + ex.ExportedAt.Format("20060102T150405Z") + ".json"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	json.NewEncoder(w).Encode(ex)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClosedAccountExport(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	transferID(t, `{"from":"alice","to":"bob","amount":10}`)
+	transferID(t, `{"from":"bob","to":"alice","amount":10}`)
+	// refused, bob is out of money, but still part of the history
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/transfer", strings.NewReader(`{"from":"bob","to":"alice","amount":1}`)))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected the overdrawing transfer to fail, got %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("DELETE", "/v1/accounts/bob", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("close: %d %s", w.Code, w.Body)
+	}
+
+	var acct Account
+	getPage(t, "/v1/accounts/bob", &acct)
+	if acct.Status != accountClosed || acct.Balance != 0 {
+		t.Errorf("expected bob closed at 0.00, got %+v", acct)
+	}
+	for _, body := range []string{`{"from":"alice","to":"bob","amount":1}`, `{"from":"bob","to":"alice","amount":1}`} {
+		w = httptest.NewRecorder()
+		serveAPI(w, httptest.NewRequest("POST", "/v1/transfer", strings.NewReader(body)))
+		if w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeAccountClosed {
+			t.Errorf("%s: expected 422 %s, got %d %s", body, codeAccountClosed, w.Code, w.Body)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/accounts/bob/export", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Disposition"), `attachment; filename=account-bob-`) {
+		t.Fatalf("expected the export as an attachment, got %d %q", w.Code, w.Header().Get("Content-Disposition"))
+	}
+	var ex accountExport
+	if err := json.NewDecoder(w.Body).Decode(&ex); err != nil {
+		t.Fatal(err)
+	}
+	if ex.Account.Status != accountClosed || ex.OpeningBalance != 0 || ex.ClosingBalance != 0 {
+		t.Errorf("expected a closed account opening and closing at 0.00, got %+v", ex)
+	}
+	// the failed payments out of bob are kept along with the two transfers
+	if got := ids(ex.Transactions); len(got) != 4 || ex.Transactions[1].Status != statusCompleted || ex.Transactions[3].Status != statusFailed {
+		t.Errorf("expected 2 completed then 2 failed entries, got %+v", ex.Transactions)
+	}
+
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/accounts/nobody/export", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown account, got %d", w.Code)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// whether a frozen account may still be paid into, set from
+// FROZEN_ACCEPTS_CREDITS. stopping money from leaving is what a freeze
+// is for, refusing incoming money too is a stricter policy
+var frozenAcceptsCredits = true
+
+// serves POST /accounts/{account}/freeze, admins only
+func freezeHandler(w http.ResponseWriter, r *http.Request) {
+	changeStatus(w, r, accountFrozen)
+}
+
+// serves POST /accounts/{account}/unfreeze, admins only
+func unfreezeHandler(w http.ResponseWriter, r *http.Request) {
+	changeStatus(w, r, accountActive)
+}
+
+func changeStatus(w http.ResponseWriter, r *http.Request, status string) {
+	if !isAdmin(r.Context()) {
+		forbidden(w)
+		return
+	}
+	account := r.PathValue("account")
+	err := storeFor(r.Context()).SetStatus(account, status)
+	if errors.Is(err, ErrAccountNotFound) {
+		writeError(w, http.StatusNotFound, codeAccountNotFound, "account not found")
+		return
+	}
+	if errors.Is(err, ErrAccountClosed) {
+		writeServiceError(w, closed(account))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not update account")
+		return
+	}
+	acct, err := storeFor(r.Context()).Get(account)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not read account")
+		return
+	}
+	slog.InfoContext(r.Context(), "account status changed", "account", account, "status", status, "by", callerName(r.Context()), "request_id", requestID(r.Context()))
+	writeAccount(w, acct)
+}
+
+// the error for money that can't leave account
+func frozen(account string) *serviceError {
+	return &serviceError{
+		code:    codeAccountFrozen,
+		message: "account is frozen",
+		details: map[string]any{"account": account},
+	}
+}
+
+// the error for money that can't move in or out of account
+func closed(account string) *serviceError {
+	return &serviceError{
+		code:    codeAccountClosed,
+		message: "account is closed",
+		details: map[string]any{"account": account},
+	}
+}
+
+// fails when account is a ledger-only account, closed, or frozen while
+// frozen accounts don't accept credits. every path paying into an
+// account goes through here. the store refuses closed and frozen
+// accounts as well, checking here first names the account that was the
+// problem
+func checkCredit(ctx context.Context, account string) error {
+	if prefix, ok := reservedPrefix(account); ok {
+		return &serviceError{
+			code:    codeInvalidAccountID,
+			message: "ids starting with " + prefix + " are reserved",
+			details: map[string]any{"account": account},
+		}
+	}
+	acct, err := storeFor(ctx).Get(account)
+	switch {
+	case err != nil:
+		return nil
+	case acct.Status == accountClosed:
+		return closed(account)
+	case acct.Status == accountFrozen && !frozenAcceptsCredits:
+		return frozen(account)
+	}
+	return nil
+}
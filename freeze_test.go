@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func setStatus(account, action string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/accounts/"+account+"/"+action, nil))
+	return w
+}
+
+func TestFreezeAccount(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": units(10)})
+	resetLedger()
+
+	if w := setStatus("alice", "freeze"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"frozen"`) {
+		t.Fatalf("expected alice frozen, got %d %s", w.Code, w.Body)
+	}
+
+	w := httptest.NewRecorder()
+	transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":10}`)))
+	if w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeAccountFrozen {
+		t.Errorf("expected a transfer out to be refused with %s, got %d %s", codeAccountFrozen, w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/accounts/alice/withdraw", strings.NewReader(`{"amount":10}`)))
+	if w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeAccountFrozen {
+		t.Errorf("expected a withdrawal to be refused with %s, got %d %s", codeAccountFrozen, w.Code, w.Body)
+	}
+
+	// reads and incoming money still work
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/accounts/alice/balance", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected the balance to be readable, got %d", w.Code)
+	}
+	transferID(t, `{"from":"bob","to":"alice","amount":5}`)
+	if a := balance(t, "alice"); a != units(105) {
+		t.Errorf("expected alice to be credited, has %v", a)
+	}
+
+	if w := setStatus("alice", "unfreeze"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	transferID(t, `{"from":"alice","to":"bob","amount":10}`)
+	if w := setStatus("nobody", "freeze"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown account, got %d", w.Code)
+	}
+}
+
+func TestFrozenRefusesCredits(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": units(10)})
+	resetLedger()
+	frozenAcceptsCredits = false
+	defer func() { frozenAcceptsCredits = true }()
+
+	setStatus("bob", "freeze")
+	w := httptest.NewRecorder()
+	transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":10}`)))
+	if w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeAccountFrozen {
+		t.Errorf("expected paying a frozen account to be refused, got %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/accounts/bob/deposit", strings.NewReader(`{"amount":10}`)))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected a deposit to be refused, got %d", w.Code)
+	}
+	if a, b := balance(t, "alice"), balance(t, "bob"); a != units(100) || b != units(10) {
+		t.Errorf("expected nothing to move, got alice=%v bob=%v", a, b)
+	}
+}
+
+func TestFreezeNeedsAdmin(t *testing.T) {
+	keys, _ := parseAPIKeys("k1=ops:admin;k2=alice:user:alice")
+	apiKeys = keys
+	defer func() { apiKeys = nil }()
+	store = newMemoryStore(map[string]Money{"alice": units(100)})
+
+	for key, want := range map[string]int{"k2": http.StatusForbidden, "k1": http.StatusOK} {
+		req := httptest.NewRequest("POST", "/v1/accounts/alice/freeze", nil)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		serveAPI(w, req)
+		if w.Code != want {
+			t.Errorf("%s: expected %d, got %d", key, want, w.Code)
+		}
+	}
+}
+
+func TestFrozenRefusesCaptureAndReversal(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0, "shop": 0})
+	resetLedger()
+	frozenAcceptsCredits = false
+	defer func() { frozenAcceptsCredits = true }()
+
+	h := placeTestHold(t, `{"from":"alice","to":"shop","amount":30}`)
+	setStatus("shop", "freeze")
+	if w := holdAction(h.ID, "capture", ""); w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeAccountFrozen {
+		t.Errorf("expected capturing to a frozen account to be refused, got %d %s", w.Code, w.Body)
+	}
+	if s := balance(t, "shop"); s != 0 {
+		t.Errorf("expected shop not to be paid, has %v", s)
+	}
+	if w := holdAction(h.ID, "release", ""); w.Code != http.StatusOK {
+		t.Errorf("expected the hold to still be active and releasable, got %d", w.Code)
+	}
+
+	id := transferID(t, `{"from":"alice","to":"bob","amount":10}`)
+	setStatus("alice", "freeze")
+	if w := reverseTx(id); w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeAccountFrozen {
+		t.Errorf("expected reversing into a frozen account to be refused, got %d %s", w.Code, w.Body)
+	}
+	if b := balance(t, "bob"); b != units(10) {
+		t.Errorf("expected bob to keep 10, has %v", b)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/rkarmaka98/Transaction_APP/transaction-api/txpb"
+)
+
+// grpcServer serves txpb.TransactionService on top of the same service
+// layer as the HTTP handlers
+type grpcServer struct {
+	txpb.UnimplementedTransactionServiceServer
+}
+
+// builds the gRPC server main listens with, API keys are checked the
+// same way as over HTTP. opts come after the server's own, TLS
+// credentials among them
+func newGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(grpcTraceUnary, grpcDeadlineUnary, grpcAuthUnary, grpcRateLimitUnary, grpcAuditUnary),
+		grpc.ChainStreamInterceptor(grpcTraceStream, grpcAuthStream, grpcRateLimitStream),
+	}, opts...)...)
+	txpb.RegisterTransactionServiceServer(s, grpcServer{})
+	return s
+}
+
+func (grpcServer) GetBalance(ctx context.Context, req *txpb.GetBalanceRequest) (*txpb.Balance, error) {
+	acct, err := getBalance(ctx, req.Account)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &txpb.Balance{Account: acct.ID, Balance: int64(acct.Balance), Currency: acct.Currency}, nil
+}
+
+func (grpcServer) Transfer(ctx context.Context, req *txpb.TransferRequest) (*txpb.Transaction, error) {
+	e, err := transferFunds(ctx, transferRequest{From: req.From, To: req.To, Amount: Money(req.Amount), Currency: req.Currency})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProto(e), nil
+}
+
+func (grpcServer) ListTransactions(ctx context.Context, req *txpb.ListTransactionsRequest) (*txpb.ListTransactionsResponse, error) {
+	if req.Limit < 0 || req.Offset < 0 {
+		return nil, grpcError(failure(codeInvalidPagination, "limit and offset must be non-negative integers"))
+	}
+	limit := defaultPageSize
+	if req.Limit > 0 {
+		limit = min(int(req.Limit), maxPageSize)
+	}
+	list, err := listTransactions(ctx, req.Account)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	page := paginate(list, limit, int(req.Offset))
+	resp := &txpb.ListTransactionsResponse{Total: int32(page.Total), NextOffset: -1}
+	if page.NextOffset != nil {
+		resp.NextOffset = int32(*page.NextOffset)
+	}
+	for _, e := range page.Transactions {
+		resp.Transactions = append(resp.Transactions, toProto(e))
+	}
+	return resp, nil
+}
+
+func (grpcServer) StreamTransactions(req *txpb.StreamTransactionsRequest, stream txpb.TransactionService_StreamTransactionsServer) error {
+	list, err := listTransactions(stream.Context(), req.Account)
+	if err != nil {
+		return grpcError(err)
+	}
+	for _, e := range list {
+		if err := stream.Send(toProto(e)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func toProto(e ledgerEntry) *txpb.Transaction {
+	return &txpb.Transaction{
+		Id:         e.ID,
+		From:       e.From,
+		To:         e.To,
+		Amount:     int64(e.Amount),
+		Currency:   e.Currency,
+		ToAmount:   int64(e.ToAmount),
+		ToCurrency: e.ToCurrency,
+		Timestamp:  e.Timestamp.UnixNano(),
+		Status:     e.Status,
+	}
+}
+
+// the gRPC status code closest to each code a *serviceError can carry
+var grpcCodes = map[string]codes.Code{
+	codeInvalidAmount:      codes.InvalidArgument,
+	codeInvalidRequest:     codes.InvalidArgument,
+	codeInvalidAccountID:   codes.InvalidArgument,
+	codeInvalidPagination:  codes.InvalidArgument,
+	codeInvalidTimestamp:   codes.InvalidArgument,
+	codeUnauthorized:       codes.Unauthenticated,
+	codeForbidden:          codes.PermissionDenied,
+	codeAccountNotFound:    codes.NotFound,
+	codeInsufficientFunds:  codes.FailedPrecondition,
+	codeCurrencyMismatch:   codes.FailedPrecondition,
+	codeSameAccount:        codes.InvalidArgument,
+	codeRecipientNotFound:  codes.FailedPrecondition,
+	codeAmountBelowMinimum: codes.InvalidArgument,
+	codeAccountFrozen:      codes.FailedPrecondition,
+	codeAccountClosed:      codes.FailedPrecondition,
+	codeRiskRejected:       codes.FailedPrecondition,
+	codeLimitExceeded:      codes.ResourceExhausted,
+	codeRateLimited:        codes.ResourceExhausted,
+	codeTimeout:            codes.DeadlineExceeded,
+	codeInternal:           codes.Internal,
+}
+
+// turns a service layer error into a gRPC status, the API error code
+// travels as the reason of an ErrorInfo so clients can branch on it
+func grpcError(err error) error {
+	var se *serviceError
+	if !errors.As(err, &se) {
+		return status.Error(codes.Internal, "internal error")
+	}
+	c, ok := grpcCodes[se.code]
+	if !ok {
+		c = codes.Internal
+	}
+	st, derr := status.New(c, se.message).WithDetails(&errdetails.ErrorInfo{Reason: se.code, Domain: "transaction-api"})
+	if derr != nil {
+		return status.Error(c, se.message)
+	}
+	return st.Err()
+}
+
+// resolves the API key in the call's metadata, or the client certificate
+// of a call without one, to a principal stored in the returned context,
+// like authenticate does for HTTP, and acts in
+// its tenant or the one x-tenant-id asks for
+func grpcAuthenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var header string
+	if v := md.Get("x-tenant-id"); len(v) > 0 {
+		header = v[0]
+	}
+	if !authEnabled() {
+		tenant, err := requestTenant(nil, header)
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		return withTenant(ctx, tenant), nil
+	}
+	var key string
+	if v := md.Get("x-api-key"); len(v) > 0 {
+		key = v[0]
+	}
+	if v := md.Get("authorization"); len(v) > 0 {
+		if bearer, ok := strings.CutPrefix(v[0], "Bearer "); ok {
+			key = bearer
+		}
+	}
+	p, ok := lookupKey(key)
+	if !ok && key == "" {
+		p, ok = lookupCert(peerTLS(ctx))
+	}
+	if !ok {
+		return nil, grpcError(failure(codeUnauthorized, "missing or invalid API key"))
+	}
+	tenant, err := requestTenant(p, header)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return withTenant(context.WithValue(ctx, principalKey{}, p), tenant), nil
+}
+
+func grpcAuthUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+	ctx, err := grpcAuthenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return next(ctx, req)
+}
+
+func grpcAuthStream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, next grpc.StreamHandler) error {
+	ctx, err := grpcAuthenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return next(srv, authedStream{ss, ctx})
+}
+
+// the same request rate limits as rateLimit applies to HTTP, sharing
+// the buckets so a caller can't get around them by switching protocols.
+// has to run after grpcAuthUnary
+func grpcRateLimitUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+	if err := grpcAllow(ctx); err != nil {
+		return nil, err
+	}
+	return next(ctx, req)
+}
+
+func grpcRateLimitStream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, next grpc.StreamHandler) error {
+	if err := grpcAllow(ss.Context()); err != nil {
+		return err
+	}
+	return next(srv, ss)
+}
+
+// takes a token for the call, a ResourceExhausted status when there is
+// none saying how long to wait
+func grpcAllow(ctx context.Context) error {
+	ok, wait := allowRequest(ctx)
+	if ok {
+		return nil
+	}
+	secs := int(math.Ceil(wait.Seconds()))
+	return grpcError(failure(codeRateLimited, fmt.Sprintf("too many requests, retry in %ds", secs)))
+}
+
+// continues the caller's trace from the call's metadata in a span named
+// after the method, and logs the call like observe does for HTTP
+func grpcTrace(ctx context.Context, method string) (context.Context, func(error)) {
+	start := time.Now()
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+	ctx, span := tracer.Start(ctx, method, trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("rpc.system", "grpc"), attribute.String("rpc.method", method)))
+	return ctx, func(err error) {
+		code := status.Code(err)
+		span.SetAttributes(attribute.String("rpc.grpc.status_code", code.String()))
+		if code == codes.Internal || code == codes.Unknown {
+			span.SetStatus(otelcodes.Error, err.Error())
+		}
+		span.End()
+		attrs := []slog.Attr{
+			slog.String("method", method),
+			slog.String("code", code.String()),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		}
+		if sc := span.SpanContext(); sc.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+		}
+		slog.LogAttrs(ctx, slog.LevelInfo, "rpc", attrs...)
+	}
+}
+
+func grpcTraceUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+	ctx, end := grpcTrace(ctx, info.FullMethod)
+	resp, err := next(ctx, req)
+	end(err)
+	return resp, err
+}
+
+func grpcTraceStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, next grpc.StreamHandler) error {
+	ctx, end := grpcTrace(ss.Context(), info.FullMethod)
+	err := next(srv, authedStream{ss, ctx})
+	end(err)
+	return err
+}
+
+// lets the propagator read trace headers out of gRPC metadata
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) { metadata.MD(c).Set(key, value) }
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// a ServerStream with a context of our own, carrying the span or the
+// authenticated principal
+type authedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authedStream) Context() context.Context { return s.ctx }
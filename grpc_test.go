@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/rkarmaka98/Transaction_APP/transaction-api/txpb"
+)
+
+// starts the gRPC server on an in-memory listener and returns a client
+func grpcClient(t *testing.T) txpb.TransactionServiceClient {
+	t.Helper()
+	ln := bufconn.Listen(1 << 20)
+	srv := newGRPCServer()
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return txpb.NewTransactionServiceClient(conn)
+}
+
+// returns the API error code carried by a gRPC error
+func errorReason(err error) string {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+	return ""
+}
+
+func TestGRPCTransfer(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": units(50)})
+	resetLedger()
+	c := grpcClient(t)
+	ctx := context.Background()
+
+	tx, err := c.Transfer(ctx, &txpb.TransferRequest{From: "alice", To: "bob", Amount: int64(units(30))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Status != statusCompleted || tx.Amount != int64(units(30)) || tx.Currency != defaultCurrency {
+		t.Errorf("unexpected transaction %v", tx)
+	}
+	b, err := c.GetBalance(ctx, &txpb.GetBalanceRequest{Account: "bob"})
+	if err != nil || b.Balance != int64(units(80)) {
+		t.Fatalf("expected bob at 80, got %v %v", b, err)
+	}
+
+	// the same validation as over HTTP, with the API error code attached
+	_, err = c.Transfer(ctx, &txpb.TransferRequest{From: "alice", To: "bob", Amount: int64(units(500))})
+	if status.Code(err) != codes.FailedPrecondition || errorReason(err) != codeInsufficientFunds {
+		t.Errorf("expected FailedPrecondition INSUFFICIENT_FUNDS, got %v", err)
+	}
+	for _, req := range []*txpb.TransferRequest{{From: "alice", Amount: 1}, {To: "bob", Amount: 1}} {
+		if _, err := c.Transfer(ctx, req); status.Code(err) != codes.InvalidArgument || errorReason(err) != codeInvalidRequest {
+			t.Errorf("expected InvalidArgument INVALID_REQUEST for %v, got %v", req, err)
+		}
+	}
+	if _, err := store.Get(""); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("expected no account with an empty id, got %v", err)
+	}
+	_, err = c.GetBalance(ctx, &txpb.GetBalanceRequest{Account: "carol"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+
+	// both attempts are in the ledger, as if made over HTTP
+	page, err := c.ListTransactions(ctx, &txpb.ListTransactionsRequest{Account: "alice", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Transactions) != 1 || page.NextOffset != 1 {
+		t.Errorf("expected first of 2 transactions, got %v", page)
+	}
+
+	stream, err := c.StreamTransactions(ctx, &txpb.StreamTransactionsRequest{Account: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statuses []string
+	for {
+		tx, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		statuses = append(statuses, tx.Status)
+	}
+	if len(statuses) != 2 || statuses[0] != statusCompleted || statuses[1] != statusFailed {
+		t.Errorf("expected completed then failed, got %v", statuses)
+	}
+}
+
+func TestGRPCAuthentication(t *testing.T) {
+	keys, err := parseAPIKeys("k-ops=ops:admin;k-alice=alice:user:alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKeys = keys
+	defer func() { apiKeys = nil }()
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": units(50)})
+	c := grpcClient(t)
+
+	with := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+key)
+	}
+	req := &txpb.TransferRequest{From: "alice", To: "bob", Amount: int64(units(1))}
+	if _, err := c.Transfer(context.Background(), req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated without a key, got %v", err)
+	}
+	if _, err := c.Transfer(with("k-ops"), req); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected admin to be denied debiting alice, got %v", err)
+	}
+	if _, err := c.Transfer(with("k-alice"), req); err != nil {
+		t.Errorf("expected owner to transfer, got %v", err)
+	}
+	if _, err := c.ListTransactions(with("k-alice"), &txpb.ListTransactionsRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected the full ledger to need admin, got %v", err)
+	}
+	stream, err := c.StreamTransactions(with("k-ops"), &txpb.StreamTransactionsRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if err != nil {
+		t.Errorf("expected admin to stream the ledger, got %v", err)
+	}
+}
+
+func TestGRPCRateLimit(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100)})
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return start }
+	globalLimit = newTokenBucket(1)
+	defer func() { globalLimit, now = nil, time.Now }()
+	c := grpcClient(t)
+	ctx := context.Background()
+
+	if _, err := c.GetBalance(ctx, &txpb.GetBalanceRequest{Account: "alice"}); err != nil {
+		t.Fatalf("expected the first call to pass, got %v", err)
+	}
+	_, err := c.GetBalance(ctx, &txpb.GetBalanceRequest{Account: "alice"})
+	if status.Code(err) != codes.ResourceExhausted || errorReason(err) != codeRateLimited {
+		t.Errorf("expected ResourceExhausted RATE_LIMITED, got %v", err)
+	}
+	stream, err := c.StreamTransactions(ctx, &txpb.StreamTransactionsRequest{Account: "alice"})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected streams to be limited too, got %v", err)
+	}
+}
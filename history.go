@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// models the JSON response for GET /accounts/{account}/balance?as_of=
+type historicalBalance struct {
+	Account           string     `json:"account"`
+	Balance           Money      `json:"balance"`
+	AsOf              time.Time  `json:"as_of"`
+	LastTransactionAt *time.Time `json:"last_transaction_at"`
+}
+
+// rebuilds the balance of account at t by replaying completed ledger
+// entries from the opening balances, each at the time its money moved.
+// existed is false when the account had not been opened or credited yet
+// at t
+func balanceAt(ctx context.Context, account string, t time.Time) (bal Money, last *time.Time, existed bool) {
+	account = qualify(tenantOf(ctx), account)
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+	if t.Before(openedAt) {
+		return 0, nil, false
+	}
+	bal, existed = openingBalances[account]
+	for _, e := range ledger {
+		if e.Timestamp.After(t) {
+			// the ledger is append only so everything after is later too
+			break
+		}
+		// an async or reviewed transfer moved its money once settled
+		at := e.Timestamp
+		if e.SettledAt != nil {
+			at = *e.SettledAt
+		}
+		if !e.touches(account) || at.After(t) {
+			continue
+		}
+		bal += e.net(account)
+		if last == nil || at.After(*last) {
+			last = &at
+		}
+		if e.To == account {
+			existed = true
+		}
+	}
+	return bal, last, existed
+}
+
+// serves GET /accounts/{account}/balance?at=<rfc3339>, as_of being the
+// older name of at. accounts that did not exist yet at that time are
+// reported as not found
+func historicalBalanceHandler(w http.ResponseWriter, r *http.Request, account, param, asOf string) {
+	t, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidTimestamp, param+" must be an RFC3339 timestamp")
+		return
+	}
+
+	bal, last, existed := balanceAt(r.Context(), account, t)
+
+	if !existed {
+		writeError(w, http.StatusNotFound, codeAccountNotFound, "account not found at "+param)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(historicalBalance{
+		Account:           account,
+		Balance:           bal,
+		AsOf:              t,
+		LastTransactionAt: last,
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBalanceAsOf(t *testing.T) {
+	start := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	clock := start
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	if err := resetLedger(); err != nil {
+		t.Fatal(err)
+	}
+
+	// three transfers an hour apart
+	for _, amount := range []string{"10", "20", "30"} {
+		clock = clock.Add(time.Hour)
+		body := `{"from":"alice","to":"bob","amount":` + amount + `}`
+		w := httptest.NewRecorder()
+		transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("transfer %s: expected 200, got %d", amount, w.Code)
+		}
+	}
+
+	// between the second and third transfer
+	asOf := start.Add(150 * time.Minute).Format(time.RFC3339)
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/accounts/alice/balance?as_of="+asOf, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var got historicalBalance
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Balance != units(70) {
+		t.Errorf("expected as of balance 70, got %v", got.Balance)
+	}
+	if got.LastTransactionAt == nil || !got.LastTransactionAt.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("expected last transaction at %v, got %v", start.Add(2*time.Hour), got.LastTransactionAt)
+	}
+
+	// carol is only opened and paid after the cutoff
+	clock = clock.Add(time.Hour)
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/accounts", strings.NewReader(`{"id":"carol"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("open carol: %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"bob","to":"carol","amount":5}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/accounts/carol/balance?as_of="+asOf, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 before carol existed, got %d", w.Code)
+	}
+}
+
+func TestBalanceAt(t *testing.T) {
+	start := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	clock := start
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+
+	clock = clock.Add(time.Hour)
+	transferID(t, `{"from":"alice","to":"bob","amount":10}`)
+	// accepted at 11:00, only made at 13:00
+	clock = clock.Add(time.Hour)
+	asyncID(t, `{"from":"alice","to":"bob","amount":20}`)
+	clock = clock.Add(2 * time.Hour)
+	runPendingTransfers()
+
+	for at, want := range map[time.Duration]Money{
+		30 * time.Minute:  units(100),
+		90 * time.Minute:  units(90),
+		150 * time.Minute: units(90),
+		5 * time.Hour:     units(70),
+	} {
+		w := httptest.NewRecorder()
+		serveAPI(w, httptest.NewRequest("GET", "/balance/alice?at="+start.Add(at).Format(time.RFC3339), nil))
+		var got historicalBalance
+		json.NewDecoder(w.Body).Decode(&got)
+		if w.Code != http.StatusOK || got.Balance != want {
+			t.Errorf("at +%v: expected %v, got %d %v", at, want, w.Code, got.Balance)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/accounts/alice/balance?at=yesterday", nil))
+	if e := decodeError(t, w); w.Code != http.StatusBadRequest || e.Code != codeInvalidTimestamp || !strings.HasPrefix(e.Message, "at ") {
+		t.Errorf("expected the bad timestamp reported, got %d %s", w.Code, w.Body)
+	}
+}
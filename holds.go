@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// states a hold moves through, only held ones can be captured or released
+const (
+	holdHeld     = "held"
+	holdCaptured = "captured"
+	holdReleased = "released"
+	holdExpired  = "expired"
+)
+
+// how long a hold lasts when the request doesn't say, like a card
+// authorization. longest accepted is maxHoldTTL
+const (
+	defaultHoldTTL = 7 * 24 * time.Hour
+	maxHoldTTL     = 30 * 24 * time.Hour
+)
+
+// held funds stay in the account, reserved so they can't be spent twice
+// (see Store.Reserve), and only move once captured. the holds themselves
+// live in memory, the file backed stores free every reservation when
+// they open so nothing is stuck after a restart, a hold that wasn't
+// captured by then is gone.
+//
+// older versions parked held funds in one escrow account per currency,
+// clients still can't open accounts with this prefix
+const escrowPrefix = "escrow:"
+
+// a reservation of Amount on From, payable to To once captured
+type hold struct {
+	ID        string    `json:"id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Amount    Money     `json:"amount"`
+	Currency  string    `json:"currency"`
+	Captured  Money     `json:"captured,omitempty"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	tenant    string
+	// gives what the hold booked against From's daily limit back, the
+	// part that's freed instead of paid out
+	unbook func(Money)
+}
+
+// holds by id. holdsMu also serializes every state change so a hold
+// can't be captured and released at once
+var (
+	holdsMu sync.Mutex
+	holds   = map[string]*hold{}
+	holdSeq int
+)
+
+// models the JSON body for POST /holds, ttl is a Go duration like "72h"
+type holdRequest struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount Money  `json:"amount"`
+	TTL    string `json:"ttl"`
+}
+
+// models the optional JSON body for POST /holds/{id}/capture, a zero
+// amount captures the whole hold, a smaller one releases the rest
+type captureRequest struct {
+	Amount Money `json:"amount"`
+}
+
+// handles POST /holds, reserving funds without moving them yet
+func holdsHandler(w http.ResponseWriter, r *http.Request) {
+	var req holdRequest
+	if !decodeBody(w, r, "HoldRequest", &req) {
+		return
+	}
+	// a hold is a transfer in two steps, checked like one
+	if err := validateTransfer(req.From, req.To, req.Amount); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	ttl := defaultHoldTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 || d > maxHoldTTL {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "ttl must be a positive duration of at most 720h")
+			return
+		}
+		ttl = d
+	}
+	if !mayDebit(r.Context(), req.From) {
+		forbidden(w)
+		return
+	}
+
+	h, err := placeHold(r.Context(), req.From, req.To, req.Amount, ttl)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(h)
+}
+
+// returns a copy of the hold r names, writing the 404 when its tenant
+// has none
+func lookupHold(w http.ResponseWriter, r *http.Request) (hold, bool) {
+	holdsMu.Lock()
+	defer holdsMu.Unlock()
+	h, ok := holds[r.PathValue("id")]
+	if !ok || h.tenant != tenantOf(r.Context()) {
+		writeError(w, http.StatusNotFound, codeHoldNotFound, "hold not found")
+		return hold{}, false
+	}
+	return *h, true
+}
+
+// handles GET /holds/{id}
+func holdHandler(w http.ResponseWriter, r *http.Request) {
+	h, ok := lookupHold(w, r)
+	if !ok {
+		return
+	}
+	if !mayRead(r.Context(), h.From) {
+		forbidden(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h)
+}
+
+// models the JSON response for GET /holds
+type holdPage struct {
+	Holds      []hold  `json:"holds"`
+	Total      int     `json:"total"`
+	NextCursor *string `json:"next_cursor"`
+}
+
+// handles GET /holds listing the holds on accounts the caller may read,
+// oldest first unless sorted otherwise (see query.go)
+func listHoldsHandler(w http.ResponseWriter, r *http.Request) {
+	lq, err := parseListQuery(r, holdList)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	holdsMu.Lock()
+	var list []hold
+	for _, h := range holds {
+		if h.tenant == tenantOf(r.Context()) && mayRead(r.Context(), h.From) {
+			list = append(list, *h)
+		}
+	}
+	holdsMu.Unlock()
+	page := pageOf(list, lq, holdList)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(holdPage{page.items, page.total, page.nextCursor})
+}
+
+// handles POST /holds/{id}/capture, only the owner may pay out
+func captureHandler(w http.ResponseWriter, r *http.Request) {
+	h, ok := lookupHold(w, r)
+	if !ok {
+		return
+	}
+	if !mayDebit(r.Context(), h.From) {
+		forbidden(w)
+		return
+	}
+	var req captureRequest
+	if r.ContentLength != 0 {
+		if !decodeBody(w, r, "CaptureRequest", &req) {
+			return
+		}
+	}
+	if req.Amount < 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidAmount, "amount must not be negative")
+		return
+	}
+	h, err := captureHold(r.Context(), h.ID, req.Amount)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h)
+}
+
+// handles POST /holds/{id}/release, admins may free funds back to their
+// owner too
+func releaseHandler(w http.ResponseWriter, r *http.Request) {
+	h, ok := lookupHold(w, r)
+	if !ok {
+		return
+	}
+	if !mayDebit(r.Context(), h.From) && !isAdmin(r.Context()) {
+		forbidden(w)
+		return
+	}
+	h, err := releaseHold(r.Context(), h.ID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h)
+}
+
+// reserves amount on the account and registers the hold
+func placeHold(ctx context.Context, from, to string, amount Money, ttl time.Duration) (hold, error) {
+	src, err := storeFor(ctx).Get(from)
+	if errors.Is(err, ErrAccountNotFound) {
+		return hold{}, failure(codeAccountNotFound, "account not found")
+	}
+	if err != nil {
+		return hold{}, failure(codeInternal, "could not read account")
+	}
+	// a recipient holding another currency could never be paid
+	if dst, err := storeFor(ctx).Get(to); err == nil && dst.Currency != src.Currency {
+		return hold{}, failure(codeCurrencyMismatch, "accounts hold different currencies, use /convert")
+	}
+	if err := checkRecipient(ctx, to); err != nil {
+		return hold{}, err
+	}
+
+	// a hold is money leaving the account as far as limits go, until
+	// it's released, expires or is captured for less
+	unbook, err := bookLimit(ctx, from, amount)
+	if err != nil {
+		return hold{}, err
+	}
+
+	holdsMu.Lock()
+	defer holdsMu.Unlock()
+	if err := holdFailure(storeFor(ctx).Reserve(from, amount), from); err != nil {
+		unbook(amount)
+		return hold{}, err
+	}
+	holdSeq++
+	h := &hold{
+		ID:        fmt.Sprintf("hold_%d", holdSeq),
+		From:      from,
+		To:        to,
+		Amount:    amount,
+		Currency:  src.Currency,
+		Status:    holdHeld,
+		CreatedAt: now(),
+		ExpiresAt: now().Add(ttl),
+		tenant:    tenantOf(ctx),
+		unbook:    unbook,
+	}
+	holds[h.ID] = h
+	return *h, nil
+}
+
+// pays amount of the hold to its recipient, zero meaning all of it, and
+// frees whatever is left
+func captureHold(ctx context.Context, id string, amount Money) (hold, error) {
+	holdsMu.Lock()
+	defer holdsMu.Unlock()
+	h := holds[id]
+	if err := holdActive(ctx, h); err != nil {
+		return *h, err
+	}
+	if amount == 0 {
+		amount = h.Amount
+	}
+	if amount > h.Amount {
+		return *h, failure(codeInvalidAmount, "capture amount exceeds the hold")
+	}
+	// the recipient may have been frozen or closed since the hold was placed
+	if err := checkCredit(ctx, h.To); err != nil {
+		return *h, err
+	}
+	if err := settleHold(ctx, h, amount); err != nil {
+		return *h, err
+	}
+	h.Captured = amount
+	// the capture went through, freeing the rest isn't cut short by the
+	// request giving up
+	if rest := h.Amount - amount; rest > 0 {
+		if err := holdFailure(storeFor(context.WithoutCancel(ctx)).Unreserve(h.From, rest), h.From); err != nil {
+			return *h, err
+		}
+		h.unbook(rest)
+	}
+	h.Status = holdCaptured
+	return *h, nil
+}
+
+// gives the held funds back to the account
+func releaseHold(ctx context.Context, id string) (hold, error) {
+	holdsMu.Lock()
+	defer holdsMu.Unlock()
+	h := holds[id]
+	if err := holdActive(ctx, h); err != nil {
+		return *h, err
+	}
+	if err := holdFailure(storeFor(ctx).Unreserve(h.From, h.Amount), h.From); err != nil {
+		return *h, err
+	}
+	h.unbook(h.Amount)
+	h.Status = holdReleased
+	return *h, nil
+}
+
+// fails unless h can still be captured or released, a hold past its
+// expiry is released on the spot, in h's tenant whoever notices. holdsMu
+// must be held
+func holdActive(ctx context.Context, h *hold) error {
+	ctx = withTenant(ctx, h.tenant)
+	if h.Status == holdHeld && !now().Before(h.ExpiresAt) {
+		if err := holdFailure(storeFor(ctx).Unreserve(h.From, h.Amount), h.From); err != nil {
+			return err
+		}
+		h.unbook(h.Amount)
+		h.Status = holdExpired
+	}
+	if h.Status != holdHeld {
+		return failure(codeHoldNotActive, "hold is "+h.Status)
+	}
+	return nil
+}
+
+// pays amount of what h reserved to its recipient, recorded in the
+// ledger like any transfer
+func settleHold(ctx context.Context, h *hold, amount Money) error {
+	entry := ledgerEntry{From: h.From, To: h.To, Amount: amount, Currency: h.Currency, HoldID: h.ID, Status: statusCompleted}
+	_, err := book(ctx, func(s Store) error { return s.Settle(h.From, h.To, amount) }, entry)
+	if err = holdFailure(err, h.From); err != nil {
+		entry.Status = statusFailed
+		record(ctx, entry)
+		return err
+	}
+	return nil
+}
+
+// turns a store error from a hold's account into a *serviceError
+func holdFailure(err error, account string) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrInsufficientFunds):
+		return &serviceError{
+			code:    codeInsufficientFunds,
+			message: "insufficient funds",
+			details: map[string]any{"account": account},
+		}
+	case errors.Is(err, ErrCurrencyMismatch):
+		return failure(codeCurrencyMismatch, "accounts hold different currencies, use /convert")
+	case errors.Is(err, ErrAccountFrozen):
+		return frozen(account)
+	case errors.Is(err, ErrAccountClosed):
+		return closed(account)
+	case errors.Is(err, ErrAccountNotFound):
+		return failure(codeAccountNotFound, "account not found")
+	}
+	return failure(codeInternal, "could not update held funds")
+}
+
+// releases every hold past its expiry
+func expireHolds(ctx context.Context) {
+	holdsMu.Lock()
+	defer holdsMu.Unlock()
+	for _, h := range holds {
+		if h.Status != holdHeld || now().Before(h.ExpiresAt) {
+			continue
+		}
+		auditWork(withTenant(ctx, h.tenant), "expire_hold", apiPrefix+"/holds/"+h.ID, func(ctx context.Context) error {
+			// an expired hold is no longer active, that's the point
+			if err := holdActive(ctx, h); h.Status != holdExpired {
+				return err
+			}
+			return nil
+		})
+	}
+}
+
+// runs expireHolds every interval until ctx is cancelled
+func runHoldExpiry(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			expireHolds(ctx)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// places a hold through the handler and returns it
+func placeTestHold(t *testing.T, body string) hold {
+	t.Helper()
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/holds", strings.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body)
+	}
+	var h hold
+	json.NewDecoder(w.Body).Decode(&h)
+	return h
+}
+
+// what account can still spend, holds left out
+func available(t *testing.T, account string) Money {
+	t.Helper()
+	acct, err := store.Get(account)
+	if err != nil {
+		t.Fatalf("get %s: %v", account, err)
+	}
+	return acct.Available()
+}
+
+func holdAction(id, action, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/holds/"+id+"/"+action, strings.NewReader(body)))
+	return w
+}
+
+func TestHoldCapture(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "shop": 0})
+
+	h := placeTestHold(t, `{"from":"alice","to":"shop","amount":60}`)
+	if h.Status != holdHeld {
+		t.Fatalf("expected held, got %+v", h)
+	}
+	// held funds can't be spent again
+	w := httptest.NewRecorder()
+	transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"alice","to":"shop","amount":50}`)))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected held funds to be unavailable, got %d", w.Code)
+	}
+
+	// capturing less than held gives the rest back
+	if w := holdAction(h.ID, "capture", `{"amount":45}`); w.Code != http.StatusOK {
+		t.Fatalf("capture failed: %d %s", w.Code, w.Body)
+	}
+	if a, s := balance(t, "alice"), balance(t, "shop"); a != units(55) || s != units(45) {
+		t.Errorf("expected alice=55 shop=45, got %v %v", a, s)
+	}
+	if a := available(t, "alice"); a != units(55) {
+		t.Errorf("expected nothing left reserved, alice has %v available", a)
+	}
+	// the capture is the only movement, placing the hold moved nothing
+	var legs []ledgerEntry
+	for _, e := range entries(t.Context(), "") {
+		if e.HoldID == h.ID {
+			legs = append(legs, e)
+		}
+	}
+	if len(legs) != 1 || legs[0].From != "alice" || legs[0].To != "shop" || legs[0].Amount != units(45) {
+		t.Errorf("expected a single transfer of 45 to shop, got %+v", legs)
+	}
+
+	if w := holdAction(h.ID, "release", ""); w.Code != http.StatusConflict {
+		t.Errorf("expected a captured hold to be final, got %d", w.Code)
+	}
+}
+
+func TestHoldRelease(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "shop": 0})
+
+	h := placeTestHold(t, `{"from":"alice","to":"shop","amount":30}`)
+	if a, b := available(t, "alice"), balance(t, "alice"); a != units(70) || b != units(100) {
+		t.Errorf("expected 70 of 100 available while held, got %v of %v", a, b)
+	}
+	if w := holdAction(h.ID, "release", ""); w.Code != http.StatusOK {
+		t.Fatalf("release failed: %d %s", w.Code, w.Body)
+	}
+	if a, s := available(t, "alice"), balance(t, "shop"); a != units(100) || s != 0 {
+		t.Errorf("expected alice=100 shop=0, got %v %v", a, s)
+	}
+	if w := holdAction(h.ID, "capture", ""); w.Code != http.StatusConflict {
+		t.Errorf("expected a released hold not to be captured, got %d", w.Code)
+	}
+}
+
+func TestHoldExpiry(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "shop": 0})
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return start }
+	defer func() { now = time.Now }()
+
+	stale := placeTestHold(t, `{"from":"alice","to":"shop","amount":10,"ttl":"1h"}`)
+	late := placeTestHold(t, `{"from":"alice","to":"shop","amount":20,"ttl":"1h"}`)
+	fresh := placeTestHold(t, `{"from":"alice","to":"shop","amount":30,"ttl":"2h"}`)
+
+	now = func() time.Time { return start.Add(90 * time.Minute) }
+	expireHolds(context.Background())
+	if a := available(t, "alice"); a != units(70) {
+		t.Errorf("expected 2 expired holds released, alice at 70, got %v", a)
+	}
+	if w := holdAction(stale.ID, "capture", ""); w.Code != http.StatusConflict {
+		t.Errorf("expected an expired hold to be refused, got %d", w.Code)
+	}
+	if holds[late.ID].Status != holdExpired || holds[fresh.ID].Status != holdHeld {
+		t.Errorf("unexpected states %s %s", holds[late.ID].Status, holds[fresh.ID].Status)
+	}
+}
+
+// what a hold books against the daily limit comes back for the part
+// that's never paid out
+func TestHoldsGiveBackDailyLimit(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(1000), "shop": 0})
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return start }
+	dailyTransferLimit, dailyDay = units(100), ""
+	defer func() { dailyTransferLimit, dailyDay, now = 0, "", time.Now }()
+	transfer := func(amount string) int {
+		w := httptest.NewRecorder()
+		serveAPI(w, httptest.NewRequest("POST", "/v1/transfer", strings.NewReader(`{"from":"alice","to":"shop","amount":`+amount+`}`)))
+		return w.Code
+	}
+
+	released := placeTestHold(t, `{"from":"alice","to":"shop","amount":80}`)
+	if code := transfer("30"); code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected the held 80 to count against the limit, got %d", code)
+	}
+	holdAction(released.ID, "release", "")
+	expired := placeTestHold(t, `{"from":"alice","to":"shop","amount":90,"ttl":"1h"}`)
+	now = func() time.Time { return start.Add(2 * time.Hour) }
+	expireHolds(context.Background())
+	if holds[expired.ID].Status != holdExpired {
+		t.Fatalf("expected the hold expired, got %s", holds[expired.ID].Status)
+	}
+	partial := placeTestHold(t, `{"from":"alice","to":"shop","amount":50}`)
+	if w := holdAction(partial.ID, "capture", `{"amount":20}`); w.Code != http.StatusOK {
+		t.Fatalf("capture failed: %d %s", w.Code, w.Body)
+	}
+	// only the 20 captured is spent, the rest of the day's 100 is left
+	if code := transfer("80"); code != http.StatusOK {
+		t.Errorf("expected transfers up to the daily limit to pass, got %d", code)
+	}
+	if code := transfer("1"); code != http.StatusUnprocessableEntity {
+		t.Errorf("expected the limit reached, got %d", code)
+	}
+}
+
+func TestHoldValidation(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(10), "shop": 0})
+	for _, tt := range []struct {
+		body string
+		code int
+	}{
+		{`{"from":"alice","to":"shop","amount":0}`, http.StatusBadRequest},
+		{`{"from":"alice","amount":1}`, http.StatusBadRequest},
+		{`{"from":"alice","to":"shop","amount":1,"ttl":"forever"}`, http.StatusBadRequest},
+		{`{"from":"nobody","to":"shop","amount":1}`, http.StatusNotFound},
+		{`{"from":"alice","to":"shop","amount":50}`, http.StatusUnprocessableEntity},
+	} {
+		w := httptest.NewRecorder()
+		serveAPI(w, httptest.NewRequest("POST", "/v1/holds", strings.NewReader(tt.body)))
+		if w.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.body, tt.code, w.Code)
+		}
+	}
+	if w := holdAction("hold_nope", "capture", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected unknown hold to be 404, got %d", w.Code)
+	}
+}
+
+// holds don't survive a restart, what they reserved mustn't stay stuck
+func TestHoldsFreedOnRestart(t *testing.T) {
+	defer func() { store = newMemoryStore(seedBalances()) }()
+	for _, kind := range []string{"sqlite", "eventlog"} {
+		t.Run(kind, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), kind)
+			s, err := openStore(kind, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store = s
+			resetLedger()
+			placeTestHold(t, `{"from":"alice","to":"bob","amount":60}`)
+			if a := available(t, "alice"); a != units(40) {
+				t.Fatalf("expected 40 available while held, got %v", a)
+			}
+			closeStore()
+
+			for range 2 {
+				s, err = openStore(kind, path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				store = s
+				if a, b := available(t, "alice"), balance(t, "alice"); a != units(100) || b != units(100) {
+					t.Errorf("expected alice back at 100 available, got %v of %v", a, b)
+				}
+				// spending what was held must replay on the next open
+				if err := s.Debit("alice", units(1)); err != nil {
+					t.Fatal(err)
+				}
+				s.Credit("alice", units(1))
+				closeStore()
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// header clients set so a retried request is replayed instead of re-run
+const idempotencyHeader = "Idempotency-Key"
+
+// how long a stored response is replayed for, overridable with
+// IDEMPOTENCY_TTL at startup
+var idempotencyTTL = 24 * time.Hour
+
+// responses remembered per idempotency key
+var idempotencyKeys = newIdempotencyCache()
+
+// the outcome of the first request made with a key. done is closed once
+// the response has been captured
+type idempotentResponse struct {
+	bodyHash [sha256.Size]byte
+	done     chan struct{}
+	code     int
+	header   http.Header
+	body     []byte
+	storedAt time.Time
+}
+
+type idempotencyCache struct {
+	mu      sync.Mutex
+	entries map[string]*idempotentResponse
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{entries: make(map[string]*idempotentResponse)}
+}
+
+// returns the entry stored for key, or registers a new in-flight entry
+// and reports fresh=true so the caller runs the request
+func (c *idempotencyCache) claim(key string, bodyHash [sha256.Size]byte) (e *idempotentResponse, fresh bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	if e, ok := c.entries[key]; ok {
+		return e, false
+	}
+	e = &idempotentResponse{bodyHash: bodyHash, done: make(chan struct{})}
+	c.entries[key] = e
+	return e, true
+}
+
+// forgets key so the request can be tried again, used when the first
+// attempt failed on our side
+func (c *idempotencyCache) release(key string, e *idempotentResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries[key] == e {
+		delete(c.entries, key)
+	}
+	close(e.done)
+}
+
+// marks e as completed so it is replayed until the retention window ends
+func (c *idempotencyCache) store(e *idempotentResponse, rec *capturingWriter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.code = rec.code
+	e.header = rec.Header().Clone()
+	e.body = rec.buf.Bytes()
+	e.storedAt = now()
+	close(e.done)
+}
+
+// drops completed entries older than the retention window. callers must
+// hold c.mu
+func (c *idempotencyCache) expire() {
+	cutoff := now().Add(-idempotencyTTL)
+	for key, e := range c.entries {
+		if !e.storedAt.IsZero() && e.storedAt.Before(cutoff) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// passes writes through to the client while keeping a copy
+type capturingWriter struct {
+	http.ResponseWriter
+	code int
+	buf  bytes.Buffer
+}
+
+func (w *capturingWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *capturingWriter) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// wraps a handler so requests carrying an Idempotency-Key are executed
+// at most once, retries get the stored response replayed. reusing a key
+// with a different body, or on another method or path, is rejected with
+// 422, a retry arriving while the first request is still running gets 409
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		// keys are per caller and tenant, otherwise one client could
+		// replay another's response by guessing its key
+		if p := caller(r.Context()); p != nil {
+			key = p.name + ":" + key
+		}
+		if t := tenantOf(r.Context()); t != "" {
+			key = t + "/" + key
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "could not read body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := requestHash(r, body)
+
+		e, fresh := idempotencyKeys.claim(key, hash)
+		if !fresh {
+			replay(w, e, hash)
+			return
+		}
+
+		rec := &capturingWriter{ResponseWriter: w, code: http.StatusOK}
+		next(rec, r)
+		// server side failures didn't change anything worth replaying,
+		// let the client retry them for real
+		if rec.code >= http.StatusInternalServerError {
+			idempotencyKeys.release(key, e)
+			return
+		}
+		idempotencyKeys.store(e, rec)
+	}
+}
+
+// hashes what makes two requests the same one: method, path, query,
+// If-Match and body. the path is taken without the version prefix,
+// /v1/transfer and /transfer run the same handler. ?async=true and a
+// version to match change what the request does, the same key with
+// another query or If-Match isn't a retry
+func requestHash(r *http.Request, body []byte) [sha256.Size]byte {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+strings.TrimPrefix(r.URL.Path, apiPrefix)+"?"+r.URL.RawQuery+"\n")
+	io.WriteString(h, r.Header.Get("If-Match")+"\n")
+	h.Write(body)
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// writes the response stored in e back to the client
+func replay(w http.ResponseWriter, e *idempotentResponse, hash [sha256.Size]byte) {
+	if e.bodyHash != hash {
+		writeError(w, http.StatusUnprocessableEntity, codeIdempotencyKeyReused, "idempotency key reused with a different request")
+		return
+	}
+	select {
+	case <-e.done:
+	default:
+		writeError(w, http.StatusConflict, codeIdempotencyKeyInUse, "request with this idempotency key is in progress")
+		return
+	}
+	if e.storedAt.IsZero() {
+		// the first attempt was released, nothing to replay
+		writeError(w, http.StatusConflict, codeIdempotencyKeyInUse, "request with this idempotency key is in progress")
+		return
+	}
+	for k, v := range e.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(e.code)
+	w.Write(e.body)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotentTransfer(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	idempotencyKeys = newIdempotencyCache()
+	handler := idempotent(transferHandler)
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/transfer", strings.NewReader(body))
+		req.Header.Set(idempotencyHeader, key)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	body := `{"from":"alice","to":"bob","amount":25}`
+
+	first := post("k1", body)
+	if first.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", first.Code)
+	}
+	retry := post("k1", body)
+	if retry.Code != http.StatusOK || retry.Body.String() != first.Body.String() {
+		t.Fatalf("expected replay of %d %q, got %d %q", first.Code, first.Body, retry.Code, retry.Body)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("expected replayed response to be marked")
+	}
+	if got := balance(t, "alice"); got != units(75) {
+		t.Errorf("expected money to move once leaving alice with 75, got %v", got)
+	}
+
+	if w := post("k1", `{"from":"alice","to":"bob","amount":1}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for key reuse with another body, got %d", w.Code)
+	}
+
+	// once the retention window passes the key can execute again
+	defer func() { now = time.Now }()
+	later := time.Now().Add(idempotencyTTL + time.Minute)
+	now = func() time.Time { return later }
+	if w := post("k1", body); w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected a fresh execution after expiry, got %d", w.Code)
+	}
+	if got := balance(t, "alice"); got != units(50) {
+		t.Errorf("expected alice to have 50, got %v", got)
+	}
+}
+
+// the same key on another endpoint or account is not a retry
+func TestIdempotencyKeyCoversMethodAndPath(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": units(100)})
+	resetLedger()
+	idempotencyKeys = newIdempotencyCache()
+
+	post := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(`{"amount":10}`))
+		req.Header.Set(idempotencyHeader, "k1")
+		w := httptest.NewRecorder()
+		serveAPI(w, req)
+		return w
+	}
+	if w := post("/v1/accounts/alice/deposit"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body)
+	}
+	if w := post("/accounts/alice/deposit"); w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected the unversioned path to replay, got %d %s", w.Code, w.Body)
+	}
+	for _, path := range []string{"/v1/accounts/bob/deposit", "/v1/accounts/alice/withdraw"} {
+		w := post(path)
+		if w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeIdempotencyKeyReused {
+			t.Errorf("%s: expected %s, got %d %s", path, codeIdempotencyKeyReused, w.Code, w.Body)
+		}
+	}
+	if a, b := balance(t, "alice"), balance(t, "bob"); a != units(110) || b != units(100) {
+		t.Errorf("expected only the first deposit to apply, got alice=%v bob=%v", a, b)
+	}
+}
+
+// the same key with ?async=true or another If-Match asks for something
+// else than the first request did
+func TestIdempotencyKeyCoversQueryAndIfMatch(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	idempotencyKeys = newIdempotencyCache()
+
+	post := func(path, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(`{"from":"alice","to":"bob","amount":10}`))
+		req.Header.Set(idempotencyHeader, "k1")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		serveAPI(w, req)
+		return w
+	}
+	if w := post("/v1/transfer", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body)
+	}
+	for _, tt := range []struct{ path, ifMatch string }{{"/v1/transfer?async=true", ""}, {"/v1/transfer", `"2"`}} {
+		w := post(tt.path, tt.ifMatch)
+		if w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeIdempotencyKeyReused {
+			t.Errorf("%s %s: expected %s, got %d %s", tt.path, tt.ifMatch, codeIdempotencyKeyReused, w.Code, w.Body)
+		}
+	}
+	if w := post("/v1/transfer", ""); w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected the same request to still replay, got %d %s", w.Code, w.Body)
+	}
+	if got := balance(t, "bob"); got != units(10) {
+		t.Errorf("expected one transfer to have run, bob has %v", got)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// savings accounts earn INTEREST_RATE per INTEREST_PERIOD, daily or
+// monthly, on the balance they end each UTC day with. a day ending at or
+// below zero earns nothing, a monthly rate is spread evenly over the
+// days of each month. nothing but the ledger is kept: what accrued is
+// worked out from it, and once a period is over a worker pays it in as
+// an entry from outside the system whose accrued_to is where the next
+// accrual starts. the total is rounded to the cent when posted, a period
+// earning less than half a cent carries over to the next
+
+const (
+	periodDaily   = "daily"
+	periodMonthly = "monthly"
+)
+
+var (
+	// the rate per period, nil turns interest off
+	interestRate   *big.Rat
+	interestPeriod = periodMonthly
+)
+
+// midnight UTC of the day t is in
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// the start of the interest period t is in
+func periodStart(t time.Time) time.Time {
+	d := startOfDay(t)
+	if interestPeriod == periodDaily {
+		return d
+	}
+	return d.AddDate(0, 0, 1-d.Day())
+}
+
+// the start of the period after the one t is in
+func nextPeriod(t time.Time) time.Time {
+	if interestPeriod == periodDaily {
+		return periodStart(t).AddDate(0, 0, 1)
+	}
+	return periodStart(t).AddDate(0, 1, 0)
+}
+
+// the part of the rate earned on the day starting at day
+func dailyRate(day time.Time) *big.Rat {
+	if interestPeriod == periodDaily {
+		return interestRate
+	}
+	days := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	return new(big.Rat).Quo(interestRate, big.NewRat(int64(days), 1))
+}
+
+// where interest on account, qualified, accrues from: the end of what was
+// last posted, or the day the ledger started. the entry saying so is
+// booked with the credit, and the ledger in memory is all there is in
+// the store since only one replica uses it (see lease.go)
+func accrualStart(account string) time.Time {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+	for i := len(ledger) - 1; i >= 0; i-- {
+		if e := ledger[i]; e.AccruedTo != nil && e.To == account {
+			return *e.AccruedTo
+		}
+	}
+	return startOfDay(openedAt)
+}
+
+// the interest account, qualified, earned on every day from since up to
+// until, both midnights, rounded to the cent
+func accruedInterest(account string, since, until time.Time) Money {
+	ledgerMu.Lock()
+	bal := openingBalances[account]
+	type change struct {
+		at  time.Time
+		net Money
+	}
+	var changes []change
+	for _, e := range ledger {
+		if !e.touches(account) {
+			continue
+		}
+		at := e.Timestamp
+		if e.SettledAt != nil {
+			at = *e.SettledAt
+		}
+		changes = append(changes, change{at, e.net(account)})
+	}
+	ledgerMu.Unlock()
+	// settled entries moved their money after entries recorded later
+	slices.SortStableFunc(changes, func(a, b change) int { return a.at.Compare(b.at) })
+
+	total := new(big.Rat)
+	for day := since; day.Before(until); day = day.AddDate(0, 0, 1) {
+		end := day.AddDate(0, 0, 1)
+		for len(changes) > 0 && changes[0].at.Before(end) {
+			bal += changes[0].net
+			changes = changes[1:]
+		}
+		if bal > 0 {
+			total.Add(total, new(big.Rat).Mul(new(big.Rat).SetInt64(int64(bal)), dailyRate(day)))
+		}
+	}
+	return roundMoney(total)
+}
+
+// pays every savings account the interest of the periods over by at
+func postInterest(at time.Time) {
+	if interestRate == nil {
+		return
+	}
+	list, err := store.All()
+	if err != nil {
+		log.Printf("interest: list accounts: %v", err)
+		return
+	}
+	due := periodStart(at)
+	for _, a := range list {
+		if a.Type != accountSavings || a.Status == accountClosed {
+			continue
+		}
+		since := accrualStart(a.ID)
+		if !since.Before(due) {
+			continue
+		}
+		amount := accruedInterest(a.ID, since, due)
+		if amount <= 0 {
+			continue
+		}
+		tenant, id := splitTenant(a.ID)
+		ctx := withTenant(context.Background(), tenant)
+		entry := ledgerEntry{To: id, Amount: amount, Currency: a.Currency, Status: statusCompleted, AccruedTo: &due}
+		err := auditWork(ctx, "interest", apiPrefix+"/accounts/"+id, func(ctx context.Context) error {
+			_, err := book(ctx, func(s Store) error { return s.Credit(id, amount) }, entry)
+			return err
+		})
+		if err != nil {
+			log.Printf("interest: credit %s: %v", a.ID, err)
+		}
+	}
+}
+
+// posts interest every interval until ctx is cancelled
+func runInterest(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		postInterest(now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// models the JSON response for GET /accounts/{account}/interest
+type interestPreview struct {
+	Account      string     `json:"account"`
+	Type         string     `json:"type"`
+	Rate         string     `json:"rate"`
+	Period       string     `json:"period"`
+	AccruedSince time.Time  `json:"accrued_since"`
+	AccruedTo    time.Time  `json:"accrued_to"`
+	Accrued      Money      `json:"accrued"`
+	Currency     string     `json:"currency"`
+	NextPosting  *time.Time `json:"next_posting"`
+}
+
+// handles GET /accounts/{account}/interest, what a savings account earned
+// over the full days since its interest was last posted. checking
+// accounts, or any account while interest is off, have accrued nothing
+func interestHandler(w http.ResponseWriter, r *http.Request) {
+	account := r.PathValue("account")
+	acct, err := getBalance(r.Context(), account)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	today := startOfDay(now())
+	p := interestPreview{Account: account, Type: acct.Type, Rate: "0", Period: interestPeriod, AccruedTo: today, Currency: acct.Currency}
+	qualified := qualify(tenantOf(r.Context()), account)
+	p.AccruedSince = accrualStart(qualified)
+	if acct.Type == accountSavings && interestRate != nil {
+		p.Rate = strings.TrimRight(strings.TrimRight(interestRate.FloatString(10), "0"), ".")
+		if p.AccruedSince.Before(today) {
+			p.Accrued = accruedInterest(qualified, p.AccruedSince, today)
+		}
+		next := nextPeriod(now())
+		p.NextPosting = &next
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
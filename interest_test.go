@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func previewInterest(t *testing.T, account string) interestPreview {
+	t.Helper()
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/accounts/"+account+"/interest", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body)
+	}
+	var p interestPreview
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestInterest(t *testing.T) {
+	clock := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	// 3.1% a month is 0.1% a day in March
+	interestRate, interestPeriod = big.NewRat(31, 1000), periodMonthly
+	defer func() { now, interestRate = time.Now, nil }()
+	store = newMemoryStore(map[string]Money{})
+	resetLedger()
+	for _, body := range []string{
+		`{"id":"savings","type":"savings","balance":1000}`,
+		`{"id":"checking","balance":1000}`,
+	} {
+		w := httptest.NewRecorder()
+		serveAPI(w, httptest.NewRequest("POST", "/v1/accounts", strings.NewReader(body)))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d %s", w.Code, w.Body)
+		}
+	}
+
+	clock = time.Date(2026, 3, 13, 8, 0, 0, 0, time.UTC)
+	p := previewInterest(t, "savings")
+	if p.Accrued != units(3) || p.Rate != "0.031" || !p.AccruedSince.Equal(time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected 3.00 over three days since March 10, got %+v", p)
+	}
+	if p.NextPosting == nil || !p.NextPosting.Equal(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the next posting on April 1, got %v", p.NextPosting)
+	}
+	if c := previewInterest(t, "checking"); c.Accrued != 0 || c.Type != accountChecking || c.NextPosting != nil {
+		t.Errorf("expected a checking account to earn nothing, got %+v", c)
+	}
+	// the month isn't over yet
+	postInterest(clock)
+	if b := balance(t, "savings"); b != units(1000) {
+		t.Errorf("expected nothing posted before the month ends, got %v", b)
+	}
+
+	// March 10 to 31 is 22 days
+	clock = time.Date(2026, 4, 2, 10, 0, 0, 0, time.UTC)
+	postInterest(clock)
+	postInterest(clock)
+	if b := balance(t, "savings"); b != units(1022) {
+		t.Errorf("expected 22.00 posted once, got a balance of %v", b)
+	}
+	if b := balance(t, "checking"); b != units(1000) {
+		t.Errorf("expected no interest on checking, got %v", b)
+	}
+	list := entries(t.Context(), "savings")
+	e := list[len(list)-1]
+	if e.From != "" || e.Amount != units(22) || e.AccruedTo == nil || !e.AccruedTo.Equal(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected an interest entry accrued to April 1, got %+v", e)
+	}
+	// the interest came in on April 2, April 1 ended at 1000.00 still and
+	// earned 3.1% of it over 30 days
+	if p := previewInterest(t, "savings"); p.Accrued != 103 || !p.AccruedSince.Equal(*e.AccruedTo) {
+		t.Errorf("expected 1.03 since April 1, got %+v", p)
+	}
+}
+
+func TestInterestDaily(t *testing.T) {
+	clock := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	interestRate, interestPeriod = big.NewRat(1, 1000), periodDaily
+	defer func() { now, interestRate, interestPeriod = time.Now, nil, periodMonthly }()
+	store = newMemoryStore(map[string]Money{"bob": 0})
+	resetLedger()
+	if err := store.Create(Account{ID: "savings", Currency: "USD", Type: accountSavings}); err != nil {
+		t.Fatal(err)
+	}
+	record(t.Context(), ledgerEntry{To: "savings", Amount: units(100), Currency: "USD", Status: statusCompleted})
+	store.Credit("savings", units(100))
+
+	// emptied on the 11th, so only the 10th earns
+	clock = clock.AddDate(0, 0, 1)
+	transferID(t, `{"from":"savings","to":"bob","amount":100}`)
+	clock = clock.AddDate(0, 0, 2)
+	postInterest(clock)
+	if b := balance(t, "savings"); b != 10 {
+		t.Errorf("expected 0.10 for the one day with a balance, got %v", b)
+	}
+	p := previewInterest(t, "savings")
+	if p.Accrued != 0 || !p.AccruedSince.Equal(startOfDay(clock)) || p.Period != periodDaily {
+		t.Errorf("expected nothing accrued since today, got %+v", p)
+	}
+}
+
+func TestInterestErrors(t *testing.T) {
+	store = newMemoryStore(map[string]Money{})
+	resetLedger()
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/accounts", strings.NewReader(`{"id":"loan","type":"loan"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown type, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/accounts/missing/interest", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+}
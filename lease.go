@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// a sqlite database is used by one replica at a time. holds, schedules,
+// limits, idempotency keys and the ledger are kept in the memory of the
+// replica that loaded them, a second one writing to the same file would
+// number entries, pay interest and answer retries from what it saw at
+// its own startup. so opening the file takes its "store" lease, a
+// replica finding it held by another refuses to start, and the lease is
+// renewed for as long as the replica runs and released when it closes
+// the store. a replica taking over after one stopped loads the ledger,
+// interest paid up to then included, once it holds the lease. one that
+// crashed holds the file until its lease runs out. the memory and
+// eventlog stores belong to one process, there is nothing to share
+
+// leaseStore is implemented by backends replicas could share
+type leaseStore interface {
+	// Lease takes name for holder until ttl from now, or renews it.
+	// false while another holder's lease hasn't expired
+	Lease(name, holder string, ttl time.Duration) (bool, error)
+}
+
+// the lease on the store itself, what openSQLStore takes
+const (
+	storeLease    = "store"
+	storeLeaseTTL = 30 * time.Second
+)
+
+// who this replica is to the others
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+}
+
+// takes the store lease for this replica, failing while another holds it
+func claimStore(ls leaseStore) error {
+	held, err := ls.Lease(storeLease, instanceID, storeLeaseTTL)
+	if err != nil {
+		return fmt.Errorf("take store lease: %w", err)
+	}
+	if !held {
+		return fmt.Errorf("another replica is using the database, it is free %v after that one stops", storeLeaseTTL)
+	}
+	return nil
+}
+
+// renews the store lease every interval until ctx is cancelled. a store
+// of its own has none. losing it, to a replica that took over while this
+// one couldn't renew, ends the process before it writes over the other's
+// work. a renewal the store refused is tried again until the lease would
+// be gone
+func runStoreLease(ctx context.Context, interval time.Duration) {
+	ls, ok := store.(leaseStore)
+	if !ok {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	renewed := now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		held, err := ls.Lease(storeLease, instanceID, storeLeaseTTL)
+		switch {
+		case !held && err == nil:
+			log.Fatalf("store lease taken by another replica")
+		case err != nil && now().Sub(renewed) >= storeLeaseTTL:
+			log.Fatalf("store lease expired: %v", err)
+		case err != nil:
+			slog.Error("renew store lease", "err", err)
+		default:
+			renewed = now()
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a transfer through the API of whichever replica runs now
+func replicaTransfer(t *testing.T, body string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/transfer", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("transfer: %d %s", w.Code, w.Body)
+	}
+}
+
+// a second replica can't open the database while the first uses it. it
+// takes over once the first stopped and carries on from its ledger
+func TestReplicasTakeTurns(t *testing.T) {
+	first := instanceID
+	defer func() {
+		instanceID = first
+		store = newMemoryStore(seedBalances())
+		resetLedger()
+	}()
+	path := filepath.Join(t.TempDir(), "tx.db")
+	a, err := openStore("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store = a
+	if err := loadLedger(); err != nil {
+		t.Fatal(err)
+	}
+	replicaTransfer(t, `{"from":"alice","to":"bob","amount":30}`)
+
+	instanceID = "replica-b"
+	if _, err := openStore("sqlite", path); err == nil {
+		t.Fatal("expected the second replica refused while the first runs")
+	}
+
+	// the first stops, releasing the database, and the second starts
+	// from nothing in memory
+	instanceID = first
+	if err := closeStore(); err != nil {
+		t.Fatal(err)
+	}
+	instanceID = "replica-b"
+	b, err := openStore("sqlite", path)
+	if err != nil {
+		t.Fatalf("expected the second replica to take over, got %v", err)
+	}
+	store = b
+	defer closeStore()
+	ledgerMu.Lock()
+	ledger = nil
+	ledgerMu.Unlock()
+	if err := loadLedger(); err != nil {
+		t.Fatal(err)
+	}
+	replicaTransfer(t, `{"from":"alice","to":"bob","amount":20}`)
+
+	list := entries(t.Context(), "")
+	if len(list) != 2 || list[0].ID != 1 || list[1].ID != 2 || list[1].Amount != units(20) {
+		t.Fatalf("expected the second replica's entry to follow the first's, got %+v", list)
+	}
+	alice, _ := store.Get("alice")
+	bob, _ := store.Get("bob")
+	if alice.Balance != units(50) || bob.Balance != units(100) {
+		t.Errorf("expected both transfers once, got alice %v and bob %v", alice.Balance, bob.Balance)
+	}
+	if tb := buildTrialBalance(t.Context(), mustAll(t)); !tb.Balanced {
+		t.Errorf("expected the ledger to explain the balances, got %+v", tb)
+	}
+}
+
+func TestLease(t *testing.T) {
+	clock := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	s, err := openSQLStore(filepath.Join(t.TempDir(), "tx.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if held, _ := s.Lease(storeLease, "b", time.Minute); held {
+		t.Error("expected the store lease held by whoever opened it")
+	}
+	for _, step := range []struct {
+		holder string
+		want   bool
+	}{{"a", true}, {"b", false}, {"a", true}} {
+		if held, err := s.Lease("worker", step.holder, time.Minute); err != nil || held != step.want {
+			t.Fatalf("%s: expected %v, got %v %v", step.holder, step.want, held, err)
+		}
+	}
+	// a stopped renewing
+	clock = clock.Add(time.Minute)
+	if held, _ := s.Lease("worker", "b", time.Minute); !held {
+		t.Error("expected b to take over once a's lease expired")
+	}
+	if held, _ := s.Lease("worker", "a", time.Minute); held {
+		t.Error("expected a to have lost the lease")
+	}
+	if held, _ := s.Lease("other", "a", time.Minute); !held {
+		t.Error("expected leases of other names to be free")
+	}
+	// the store's runs out the same way, as when its replica crashed
+	if held, _ := s.Lease(storeLease, "b", time.Minute); !held {
+		t.Error("expected the store lease free once it ran out")
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// states a ledger entry can be recorded in. async transfers are
+// recorded pending and move on to processing, then completed or failed,
+// when the outbox worker gets to them (see async.go)
+const (
+	statusCompleted  = "completed"
+	statusFailed     = "failed"
+	statusPending    = "pending"
+	statusProcessing = "processing"
+	// flagged by a risk check, waiting for an admin (see risk.go)
+	statusPendingReview = "pending_review"
+)
+
+// page size limits for the transaction list endpoints
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// a record of one attempted movement of funds. From is empty for money
+// coming from outside the system (deposits, confirmed payment callbacks)
+// and To for money leaving it (withdrawals). failed entries never touched
+// any balance. conversions credit ToAmount in ToCurrency instead of
+// Amount. Postings are the debits and credits the entry is made of, see
+// postings.go. entries don't change once recorded, except that
+// ReversedBy is set when a reversal undoes them and an async transfer is
+// finished in the entry it was accepted with, as is a transfer parked for
+// review. Error says why an async transfer failed or why a transfer was
+// flagged by the risk checks. SettledAt is when such an entry finished,
+// its money moved then and not at Timestamp
+type ledgerEntry struct {
+	ID         int64      `json:"id"`
+	From       string     `json:"from,omitempty"`
+	To         string     `json:"to,omitempty"`
+	Amount     Money      `json:"amount"`
+	Currency   string     `json:"currency"`
+	ToAmount   Money      `json:"to_amount,omitempty"`
+	ToCurrency string     `json:"to_currency,omitempty"`
+	Timestamp  time.Time  `json:"timestamp"`
+	Status     string     `json:"status"`
+	HoldID     string     `json:"hold_id,omitempty"`
+	Reverses   int64      `json:"reverses,omitempty"`
+	ReversedBy int64      `json:"reversed_by,omitempty"`
+	Postings   []posting  `json:"postings,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	SettledAt  *time.Time `json:"settled_at,omitempty"`
+	// set on interest paid to a savings account, the end of the days it
+	// was earned over
+	AccruedTo *time.Time `json:"accrued_to,omitempty"`
+}
+
+var (
+	// guards everything below
+	ledgerMu sync.Mutex
+	// balances as they were when the ledger started recording
+	openingBalances map[string]Money
+	openingPostings []posting
+	openedAt        time.Time
+	// the opening the above were set from, for snapshots
+	openedFrom ledgerOpening
+	// every recorded entry in the order it happened, IDs start at 1
+	ledger []ledgerEntry
+	// signalled whenever an entry was added to ledger
+	ledgerGrew = sync.NewCond(&ledgerMu)
+	// held for reading by every booking and taken whole while the ledger
+	// is loaded, reset or restored, so no booking is half done then
+	bookMu sync.RWMutex
+)
+
+// clock used to timestamp the ledger, swapped out in tests
+var now = time.Now
+
+// models the JSON response for the transaction list endpoints, total
+// counts what the filters let through
+type transactionPage struct {
+	Transactions []ledgerEntry `json:"transactions"`
+	Total        int           `json:"total"`
+	NextOffset   *int          `json:"next_offset"`
+	NextCursor   *string       `json:"next_cursor"`
+}
+
+// ledgerStore is implemented by the store backends that keep the ledger
+// next to the balances, so entry ids and history survive a restart. the
+// memory store doesn't, its ledger goes when its balances do
+type ledgerStore interface {
+	// LoadLedger returns where the ledger started and every entry since
+	// in id order, a zero opening when no ledger was ever started
+	LoadLedger() (ledgerOpening, []ledgerEntry, error)
+	// OpenLedger starts over from o, dropping every entry kept so far
+	OpenLedger(o ledgerOpening) error
+	// Book makes the change op makes to the store it is handed and keeps
+	// entries in the same write, so either both are kept or neither is.
+	// op's error is returned as it is. entries without an ID are
+	// appended, the store numbers and timestamps them as it writes, the
+	// others replace the kept entry with their ID. an appended reversal
+	// that completed marks the entry it reverses too. op may be nil, it
+	// makes at most one change
+	Book(ctx context.Context, op func(Store) error, entries []ledgerEntry) ([]ledgerEntry, error)
+}
+
+// the balances a ledger starts from, only ID, Balance and Currency of
+// each account matter
+type ledgerOpening struct {
+	At       time.Time `json:"at"`
+	Accounts []Account `json:"accounts"`
+}
+
+// picks the ledger up from the store when it keeps one, otherwise
+// starts a fresh one as resetLedger does
+func loadLedger() error {
+	ls, ok := store.(ledgerStore)
+	if !ok {
+		return resetLedger()
+	}
+	bookMu.Lock()
+	o, list, err := ls.LoadLedger()
+	if err == nil && !o.At.IsZero() {
+		list, err = failInterrupted(ls, list)
+	}
+	bookMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if o.At.IsZero() {
+		return resetLedger()
+	}
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+	startLedger(o)
+	ledger = list
+	return nil
+}
+
+// starts a fresh ledger using the balances currently in the store as
+// the known starting point
+func resetLedger() error {
+	bookMu.Lock()
+	defer bookMu.Unlock()
+	accts, err := store.All()
+	if err != nil {
+		return err
+	}
+	o := ledgerOpening{At: now(), Accounts: accts}
+	if ls, ok := store.(ledgerStore); ok {
+		if err := ls.OpenLedger(o); err != nil {
+			return err
+		}
+	}
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+	startLedger(o)
+	ledger = nil
+	return nil
+}
+
+// sets the opening balances from o, called with ledgerMu held
+func startLedger(o ledgerOpening) {
+	openingBalances = make(map[string]Money, len(o.Accounts))
+	openingPostings = nil
+	for _, a := range o.Accounts {
+		openingBalances[a.ID] = a.Balance
+		// whatever was there already came in from outside at some point
+		opening := ledgerEntry{To: a.ID, Amount: a.Balance, Currency: a.Currency, Status: statusCompleted}
+		if a.Balance < 0 {
+			opening = ledgerEntry{From: a.ID, Amount: -a.Balance, Currency: a.Currency, Status: statusCompleted}
+		}
+		if a.Balance != 0 {
+			openingPostings = append(openingPostings, opening.withPostings().Postings...)
+		}
+	}
+	openedAt = o.At
+	openedFrom = o
+}
+
+// makes the change op makes to ctx's store and records entries with
+// it. a store keeping the ledger writes both at once, so a crash never
+// leaves money moved that the ledger doesn't explain. new entries get
+// their ID and timestamp in the order they are written, an entry with an
+// ID already replaces that one. nothing is recorded when op fails, its
+// error is returned, and an entry that can't be kept fails the change
+// with it. webhooks and streams hear about new entries, and about
+// replaced ones once they are final, after. webhook deliveries belong to
+// ctx's trace. op may only change the accounts of entries
+func book(ctx context.Context, op func(Store) error, entries ...ledgerEntry) ([]ledgerEntry, error) {
+	entries = slices.Clone(entries)
+	fresh := make([]bool, len(entries))
+	for i, e := range entries {
+		if fresh[i] = e.ID == 0; fresh[i] {
+			e.Postings = e.postings()
+			entries[i] = e.qualified(tenantOf(ctx))
+		}
+	}
+	release := func() {}
+	if t := auditTrailOf(ctx); t != nil && op != nil {
+		// op changes the accounts of the entries, an audited call keeps
+		// them to itself until the booking is over
+		var accounts []string
+		for _, e := range entries {
+			accounts = append(accounts, e.From, e.To)
+		}
+		release = t.hold(storeFor(context.WithoutCancel(ctx)), tenantOf(ctx), accounts...)
+	}
+	bookMu.RLock()
+	ls, ok := store.(ledgerStore)
+	if !ok {
+		if op != nil {
+			if err := op(storeFor(ctx)); err != nil {
+				bookMu.RUnlock()
+				release()
+				return nil, err
+			}
+		}
+		ledgerMu.Lock()
+		for i := range entries {
+			if fresh[i] {
+				entries[i].ID = int64(len(ledger) + 1)
+				entries[i].Timestamp = now()
+			}
+			keep(entries[i])
+		}
+		ledgerMu.Unlock()
+	} else {
+		var apply func(Store) error
+		if op != nil {
+			apply = func(s Store) error { return op(storeOver(ctx, s)) }
+		} else {
+			// there is no change for the request to call off, the entries
+			// are written whatever happens to it
+			ctx = context.WithoutCancel(ctx)
+		}
+		kept, err := ls.Book(ctx, apply, entries)
+		if err != nil {
+			bookMu.RUnlock()
+			release()
+			return nil, err
+		}
+		ledgerMu.Lock()
+		for i, e := range kept {
+			// bookings return in any order, the ledger takes them in the
+			// order the store numbered them
+			for fresh[i] && e.ID > int64(len(ledger)+1) {
+				ledgerGrew.Wait()
+			}
+			keep(e)
+		}
+		ledgerMu.Unlock()
+		entries = kept
+	}
+	bookMu.RUnlock()
+	release()
+
+	out := make([]ledgerEntry, len(entries))
+	for i, e := range entries {
+		if fresh[i] || e.Status == statusCompleted || e.Status == statusFailed {
+			notifyWebhooks(ctx, e)
+			publish(e)
+		}
+		out[i] = e.local()
+	}
+	return out, nil
+}
+
+// puts a booked entry in its place, appended when it is the next one.
+// a completed reversal marks the entry it undid. called with ledgerMu held
+func keep(e ledgerEntry) {
+	if e.ID > int64(len(ledger)) {
+		ledger = append(ledger, e)
+		ledgerGrew.Broadcast()
+		if e.Reverses != 0 && e.Status == statusCompleted {
+			ledger[e.Reverses-1].ReversedBy = e.ID
+		}
+		return
+	}
+	ledger[e.ID-1] = e
+}
+
+// records e, which changes no balance, like a failed attempt or a
+// transfer accepted for later
+func record(ctx context.Context, e ledgerEntry) (ledgerEntry, error) {
+	list, err := book(ctx, nil, e)
+	if err != nil {
+		return ledgerEntry{}, err
+	}
+	return list[0], nil
+}
+
+// entry id with its status and error set, and its postings and the time
+// it settled once it is final, ready to be booked in its place
+func settled(id int64, status, reason string) ledgerEntry {
+	ledgerMu.Lock()
+	e := ledger[id-1]
+	ledgerMu.Unlock()
+	e.Status, e.Error = status, reason
+	e = e.withPostings()
+	if status == statusCompleted || status == statusFailed {
+		t := now()
+		e.SettledAt = &t
+	}
+	return e
+}
+
+// sets the status of entry id, with postings once it completed, when
+// that could be kept
+func updateEntry(ctx context.Context, id int64, status, reason string) (ledgerEntry, error) {
+	list, err := book(ctx, nil, settled(id, status, reason))
+	if err != nil {
+		return ledgerEntry{}, err
+	}
+	return list[0], nil
+}
+
+// returns the entry with the given id, as long as it is of ctx's tenant
+func entryByID(ctx context.Context, id int64) (ledgerEntry, bool) {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+	if id < 1 || id > int64(len(ledger)) || ledger[id-1].tenant() != tenantOf(ctx) {
+		return ledgerEntry{}, false
+	}
+	return ledger[id-1].local(), true
+}
+
+// serves GET /transactions/{id} to whoever may read either side of it
+func transactionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	e, ok := entryByID(r.Context(), id)
+	if err != nil || !ok {
+		writeError(w, http.StatusNotFound, codeTransactionNotFound, "transaction not found")
+		return
+	}
+	if !mayRead(r.Context(), e.From) && !mayRead(r.Context(), e.To) {
+		forbidden(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
+
+// returns the entries of ctx's tenant touching account, or all of them
+// when account is empty, oldest first
+func entries(ctx context.Context, account string) []ledgerEntry {
+	tenant := tenantOf(ctx)
+	account = qualify(tenant, account)
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+	var out []ledgerEntry
+	for _, e := range ledger {
+		if e.tenant() != tenant {
+			continue
+		}
+		if account == "" || e.From == account || e.To == account {
+			out = append(out, e.local())
+		}
+	}
+	return out
+}
+
+// handles GET /transactions listing the whole ledger
+func transactionsHandler(w http.ResponseWriter, r *http.Request) {
+	writeTransactions(w, r, "")
+}
+
+// serves GET /accounts/{account}/transactions
+func accountTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	writeTransactions(w, r, r.PathValue("account"))
+}
+
+// writes one page of account's entries, all of them when account is
+// empty, selected by the list params (see query.go)
+func writeTransactions(w http.ResponseWriter, r *http.Request, account string) {
+	lq, err := parseListQuery(r, transactionList)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	list, err := listTransactions(r.Context(), account)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	page := pageOf(list, lq, transactionList)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transactionPage{page.items, page.total, page.nextOffset, page.nextCursor})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransactionsPagination(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0, "carol": 0})
+	if err := resetLedger(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []string{
+		`{"from":"alice","to":"bob","amount":10}`,
+		`{"from":"bob","to":"carol","amount":500}`, // insufficient funds
+		`{"from":"alice","to":"carol","amount":20}`,
+	} {
+		transferHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/transfer", strings.NewReader(body)))
+	}
+
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/transactions?limit=2", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var page transactionPage
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 3 || len(page.Transactions) != 2 {
+		t.Fatalf("expected 2 of 3 entries, got %d of %d", len(page.Transactions), page.Total)
+	}
+	if page.NextOffset == nil || *page.NextOffset != 2 {
+		t.Errorf("expected next offset 2, got %v", page.NextOffset)
+	}
+	if page.Transactions[1].Status != statusFailed {
+		t.Errorf("expected the overdraft to be recorded as failed, got %q", page.Transactions[1].Status)
+	}
+
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/accounts/carol/transactions?offset=1", nil))
+	page = transactionPage{}
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Transactions) != 1 || page.NextOffset != nil {
+		t.Fatalf("expected last of carol's 2 entries, got %+v", page)
+	}
+	if e := page.Transactions[0]; e.ID != 3 || e.From != "alice" || e.Amount != units(20) || e.Status != statusCompleted {
+		t.Errorf("unexpected entry %+v", e)
+	}
+}
+
+// entries, their ids and reversals come back after a restart of the
+// stores that keep the ledger
+func TestLedgerSurvivesRestart(t *testing.T) {
+	defer func() { store = newMemoryStore(seedBalances()) }()
+	for _, kind := range []string{"sqlite", "eventlog"} {
+		t.Run(kind, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), kind)
+			s, err := openStore(kind, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store = s
+			if err := loadLedger(); err != nil {
+				t.Fatal(err)
+			}
+			opened := openedAt
+			id := transferID(t, `{"from":"alice","to":"bob","amount":30}`)
+			if w := reverseTx(id); w.Code != http.StatusCreated {
+				t.Fatalf("reverse: %d %s", w.Code, w.Body)
+			}
+			transferID(t, `{"from":"alice","to":"bob","amount":5}`)
+			closeStore()
+
+			// as if the process restarted, nothing is left in memory
+			ledgerMu.Lock()
+			ledger, openedAt = nil, time.Time{}
+			ledgerMu.Unlock()
+			s, err = openStore(kind, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store = s
+			defer closeStore()
+			if err := loadLedger(); err != nil {
+				t.Fatal(err)
+			}
+
+			list := entries(t.Context(), "")
+			if len(list) != 3 || list[0].ID != id || list[0].ReversedBy != 2 || list[1].Reverses != id {
+				t.Fatalf("expected the transfer, its reversal and the second transfer, got %+v", list)
+			}
+			if !openedAt.Equal(opened) {
+				t.Errorf("expected the ledger to still open at %v, got %v", opened, openedAt)
+			}
+			if w := reverseTx(id); w.Code != http.StatusConflict {
+				t.Errorf("expected the reversal to be remembered, got %d", w.Code)
+			}
+			if next := transferID(t, `{"from":"alice","to":"bob","amount":1}`); next != 4 {
+				t.Errorf("expected ids to carry on at 4, got %d", next)
+			}
+			if bal, _, _ := balanceAt(t.Context(), "alice", time.Now()); bal != units(94) {
+				t.Errorf("expected history to rebuild alice at 94, got %v", bal)
+			}
+			if tb := buildTrialBalance(t.Context(), mustAll(t)); !tb.Balanced {
+				t.Errorf("expected the reloaded ledger to balance, got %+v", tb)
+			}
+		})
+	}
+}
+
+func mustAll(t *testing.T) []Account {
+	t.Helper()
+	accts, err := store.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return accts
+}
+
+// a change and its entries are kept together or not at all, and a
+// transfer is a single write to the event log
+func TestBookingIsAtomic(t *testing.T) {
+	defer func() { store = newMemoryStore(seedBalances()) }()
+	for _, kind := range []string{"sqlite", "eventlog"} {
+		t.Run(kind, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), kind)
+			s, err := openStore(kind, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store = s
+			defer closeStore()
+			if err := loadLedger(); err != nil {
+				t.Fatal(err)
+			}
+			boom := errors.New("boom")
+			_, err = book(t.Context(), func(s Store) error {
+				if err := s.Credit("alice", units(5)); err != nil {
+					return err
+				}
+				return boom
+			}, ledgerEntry{To: "alice", Amount: units(5), Currency: "USD", Status: statusCompleted})
+			if !errors.Is(err, boom) {
+				t.Fatalf("expected the change's error, got %v", err)
+			}
+			if a, _ := store.Get("alice"); a.Balance != units(100) || len(entries(t.Context(), "")) != 0 {
+				t.Errorf("expected neither the credit nor its entry kept, alice has %v", a.Balance)
+			}
+
+			lines := func() int {
+				b, _ := os.ReadFile(path)
+				return strings.Count(string(b), "\n")
+			}
+			before := lines()
+			transferID(t, `{"from":"alice","to":"bob","amount":30}`)
+			if kind == "eventlog" && lines() != before+1 {
+				t.Errorf("expected the transfer and its entry in one event, the log grew by %d", lines()-before)
+			}
+			_, kept, err := store.(ledgerStore).LoadLedger()
+			if kind == "sqlite" && (err != nil || len(kept) != 1 || kept[0].Status != statusCompleted) {
+				t.Errorf("expected the transfer's entry kept, got %+v %v", kept, err)
+			}
+		})
+	}
+}
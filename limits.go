@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// request rate limits, in requests per second with an equally sized
+// burst, loaded from RATE_LIMIT (whole API) and KEY_RATE_LIMIT (per API
+// key) at startup. zero leaves them off
+var (
+	limitsMu    sync.Mutex
+	globalLimit *tokenBucket
+	keyRate     float64
+	keyLimits   = map[string]*tokenBucket{}
+)
+
+// business limits on money leaving an account, from MAX_TRANSFER_AMOUNT
+// and DAILY_TRANSFER_LIMIT, and the smallest transfer, from
+// MIN_TRANSFER_AMOUNT. zero means unlimited
+var (
+	maxTransferAmount  Money
+	dailyTransferLimit Money
+	minTransferAmount  Money
+)
+
+// tokenBucket allows rate requests per second on average and up to burst
+// at once. callers serialize access through limitsMu
+type tokenBucket struct {
+	rate, burst, tokens float64
+	last                time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := math.Max(rate, 1)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now()}
+}
+
+// takes a token if there is one, otherwise reports how long until there is
+func (b *tokenBucket) take() (bool, time.Duration) {
+	t := now()
+	b.tokens = math.Min(b.burst, b.tokens+t.Sub(b.last).Seconds()*b.rate)
+	b.last = t
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// wraps a handler so requests over the global or the caller's rate get
+// 429 with a Retry-After hint. has to run after authenticate
+func rateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := allowRequest(r.Context()); !ok {
+			secs := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			writeErrorDetails(w, http.StatusTooManyRequests, codeRateLimited, "too many requests",
+				map[string]any{"retry_after_seconds": secs})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// takes a token from the global and the caller's bucket, the caller
+// being whoever authenticated on ctx over HTTP or gRPC
+func allowRequest(ctx context.Context) (bool, time.Duration) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	if globalLimit != nil {
+		if ok, wait := globalLimit.take(); !ok {
+			return false, wait
+		}
+	}
+	p := caller(ctx)
+	if keyRate <= 0 || p == nil {
+		return true, 0
+	}
+	b, ok := keyLimits[p.name]
+	if !ok {
+		b = newTokenBucket(keyRate)
+		keyLimits[p.name] = b
+	}
+	return b.take()
+}
+
+// what has left each account today, reset when the UTC day changes
+var (
+	dailyMu    sync.Mutex
+	dailyDay   string
+	dailySpent = map[string]Money{}
+)
+
+// checks amount against the business limits and books it against the
+// account's daily total. the returned func gives it back if the transfer
+// doesn't go through
+func reserveLimit(ctx context.Context, account string, amount Money) (func(), error) {
+	giveBack, err := bookLimit(ctx, account, amount)
+	if err != nil {
+		return nil, err
+	}
+	return func() { giveBack(amount) }, nil
+}
+
+// reserveLimit for money that may only partly leave, like a hold. the
+// returned func gives back any part of amount, on the day it was booked
+func bookLimit(ctx context.Context, account string, amount Money) (func(Money), error) {
+	account = qualify(tenantOf(ctx), account)
+	if maxTransferAmount > 0 && amount > maxTransferAmount {
+		return nil, &serviceError{
+			code:    codeLimitExceeded,
+			message: "amount exceeds the per transfer limit",
+			details: map[string]any{"limit": maxTransferAmount},
+		}
+	}
+	if dailyTransferLimit <= 0 {
+		return func(Money) {}, nil
+	}
+
+	dailyMu.Lock()
+	defer dailyMu.Unlock()
+	t := now().UTC()
+	if day := t.Format(time.DateOnly); day != dailyDay {
+		dailyDay, dailySpent = day, map[string]Money{}
+	}
+	if dailySpent[account]+amount > dailyTransferLimit {
+		tomorrow := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		return nil, &serviceError{
+			code:    codeLimitExceeded,
+			message: "amount exceeds the daily transfer limit",
+			details: map[string]any{
+				"limit":     dailyTransferLimit,
+				"remaining": dailyTransferLimit - dailySpent[account],
+				"resets_at": tomorrow,
+			},
+		}
+	}
+	dailySpent[account] += amount
+	day := dailyDay
+	return func(unspent Money) {
+		dailyMu.Lock()
+		defer dailyMu.Unlock()
+		if dailyDay == day {
+			dailySpent[account] -= unspent
+		}
+	}, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	keys, err := parseAPIKeys("k-alice=alice:user:alice;k-bob=bob:user:bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKeys = keys
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return start }
+	keyRate, keyLimits = 2, map[string]*tokenBucket{}
+	defer func() { apiKeys, keyRate, now = nil, 0, time.Now }()
+
+	h := authenticate(rateLimit(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func(key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/v1/accounts/alice/balance", nil)
+		r.Header.Set("X-API-Key", key)
+		h(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := get("k-alice"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200 within the burst, got %d", i, w.Code)
+		}
+	}
+	w := get("k-alice")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected 429 with Retry-After 1, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if e := decodeError(t, w); e.Code != codeRateLimited {
+		t.Errorf("expected %s, got %s", codeRateLimited, e.Code)
+	}
+	// every key has its own bucket
+	if w := get("k-bob"); w.Code != http.StatusOK {
+		t.Errorf("expected bob unaffected, got %d", w.Code)
+	}
+	// the bucket refills at the configured rate
+	now = func() time.Time { return start.Add(500 * time.Millisecond) }
+	if w := get("k-alice"); w.Code != http.StatusOK {
+		t.Errorf("expected a token after half a second, got %d", w.Code)
+	}
+}
+
+func TestTransferLimits(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(1000), "bob": 0})
+	start := time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)
+	now = func() time.Time { return start }
+	maxTransferAmount, dailyTransferLimit = units(100), units(150)
+	defer func() { maxTransferAmount, dailyTransferLimit, now = 0, 0, time.Now }()
+
+	transfer := func(amount string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":`+amount+`}`)))
+		return w
+	}
+
+	if w := transfer("101"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected the per transfer limit to apply, got %d", w.Code)
+	}
+	if w := transfer("100"); w.Code != http.StatusOK {
+		t.Fatalf("expected 100 to pass, got %d %s", w.Code, w.Body)
+	}
+	// a failed transfer doesn't use up the daily allowance
+	if w := transfer("5000"); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", w.Code)
+	}
+	w := transfer("60")
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected the daily limit to apply, got %d", w.Code)
+	}
+	e := decodeError(t, w)
+	details, _ := e.Details.(map[string]any)
+	if e.Code != codeLimitExceeded || details["remaining"] != 50.0 || details["resets_at"] != "2024-05-02T00:00:00Z" {
+		t.Errorf("expected remaining 50 and reset at midnight, got %+v", e)
+	}
+	if w := transfer("50"); w.Code != http.StatusOK {
+		t.Errorf("expected what's left to pass, got %d", w.Code)
+	}
+
+	now = func() time.Time { return start.Add(2 * time.Hour) }
+	if w := transfer("100"); w.Code != http.StatusOK {
+		t.Errorf("expected the allowance to reset the next day, got %d", w.Code)
+	}
+}
+
+// withdrawals and conversions take money out too, they share the limits
+func TestLimitsCoverWithdrawAndConvert(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(1000)})
+	store.Create(Account{ID: "alice-eur", Currency: "EUR"})
+	table, _ := parseRates("USD/EUR=0.5")
+	rates = table
+	maxTransferAmount, dailyTransferLimit = units(100), units(150)
+	defer func() { maxTransferAmount, dailyTransferLimit, rates = 0, 0, staticRates{} }()
+	dailyDay = ""
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		serveAPI(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return w
+	}
+	withdraw := func(amount string) *httptest.ResponseRecorder {
+		return post("/v1/accounts/alice/withdraw", `{"amount":`+amount+`}`)
+	}
+	convert := func(amount string) *httptest.ResponseRecorder {
+		return post("/v1/convert", `{"from":"alice","to":"alice-eur","amount":`+amount+`}`)
+	}
+
+	for name, w := range map[string]*httptest.ResponseRecorder{"withdraw": withdraw("101"), "convert": convert("101")} {
+		if w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeLimitExceeded {
+			t.Errorf("%s: expected the per transfer limit to apply, got %d %s", name, w.Code, w.Body)
+		}
+	}
+	if w := withdraw("100"); w.Code != http.StatusOK {
+		t.Fatalf("expected 100 to pass, got %d %s", w.Code, w.Body)
+	}
+	if w := convert("60"); w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeLimitExceeded {
+		t.Errorf("expected the withdrawal to count towards the daily limit, got %d %s", w.Code, w.Body)
+	}
+	if w := convert("50"); w.Code != http.StatusOK {
+		t.Fatalf("expected what's left to convert, got %d %s", w.Code, w.Body)
+	}
+	if w := withdraw("1"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected the daily limit to be used up, got %d", w.Code)
+	}
+	if a := balance(t, "alice"); a != units(850) {
+		t.Errorf("expected alice at 850, got %v", a)
+	}
+}
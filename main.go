@@ -1,95 +1,435 @@
 // A simple HTTP seerver keep account balances in
-// a map[string]float64 balances protected by sync.Mutex
-// to avoid concurrent access issues.
+// a pluggable Store, either an in-memory map protected
+// by sync.Mutex, a SQLite database or an append-only event log
+// replayed at startup, the last two survive restarts along with the
+// ledger of transactions.
+// settings come from a JSON file given with -config (or CONFIG_FILE),
+// then the environment variables below, then flags, see the config
+// package for the file's keys. every variable is also a flag, STORE_PATH
+// is -store-path. they are all checked before starting.
+// STORE=memory|sqlite|eventlog picks the backend, STORE_PATH its file.
+// a sqlite file is used by one replica at a time, see lease.go.
+// IDEMPOTENCY_TTL sets how long transfer responses are replayed.
+// API_KEYS turns on API key authentication (see parseAPIKeys), keys
+// named tenant/name belong to a tenant and X-Tenant-ID picks one
+// otherwise, tenants never see each other's accounts (see tenant.go).
+// FX_RATES or FX_RATES_URL configure exchange rates for /convert.
+// RATE_LIMIT and KEY_RATE_LIMIT cap requests per second overall and per
+// API key, MAX_TRANSFER_AMOUNT and DAILY_TRANSFER_LIMIT cap what can
+// leave an account at once and per day, MIN_TRANSFER_AMOUNT is the
+// smallest transfer.
+// SCHEDULE_PATH is the file scheduled transfers are kept in.
+// FROZEN_ACCEPTS_CREDITS=false stops frozen accounts receiving money too.
+// logs are JSON on stderr, one access log line per request with its
+// X-Request-ID. OTEL_EXPORTER_OTLP_ENDPOINT exports OpenTelemetry spans
+// of every request and store call, traceparent headers are honoured.
+// -addr (or ADDR) sets the listen address, :8080 by default. SIGTERM
+// drains in-flight requests for up to SHUTDOWN_TIMEOUT before exiting.
+// HANDLER_TIMEOUT (-handler-timeout, 25s by default) gives up on a request
+// running longer with 504, X-Request-Timeout asks for less (see deadline.go).
+// -grpc-addr (or GRPC_ADDR, :9090 by default) serves the gRPC API of
+// txpb/transaction.proto, with balances, transfers and transactions.
+// TLS_CERT and TLS_KEY, or TLS_AUTOCERT for Let's Encrypt, serve both
+// over TLS, TLS_CLIENT_CA requires client certificates and CLIENT_CERTS
+// maps their common names to callers (see tls.go).
+// INTEREST_RATE is what savings accounts earn per INTEREST_PERIOD, daily or
+// monthly (the default), posted to the ledger once a period is over.
 
-// GET /balance/{account} return accounts balance
-// POST /transfer moves funds between accounts with validation
+// every endpoint below but /metrics, /openapi.json and /docs is served
+// under /v1 (see router.go), and without the prefix for older clients.
+// GET /accounts/{id}/balance return accounts book balance and what is
+// available to spend including any overdraft, its ETag is the
+// account's version. sending it back as If-Match on /transfer or
+// /withdraw makes them fail with 412 if the account changed meanwhile
+// GET /accounts/{id}/balance?at=<rfc3339> (or as_of) rebuilds a past
+// balance from history, GET /balance/{id} is the old path of both
+// POST /transfer moves funds between accounts with validation,
+// retries carrying the same Idempotency-Key are replayed not re-run.
+// ?async=true answers 202 with a pending transaction made in the
+// background, GET /transactions/{id} shows how it went
+// POST /transfers/batch applies a list of transfers all-or-nothing
+// POST /callback applies HMAC signed payment confirmations, each id once
+// POST /convert moves money between accounts of different currencies
+// GET /accounts lists accounts, POST /accounts opens one. every list
+// (accounts, transactions, holds) pages with ?limit=&cursor= and takes
+// sort=, from_date=, to_date= and min_amount= (see query.go)
+// GET /accounts/{id} shows an account, closed ones too
+// DELETE /accounts/{id} closes an account once its balance is zero, it
+// is kept with status closed and can't be paid into or out of again
+// GET /accounts/{id}/export is its full history, for keeping once closed
+// POST /accounts/{id}/deposit and /withdraw move money in and out
+// POST /accounts/{id}/freeze and /unfreeze stop and resume money leaving
+// PUT /accounts/{id}/overdraft sets how far below zero an account may go
+// GET /transactions lists the ledger of every attempted transfer
+// GET /transactions/stream?account= pushes completed ones as server-sent
+// events while connected
+// GET /accounts/{id}/transactions lists the ledger entries of one account
+// GET /accounts/{id}/statement?from=&to=&format=csv|json exports a statement
+// GET /accounts/{id}/interest previews what a savings account has earned
+// since its interest was last posted
+// POST /transactions/{id}/reverse moves a transfer's funds back
+// PUT /admin/risk-rules sets the risk checks transfers go through, a
+// flagged one is rejected or answered 202 pending_review until an admin
+// POSTs /transactions/{id}/approve or /deny, GET /admin/reviews lists them
+// every completed transaction is a set of balanced debit and credit
+// postings, money in and out is posted against a cash account per
+// currency. GET /ledger/trial-balance checks they add up
+// POST /reconcile compares a CSV statement from a bank or provider to the
+// ledger, answering which lines matched, are missing or disagree
+// GET /admin/stats shows the money supply, accounts, transfer volume over
+// time and the busiest accounts. a worker checks every minute the supply
+// only changed by money entering or leaving the system, logging and
+// counting tx_money_supply_alerts_total when it didn't
+// GET /metrics exposes Prometheus metrics
+// GET /openapi.json describes all of the above, GET /docs renders it
+// POST /holds reserves funds, POST /holds/{id}/capture pays them out and
+// POST /holds/{id}/release frees them, unused holds expire on their own.
+// GET /holds lists them
+// POST /scheduled-transfers makes a transfer later, at run_at or on a
+// cron schedule, GET lists them, GET/DELETE /scheduled-transfers/{id}
+// inspects or cancels one
+// GET /webhooks lists, POST /webhooks registers receivers of signed
+// transfer.completed / transfer.failed events, DELETE /webhooks/{id}
+// POST /admin/snapshot saves accounts and the ledger to a file in
+// SNAPSHOT_DIR or a URL, POST /admin/restore puts them back. -restore (or
+// RESTORE_FROM) restores one at startup, -snapshot-on-exit (or
+// SNAPSHOT_ON_EXIT) takes one once the server drained
+// SEED_ACCOUNTS sets the accounts a fresh store opens with, CHAOS injects
+// latency, store errors and transfers failing halfway for testing
+// clients, see chaos.go
+// GET /admin/audit lists every state-changing call with the balances it
+// touched before and after, hash chained so GET /admin/audit/verify finds
+// entries changed since. AUDIT_LOG keeps them in a file, see audit.go
+//
+// errors are JSON too, {"error":{"code":..,"message":..}}, see errors.go
+// for the codes
 
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"log"
+	"log/slog"
+	"math/big"
+	"net"
 	"net/http"
-	"sync"
-)
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
-// In-memory store
-var (
-	// protects balances ensure only one
-	// coroutine can access at a time
-	// maps in go are not safe for concurrent access
-	// without a mutex to avoid race conditions
-	mu       sync.Mutex
-	balances = map[string]float64{
-		"alice": 100,
-		"bob":   50,
-	}
+	"github.com/rkarmaka98/Transaction_APP/transaction-api/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
-// models the JSON body for POST /transfer
+// backend holding all balances, handlers only go through this
+var store Store = newMemoryStore(seedBalances())
+
+// models the JSON body for POST /transfer, currency is optional and
+// only checked against the sending account when given
 type transferRequest struct {
-	From   string  `json:"from"`
-	To     string  `json:"to"`
-	Amount float64 `json:"amount"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Amount   Money  `json:"amount"`
+	Currency string `json:"currency"`
+	// the sender's version from If-Match, 0 when the transfer is
+	// unconditional
+	Version int64 `json:"-"`
 }
 
 func main() {
-	// Register handler function and listen on port
-	http.HandleFunc("/balance/", balanceHandler)
-	http.HandleFunc("/transfer", transferHandler)
-	fmt.Println("Server listening on :8080")
-	http.ListenAndServe(":8080", nil)
-}
+	// log.Printf goes through slog from here on, so every line is JSON
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	cfg, err := config.Load(os.Args, os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if err := applyConfig(cfg); err != nil {
+		log.Fatalf("config: %v", err)
+	}
 
-// handles GET /balance/{account} to read account balance
-func balanceHandler(w http.ResponseWriter, r *http.Request) {
-	account := r.URL.Path[len("/balance/"):]
-	// blocks until safe to access the map
-	mu.Lock()
-	bal, ok := balances[account]
-	// after reading balance unlock so coroutines no longer blocked
-	mu.Unlock()
+	s, err := openStore(cfg.Store, cfg.StorePath)
+	if err != nil {
+		log.Fatalf("open store: %v", err)
+	}
+	store = s
 
-	if !ok {
-		http.Error(w, "account not found", http.StatusNotFound)
-		return
+	// the sqlite and eventlog stores keep the ledger, with the memory
+	// store whatever it holds at startup is the starting point for it
+	if err := loadLedger(); err != nil {
+		log.Fatalf("load ledger: %v", err)
+	}
+	// replaces whatever the store held, a file backend keeps it from
+	// then on
+	if cfg.RestoreFrom != "" {
+		s, err := loadSnapshot(context.Background(), cfg.RestoreFrom)
+		if err == nil {
+			err = restoreSnapshot(s)
+		}
+		if err != nil {
+			log.Fatalf("restore %s: %v", cfg.RestoreFrom, err)
+		}
+		slog.Info("restored snapshot", "source", cfg.RestoreFrom, "taken_at", s.TakenAt, "accounts", len(s.Accounts), "entries", len(s.Ledger))
+	}
+	if cfg.SchedulePath != "" {
+		if err := loadSchedules(cfg.SchedulePath); err != nil {
+			log.Fatalf("schedule_path: %v", err)
+		}
+	} else {
+		log.Println("SCHEDULE_PATH not set, scheduled transfers are lost on restart")
+	}
+	if cfg.AuditLog != "" {
+		if err := loadAudit(cfg.AuditLog); err != nil {
+			log.Fatalf("audit_log: %v", err)
+		}
+	} else {
+		log.Println("AUDIT_LOG not set, the audit log is lost on restart")
+	}
+	router := newRouter()
+
+	// SIGTERM is what docker and kubernetes send before killing us
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		log.Fatalf("tracing: %v", err)
+	}
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		log.Fatalf("tls: %v", err)
+	}
+	waitWorkers := startWorkers(ctx, time.Minute, time.Second)
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		log.Fatalf("listen: %v", err)
+	}
+	var grpcDone chan error
+	grace := time.Duration(cfg.ShutdownTimeout)
+	if cfg.GRPCAddr != "" {
+		gln, err := net.Listen("tcp", cfg.GRPCAddr)
+		if err != nil {
+			log.Fatalf("listen gRPC: %v", err)
+		}
+		slog.Info("gRPC listening", "addr", gln.Addr().String())
+		grpcDone = make(chan error, 1)
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		go func() { grpcDone <- serveGRPC(ctx, newGRPCServer(opts...), gln, grace) }()
+	}
+	slog.Info("server listening", "addr", ln.Addr().String(), "tls", tlsConfig != nil)
+	srv := newServer(cfg.Addr, observe(router))
+	srv.TLSConfig = tlsConfig
+	if err := serve(ctx, srv, ln, grace); err != nil {
+		log.Printf("serve: %v", err)
+	}
+	if grpcDone != nil {
+		if err := <-grpcDone; err != nil {
+			log.Printf("serve gRPC: %v", err)
+		}
+	}
+	// flush the spans still batched, ctx is already cancelled by now
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Printf("flush traces: %v", err)
+	}
+	// serve may have returned on an error of its own, stop the workers
+	// either way
+	stop()
+	waitWorkers()
+	if cfg.SnapshotOnExit != "" {
+		if err := snapshotOnExit(context.Background(), cfg.SnapshotOnExit); err != nil {
+			log.Printf("snapshot on exit: %v", err)
+		}
+	}
+	if err := closeStore(); err != nil {
+		log.Fatalf("close store: %v", err)
 	}
-	fmt.Fprintf(w, `{"account":"%s","balance":%.2f}`, account, bal)
 }
 
-// handles POST /transfer all other get 405
-func transferHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "only POST request allowed", http.StatusMethodNotAllowed)
+// sets the globals the settings in c stand for, failing on everything
+// that only the server knows how to parse
+func applyConfig(c config.Config) error {
+	var errs []error
+	idempotencyTTL = time.Duration(c.IdempotencyTTL)
+	handlerTimeout = time.Duration(c.HandlerTimeout)
+	apiKeys = nil
+	if c.APIKeys != "" {
+		keys, err := parseAPIKeys(c.APIKeys)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("api_keys: %w", err))
+		}
+		apiKeys = keys
+	}
+	clientCerts = nil
+	if c.ClientCerts != "" {
+		certs, err := parseClientCerts(c.ClientCerts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("client_certs: %w", err))
+		}
+		clientCerts = certs
+	}
+	if !authEnabled() {
+		log.Println("API_KEYS not set, authentication is disabled")
+	}
+	globalLimit = nil
+	if c.RateLimit > 0 {
+		globalLimit = newTokenBucket(c.RateLimit)
+	}
+	keyRate = c.KeyRateLimit
+	for name, limit := range map[string]struct {
+		v   string
+		dst *Money
+	}{
+		"max_transfer_amount":  {c.MaxTransferAmount, &maxTransferAmount},
+		"min_transfer_amount":  {c.MinTransferAmount, &minTransferAmount},
+		"daily_transfer_limit": {c.DailyTransferLimit, &dailyTransferLimit},
+	} {
+		*limit.dst = 0
+		if limit.v == "" {
+			continue
+		}
+		m, err := ParseMoney(limit.v)
+		if err != nil || m <= 0 {
+			errs = append(errs, fmt.Errorf("%s: must be a positive amount", name))
+			continue
+		}
+		*limit.dst = m
+	}
+	if maxTransferAmount > 0 && minTransferAmount > maxTransferAmount {
+		errs = append(errs, errors.New("min_transfer_amount: must not be over max_transfer_amount"))
+	}
+	frozenAcceptsCredits = c.FrozenAcceptsCredits
+	switch {
+	case c.FXRatesURL != "":
+		rates = newHTTPRates(c.FXRatesURL)
+	case c.FXRates != "":
+		table, err := parseRates(c.FXRates)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("fx_rates: %w", err))
+		}
+		rates = table
+	}
+	interestRate = nil
+	if c.InterestRate != "" {
+		r, ok := new(big.Rat).SetString(c.InterestRate)
+		if !ok || r.Sign() < 0 {
+			errs = append(errs, errors.New("interest_rate: must be a decimal rate like 0.004, not negative"))
+		} else if r.Sign() > 0 {
+			interestRate = r
+		}
+	}
+	interestPeriod = c.InterestPeriod
+	seedAccounts = nil
+	if c.SeedAccounts != "" {
+		seed, err := parseSeedAccounts(c.SeedAccounts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("seed_accounts: %w", err))
+		}
+		seedAccounts = seed
+	}
+	chaos = nil
+	if c.Chaos != "" {
+		cc, err := parseChaos(c.Chaos)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("chaos: %w", err))
+		}
+		chaos = cc
+	}
+	callbackSecret = []byte(c.CallbackSecret)
+	snapshotDir = c.SnapshotDir
+	return errors.Join(errs...)
+}
+
+// handles GET /accounts/{account}/balance to read account balance
+func balanceHandler(w http.ResponseWriter, r *http.Request) {
+	account := r.PathValue("account")
+	for _, param := range []string{"at", "as_of"} {
+		asOf := r.URL.Query().Get(param)
+		if asOf == "" {
+			continue
+		}
+		if !mayRead(r.Context(), account) {
+			forbidden(w)
+			return
+		}
+		historicalBalanceHandler(w, r, account, param, asOf)
+		return
+	}
+	acct, err := getBalance(r.Context(), account)
+	if err != nil {
+		writeServiceError(w, err)
 		return
 	}
+	w.Header().Set("ETag", etag(acct.Version))
+	if notModified(r, acct.Version) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	fmt.Fprintf(w, `{"account":"%s","balance":%s,"available":%s,"held":%s,"overdraft":%s,"currency":"%s","status":"%s"}`,
+		account, acct.Balance, acct.Available(), acct.Held, acct.Overdraft, acct.Currency, acct.Status)
+}
 
+// handles POST /transfer
+func transferHandler(w http.ResponseWriter, r *http.Request) {
 	var req transferRequest
 
 	// Reads and parses POST body into transferRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid JSON", http.StatusBadRequest)
+	if !decodeBody(w, r, "TransferRequest", &req) {
+		transfersAttempted.Inc()
+		transfersFailed.WithLabelValues(reasonInvalidRequest).Inc()
 		return
 	}
-
-	// Basic validation
-	if req.Amount <= 0 {
-		http.Error(w, "amount must be positive", http.StatusBadRequest)
+	version, ok := ifMatch(r)
+	if !ok {
+		badPrecondition(w)
 		return
 	}
+	req.Version = version
 
-	// lock the store then defer ensures any return from
-	// this function first unlocks the mutex avoiding deadlocks
-	mu.Lock()
-	defer mu.Unlock()
-	if balances[req.From] < req.Amount {
-		http.Error(w, "insufficient funds", http.StatusUnprocessableEntity)
+	if v := r.URL.Query().Get("async"); v != "" {
+		async, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "async must be true or false")
+			return
+		}
+		if async {
+			acceptAsyncTransfer(w, r, req)
+			return
+		}
+	}
+	e, err := transferFunds(r.Context(), req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if e.Status == statusPendingReview {
+		w.Header().Set("Location", apiPrefix+"/transactions/"+strconv.FormatInt(e.ID, 10))
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, `{"status": "%s", "id": %d}`, e.Status, e.ID)
 		return
 	}
-	balances[req.From] -= req.Amount
-	balances[req.To] += req.Amount
-
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"status": "ok"}`)
+	fmt.Fprintf(w, `{"status": "ok", "id": %d}`, e.ID)
+}
+
+// answers a body that failed to decode with 400, money format problems
+// are spelled out since clients can't guess them from "invalid JSON"
+func badJSON(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errMoneyPrecision), errors.Is(err, errMoneyFormat), errors.Is(err, errMoneyRange):
+		writeError(w, http.StatusBadRequest, codeInvalidAmount, err.Error())
+	default:
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, "invalid JSON")
+	}
 }
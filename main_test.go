@@ -8,9 +8,9 @@ import (
 )
 
 func TestBalanceHandler(t *testing.T) {
-	req := httptest.NewRequest("GET", "/balance/alice", nil)
+	req := httptest.NewRequest("GET", "/v1/accounts/alice/balance", nil)
 	w := httptest.NewRecorder()
-	balanceHandler(w, req)
+	serveAPI(w, req)
 
 	if w.Code != http.StatusOK {
 		t.Fatalf("expected 200, got %d", w.Code)
@@ -19,7 +19,7 @@ func TestBalanceHandler(t *testing.T) {
 
 func TestTransferHandler(t *testing.T) {
 	// reset balances for test
-	balances = map[string]float64{"alice": 100, "bob": 0}
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
 
 	body := `{"from":"alice","to":"bob","amount":25}`
 	// Lets me test handler without live server
@@ -30,7 +30,17 @@ func TestTransferHandler(t *testing.T) {
 	if w.Code != http.StatusOK {
 		t.Fatalf("expected 200, got %d", w.Code)
 	}
-	if balances["alice"] != 75 || balances["bob"] != 25 {
-		t.Errorf("balances not updated correctly: %+v", balances)
+	if alice, bob := balance(t, "alice"), balance(t, "bob"); alice != units(75) || bob != units(25) {
+		t.Errorf("balances not updated correctly: alice=%v bob=%v", alice, bob)
 	}
 }
+
+// reads an account balance straight from the store
+func balance(t *testing.T, account string) Money {
+	t.Helper()
+	acct, err := store.Get(account)
+	if err != nil {
+		t.Fatalf("get %s: %v", account, err)
+	}
+	return acct.Balance
+}
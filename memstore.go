@@ -0,0 +1,427 @@
+package main
+
+import (
+	"hash/maphash"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// number of lock shards a memory store spreads its accounts over
+const memoryShards = 64
+
+// memoryStore keeps balances in memory, they are lost on restart.
+// accounts are spread over shards each with their own mutex so requests
+// touching unrelated accounts don't wait on each other
+type memoryStore struct {
+	seed   maphash.Seed
+	shards []memoryShard
+}
+
+type memoryShard struct {
+	// maps in go are not safe for concurrent access
+	// without a mutex to avoid race conditions
+	mu       sync.Mutex
+	accounts map[string]*Account
+}
+
+// builds a memory store holding balances in the default currency
+func newMemoryStore(balances map[string]Money) *memoryStore {
+	return newShardedMemoryStore(balances, memoryShards)
+}
+
+// builds a memory store with n lock shards, n=1 is a single global lock
+func newShardedMemoryStore(balances map[string]Money, n int) *memoryStore {
+	s := &memoryStore{seed: maphash.MakeSeed(), shards: make([]memoryShard, n)}
+	for i := range s.shards {
+		s.shards[i].accounts = make(map[string]*Account)
+	}
+	for acct, bal := range balances {
+		s.shard(acct).accounts[acct] = &Account{ID: acct, Balance: bal, Type: accountChecking, Currency: defaultCurrency, Version: 1, Status: accountActive}
+	}
+	return s
+}
+
+func (s *memoryStore) shardIndex(account string) int {
+	return int(maphash.String(s.seed, account) % uint64(len(s.shards)))
+}
+
+func (s *memoryStore) shard(account string) *memoryShard {
+	return &s.shards[s.shardIndex(account)]
+}
+
+// locks the shards of every account, always lowest index first so two
+// opposite transfers can't each hold one lock and wait for the other
+func (s *memoryStore) lockShards(accounts ...string) (unlock func()) {
+	idx := make([]int, 0, len(accounts))
+	for _, a := range accounts {
+		idx = append(idx, s.shardIndex(a))
+	}
+	sort.Ints(idx)
+	idx = slices.Compact(idx)
+	for _, i := range idx {
+		s.shards[i].mu.Lock()
+	}
+	return func() {
+		for k := len(idx) - 1; k >= 0; k-- {
+			s.shards[idx[k]].mu.Unlock()
+		}
+	}
+}
+
+func (s *memoryStore) Get(account string) (Account, error) {
+	// blocks until safe to access the map
+	sh := s.shard(account)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	a, ok := sh.accounts[account]
+	if !ok {
+		return Account{}, ErrAccountNotFound
+	}
+	return *a, nil
+}
+
+func (s *memoryStore) Credit(account string, amount Money) error {
+	sh := s.shard(account)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	a, ok := sh.accounts[account]
+	if ok && a.Status == accountClosed {
+		return ErrAccountClosed
+	}
+	if !ok {
+		a = &Account{ID: account, Type: accountChecking, Currency: defaultCurrency, Status: accountActive}
+		sh.accounts[account] = a
+	}
+	a.Balance += amount
+	a.Version++
+	return nil
+}
+
+func (s *memoryStore) Debit(account string, amount Money) error {
+	return s.DebitIf(account, amount, 0)
+}
+
+func (s *memoryStore) DebitIf(account string, amount Money, version int64) error {
+	sh := s.shard(account)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	a, ok := sh.accounts[account]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	if err := debitBlocked(a.Status); err != nil {
+		return err
+	}
+	if version != 0 && a.Version != version {
+		return ErrVersionMismatch
+	}
+	if a.Available() < amount {
+		return ErrInsufficientFunds
+	}
+	a.Balance -= amount
+	a.Version++
+	return nil
+}
+
+func (s *memoryStore) Transfer(from, to string, amount Money) error {
+	return s.TransferIf(from, to, amount, 0)
+}
+
+func (s *memoryStore) TransferIf(from, to string, amount Money, version int64) error {
+	// lock both sides then defer ensures any return from
+	// this function first unlocks the mutexes avoiding deadlocks
+	defer s.lockShards(from, to)()
+	src, ok := s.shard(from).accounts[from]
+	if ok {
+		if err := debitBlocked(src.Status); err != nil {
+			return err
+		}
+	}
+	if ok && version != 0 && src.Version != version {
+		return ErrVersionMismatch
+	}
+	if !ok || src.Available() < amount {
+		return ErrInsufficientFunds
+	}
+	dst, ok := s.shard(to).accounts[to]
+	if ok && dst.Status == accountClosed {
+		return ErrAccountClosed
+	}
+	if ok && dst.Currency != src.Currency {
+		return ErrCurrencyMismatch
+	}
+	if !ok {
+		dst = &Account{ID: to, Type: accountChecking, Currency: src.Currency, Status: accountActive}
+		s.shard(to).accounts[to] = dst
+	}
+	src.Balance -= amount
+	dst.Balance += amount
+	src.Version++
+	dst.Version++
+	return nil
+}
+
+func (s *memoryStore) TransferBatch(items []TransferItem) error {
+	ids := make([]string, 0, 2*len(items))
+	for _, it := range items {
+		ids = append(ids, it.From, it.To)
+	}
+	defer s.lockShards(ids...)()
+
+	// apply the batch to copies so nothing changes unless every item goes through
+	work := make(map[string]*Account)
+	get := func(id string) (*Account, bool) {
+		if a, ok := work[id]; ok {
+			return a, true
+		}
+		a, ok := s.shard(id).accounts[id]
+		if !ok {
+			return nil, false
+		}
+		c := *a
+		work[id] = &c
+		return &c, true
+	}
+	for i, it := range items {
+		src, ok := get(it.From)
+		if ok {
+			if err := debitBlocked(src.Status); err != nil {
+				return &BatchError{Index: i, Err: err}
+			}
+		}
+		if !ok || src.Available() < it.Amount {
+			return &BatchError{Index: i, Err: ErrInsufficientFunds}
+		}
+		dst, ok := get(it.To)
+		if ok && dst.Status == accountClosed {
+			return &BatchError{Index: i, Err: ErrAccountClosed}
+		}
+		if ok && dst.Currency != src.Currency {
+			return &BatchError{Index: i, Err: ErrCurrencyMismatch}
+		}
+		if !ok {
+			dst = &Account{ID: it.To, Type: accountChecking, Currency: src.Currency, Status: accountActive}
+			work[it.To] = dst
+		}
+		src.Balance -= it.Amount
+		dst.Balance += it.Amount
+		src.Version++
+		dst.Version++
+	}
+	for id, a := range work {
+		s.shard(id).accounts[id] = a
+	}
+	return nil
+}
+
+func (s *memoryStore) Exchange(from, to string, debit, credit Money) error {
+	defer s.lockShards(from, to)()
+	src, ok := s.shard(from).accounts[from]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	dst, ok := s.shard(to).accounts[to]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	if err := debitBlocked(src.Status); err != nil {
+		return err
+	}
+	if dst.Status == accountClosed {
+		return ErrAccountClosed
+	}
+	if src.Available() < debit {
+		return ErrInsufficientFunds
+	}
+	src.Balance -= debit
+	dst.Balance += credit
+	src.Version++
+	dst.Version++
+	return nil
+}
+
+// holds every shard lock at once so the copy is a consistent snapshot
+func (s *memoryStore) All() ([]Account, error) {
+	for i := range s.shards {
+		s.shards[i].mu.Lock()
+	}
+	defer func() {
+		for i := range s.shards {
+			s.shards[i].mu.Unlock()
+		}
+	}()
+	var out []Account
+	for i := range s.shards {
+		for _, a := range s.shards[i].accounts {
+			out = append(out, *a)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (s *memoryStore) Create(acct Account) error {
+	sh := s.shard(acct.ID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, ok := sh.accounts[acct.ID]; ok {
+		return ErrAccountExists
+	}
+	acct.Version = 1
+	acct.Type = accountType(acct.Type)
+	if acct.Status == "" {
+		acct.Status = accountActive
+	}
+	sh.accounts[acct.ID] = &acct
+	return nil
+}
+
+func (s *memoryStore) Delete(account string) error {
+	sh := s.shard(account)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	a, ok := sh.accounts[account]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	if a.Status == accountClosed {
+		return nil
+	}
+	if a.Balance != 0 || a.Held != 0 {
+		return ErrNonZeroBalance
+	}
+	a.Status = accountClosed
+	a.Version++
+	return nil
+}
+
+func (s *memoryStore) SetStatus(account, status string) error {
+	sh := s.shard(account)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	a, ok := sh.accounts[account]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	if a.Status == accountClosed {
+		return ErrAccountClosed
+	}
+	if a.Status != status {
+		a.Status = status
+		a.Version++
+	}
+	return nil
+}
+
+func (s *memoryStore) SetOverdraft(account string, limit Money) error {
+	sh := s.shard(account)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	a, ok := sh.accounts[account]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	if a.Overdraft != limit {
+		a.Overdraft = limit
+		a.Version++
+	}
+	return nil
+}
+
+func (s *memoryStore) Reserve(account string, amount Money) error {
+	sh := s.shard(account)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	a, ok := sh.accounts[account]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	if err := debitBlocked(a.Status); err != nil {
+		return err
+	}
+	if a.Available() < amount {
+		return ErrInsufficientFunds
+	}
+	a.Held += amount
+	a.Version++
+	return nil
+}
+
+func (s *memoryStore) Unreserve(account string, amount Money) error {
+	sh := s.shard(account)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	a, ok := sh.accounts[account]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	a.Held -= min(amount, a.Held)
+	a.Version++
+	return nil
+}
+
+func (s *memoryStore) Settle(from, to string, amount Money) error {
+	defer s.lockShards(from, to)()
+	src, ok := s.shard(from).accounts[from]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	if err := debitBlocked(src.Status); err != nil {
+		return err
+	}
+	if src.Held < amount {
+		return ErrInsufficientFunds
+	}
+	dst, ok := s.shard(to).accounts[to]
+	if ok && dst.Status == accountClosed {
+		return ErrAccountClosed
+	}
+	if ok && dst.Currency != src.Currency {
+		return ErrCurrencyMismatch
+	}
+	if !ok {
+		dst = &Account{ID: to, Type: accountChecking, Currency: src.Currency, Status: accountActive}
+		s.shard(to).accounts[to] = dst
+	}
+	src.Held -= amount
+	src.Balance -= amount
+	dst.Balance += amount
+	src.Version++
+	dst.Version++
+	return nil
+}
+
+// frees every reservation without counting it as a change, for the
+// event log whose holds didn't survive a restart
+func (s *memoryStore) clearHeld() {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for _, a := range sh.accounts {
+			a.Held = 0
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// replaces every account by those of s, whose ledger this store doesn't
+// keep
+func (s *memoryStore) Restore(snap snapshot) error {
+	for i := range s.shards {
+		s.shards[i].mu.Lock()
+	}
+	defer func() {
+		for i := range s.shards {
+			s.shards[i].mu.Unlock()
+		}
+	}()
+	for i := range s.shards {
+		s.shards[i].accounts = make(map[string]*Account)
+	}
+	for _, a := range snap.Accounts {
+		a.Type = accountType(a.Type)
+		s.shard(a.ID).accounts[a.ID] = &a
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// reasons a transfer is counted as failed
+const (
+	reasonInvalidRequest   = "invalid_request"
+	reasonForbidden        = "forbidden"
+	reasonInsufficientFund = "insufficient_funds"
+	reasonCurrencyMismatch = "currency_mismatch"
+	reasonLimitExceeded    = "limit_exceeded"
+	reasonVersionMismatch  = "version_mismatch"
+	reasonAccountFrozen    = "account_frozen"
+	reasonAccountClosed    = "account_closed"
+	reasonRiskRejected     = "risk_rejected"
+	reasonInternal         = "internal"
+)
+
+var (
+	transfersAttempted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tx_transfers_attempted_total",
+		Help: "Transfers received on POST /transfer.",
+	})
+	transfersSucceeded = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tx_transfers_succeeded_total",
+		Help: "Transfers that moved money.",
+	})
+	transfersFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tx_transfers_failed_total",
+		Help: "Transfers that were rejected, by reason.",
+	}, []string{"reason"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tx_http_request_duration_seconds",
+		Help:    "Time spent serving requests, by handler.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"handler"})
+
+	supplyDriftGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tx_money_supply_drift",
+		Help: "How far the money supply is off from what entered and left the system at the last check, by currency.",
+	}, []string{"currency"})
+	supplyAlerts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tx_money_supply_alerts_total",
+		Help: "Supply checks that found the same currency drifting twice in a row.",
+	}, []string{"currency"})
+
+	chaosFaults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tx_chaos_faults_total",
+		Help: "Faults injected into store calls in chaos mode, by fault.",
+	}, []string{"fault"})
+
+	moneySupplyDesc = prometheus.NewDesc(
+		"tx_money_supply",
+		"Sum of all account balances, by currency.",
+		[]string{"currency"}, nil,
+	)
+)
+
+// registry served on /metrics
+var metrics = newMetricsRegistry()
+
+func newMetricsRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		transfersAttempted,
+		transfersSucceeded,
+		transfersFailed,
+		requestDuration,
+		supplyDriftGauge,
+		supplyAlerts,
+		chaosFaults,
+		moneySupply{},
+	)
+	return reg
+}
+
+// handler for GET /metrics
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metrics, promhttp.HandlerOpts{})
+}
+
+// moneySupply totals the store on every scrape so the gauge can never
+// drift from the balances themselves
+type moneySupply struct{}
+
+func (moneySupply) Describe(ch chan<- *prometheus.Desc) {
+	ch <- moneySupplyDesc
+}
+
+func (moneySupply) Collect(ch chan<- prometheus.Metric) {
+	accts, err := store.All()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(moneySupplyDesc, err)
+		return
+	}
+	totals := make(map[string]Money)
+	for _, a := range accts {
+		totals[a.Currency] += a.Balance
+	}
+	for cur, total := range totals {
+		ch <- prometheus.MustNewConstMetric(moneySupplyDesc, prometheus.GaugeValue,
+			float64(total)/minorUnits, cur)
+	}
+}
+
+// wraps a handler recording how long each request took under name, the
+// request's span and access log line are named after it too
+func instrument(name string, next http.HandlerFunc) http.HandlerFunc {
+	obs := requestDuration.WithLabelValues(name)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+			info.handler = name
+		}
+		trace.SpanFromContext(r.Context()).SetName(r.Method + " " + name)
+		start := time.Now()
+		next(w, r)
+		obs.Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTransferMetrics(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	succeeded := testutil.ToFloat64(transfersSucceeded)
+	insufficient := testutil.ToFloat64(transfersFailed.WithLabelValues(reasonInsufficientFund))
+
+	for _, body := range []string{
+		`{"from":"alice","to":"bob","amount":10}`,
+		`{"from":"bob","to":"alice","amount":50}`,
+		`{"from":"bob","to":"alice","amount":50}`,
+	} {
+		transferHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/transfer", strings.NewReader(body)))
+	}
+	if got := testutil.ToFloat64(transfersSucceeded) - succeeded; got != 1 {
+		t.Errorf("expected 1 more successful transfer, got %v", got)
+	}
+	if got := testutil.ToFloat64(transfersFailed.WithLabelValues(reasonInsufficientFund)) - insufficient; got != 2 {
+		t.Errorf("expected 2 more insufficient funds failures, got %v", got)
+	}
+
+	// the supply gauge reads the store, transfers don't change it
+	want := `
+# HELP tx_money_supply Sum of all account balances, by currency.
+# TYPE tx_money_supply gauge
+tx_money_supply{currency="USD"} 100
+`
+	if err := testutil.CollectAndCompare(moneySupply{}, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+
+	w := httptest.NewRecorder()
+	instrument("test", transferHandler)(w, httptest.NewRequest("GET", "/transfer", nil))
+	if n := testutil.CollectAndCount(requestDuration, "tx_http_request_duration_seconds"); n == 0 {
+		t.Error("expected a latency observation")
+	}
+}
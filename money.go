@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in minor units (cents), so repeated arithmetic
+// never drifts the way float64 does. On the wire it is a plain JSON
+// number with at most two decimal places.
+type Money int64
+
+// number of minor units in one major unit
+const minorUnits = 100
+
+var (
+	errMoneyPrecision = errors.New("amount must have at most 2 decimal places")
+	errMoneyFormat    = errors.New("amount must be a decimal number")
+	errMoneyRange     = errors.New("amount out of range")
+)
+
+// returns n whole units as Money
+func units(n int64) Money {
+	return Money(n * minorUnits)
+}
+
+// formats m as a decimal with exactly two places, e.g. "-12.05"
+func (m Money) String() string {
+	sign := ""
+	u := uint64(m)
+	if m < 0 {
+		sign = "-"
+		u = uint64(-m)
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, u/minorUnits, u%minorUnits)
+}
+
+// parses a decimal amount like "10", "10.5" or "-0.25" without going
+// through float64
+func ParseMoney(s string) (Money, error) {
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if whole == "" || (hasFrac && frac == "") || !digits(whole) || !digits(frac) {
+		return 0, errMoneyFormat
+	}
+	if len(frac) > 2 {
+		return 0, errMoneyPrecision
+	}
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || w > math.MaxInt64/minorUnits-1 {
+		return 0, errMoneyRange
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	f, _ := strconv.ParseInt(frac, 10, 64)
+	m := Money(w*minorUnits + f)
+	if neg {
+		m = -m
+	}
+	return m, nil
+}
+
+// reports whether s only holds ASCII digits
+func digits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(b []byte) error {
+	v, err := ParseMoney(string(b))
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+		err  error
+	}{
+		{"10", 1000, nil},
+		{"10.5", 1050, nil},
+		{"0.01", 1, nil},
+		{"-2.25", -225, nil},
+		{"1.234", 0, errMoneyPrecision},
+		{"1e2", 0, errMoneyFormat},
+		{"1.", 0, errMoneyFormat},
+		{`"5"`, 0, errMoneyFormat},
+		{"99999999999999999999", 0, errMoneyRange},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if err != tt.err || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %v, %v; want %v, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+	if s := Money(-5).String(); s != "-0.05" {
+		t.Errorf("expected -0.05, got %s", s)
+	}
+}
+
+func TestTransferNoRoundingDrift(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(1), "bob": 0})
+
+	for range 10 {
+		w := httptest.NewRecorder()
+		transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":0.1}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+	}
+	if alice, bob := balance(t, "alice"), balance(t, "bob"); alice != 0 || bob != units(1) {
+		t.Errorf("expected alice=0.00 bob=1.00, got alice=%v bob=%v", alice, bob)
+	}
+
+	w := httptest.NewRecorder()
+	transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"bob","to":"alice","amount":0.001}`)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "2 decimal places") {
+		t.Errorf("expected 400 for 3 decimal places, got %d %q", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// the OpenAPI document describing every endpoint, also the source of the
+// schemas request bodies are validated against
+//
+//go:embed openapi.json
+var openAPIDoc []byte
+
+// JSON request bodies larger than this are rejected
+const maxRequestBody = 1 << 20
+
+// a JSON Schema, only the keywords openapi.json uses are understood
+type schema struct {
+	Ref              string             `json:"$ref"`
+	Type             any                `json:"type"`
+	Required         []string           `json:"required"`
+	Properties       map[string]*schema `json:"properties"`
+	Items            *schema            `json:"items"`
+	OneOf            []*schema          `json:"oneOf"`
+	Enum             []any              `json:"enum"`
+	MinLength        *int               `json:"minLength"`
+	Pattern          string             `json:"pattern"`
+	MinItems         *int               `json:"minItems"`
+	MaxItems         *int               `json:"maxItems"`
+	Minimum          json.Number        `json:"minimum"`
+	ExclusiveMinimum json.Number        `json:"exclusiveMinimum"`
+	MultipleOf       json.Number        `json:"multipleOf"`
+}
+
+// the named schemas of openapi.json, parsed once at startup so a broken
+// document fails the build's tests rather than a request
+var schemas = func() map[string]*schema {
+	var doc struct {
+		Components struct {
+			Schemas map[string]*schema `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(openAPIDoc, &doc); err != nil {
+		panic("openapi.json: " + err.Error())
+	}
+	return doc.Components.Schemas
+}()
+
+// one reason a body doesn't match its schema
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// reads r's body, checks it against the named schema and decodes it into
+// v. on failure the error response is written and false returned
+func decodeBody(w http.ResponseWriter, r *http.Request, name string, v any) bool {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBody+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "could not read body")
+		return false
+	}
+	if len(body) > maxRequestBody {
+		writeError(w, http.StatusRequestEntityTooLarge, codeInvalidRequest, "body larger than 1MB")
+		return false
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			writeErrorDetails(w, http.StatusBadRequest, codeInvalidJSON, "invalid JSON: "+syntax.Error(),
+				map[string]any{"offset": syntax.Offset})
+		} else {
+			writeError(w, http.StatusBadRequest, codeInvalidJSON, "invalid JSON")
+		}
+		return false
+	}
+	if errs := validate(schemas[name], doc, ""); len(errs) > 0 {
+		writeErrorDetails(w, http.StatusBadRequest, codeValidationFailed, errs[0].Field+": "+errs[0].Message,
+			map[string]any{"errors": errs})
+		return false
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		badJSON(w, err)
+		return false
+	}
+	return true
+}
+
+// checks v against s and returns every mismatch, path names the field
+// being checked like "transfers[2].amount"
+func validate(s *schema, v any, path string) []fieldError {
+	if s == nil {
+		return nil
+	}
+	field := path
+	if field == "" {
+		field = "body"
+	}
+	fail := func(format string, args ...any) []fieldError {
+		return []fieldError{{Field: field, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	var errs []fieldError
+	if s.Ref != "" {
+		errs = append(errs, validate(schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")], v, path)...)
+	}
+	if len(s.OneOf) > 0 {
+		matched := slices.ContainsFunc(s.OneOf, func(alt *schema) bool { return len(validate(alt, v, path)) == 0 })
+		if !matched {
+			return fail("matches none of the allowed shapes")
+		}
+	}
+	if s.Type != nil && !hasType(s.Type, v) {
+		return fail("must be %s", typeName(s.Type))
+	}
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, v) {
+		return fail("must be one of %v", s.Enum)
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, req := range s.Required {
+			if _, ok := v[req]; !ok {
+				errs = append(errs, fieldError{Field: join(path, req), Message: "is required"})
+			}
+		}
+		// sorted so the first error reported doesn't change between runs
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			if pv, ok := v[name]; ok {
+				errs = append(errs, validate(s.Properties[name], pv, join(path, name))...)
+			}
+		}
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return fail("must hold at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return fail("must hold at most %d items", *s.MaxItems)
+		}
+		for i, item := range v {
+			errs = append(errs, validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case string:
+		if s.MinLength != nil && len(v) < *s.MinLength {
+			return fail("must not be empty")
+		}
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(v) {
+			return fail("must match %s", s.Pattern)
+		}
+	case json.Number:
+		n, ok := new(big.Rat).SetString(v.String())
+		if !ok {
+			return fail("must be a number")
+		}
+		if s.Minimum != "" && n.Cmp(rat(s.Minimum)) < 0 {
+			return fail("must be at least %s", s.Minimum)
+		}
+		if s.ExclusiveMinimum != "" && n.Cmp(rat(s.ExclusiveMinimum)) <= 0 {
+			return fail("must be greater than %s", s.ExclusiveMinimum)
+		}
+		if s.MultipleOf != "" && !new(big.Rat).Quo(n, rat(s.MultipleOf)).IsInt() {
+			// 0.01 reads better as what it means for money
+			if _, frac, ok := strings.Cut(s.MultipleOf.String(), "."); ok && strings.Trim(frac, "0") == "1" {
+				return fail("must have at most %d decimal places", len(frac))
+			}
+			return fail("must be a multiple of %s", s.MultipleOf)
+		}
+	}
+	return errs
+}
+
+func rat(n json.Number) *big.Rat {
+	r, _ := new(big.Rat).SetString(n.String())
+	return r
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// reports whether v is of the JSON type t, which may be a list of types
+func hasType(t, v any) bool {
+	if list, ok := t.([]any); ok {
+		return slices.ContainsFunc(list, func(t any) bool { return hasType(t, v) })
+	}
+	switch v := v.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case json.Number:
+		if t == "integer" {
+			_, err := v.Int64()
+			return err == nil
+		}
+		return t == "number"
+	case []any:
+		return t == "array"
+	case map[string]any:
+		return t == "object"
+	}
+	return false
+}
+
+func typeName(t any) string {
+	if list, ok := t.([]any); ok {
+		names := make([]string, len(list))
+		for i, t := range list {
+			names[i] = fmt.Sprint(t)
+		}
+		return strings.Join(names, " or ")
+	}
+	switch t {
+	case "object", "array", "integer":
+		return "an " + t.(string)
+	}
+	return "a " + fmt.Sprint(t)
+}
+
+// serves GET /openapi.json
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDoc)
+}
+
+// serves GET /docs, Swagger UI pointed at /openapi.json. the UI's assets
+// come from a CDN so the binary doesn't have to carry them
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, swaggerUI)
+}
+
+const swaggerUI = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Transaction API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"})</script>
+</body>
+</html>
+`
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// every $ref in the document has to point at something that exists
+func TestOpenAPIRefsResolve(t *testing.T) {
+	var doc map[string]any
+	if err := json.Unmarshal(openAPIDoc, &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range regexp.MustCompile(`"\$ref":\s*"#/components/(\w+)/(\w+)"`).FindAllStringSubmatch(string(openAPIDoc), -1) {
+		section, _ := doc["components"].(map[string]any)[m[1]].(map[string]any)
+		if _, ok := section[m[2]]; !ok {
+			t.Errorf("unresolved $ref %s/%s", m[1], m[2])
+		}
+	}
+
+	w := httptest.NewRecorder()
+	openAPIHandler(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected the document as JSON, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	w = httptest.NewRecorder()
+	docsHandler(w, httptest.NewRequest("GET", "/docs", nil))
+	if !strings.Contains(w.Body.String(), "/openapi.json") {
+		t.Error("expected Swagger UI to load /openapi.json")
+	}
+}
+
+func TestRequestValidation(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		field   string
+		message string
+	}{
+		{"missing field", transferHandler, `{"from":"alice","amount":1}`, "to", "is required"},
+		{"wrong type", transferHandler, `{"from":"alice","to":"bob","amount":"1"}`, "amount", "must be a number"},
+		{"not positive", transferHandler, `{"from":"alice","to":"bob","amount":0}`, "amount", "must be greater than 0"},
+		{"bad currency", transferHandler, `{"from":"alice","to":"bob","amount":1,"currency":"usd"}`, "currency", "must match ^[A-Z]{3}$"},
+		{"nested item", batchTransferHandler, `{"transfers":[{"from":"alice","to":"bob","amount":1},{"from":"alice","amount":1}]}`, "transfers[1].to", "is required"},
+		{"empty batch", batchTransferHandler, `{"transfers":[]}`, "transfers", "must hold at least 1 items"},
+		{"not an object", transferHandler, `[1]`, "body", "must be an object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, httptest.NewRequest("POST", "/", strings.NewReader(tt.body)))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d", w.Code)
+			}
+			e := decodeError(t, w)
+			if e.Code != codeValidationFailed || e.Message != tt.field+": "+tt.message {
+				t.Errorf("expected %q, got %s %q", tt.field+": "+tt.message, e.Code, e.Message)
+			}
+		})
+	}
+
+	// syntax errors say where they are
+	w := httptest.NewRecorder()
+	transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"alice",}`)))
+	e := decodeError(t, w)
+	if details, _ := e.Details.(map[string]any); e.Code != codeInvalidJSON || details["offset"] != 17.0 {
+		t.Errorf("expected INVALID_JSON at offset 17, got %+v", e)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// models the JSON body for PUT /accounts/{id}/overdraft
+type overdraftRequest struct {
+	Limit Money `json:"limit"`
+}
+
+// serves PUT /accounts/{account}/overdraft, admins only. a limit of 0
+// takes the overdraft away
+func overdraftHandler(w http.ResponseWriter, r *http.Request) {
+	account := r.PathValue("account")
+	if !isAdmin(r.Context()) {
+		forbidden(w)
+		return
+	}
+	var req overdraftRequest
+	if !decodeBody(w, r, "OverdraftRequest", &req) {
+		return
+	}
+	if req.Limit < 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidAmount, "limit must not be negative")
+		return
+	}
+	err := storeFor(r.Context()).SetOverdraft(account, req.Limit)
+	if errors.Is(err, ErrAccountNotFound) {
+		writeError(w, http.StatusNotFound, codeAccountNotFound, "account not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not update account")
+		return
+	}
+	acct, err := storeFor(r.Context()).Get(account)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not read account")
+		return
+	}
+	slog.InfoContext(r.Context(), "overdraft changed", "account", account, "limit", req.Limit.String(), "by", callerName(r.Context()), "request_id", requestID(r.Context()))
+	writeAccount(w, acct)
+}
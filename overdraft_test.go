@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func setOverdraft(account, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("PUT", "/v1/accounts/"+account+"/overdraft", strings.NewReader(body)))
+	return w
+}
+
+func TestOverdraft(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": units(10)})
+	resetLedger()
+
+	if w := setOverdraft("alice", `{"limit":50}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"overdraft":50.00`) {
+		t.Fatalf("expected the limit to be set, got %d %s", w.Code, w.Body)
+	}
+	transferID(t, `{"from":"alice","to":"bob","amount":130}`)
+
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/accounts/alice/balance", nil))
+	var got struct{ Balance, Available, Overdraft Money }
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Balance != -units(30) || got.Available != units(20) || got.Overdraft != units(50) {
+		t.Errorf("expected balance -30, available 20 and overdraft 50, got %+v", got)
+	}
+
+	// going past the limit is refused like any shortfall
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/accounts/alice/withdraw", strings.NewReader(`{"amount":21}`)))
+	if w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeInsufficientFunds {
+		t.Errorf("expected %s beyond the overdraft, got %d %s", codeInsufficientFunds, w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/accounts/alice/withdraw", strings.NewReader(`{"amount":20}`)))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected the rest of the overdraft to be usable, got %d %s", w.Code, w.Body)
+	}
+	if b := balance(t, "alice"); b != -units(50) {
+		t.Errorf("expected alice at -50, has %v", b)
+	}
+}
+
+func TestOverdraftValidation(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100)})
+
+	if w := setOverdraft("alice", `{"limit":-1}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a negative limit to be refused, got %d", w.Code)
+	}
+	if w := setOverdraft("nobody", `{"limit":10}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown account, got %d", w.Code)
+	}
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/accounts/alice/overdraft", strings.NewReader(`{"limit":10}`)))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", w.Code)
+	}
+
+	keys, _ := parseAPIKeys("k1=ops:admin;k2=alice:user:alice")
+	apiKeys = keys
+	defer func() { apiKeys = nil }()
+	req := httptest.NewRequest("PUT", "/v1/accounts/alice/overdraft", strings.NewReader(`{"limit":1000}`))
+	req.Header.Set("X-API-Key", "k2")
+	w = httptest.NewRecorder()
+	serveAPI(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected owners not to raise their own limit, got %d", w.Code)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// accounts that only exist in the ledger, one per currency. cash is the
+// other side of money entering or leaving the system, fx the other side
+// of each leg of a conversion so every currency balances on its own
+const (
+	cashPrefix = "cash:"
+	fxPrefix   = "fx:"
+)
+
+func cashAccount(currency string) string { return cashPrefix + currency }
+func fxAccount(currency string) string   { return fxPrefix + currency }
+
+// returns the reserved prefix id starts with, if any. nothing may open
+// or pay into such an account, it would show up twice in the postings
+func reservedPrefix(id string) (string, bool) {
+	for _, prefix := range []string{escrowPrefix, cashPrefix, fxPrefix} {
+		if strings.HasPrefix(id, prefix) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// one side of a ledger entry. accounts hold what the system owes their
+// owners, so a credit raises a balance and a debit lowers it
+type posting struct {
+	Account  string `json:"account"`
+	Debit    Money  `json:"debit,omitempty"`
+	Credit   Money  `json:"credit,omitempty"`
+	Currency string `json:"currency"`
+}
+
+// the postings a completed entry is made of, they always balance per
+// currency. failed entries moved nothing and have none
+func (e ledgerEntry) postings() []posting {
+	if e.Status != statusCompleted {
+		return nil
+	}
+	from, to := e.From, e.To
+	if from == "" {
+		from = cashAccount(e.Currency)
+	}
+	if to == "" {
+		to = cashAccount(e.Currency)
+	}
+	if e.ToCurrency == "" {
+		return []posting{
+			{Account: from, Debit: e.Amount, Currency: e.Currency},
+			{Account: to, Credit: e.Amount, Currency: e.Currency},
+		}
+	}
+	return []posting{
+		{Account: from, Debit: e.Amount, Currency: e.Currency},
+		{Account: fxAccount(e.Currency), Credit: e.Amount, Currency: e.Currency},
+		{Account: fxAccount(e.ToCurrency), Debit: e.ToAmount, Currency: e.ToCurrency},
+		{Account: to, Credit: e.ToAmount, Currency: e.ToCurrency},
+	}
+}
+
+// what e did to account's balance
+func (e ledgerEntry) net(account string) Money {
+	var n Money
+	for _, p := range e.Postings {
+		if p.Account == account {
+			n += p.Credit - p.Debit
+		}
+	}
+	return n
+}
+
+// reports whether e has a posting against account
+func (e ledgerEntry) touches(account string) bool {
+	for _, p := range e.Postings {
+		if p.Account == account {
+			return true
+		}
+	}
+	return false
+}
+
+// one row of the trial balance
+type trialBalanceAccount struct {
+	Account  string `json:"account"`
+	Currency string `json:"currency"`
+	Debits   Money  `json:"debits"`
+	Credits  Money  `json:"credits"`
+	Balance  Money  `json:"balance"`
+}
+
+// debits and credits of one currency, equal unless the ledger is broken
+type trialBalanceTotal struct {
+	Currency string `json:"currency"`
+	Debits   Money  `json:"debits"`
+	Credits  Money  `json:"credits"`
+}
+
+// an account whose balance in the store differs from what its postings
+// add up to
+type trialBalanceMismatch struct {
+	Account string `json:"account"`
+	Ledger  Money  `json:"ledger"`
+	Store   Money  `json:"store"`
+}
+
+// models the JSON response for GET /ledger/trial-balance
+type trialBalance struct {
+	Balanced   bool                   `json:"balanced"`
+	Totals     []trialBalanceTotal    `json:"totals"`
+	Accounts   []trialBalanceAccount  `json:"accounts"`
+	Mismatches []trialBalanceMismatch `json:"mismatches"`
+}
+
+// adds up every posting since the ledger opened, the opening balances
+// count as coming from cash. accts is read apart from the ledger, a
+// transfer landing in between shows up as a mismatch that is gone on
+// the next call. only the postings of ctx's tenant count, its ledger
+// balances on its own
+func buildTrialBalance(ctx context.Context, accts []Account) trialBalance {
+	tenant := tenantOf(ctx)
+	ledgerMu.Lock()
+	rows := map[string]*trialBalanceAccount{}
+	post := func(p posting) {
+		t, account := splitTenant(p.Account)
+		if t != tenant {
+			return
+		}
+		p.Account = account
+		row, ok := rows[p.Account]
+		if !ok {
+			row = &trialBalanceAccount{Account: p.Account, Currency: p.Currency}
+			rows[p.Account] = row
+		}
+		row.Debits += p.Debit
+		row.Credits += p.Credit
+		row.Balance += p.Credit - p.Debit
+	}
+	for _, p := range openingPostings {
+		post(p)
+	}
+	for _, e := range ledger {
+		for _, p := range e.Postings {
+			post(p)
+		}
+	}
+	ledgerMu.Unlock()
+
+	tb := trialBalance{Balanced: true, Totals: []trialBalanceTotal{}, Accounts: []trialBalanceAccount{}, Mismatches: []trialBalanceMismatch{}}
+	totals := map[string]*trialBalanceTotal{}
+	for _, row := range rows {
+		tb.Accounts = append(tb.Accounts, *row)
+		t, ok := totals[row.Currency]
+		if !ok {
+			t = &trialBalanceTotal{Currency: row.Currency}
+			totals[row.Currency] = t
+		}
+		t.Debits += row.Debits
+		t.Credits += row.Credits
+	}
+	for _, t := range totals {
+		tb.Totals = append(tb.Totals, *t)
+		if t.Debits != t.Credits {
+			tb.Balanced = false
+		}
+	}
+	for _, a := range accts {
+		var posted Money
+		if row, ok := rows[a.ID]; ok {
+			posted = row.Balance
+		}
+		if posted != a.Balance {
+			tb.Mismatches = append(tb.Mismatches, trialBalanceMismatch{Account: a.ID, Ledger: posted, Store: a.Balance})
+			tb.Balanced = false
+		}
+	}
+	slices.SortFunc(tb.Accounts, func(a, b trialBalanceAccount) int { return strings.Compare(a.Account, b.Account) })
+	slices.SortFunc(tb.Totals, func(a, b trialBalanceTotal) int { return strings.Compare(a.Currency, b.Currency) })
+	return tb
+}
+
+// handles GET /ledger/trial-balance, admins only. balanced is false when
+// debits and credits of a currency differ or an account's stored balance
+// isn't what its postings add up to
+func trialBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r.Context()) {
+		forbidden(w)
+		return
+	}
+	accts, err := storeFor(r.Context()).All()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not list accounts")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildTrialBalance(r.Context(), accts))
+}
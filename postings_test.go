@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func trialBalanceOf(t *testing.T) trialBalance {
+	t.Helper()
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/ledger/trial-balance", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body)
+	}
+	var tb trialBalance
+	if err := json.NewDecoder(w.Body).Decode(&tb); err != nil {
+		t.Fatal(err)
+	}
+	return tb
+}
+
+func TestTrialBalance(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": units(20)})
+	store.Create(Account{ID: "alice-eur", Currency: "EUR"})
+	resetLedger()
+	table, _ := parseRates("USD/EUR=0.92")
+	rates = table
+	defer func() { rates = staticRates{} }()
+
+	transferID(t, `{"from":"alice","to":"bob","amount":30}`)
+	transferHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"bob","to":"alice","amount":500}`)))
+	serveAPI(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/accounts/bob/deposit", strings.NewReader(`{"amount":5}`)))
+	serveAPI(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/accounts/alice/withdraw", strings.NewReader(`{"amount":8}`)))
+	convertHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/convert", strings.NewReader(`{"from":"alice","to":"alice-eur","amount":10}`)))
+
+	tb := trialBalanceOf(t)
+	if !tb.Balanced || len(tb.Mismatches) != 0 {
+		t.Fatalf("expected the ledger to balance, got %+v", tb)
+	}
+	for _, total := range tb.Totals {
+		if total.Debits != total.Credits {
+			t.Errorf("%s: debits %v != credits %v", total.Currency, total.Debits, total.Credits)
+		}
+	}
+	rows := map[string]trialBalanceAccount{}
+	for _, row := range tb.Accounts {
+		rows[row.Account] = row
+	}
+	// opening 120, +5 deposited, -8 withdrawn
+	if c := rows[cashAccount("USD")]; c.Balance != -units(117) {
+		t.Errorf("expected cash at -117, got %+v", c)
+	}
+	if fx := rows[fxAccount("USD")]; fx.Balance != units(10) {
+		t.Errorf("expected 10 USD on fx, got %+v", fx)
+	}
+	if a := rows["alice"]; a.Balance != units(52) || a.Debits != units(48) || a.Credits != units(100) {
+		t.Errorf("expected alice at 52 from 100 opening and 48 out, got %+v", a)
+	}
+
+	// failed attempts post nothing
+	for _, e := range entries(t.Context(), "bob") {
+		if e.Status == statusFailed && len(e.Postings) != 0 {
+			t.Errorf("expected no postings on failed entry %d, got %v", e.ID, e.Postings)
+		}
+	}
+}
+
+func TestTrialBalanceMismatch(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100)})
+	resetLedger()
+
+	// money appearing without a ledger entry
+	store.Credit("alice", units(1))
+	tb := trialBalanceOf(t)
+	if tb.Balanced || len(tb.Mismatches) != 1 || tb.Mismatches[0].Store-tb.Mismatches[0].Ledger != units(1) {
+		t.Errorf("expected alice to be reported, got %+v", tb)
+	}
+}
+
+func TestReservedAccountIDs(t *testing.T) {
+	store = newMemoryStore(nil)
+	for _, id := range []string{cashAccount("USD"), fxAccount("EUR")} {
+		w := httptest.NewRecorder()
+		serveAPI(w, httptest.NewRequest("POST", "/v1/accounts", strings.NewReader(`{"id":"`+id+`"}`)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", id, w.Code)
+		}
+	}
+}
+
+// nothing may pay into a ledger-only account, it would be counted twice
+func TestReservedAccountsRefuseCredits(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100)})
+	resetLedger()
+	seenCallbacks = make(map[string]time.Time)
+
+	for name, send := range map[string]func(w http.ResponseWriter){
+		"transfer": func(w http.ResponseWriter) {
+			serveAPI(w, httptest.NewRequest("POST", "/v1/transfer", strings.NewReader(`{"from":"alice","to":"cash:USD","amount":1}`)))
+		},
+		"batch": func(w http.ResponseWriter) {
+			serveAPI(w, httptest.NewRequest("POST", "/v1/transfers/batch", strings.NewReader(`{"transfers":[{"from":"alice","to":"fx:USD","amount":1}]}`)))
+		},
+		"convert": func(w http.ResponseWriter) {
+			serveAPI(w, httptest.NewRequest("POST", "/v1/convert", strings.NewReader(`{"from":"alice","to":"fx:EUR","amount":1}`)))
+		},
+		"hold": func(w http.ResponseWriter) {
+			serveAPI(w, httptest.NewRequest("POST", "/v1/holds", strings.NewReader(`{"from":"alice","to":"escrow:USD","amount":1}`)))
+		},
+		"callback": func(w http.ResponseWriter) {
+			body := fmt.Sprintf(`{"id":"evt-reserved","event":"payment.confirmed","account":"cash:USD","amount":1,"created_at":%q}`, time.Now().Format(time.RFC3339))
+			callbackHandler(w, httptest.NewRequest("POST", "/callback", strings.NewReader(body)))
+		},
+	} {
+		w := httptest.NewRecorder()
+		send(w)
+		if w.Code != http.StatusBadRequest || decodeError(t, w).Code != codeInvalidAccountID {
+			t.Errorf("%s: expected 400 %s, got %d %s", name, codeInvalidAccountID, w.Code, w.Body)
+		}
+	}
+	if a := balance(t, "alice"); a != units(100) {
+		t.Errorf("expected alice to keep 100, has %v", a)
+	}
+	if tb := trialBalanceOf(t); !tb.Balanced {
+		t.Errorf("expected the trial balance to still balance, got %+v", tb)
+	}
+}
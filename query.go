@@ -0,0 +1,250 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the list endpoints, GET /accounts, /transactions,
+// /accounts/{account}/transactions and /holds, share their query params:
+//
+//	limit       page size, defaultPageSize unless given, at most maxPageSize
+//	cursor      next_cursor of the page before
+//	offset      where to start instead, what older clients page with
+//	sort        a field of the endpoint to order by, - in front for
+//	            descending. ties are broken by id so the order is stable
+//	from_date   RFC3339 time or date, first point in time listed
+//	to_date     same, last point in time listed, a date includes its day
+//	min_amount  the smallest amount listed, for accounts their balance
+//
+// a cursor holds the sort key and id of the last item of its page, the
+// next starts right after that item however the list changed since. it
+// is only good with the sort and filters it was made with
+
+// the value items are ordered by, numbers first then text
+type sortKey struct {
+	N int64  `json:"n,omitempty"`
+	S string `json:"s,omitempty"`
+}
+
+func (a sortKey) compare(b sortKey) int {
+	if c := cmp.Compare(a.N, b.N); c != 0 {
+		return c
+	}
+	return strings.Compare(a.S, b.S)
+}
+
+func timeKey(t time.Time) sortKey { return sortKey{N: t.UnixNano()} }
+
+// what a list endpoint pages through. sorts has every field it can be
+// ordered by, at least defaultSort. when is nil for items that can't be
+// filtered by date
+type listSpec[T any] struct {
+	id          func(T) sortKey
+	sorts       map[string]func(T) sortKey
+	defaultSort string
+	when        func(T) time.Time
+	amount      func(T) Money
+}
+
+// where a page ended, as handed out base64 encoded in next_cursor
+type pageCursor struct {
+	Sort   string  `json:"sort"`
+	Filter string  `json:"filter"`
+	Key    sortKey `json:"key"`
+	ID     sortKey `json:"id"`
+}
+
+// the parsed query params of a list request
+type listQuery struct {
+	limit     int
+	offset    int
+	sort      string
+	desc      bool
+	from, to  time.Time
+	minAmount Money
+	// the filter params as given, cursors remember them
+	filter string
+	after  *pageCursor
+}
+
+// the sort as written in the query, defaults filled in
+func (lq listQuery) sortParam() string {
+	if lq.desc {
+		return "-" + lq.sort
+	}
+	return lq.sort
+}
+
+// one page of a list as cut by pageOf
+type listPage[T any] struct {
+	items      []T
+	total      int
+	nextOffset *int
+	nextCursor *string
+}
+
+// reads the list params of r for a list of spec's items
+func parseListQuery[T any](r *http.Request, spec listSpec[T]) (listQuery, error) {
+	q := r.URL.Query()
+	lq := listQuery{limit: defaultPageSize, sort: spec.defaultSort}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return listQuery{}, failure(codeInvalidPagination, "limit and offset must be non-negative integers")
+		}
+		lq.limit = min(n, maxPageSize)
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return listQuery{}, failure(codeInvalidPagination, "limit and offset must be non-negative integers")
+		}
+		lq.offset = n
+	}
+	if v := q.Get("sort"); v != "" {
+		field := strings.TrimPrefix(v, "-")
+		if _, ok := spec.sorts[field]; !ok {
+			fields := make([]string, 0, len(spec.sorts))
+			for f := range spec.sorts {
+				fields = append(fields, f)
+			}
+			slices.Sort(fields)
+			return listQuery{}, failure(codeInvalidPagination, "sort must be one of "+strings.Join(fields, ", ")+", - in front for descending")
+		}
+		lq.sort, lq.desc = field, field != v
+	}
+
+	var err error
+	if lq.from, err = parseStatementTime(q.Get("from_date"), false); err != nil {
+		return listQuery{}, failure(codeInvalidTimestamp, "from_date must be an RFC3339 timestamp or a date")
+	}
+	if lq.to, err = parseStatementTime(q.Get("to_date"), true); err != nil {
+		return listQuery{}, failure(codeInvalidTimestamp, "to_date must be an RFC3339 timestamp or a date")
+	}
+	if spec.when == nil && !(lq.from.IsZero() && lq.to.IsZero()) {
+		return listQuery{}, failure(codeInvalidRequest, "this list can't be filtered by date")
+	}
+	if !lq.from.IsZero() && !lq.to.IsZero() && lq.from.After(lq.to) {
+		return listQuery{}, failure(codeInvalidTimestamp, "from_date must not be after to_date")
+	}
+	if v := q.Get("min_amount"); v != "" {
+		if lq.minAmount, err = ParseMoney(v); err != nil {
+			return listQuery{}, failure(codeInvalidAmount, "min_amount must be a decimal number with at most 2 decimal places")
+		}
+	}
+	lq.filter = q.Get("from_date") + "|" + q.Get("to_date") + "|" + q.Get("min_amount")
+
+	if v := q.Get("cursor"); v != "" {
+		if lq.offset != 0 {
+			return listQuery{}, failure(codeInvalidPagination, "cursor and offset can't be used together")
+		}
+		var c pageCursor
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err == nil {
+			err = json.Unmarshal(b, &c)
+		}
+		if err != nil {
+			return listQuery{}, failure(codeInvalidPagination, "cursor is not one this server handed out")
+		}
+		if c.Sort != lq.sortParam() || c.Filter != lq.filter {
+			return listQuery{}, failure(codeInvalidPagination, "cursor was made for another sort or filter")
+		}
+		lq.after = &c
+	}
+	return lq, nil
+}
+
+// filters and orders list, then cuts out the page lq asks for
+func pageOf[T any](list []T, lq listQuery, spec listSpec[T]) listPage[T] {
+	var kept []T
+	for _, it := range list {
+		if spec.when != nil {
+			at := spec.when(it)
+			if !lq.from.IsZero() && at.Before(lq.from) || !lq.to.IsZero() && at.After(lq.to) {
+				continue
+			}
+		}
+		if spec.amount(it) < lq.minAmount {
+			continue
+		}
+		kept = append(kept, it)
+	}
+	key := spec.sorts[lq.sort]
+	order := func(k, id sortKey, it T) int {
+		c := k.compare(key(it))
+		if c == 0 {
+			c = id.compare(spec.id(it))
+		}
+		if lq.desc {
+			return -c
+		}
+		return c
+	}
+	slices.SortStableFunc(kept, func(a, b T) int { return order(key(a), spec.id(a), b) })
+
+	start := min(lq.offset, len(kept))
+	if lq.after != nil {
+		// the first item ordered after the cursor's
+		start, _ = slices.BinarySearchFunc(kept, 0, func(it T, _ int) int {
+			if order(lq.after.Key, lq.after.ID, it) < 0 {
+				return 1
+			}
+			return -1
+		})
+	}
+	end := min(start+lq.limit, len(kept))
+	page := listPage[T]{items: kept[start:end], total: len(kept)}
+	if page.items == nil {
+		page.items = []T{}
+	}
+	if end < len(kept) {
+		page.nextOffset = &end
+		last := kept[end-1]
+		b, _ := json.Marshal(pageCursor{Sort: lq.sortParam(), Filter: lq.filter, Key: key(last), ID: spec.id(last)})
+		c := base64.RawURLEncoding.EncodeToString(b)
+		page.nextCursor = &c
+	}
+	return page
+}
+
+// the ledger as listed by /transactions, oldest first by default
+var transactionList = listSpec[ledgerEntry]{
+	id: func(e ledgerEntry) sortKey { return sortKey{N: e.ID} },
+	sorts: map[string]func(ledgerEntry) sortKey{
+		"id":        func(e ledgerEntry) sortKey { return sortKey{N: e.ID} },
+		"timestamp": func(e ledgerEntry) sortKey { return timeKey(e.Timestamp) },
+		"amount":    func(e ledgerEntry) sortKey { return sortKey{N: int64(e.Amount)} },
+	},
+	defaultSort: "id",
+	when:        func(e ledgerEntry) time.Time { return e.Timestamp },
+	amount:      func(e ledgerEntry) Money { return e.Amount },
+}
+
+var accountList = listSpec[Account]{
+	id: func(a Account) sortKey { return sortKey{S: a.ID} },
+	sorts: map[string]func(Account) sortKey{
+		"id":      func(a Account) sortKey { return sortKey{S: a.ID} },
+		"balance": func(a Account) sortKey { return sortKey{N: int64(a.Balance)} },
+	},
+	defaultSort: "id",
+	amount:      func(a Account) Money { return a.Balance },
+}
+
+var holdList = listSpec[hold]{
+	id: func(h hold) sortKey { return sortKey{S: h.ID} },
+	sorts: map[string]func(hold) sortKey{
+		"created_at": func(h hold) sortKey { return timeKey(h.CreatedAt) },
+		"expires_at": func(h hold) sortKey { return timeKey(h.ExpiresAt) },
+		"amount":     func(h hold) sortKey { return sortKey{N: int64(h.Amount)} },
+	},
+	defaultSort: "created_at",
+	when:        func(h hold) time.Time { return h.CreatedAt },
+	amount:      func(h hold) Money { return h.Amount },
+}
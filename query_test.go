@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// GETs path and decodes the page it answers with into out
+func getPage(t *testing.T, path string, out any) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", path, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: expected 200, got %d %s", path, w.Code, w.Body)
+	}
+	if err := json.NewDecoder(w.Body).Decode(out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ids(list []ledgerEntry) []int64 {
+	var out []int64
+	for _, e := range list {
+		out = append(out, e.ID)
+	}
+	return out
+}
+
+func TestTransactionsCursor(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	for _, amount := range []string{"5", "20", "10", "20", "1"} {
+		transferID(t, `{"from":"alice","to":"bob","amount":`+amount+`}`)
+	}
+
+	// largest first, descending ids break the tie of the two 20s
+	var page transactionPage
+	getPage(t, "/v1/transactions?sort=-amount&limit=2", &page)
+	if got := ids(page.Transactions); len(got) != 2 || got[0] != 4 || got[1] != 2 || page.NextCursor == nil {
+		t.Fatalf("expected 4 and 2 and a cursor, got %v %v", got, page.NextCursor)
+	}
+	// what comes in meanwhile doesn't shift the next page
+	transferID(t, `{"from":"alice","to":"bob","amount":30}`)
+	cursor := *page.NextCursor
+	page = transactionPage{}
+	getPage(t, "/v1/transactions?sort=-amount&limit=2&cursor="+url.QueryEscape(cursor), &page)
+	if got := ids(page.Transactions); len(got) != 2 || got[0] != 3 || got[1] != 1 || page.NextCursor == nil {
+		t.Fatalf("expected 3 and 1 after the cursor, got %v", got)
+	}
+	last := *page.NextCursor
+	page = transactionPage{}
+	getPage(t, "/v1/transactions?sort=-amount&limit=2&cursor="+url.QueryEscape(last), &page)
+	if got := ids(page.Transactions); len(got) != 1 || got[0] != 5 || page.NextCursor != nil || page.Total != 6 {
+		t.Errorf("expected 5 alone on the last page, got %v of %d", got, page.Total)
+	}
+
+	page = transactionPage{}
+	getPage(t, "/v1/accounts/bob/transactions?min_amount=10&to_date="+now().UTC().Format(time.DateOnly), &page)
+	if got := ids(page.Transactions); page.Total != 4 || len(got) != 4 || got[0] != 2 || got[3] != 6 || page.NextCursor != nil {
+		t.Errorf("expected the 4 entries of at least 10.00, got %v of %d", got, page.Total)
+	}
+	page = transactionPage{}
+	getPage(t, "/v1/transactions?from_date="+now().Add(time.Hour).UTC().Format(time.RFC3339), &page)
+	if page.Total != 0 || len(page.Transactions) != 0 {
+		t.Errorf("expected nothing after now, got %+v", page)
+	}
+
+	for query, code := range map[string]string{
+		"sort=fee":                                codeInvalidPagination,
+		"cursor=garbage":                          codeInvalidPagination,
+		"cursor=" + cursor:                        codeInvalidPagination, // made for another sort
+		"cursor=" + cursor + "&offset=1":          codeInvalidPagination,
+		"from_date=yesterday":                     codeInvalidTimestamp,
+		"from_date=2026-02-01&to_date=2026-01-01": codeInvalidTimestamp,
+		"min_amount=lots":                         codeInvalidAmount,
+	} {
+		w := httptest.NewRecorder()
+		serveAPI(w, httptest.NewRequest("GET", "/v1/transactions?"+query, nil))
+		if w.Code != http.StatusBadRequest || decodeError(t, w).Code != code {
+			t.Errorf("%s: expected 400 %s, got %d %s", query, code, w.Code, w.Body)
+		}
+	}
+}
+
+func TestAccountsAndHoldsPages(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": units(5), "carol": units(50), "dave": 0})
+	resetLedger()
+	holds = map[string]*hold{}
+
+	var accounts accountPage
+	getPage(t, "/v1/accounts?sort=-balance&limit=3&min_amount=1", &accounts)
+	if accounts.Total != 3 || len(accounts.Accounts) != 3 || accounts.Accounts[0].ID != "alice" || accounts.Accounts[2].ID != "bob" || accounts.NextCursor != nil {
+		t.Errorf("expected alice, carol and bob richest first, got %+v", accounts)
+	}
+	accounts = accountPage{}
+	getPage(t, "/v1/accounts?limit=3", &accounts)
+	if len(accounts.Accounts) != 3 || accounts.NextCursor == nil {
+		t.Fatalf("expected a first page of 3, got %+v", accounts)
+	}
+	cursor := *accounts.NextCursor
+	accounts = accountPage{}
+	getPage(t, "/v1/accounts?limit=3&cursor="+url.QueryEscape(cursor), &accounts)
+	if len(accounts.Accounts) != 1 || accounts.Accounts[0].ID != "dave" {
+		t.Errorf("expected dave on the second page, got %+v", accounts)
+	}
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/accounts?from_date=2026-01-01", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected accounts not to filter by date, got %d", w.Code)
+	}
+
+	placeTestHold(t, `{"from":"alice","to":"bob","amount":30}`)
+	placeTestHold(t, `{"from":"carol","to":"bob","amount":10}`)
+	placeTestHold(t, `{"from":"alice","to":"carol","amount":5}`)
+	var hp holdPage
+	getPage(t, "/v1/holds?sort=amount&min_amount=6", &hp)
+	if hp.Total != 2 || hp.Holds[0].Amount != units(10) || hp.Holds[1].Amount != units(30) {
+		t.Errorf("expected the holds of 10.00 and 30.00, got %+v", hp)
+	}
+
+	keys, _ := parseAPIKeys("k1=alice:user:alice")
+	apiKeys = keys
+	defer func() { apiKeys = nil }()
+	req := httptest.NewRequest("GET", "/v1/holds", nil)
+	req.Header.Set("X-API-Key", "k1")
+	w = httptest.NewRecorder()
+	serveAPI(w, req)
+	hp = holdPage{}
+	json.NewDecoder(w.Body).Decode(&hp)
+	if hp.Total != 2 || !strings.HasPrefix(hp.Holds[0].ID, "hold_") || hp.Holds[0].From != "alice" || hp.Holds[1].From != "alice" {
+		t.Errorf("expected only alice's 2 holds, got %d %+v", w.Code, hp)
+	}
+}
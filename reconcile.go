@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reconciliation checks an external statement, a bank's or a payment
+// provider's, against the ledger. the statement is a CSV with a header
+// row naming its columns, in any order:
+//
+//	id         the ledger id the line is about, optional
+//	timestamp  RFC3339 time or date the money moved, optional, compared
+//	           by UTC day since statements rarely agree on the second
+//	from, to   the accounts, required unless id is
+//	amount     required
+//	currency   optional
+//
+// other columns are ignored. a line with an id is compared to that entry,
+// one without is matched to a completed entry with the same accounts,
+// amount and, when given, currency and day that no other line matched.
+// lines are matched, missing when there is no such entry, or mismatched
+// with the fields that differ
+
+// one line of the statement, as read
+type reconcileRow struct {
+	Line      int        `json:"line"`
+	ID        int64      `json:"id,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	From      string     `json:"from,omitempty"`
+	To        string     `json:"to,omitempty"`
+	Amount    Money      `json:"amount"`
+	Currency  string     `json:"currency,omitempty"`
+}
+
+type reconcileMatch struct {
+	Line        int   `json:"line"`
+	Transaction int64 `json:"transaction"`
+}
+
+// a field the statement and the ledger disagree on, both as text
+type reconcileDiff struct {
+	Field     string `json:"field"`
+	Statement string `json:"statement"`
+	Ledger    string `json:"ledger"`
+}
+
+type reconcileMismatch struct {
+	Row         reconcileRow    `json:"row"`
+	Transaction ledgerEntry     `json:"transaction"`
+	Differences []reconcileDiff `json:"differences"`
+}
+
+// models the JSON response for POST /reconcile, each list in statement
+// order
+type reconciliation struct {
+	Lines      int                 `json:"lines"`
+	Matched    []reconcileMatch    `json:"matched"`
+	Missing    []reconcileRow      `json:"missing"`
+	Mismatched []reconcileMismatch `json:"mismatched"`
+}
+
+// a line of the statement that can't be read, line 1 being the header
+type statementError struct {
+	line int
+	code string
+	msg  string
+}
+
+func (e *statementError) Error() string { return fmt.Sprintf("line %d: %s", e.line, e.msg) }
+
+// reads the statement CSV in body
+func parseStatement(body []byte) ([]reconcileRow, error) {
+	cr := csv.NewReader(bytes.NewReader(body))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, &statementError{1, codeInvalidRequest, "statement is empty, expected a header row"}
+	}
+	var perr *csv.ParseError
+	if errors.As(err, &perr) {
+		return nil, &statementError{1, codeInvalidRequest, perr.Err.Error()}
+	}
+	if err != nil {
+		return nil, err
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	_, hasID := col["id"]
+	_, hasFrom := col["from"]
+	_, hasTo := col["to"]
+	if _, ok := col["amount"]; !ok || !hasID && !(hasFrom && hasTo) {
+		return nil, &statementError{1, codeInvalidRequest, "header must name an amount column and id or from and to"}
+	}
+
+	var rows []reconcileRow
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			return nil, &statementError{perr.StartLine, codeInvalidRequest, perr.Err.Error()}
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := col[name]; ok && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		row := reconcileRow{Line: line, From: field("from"), To: field("to"), Currency: field("currency")}
+		if row.Amount, err = ParseMoney(field("amount")); err != nil || row.Amount <= 0 {
+			return nil, &statementError{line, codeInvalidAmount, "amount must be a positive decimal number with at most 2 decimal places"}
+		}
+		if v := field("id"); v != "" {
+			if row.ID, err = strconv.ParseInt(v, 10, 64); err != nil || row.ID < 1 {
+				return nil, &statementError{line, codeInvalidRequest, "id must be a transaction id"}
+			}
+		} else if row.From == "" && row.To == "" {
+			return nil, &statementError{line, codeInvalidRequest, "a line needs an id or its accounts"}
+		}
+		if v := field("timestamp"); v != "" {
+			t, err := parseStatementTime(v, false)
+			if err != nil {
+				return nil, &statementError{line, codeInvalidRequest, "timestamp must be an RFC3339 time or a date"}
+			}
+			row.Timestamp = &t
+		}
+		rows = append(rows, row)
+	}
+}
+
+// the fields row and e disagree on, the ones row leaves out count as
+// agreeing
+func (row reconcileRow) differences(e ledgerEntry) []reconcileDiff {
+	var diffs []reconcileDiff
+	differ := func(field, statement, ledger string) {
+		if statement != ledger {
+			diffs = append(diffs, reconcileDiff{field, statement, ledger})
+		}
+	}
+	if e.Status != statusCompleted {
+		differ("status", statusCompleted, e.Status)
+	}
+	if row.From != "" || row.To != "" {
+		differ("from", row.From, e.From)
+		differ("to", row.To, e.To)
+	}
+	differ("amount", row.Amount.String(), e.Amount.String())
+	if row.Currency != "" {
+		differ("currency", row.Currency, e.Currency)
+	}
+	if row.Timestamp != nil {
+		differ("timestamp", row.Timestamp.UTC().Format(time.DateOnly), e.Timestamp.UTC().Format(time.DateOnly))
+	}
+	return diffs
+}
+
+// compares rows to list, the ledger entries they may refer to
+func reconcile(rows []reconcileRow, list []ledgerEntry) reconciliation {
+	rec := reconciliation{Lines: len(rows), Matched: []reconcileMatch{}, Missing: []reconcileRow{}, Mismatched: []reconcileMismatch{}}
+	// entries by id and, for lines without one, by accounts and amount
+	type key struct {
+		from, to string
+		amount   Money
+	}
+	byID := make(map[int64]ledgerEntry, len(list))
+	byKey := map[key][]ledgerEntry{}
+	for _, e := range list {
+		byID[e.ID] = e
+		k := key{e.From, e.To, e.Amount}
+		byKey[k] = append(byKey[k], e)
+	}
+	taken := map[int64]bool{}
+	// lines naming their entry first, so the others can't take it
+	for _, row := range rows {
+		if row.ID == 0 {
+			continue
+		}
+		e, ok := byID[row.ID]
+		switch diffs := row.differences(e); {
+		case !ok:
+			rec.Missing = append(rec.Missing, row)
+		case len(diffs) > 0:
+			rec.Mismatched = append(rec.Mismatched, reconcileMismatch{row, e, diffs})
+		default:
+			rec.Matched = append(rec.Matched, reconcileMatch{row.Line, e.ID})
+		}
+		if ok {
+			taken[e.ID] = true
+		}
+	}
+	for _, row := range rows {
+		if row.ID != 0 {
+			continue
+		}
+		candidates := byKey[key{row.From, row.To, row.Amount}]
+		i := slices.IndexFunc(candidates, func(e ledgerEntry) bool {
+			return !taken[e.ID] && len(row.differences(e)) == 0
+		})
+		if i < 0 {
+			rec.Missing = append(rec.Missing, row)
+			continue
+		}
+		taken[candidates[i].ID] = true
+		rec.Matched = append(rec.Matched, reconcileMatch{row.Line, candidates[i].ID})
+	}
+	slices.SortFunc(rec.Matched, func(a, b reconcileMatch) int { return a.Line - b.Line })
+	slices.SortFunc(rec.Missing, func(a, b reconcileRow) int { return a.Line - b.Line })
+	return rec
+}
+
+// handles POST /reconcile, admins only. the body is the statement CSV,
+// the answer what of it the ledger of the caller's tenant agrees with
+func reconcileHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r.Context()) {
+		forbidden(w)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBody+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "could not read body")
+		return
+	}
+	if len(body) > maxRequestBody {
+		writeError(w, http.StatusRequestEntityTooLarge, codeInvalidRequest, "body larger than 1MB")
+		return
+	}
+	rows, err := parseStatement(body)
+	var se *statementError
+	if errors.As(err, &se) {
+		writeErrorDetails(w, http.StatusBadRequest, se.code, se.Error(), map[string]any{"line": se.line})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "could not read statement")
+		return
+	}
+	rec := reconcile(rows, entries(r.Context(), ""))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rec)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postStatement(t *testing.T, csv string) (*httptest.ResponseRecorder, reconciliation) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/v1/reconcile", strings.NewReader(csv))
+	req.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	serveAPI(w, req)
+	var rec reconciliation
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w, rec
+}
+
+func TestReconcile(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0, "carol": 0})
+	resetLedger()
+	transferID(t, `{"from":"alice","to":"bob","amount":10}`)
+	transferID(t, `{"from":"alice","to":"carol","amount":5}`)
+	transferID(t, `{"from":"alice","to":"carol","amount":5}`)
+	today := now().UTC().Format("2006-01-02")
+
+	w, rec := postStatement(t, "ID,Timestamp,From,To,Amount,Currency,Memo\n"+
+		"1,"+today+",alice,bob,10.00,USD,rent\n"+
+		// by id but for another amount
+		"2,,alice,carol,6,,\n"+
+		// without an id it takes the entry no other line did
+		",,alice,carol,5,USD,\n"+
+		",,alice,carol,5,,\n"+
+		"7,,alice,bob,1,,\n"+
+		",2001-01-01,alice,bob,10,,\n")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body)
+	}
+	if rec.Lines != 6 {
+		t.Errorf("expected 6 lines, got %d", rec.Lines)
+	}
+	if len(rec.Matched) != 2 || rec.Matched[0] != (reconcileMatch{2, 1}) || rec.Matched[1] != (reconcileMatch{4, 3}) {
+		t.Errorf("expected lines 2 and 4 matched to 1 and 3, got %+v", rec.Matched)
+	}
+	if len(rec.Mismatched) != 1 {
+		t.Fatalf("expected one mismatch, got %+v", rec.Mismatched)
+	}
+	m := rec.Mismatched[0]
+	if m.Row.Line != 3 || m.Transaction.ID != 2 || len(m.Differences) != 1 || m.Differences[0] != (reconcileDiff{"amount", "6.00", "5.00"}) {
+		t.Errorf("expected line 3 to differ from 2 in its amount, got %+v", m)
+	}
+	// line 5's entry went to line 3 by id already
+	var missing []int
+	for _, row := range rec.Missing {
+		missing = append(missing, row.Line)
+	}
+	if len(missing) != 3 || missing[0] != 5 || missing[1] != 6 || missing[2] != 7 {
+		t.Errorf("expected lines 5, 6 and 7 missing, got %v", missing)
+	}
+}
+
+func TestReconcileFailedEntry(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(1), "bob": 0})
+	resetLedger()
+	w := httptest.NewRecorder()
+	transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":10}`)))
+
+	_, rec := postStatement(t, "id,amount\n1,10\n")
+	if len(rec.Mismatched) != 1 || rec.Mismatched[0].Differences[0] != (reconcileDiff{"status", statusCompleted, statusFailed}) {
+		t.Errorf("expected the failed transfer to mismatch on its status, got %+v", rec)
+	}
+	_, rec = postStatement(t, "from,to,amount\nalice,bob,10\n")
+	if len(rec.Missing) != 1 {
+		t.Errorf("expected a line without id to only match completed entries, got %+v", rec)
+	}
+}
+
+func TestReconcileBadStatement(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100)})
+	resetLedger()
+	for csv, want := range map[string]struct {
+		code string
+		line int
+	}{
+		"":                                {codeInvalidRequest, 1},
+		"from,to\nalice,bob\n":            {codeInvalidRequest, 1},
+		"amount\n10\n":                    {codeInvalidRequest, 1},
+		"id,amount\n1,ten\n":              {codeInvalidAmount, 2},
+		"id,amount\n1,10\n1,-5\n":         {codeInvalidAmount, 3},
+		"id,amount\nx,10\n":               {codeInvalidRequest, 2},
+		"from,to,amount\n,,10\n":          {codeInvalidRequest, 2},
+		"id,timestamp,amount\n1,soon,1\n": {codeInvalidRequest, 2},
+		"id,amount\n\"1,10\n":             {codeInvalidRequest, 2},
+	} {
+		w, _ := postStatement(t, csv)
+		e := decodeError(t, w)
+		if details, _ := e.Details.(map[string]any); w.Code != http.StatusBadRequest || e.Code != want.code || details["line"] != float64(want.line) {
+			t.Errorf("%q: expected 400 %s on line %d, got %d %s", csv, want.code, want.line, w.Code, w.Body)
+		}
+	}
+
+	keys, _ := parseAPIKeys("k1=alice:user:alice")
+	apiKeys = keys
+	defer func() { apiKeys = nil }()
+	req := httptest.NewRequest("POST", "/v1/reconcile", strings.NewReader("id,amount\n1,10\n"))
+	req.Header.Set("X-API-Key", "k1")
+	w := httptest.NewRecorder()
+	serveAPI(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a user, got %d", w.Code)
+	}
+}
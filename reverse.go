@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// serializes reversals so two requests can't both undo the same entry
+var reverseMu sync.Mutex
+
+// handles POST /transactions/{id}/reverse
+func reverseHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		notFound(w)
+		return
+	}
+	e, err := reverse(r, id)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(e)
+}
+
+// moves the funds of transfer id back with a compensating entry. the
+// recipient pays them back so it's the recipient's owner who may ask
+func reverse(r *http.Request, id int64) (ledgerEntry, error) {
+	reverseMu.Lock()
+	defer reverseMu.Unlock()
+
+	orig, ok := entryByID(r.Context(), id)
+	if !ok {
+		return ledgerEntry{}, failure(codeTransactionNotFound, "transaction not found")
+	}
+	if !mayDebit(r.Context(), orig.To) {
+		return ledgerEntry{}, errForbidden
+	}
+	switch {
+	case orig.ReversedBy != 0:
+		return ledgerEntry{}, &serviceError{
+			code:    codeAlreadyReversed,
+			message: "transaction was already reversed",
+			details: map[string]any{"reversed_by": orig.ReversedBy},
+		}
+	case orig.Status != statusCompleted:
+		return ledgerEntry{}, failure(codeNotReversible, "only completed transactions can be reversed")
+	case orig.From == "" || orig.To == "" || orig.HoldID != "" || orig.Reverses != 0:
+		// deposits, withdrawals and hold legs have their own way back
+		return ledgerEntry{}, failure(codeNotReversible, "only transfers and conversions can be reversed")
+	}
+
+	if err := checkCredit(r.Context(), orig.From); err != nil {
+		return ledgerEntry{}, err
+	}
+
+	// booked completed, which marks orig reversed in the same write
+	entry := ledgerEntry{From: orig.To, To: orig.From, Reverses: orig.ID, Status: statusCompleted}
+	var change func(Store) error
+	if orig.ToCurrency != "" {
+		// undo a conversion at its original rate, not today's
+		entry.Amount, entry.Currency = orig.ToAmount, orig.ToCurrency
+		entry.ToAmount, entry.ToCurrency = orig.Amount, orig.Currency
+		change = func(s Store) error { return s.Exchange(orig.To, orig.From, orig.ToAmount, orig.Amount) }
+	} else {
+		entry.Amount, entry.Currency = orig.Amount, orig.Currency
+		change = func(s Store) error { return s.Transfer(orig.To, orig.From, orig.Amount) }
+	}
+	booked, err := book(r.Context(), change, entry)
+	if err != nil {
+		entry.Status = statusFailed
+		record(r.Context(), entry)
+	}
+	switch {
+	case errors.Is(err, ErrInsufficientFunds):
+		return ledgerEntry{}, &serviceError{
+			code:    codeInsufficientFunds,
+			message: "recipient no longer holds enough to reverse",
+			details: map[string]any{"account": orig.To, "amount": entry.Amount},
+		}
+	case errors.Is(err, ErrAccountFrozen):
+		return ledgerEntry{}, frozen(orig.To)
+	case errors.Is(err, ErrAccountClosed):
+		return ledgerEntry{}, closed(orig.To)
+	case errors.Is(err, ErrAccountNotFound):
+		return ledgerEntry{}, failure(codeAccountNotFound, "account not found")
+	case err != nil:
+		return ledgerEntry{}, failure(codeInternal, "reversal failed")
+	}
+	return booked[0], nil
+}
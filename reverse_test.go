@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func reverseTx(id int64) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/transactions/"+strconv.FormatInt(id, 10)+"/reverse", nil))
+	return w
+}
+
+// makes a transfer through the handler and returns its ledger id
+func transferID(t *testing.T, body string) int64 {
+	t.Helper()
+	w := httptest.NewRecorder()
+	transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("transfer failed: %d %s", w.Code, w.Body)
+	}
+	var resp struct{ ID int64 }
+	json.NewDecoder(w.Body).Decode(&resp)
+	return resp.ID
+}
+
+func TestReverseTransfer(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+
+	id := transferID(t, `{"from":"alice","to":"bob","amount":40}`)
+	w := reverseTx(id)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", w.Code, w.Body)
+	}
+	var rev ledgerEntry
+	json.NewDecoder(w.Body).Decode(&rev)
+	if rev.From != "bob" || rev.To != "alice" || rev.Amount != units(40) || rev.Reverses != id {
+		t.Errorf("unexpected reversal %+v", rev)
+	}
+	if a, b := balance(t, "alice"), balance(t, "bob"); a != units(100) || b != 0 {
+		t.Errorf("expected alice=100 bob=0, got %v %v", a, b)
+	}
+	if orig, _ := entryByID(t.Context(), id); orig.ReversedBy != rev.ID {
+		t.Errorf("expected original marked reversed by %d, got %+v", rev.ID, orig)
+	}
+
+	if w := reverseTx(id); w.Code != http.StatusConflict || decodeError(t, w).Code != codeAlreadyReversed {
+		t.Errorf("expected double reversal to be refused, got %d", w.Code)
+	}
+	if w := reverseTx(rev.ID); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected a reversal not to be reversible, got %d", w.Code)
+	}
+	if w := reverseTx(999); w.Code != http.StatusNotFound {
+		t.Errorf("expected unknown transaction to be 404, got %d", w.Code)
+	}
+}
+
+func TestReverseNeedsRecipientFunds(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0, "carol": 0})
+	resetLedger()
+
+	id := transferID(t, `{"from":"alice","to":"bob","amount":40}`)
+	transferID(t, `{"from":"bob","to":"carol","amount":30}`)
+
+	w := reverseTx(id)
+	if w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeInsufficientFunds {
+		t.Fatalf("expected 422 INSUFFICIENT_FUNDS, got %d", w.Code)
+	}
+	if a, b := balance(t, "alice"), balance(t, "bob"); a != units(60) || b != units(10) {
+		t.Errorf("expected nothing to move, got alice=%v bob=%v", a, b)
+	}
+	// the original can still be reversed once bob has the money again
+	if orig, _ := entryByID(t.Context(), id); orig.ReversedBy != 0 {
+		t.Errorf("expected original not marked reversed, got %+v", orig)
+	}
+}
+
+func TestReverseConversionAtOriginalRate(t *testing.T) {
+	store = newMemoryStore(map[string]Money{})
+	store.Create(Account{ID: "usd", Balance: units(100), Currency: "USD"})
+	store.Create(Account{ID: "eur", Currency: "EUR"})
+	resetLedger()
+	rates = staticRates{"USD/EUR": big.NewRat(92, 100)}
+	defer func() { rates = staticRates{} }()
+
+	w := httptest.NewRecorder()
+	convertHandler(w, httptest.NewRequest("POST", "/convert", strings.NewReader(`{"from":"usd","to":"eur","amount":10}`)))
+	var resp struct{ ID int64 }
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	// the rate moving since must not matter
+	rates = staticRates{"USD/EUR": big.NewRat(1, 2)}
+	if w := reverseTx(resp.ID); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", w.Code, w.Body)
+	}
+	if u, e := balance(t, "usd"), balance(t, "eur"); u != units(100) || e != 0 {
+		t.Errorf("expected usd=100 eur=0, got %v %v", u, e)
+	}
+}
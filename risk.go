@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// transfers pass the risk checks once they passed checkTransfer and
+// before any money moves, sync, async, scheduled and gRPC ones alike. a
+// check flags a transfer with the action its rule was set up with:
+// rejected ones fail with RISK_REJECTED, reviewed ones are recorded
+// pending_review until an admin approves or denies them. a batch can't
+// be parked, any flagged item fails it. the built-in rules are set with
+// PUT /admin/risk-rules and are not kept across restarts
+
+// what happens to a transfer a rule flags
+const (
+	riskReject = "reject"
+	riskReview = "review"
+)
+
+// RiskChecker looks at a transfer about to be made and returns why it is
+// suspicious, or nil to let it through. the built-in rules below are
+// RiskCheckers, more can be added to extraRiskCheckers
+type RiskChecker interface {
+	Check(ctx context.Context, req transferRequest) *riskFlag
+}
+
+// why a transfer was flagged, the details of a RISK_REJECTED error
+type riskFlag struct {
+	Rule   string `json:"rule"`
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+}
+
+// one built-in rule as PUT /admin/risk-rules takes it. amount flags
+// transfers of threshold or more, velocity an account's max-th transfer
+// out within window, new_recipient a first transfer between two accounts
+type riskRule struct {
+	Type      string `json:"type"`
+	Action    string `json:"action"`
+	Threshold Money  `json:"threshold,omitempty"`
+	Max       int    `json:"max,omitempty"`
+	Window    string `json:"window,omitempty"`
+}
+
+var (
+	riskMu       sync.RWMutex
+	riskRules    = []riskRule{}
+	riskCheckers []RiskChecker
+)
+
+// checkers run after the configured rules, for checks that need code
+var extraRiskCheckers []RiskChecker
+
+// flags transfers of at least threshold
+type amountRule struct {
+	threshold Money
+	action    string
+}
+
+func (r amountRule) Check(ctx context.Context, req transferRequest) *riskFlag {
+	if req.Amount < r.threshold {
+		return nil
+	}
+	return &riskFlag{"amount", r.action, fmt.Sprintf("amount %s is at or over %s", req.Amount, r.threshold)}
+}
+
+// flags a transfer when its sender already made max within window,
+// failed attempts not counted
+type velocityRule struct {
+	max    int
+	window time.Duration
+	action string
+}
+
+func (r velocityRule) Check(ctx context.Context, req transferRequest) *riskFlag {
+	since := now().Add(-r.window)
+	n := 0
+	for _, e := range entries(ctx, req.From) {
+		if e.From == req.From && e.Status != statusFailed && e.Timestamp.After(since) {
+			n++
+		}
+	}
+	if n < r.max {
+		return nil
+	}
+	return &riskFlag{"velocity", r.action, fmt.Sprintf("%s made %d transfers in the last %s", req.From, n, r.window)}
+}
+
+// flags the first transfer from one account to another
+type newRecipientRule struct {
+	action string
+}
+
+func (r newRecipientRule) Check(ctx context.Context, req transferRequest) *riskFlag {
+	for _, e := range entries(ctx, req.From) {
+		if e.From == req.From && e.To == req.To && e.Status == statusCompleted {
+			return nil
+		}
+	}
+	return &riskFlag{"new_recipient", r.action, fmt.Sprintf("%s never paid %s before", req.From, req.To)}
+}
+
+// turns rules into checkers, failing on the first one that doesn't make
+// sense
+func riskCheckersFor(rules []riskRule) ([]RiskChecker, error) {
+	var out []RiskChecker
+	for i, r := range rules {
+		if r.Action != riskReject && r.Action != riskReview {
+			return nil, fmt.Errorf("rule %d: action must be reject or review", i)
+		}
+		switch r.Type {
+		case "amount":
+			if r.Threshold <= 0 {
+				return nil, fmt.Errorf("rule %d: threshold must be positive", i)
+			}
+			out = append(out, amountRule{r.Threshold, r.Action})
+		case "velocity":
+			window, err := time.ParseDuration(r.Window)
+			if err != nil || window <= 0 || r.Max <= 0 {
+				return nil, fmt.Errorf("rule %d: velocity needs a positive max and window", i)
+			}
+			out = append(out, velocityRule{r.Max, window, r.Action})
+		case "new_recipient":
+			out = append(out, newRecipientRule{r.Action})
+		default:
+			return nil, fmt.Errorf("rule %d: unknown type %q", i, r.Type)
+		}
+	}
+	return out, nil
+}
+
+// runs every checker on req. a rejection wins over a review, otherwise
+// the first flag is returned
+func assessRisk(ctx context.Context, req transferRequest) *riskFlag {
+	riskMu.RLock()
+	checkers := append(riskCheckers[:len(riskCheckers):len(riskCheckers)], extraRiskCheckers...)
+	riskMu.RUnlock()
+	var review *riskFlag
+	for _, c := range checkers {
+		f := c.Check(ctx, req)
+		if f != nil && f.Action == riskReject {
+			return f
+		}
+		if review == nil {
+			review = f
+		}
+	}
+	return review
+}
+
+// records a flagged transfer, failed when it is rejected and
+// pending_review otherwise. rejections are returned as the error
+func flagTransfer(ctx context.Context, req transferRequest, currency string, f *riskFlag) (ledgerEntry, error) {
+	e := ledgerEntry{From: req.From, To: req.To, Amount: req.Amount, Currency: currency, Error: f.Reason}
+	if f.Action == riskReview {
+		e.Status = statusPendingReview
+		e, err := record(ctx, e)
+		if err != nil {
+			return ledgerEntry{}, failure(codeInternal, "could not park transfer for review")
+		}
+		return e, nil
+	}
+	e.Status = statusFailed
+	record(ctx, e)
+	transfersFailed.WithLabelValues(reasonRiskRejected).Inc()
+	return ledgerEntry{}, &serviceError{code: codeRiskRejected, message: "transfer rejected by the risk checks", details: f}
+}
+
+// serves GET /admin/risk-rules. the rules apply to every tenant, only
+// admins of the whole deployment may see or change them
+func riskRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if !isOperator(r.Context()) {
+		forbidden(w)
+		return
+	}
+	riskMu.RLock()
+	defer riskMu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]riskRule{"rules": riskRules})
+}
+
+// serves PUT /admin/risk-rules, replacing every built-in rule
+func setRiskRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if !isOperator(r.Context()) {
+		forbidden(w)
+		return
+	}
+	var req struct {
+		Rules []riskRule `json:"rules"`
+	}
+	if !decodeBody(w, r, "RiskRules", &req) {
+		return
+	}
+	checkers, err := riskCheckersFor(req.Rules)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	if req.Rules == nil {
+		req.Rules = []riskRule{}
+	}
+	riskMu.Lock()
+	riskRules, riskCheckers = req.Rules, checkers
+	riskMu.Unlock()
+	riskRulesHandler(w, r)
+}
+
+// serves GET /admin/reviews, the transfers waiting for a decision
+// oldest first
+func reviewsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r.Context()) {
+		forbidden(w)
+		return
+	}
+	list := []ledgerEntry{}
+	for _, e := range entries(r.Context(), "") {
+		if e.Status == statusPendingReview {
+			list = append(list, e)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]ledgerEntry{"transactions": list})
+}
+
+// serves POST /transactions/{id}/approve
+func approveHandler(w http.ResponseWriter, r *http.Request) {
+	review(w, r, true)
+}
+
+// serves POST /transactions/{id}/deny
+func denyHandler(w http.ResponseWriter, r *http.Request) {
+	review(w, r, false)
+}
+
+// decides on a transfer parked for review. an approved one is made as
+// if it had just been asked for, minus the risk checks, and can still
+// fail like any transfer. a denied one fails with who denied it
+func review(w http.ResponseWriter, r *http.Request, approve bool) {
+	if !isAdmin(r.Context()) {
+		forbidden(w)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeTransactionNotFound, "transaction not found")
+		return
+	}
+	e, err := takeForReview(r.Context(), id)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if !approve {
+		e, err = updateEntry(r.Context(), id, statusFailed, "denied in review by "+reviewer(r.Context()))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, "could not update transaction")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	// the admin doesn't own From, the transfer runs as its sender who
+	// asked for it
+	ctx := context.WithValue(r.Context(), principalKey{}, &principal{name: reviewer(r.Context()), accounts: map[string]bool{e.From: true}})
+	req := transferRequest{From: e.From, To: e.To, Amount: e.Amount, Currency: e.Currency}
+	currency, err := checkTransfer(ctx, req)
+	if err == nil {
+		e, err = runTransfer(ctx, req, currency, id)
+	}
+	if err != nil {
+		updateEntry(ctx, id, statusFailed, err.Error())
+		writeServiceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
+
+// who decided on a review, for the entry's error and the logs
+func reviewer(ctx context.Context) string {
+	if name := callerName(ctx); name != "" {
+		return name
+	}
+	return "admin"
+}
+
+// moves entry id from pending_review to processing, persisted, so only
+// one decision is ever made on it and a crash mid-way fails it on restart.
+// it is claimed in memory first, a second decision arriving while
+// processing is persisted finds it taken
+func takeForReview(ctx context.Context, id int64) (ledgerEntry, error) {
+	ledgerMu.Lock()
+	if id < 1 || id > int64(len(ledger)) || ledger[id-1].tenant() != tenantOf(ctx) {
+		ledgerMu.Unlock()
+		return ledgerEntry{}, failure(codeTransactionNotFound, "transaction not found")
+	}
+	e := &ledger[id-1]
+	if e.Status != statusPendingReview {
+		ledgerMu.Unlock()
+		return ledgerEntry{}, &serviceError{
+			code:    codeNotPendingReview,
+			message: "transaction is not pending review",
+			details: map[string]any{"status": e.Status},
+		}
+	}
+	e.Status = statusProcessing
+	reason := e.Error
+	ledgerMu.Unlock()
+	taken, err := updateEntry(ctx, id, statusProcessing, reason)
+	if err != nil {
+		ledgerMu.Lock()
+		ledger[id-1].Status = statusPendingReview
+		ledgerMu.Unlock()
+		return ledgerEntry{}, failure(codeInternal, "could not update transaction")
+	}
+	return taken, nil
+}
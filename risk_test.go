@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// replaces the risk rules, dropping them again when the test ends
+func setRiskRules(t *testing.T, rules string) {
+	t.Helper()
+	t.Cleanup(func() {
+		riskMu.Lock()
+		riskRules, riskCheckers = []riskRule{}, nil
+		riskMu.Unlock()
+	})
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("PUT", "/v1/admin/risk-rules", strings.NewReader(`{"rules":`+rules+`}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the rules to be set, got %d %s", w.Code, w.Body)
+	}
+}
+
+// posts a transfer expecting it to be parked, returns its ledger id
+func reviewedID(t *testing.T, body string) int64 {
+	t.Helper()
+	w := httptest.NewRecorder()
+	transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(body)))
+	var resp struct {
+		Status string
+		ID     int64
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusAccepted || resp.Status != statusPendingReview {
+		t.Fatalf("expected 202 pending_review, got %d %+v", w.Code, resp)
+	}
+	if loc := w.Header().Get("Location"); loc != "/v1/transactions/"+strconv.FormatInt(resp.ID, 10) {
+		t.Errorf("expected the transaction's location, got %q", loc)
+	}
+	return resp.ID
+}
+
+func decide(id int64, action string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/transactions/"+strconv.FormatInt(id, 10)+"/"+action, nil))
+	return w
+}
+
+func TestRiskReject(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	setRiskRules(t, `[{"type":"amount","action":"reject","threshold":50}]`)
+
+	w := httptest.NewRecorder()
+	transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":50}`)))
+	e := decodeError(t, w)
+	if w.Code != http.StatusUnprocessableEntity || e.Code != codeRiskRejected {
+		t.Fatalf("expected %s, got %d %s", codeRiskRejected, w.Code, w.Body)
+	}
+	if details, _ := e.Details.(map[string]any); details["rule"] != "amount" || details["action"] != riskReject {
+		t.Errorf("expected the rule in the details, got %v", e.Details)
+	}
+	if got := getTransaction(t, 1); got.Status != statusFailed || got.Error == "" {
+		t.Errorf("expected the attempt recorded failed with its reason, got %+v", got)
+	}
+	transferID(t, `{"from":"alice","to":"bob","amount":49.99}`)
+	if a := balance(t, "alice"); a != units(100)-4999 {
+		t.Errorf("expected only the small transfer to go through, alice has %v", a)
+	}
+
+	// a batch fails on a flagged item whatever the rule's action
+	setRiskRules(t, `[{"type":"amount","action":"review","threshold":50}]`)
+	w = httptest.NewRecorder()
+	batchTransferHandler(w, httptest.NewRequest("POST", "/transfers/batch",
+		strings.NewReader(`{"transfers":[{"from":"alice","to":"bob","amount":1},{"from":"alice","to":"bob","amount":50}]}`)))
+	if w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeRiskRejected {
+		t.Errorf("expected the batch to be rejected, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRiskReview(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0, "carol": 0})
+	resetLedger()
+	setRiskRules(t, `[{"type":"new_recipient","action":"review"}]`)
+
+	id := reviewedID(t, `{"from":"alice","to":"bob","amount":10}`)
+	if a := balance(t, "alice"); a != units(100) {
+		t.Errorf("expected nothing to move before the review, alice has %v", a)
+	}
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/admin/reviews", nil))
+	if !strings.Contains(w.Body.String(), `"id":`+strconv.FormatInt(id, 10)) {
+		t.Errorf("expected the transfer to be listed for review, got %s", w.Body)
+	}
+
+	if w := decide(id, "approve"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"completed"`) {
+		t.Fatalf("expected the approved transfer completed, got %d %s", w.Code, w.Body)
+	}
+	if a, b := balance(t, "alice"), balance(t, "bob"); a != units(90) || b != units(10) {
+		t.Errorf("expected 10 to move, got alice=%v bob=%v", a, b)
+	}
+	if w := decide(id, "deny"); w.Code != http.StatusConflict || decodeError(t, w).Code != codeNotPendingReview {
+		t.Errorf("expected a second decision to be refused, got %d %s", w.Code, w.Body)
+	}
+	// bob was paid before now
+	transferID(t, `{"from":"alice","to":"bob","amount":10}`)
+
+	id = reviewedID(t, `{"from":"alice","to":"carol","amount":10}`)
+	if w := decide(id, "deny"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body)
+	}
+	if e := getTransaction(t, id); e.Status != statusFailed || !strings.Contains(e.Error, "denied") {
+		t.Errorf("expected the denied transfer failed, got %+v", e)
+	}
+	if c := balance(t, "carol"); c != 0 {
+		t.Errorf("expected carol not to be paid, has %v", c)
+	}
+
+	// approving runs the transfer as it stands now
+	id = reviewedID(t, `{"from":"alice","to":"carol","amount":80}`)
+	transferID(t, `{"from":"alice","to":"bob","amount":50}`)
+	if w := decide(id, "approve"); w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeInsufficientFunds {
+		t.Errorf("expected the approval to fail for lack of funds, got %d %s", w.Code, w.Body)
+	}
+	if e := getTransaction(t, id); e.Status != statusFailed {
+		t.Errorf("expected the entry failed, got %+v", e)
+	}
+	if w := decide(999, "approve"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown transaction, got %d", w.Code)
+	}
+}
+
+func TestRiskVelocity(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	setRiskRules(t, `[{"type":"velocity","action":"reject","max":2,"window":"1h"}]`)
+
+	transferID(t, `{"from":"alice","to":"bob","amount":1}`)
+	transferID(t, `{"from":"alice","to":"bob","amount":1}`)
+	w := httptest.NewRecorder()
+	transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":1}`)))
+	if w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeRiskRejected {
+		t.Errorf("expected the third transfer within the hour rejected, got %d %s", w.Code, w.Body)
+	}
+	// incoming money doesn't count
+	transferID(t, `{"from":"bob","to":"alice","amount":1}`)
+}
+
+func TestRiskAsyncReview(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	setRiskRules(t, `[{"type":"amount","action":"review","threshold":10}]`)
+
+	w := postAsync(`{"from":"alice","to":"bob","amount":10}`)
+	if w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), statusPendingReview) {
+		t.Fatalf("expected 202 pending_review, got %d %s", w.Code, w.Body)
+	}
+	runPendingTransfers()
+	if e := getTransaction(t, 1); e.Status != statusPendingReview {
+		t.Errorf("expected the worker to leave it for review, got %+v", e)
+	}
+	if w := decide(1, "approve"); w.Code != http.StatusOK {
+		t.Errorf("expected the approval to go through, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRiskRules(t *testing.T) {
+	for body, code := range map[string]string{
+		`{"rules":[{"type":"amount","action":"block","threshold":1}]}`:    codeValidationFailed,
+		`{"rules":[{"type":"amount","action":"reject"}]}`:                 codeInvalidRequest,
+		`{"rules":[{"type":"velocity","action":"reject","max":2}]}`:       codeInvalidRequest,
+		`{"rules":[{"type":"velocity","action":"reject","window":"1h"}]}`: codeInvalidRequest,
+	} {
+		w := httptest.NewRecorder()
+		serveAPI(w, httptest.NewRequest("PUT", "/v1/admin/risk-rules", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest || decodeError(t, w).Code != code {
+			t.Errorf("%s: expected 400 %s, got %d %s", body, code, w.Code, w.Body)
+		}
+	}
+
+	setRiskRules(t, `[{"type":"new_recipient","action":"review"}]`)
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/admin/risk-rules", nil))
+	if !strings.Contains(w.Body.String(), `"type":"new_recipient"`) {
+		t.Errorf("expected the rule back, got %s", w.Body)
+	}
+
+	keys, _ := parseAPIKeys("k1=alice:user:alice")
+	apiKeys = keys
+	defer func() { apiKeys = nil }()
+	for _, path := range []string{"/v1/admin/risk-rules", "/v1/admin/reviews"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-API-Key", "k1")
+		w := httptest.NewRecorder()
+		serveAPI(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected 403 for a user, got %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// prefix every route of the current API version is mounted under. a /v2
+// gets a route table of its own next to v1Routes
+const apiPrefix = "/v1"
+
+// the methods a route that doesn't serve them answers with 405, others
+// get a 404
+var httpMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// one endpoint, path is relative to the version prefix and uses
+// http.ServeMux wildcards. name labels its metrics, span and log lines
+type route struct {
+	method  string
+	path    string
+	name    string
+	handler http.HandlerFunc
+}
+
+// the middleware every endpoint taking an API key sits behind. audited
+// sits outside of withDeadline, the balances after a call timing out are
+// still read
+func api(h http.HandlerFunc) http.HandlerFunc {
+	return authenticate(rateLimit(audited(withDeadline(h))))
+}
+
+// every endpoint of version 1
+func v1Routes() []route {
+	return []route{
+		{"GET", "/accounts/{account}/balance", "balance", api(balanceHandler)},
+		{"POST", "/transfer", "transfer", api(idempotent(transferHandler))},
+		{"POST", "/transfers/batch", "batch", api(batchTransferHandler)},
+		{"POST", "/convert", "convert", api(convertHandler)},
+		{"GET", "/transactions", "transactions", api(transactionsHandler)},
+		// a stream stays open for as long as its client wants, no deadline
+		{"GET", "/transactions/stream", "transactions_stream", authenticate(rateLimit(streamHandler))},
+		{"GET", "/transactions/{id}", "transaction", api(transactionHandler)},
+		{"POST", "/transactions/{id}/reverse", "reverse", api(idempotent(reverseHandler))},
+		{"POST", "/transactions/{id}/approve", "approve", api(approveHandler)},
+		{"POST", "/transactions/{id}/deny", "deny", api(denyHandler)},
+		{"GET", "/ledger/trial-balance", "trial_balance", api(trialBalanceHandler)},
+		{"POST", "/reconcile", "reconcile", api(reconcileHandler)},
+		{"GET", "/accounts", "accounts", api(listAccountsHandler)},
+		{"POST", "/accounts", "accounts", api(createAccountHandler)},
+		{"GET", "/accounts/{account}", "account", api(accountHandler)},
+		{"DELETE", "/accounts/{account}", "close_account", api(idempotent(closeAccountHandler))},
+		{"GET", "/accounts/{account}/export", "export", api(exportHandler)},
+		{"GET", "/accounts/{account}/transactions", "account_transactions", api(accountTransactionsHandler)},
+		{"GET", "/accounts/{account}/statement", "statement", api(statementHandler)},
+		{"GET", "/accounts/{account}/interest", "interest", api(interestHandler)},
+		{"POST", "/accounts/{account}/deposit", "deposit", api(idempotent(depositHandler))},
+		{"POST", "/accounts/{account}/withdraw", "withdraw", api(idempotent(withdrawHandler))},
+		{"POST", "/accounts/{account}/freeze", "freeze", api(freezeHandler)},
+		{"POST", "/accounts/{account}/unfreeze", "unfreeze", api(unfreezeHandler)},
+		{"PUT", "/accounts/{account}/overdraft", "overdraft", api(overdraftHandler)},
+		{"GET", "/holds", "holds", api(listHoldsHandler)},
+		{"POST", "/holds", "holds", api(idempotent(holdsHandler))},
+		{"GET", "/holds/{id}", "hold", api(holdHandler)},
+		{"POST", "/holds/{id}/capture", "capture", api(idempotent(captureHandler))},
+		{"POST", "/holds/{id}/release", "release", api(idempotent(releaseHandler))},
+		{"GET", "/scheduled-transfers", "schedules", api(listSchedulesHandler)},
+		{"POST", "/scheduled-transfers", "schedules", api(idempotent(createScheduleHandler))},
+		{"GET", "/scheduled-transfers/{id}", "schedule", api(scheduleHandler)},
+		{"DELETE", "/scheduled-transfers/{id}", "schedule", api(cancelScheduleHandler)},
+		{"GET", "/admin/risk-rules", "risk_rules", api(riskRulesHandler)},
+		{"PUT", "/admin/risk-rules", "risk_rules", api(setRiskRulesHandler)},
+		{"GET", "/admin/reviews", "reviews", api(reviewsHandler)},
+		{"GET", "/admin/stats", "stats", api(statsHandler)},
+		{"POST", "/admin/snapshot", "snapshot", api(snapshotHandler)},
+		{"POST", "/admin/restore", "restore", api(restoreHandler)},
+		{"GET", "/admin/audit", "audit", api(auditHandler)},
+		{"GET", "/admin/audit/verify", "audit_verify", api(verifyAuditHandler)},
+		{"GET", "/webhooks", "webhooks", api(listWebhooksHandler)},
+		{"POST", "/webhooks", "webhooks", api(registerWebhookHandler)},
+		{"DELETE", "/webhooks/{id}", "webhook", api(deleteWebhookHandler)},
+		// callbacks from the payment provider must be signed, they carry no API key
+		{"POST", "/callback", "callback", requireSignature(callbackSecret, audited(callbackHandler))},
+	}
+}
+
+// builds the mux serving the HTTP API. v1 is also served without its
+// prefix, and GET /balance/{account} kept, for clients from before
+// versioning
+func newRouter() *http.ServeMux {
+	mux := http.NewServeMux()
+	allowed := map[string][]string{}
+	add := func(method, path, name string, h http.HandlerFunc) {
+		mux.HandleFunc(method+" "+path, instrument(name, h))
+		allowed[path] = append(allowed[path], method)
+	}
+	for _, rt := range v1Routes() {
+		add(rt.method, apiPrefix+rt.path, rt.name, rt.handler)
+		add(rt.method, rt.path, rt.name, rt.handler)
+	}
+	add("GET", "/balance/{account}", "balance", api(balanceHandler))
+
+	// the mux answers unknown paths and methods in plain text, these
+	// keep every error in the JSON envelope. they are registered per
+	// method, a pattern without one would clash with a wildcard route
+	// for a single method next to it (/transactions/stream and GET
+	// /transactions/{id})
+	for path, methods := range allowed {
+		slices.Sort(methods)
+		allow := strings.Join(methods, ", ")
+		for _, m := range httpMethods {
+			if slices.Contains(methods, m) || m == "HEAD" && slices.Contains(methods, "GET") {
+				continue
+			}
+			mux.HandleFunc(m+" "+path, func(w http.ResponseWriter, r *http.Request) {
+				methodNotAllowed(w, allow)
+			})
+		}
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { notFound(w) })
+
+	mux.Handle("GET /metrics", metricsHandler())
+	mux.HandleFunc("GET /openapi.json", openAPIHandler)
+	mux.HandleFunc("GET /docs", docsHandler)
+	return mux
+}
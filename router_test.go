@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rkarmaka98/Transaction_APP/transaction-api/client"
+)
+
+// sends r through the whole router the way the server does
+func serveAPI(w http.ResponseWriter, r *http.Request) {
+	newRouter().ServeHTTP(w, r)
+}
+
+func TestRouterVersions(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+
+	for _, path := range []string{"/v1/accounts/alice/balance", "/balance/alice"} {
+		w := httptest.NewRecorder()
+		serveAPI(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"balance":100.00`) {
+			t.Errorf("%s: expected alice's balance, got %d %s", path, w.Code, w.Body)
+		}
+	}
+	for _, path := range []string{"/v1/transfer", "/transfer"} {
+		w := httptest.NewRecorder()
+		serveAPI(w, httptest.NewRequest("POST", path, strings.NewReader(`{"from":"alice","to":"bob","amount":1}`)))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d %s", path, w.Code, w.Body)
+		}
+	}
+}
+
+func TestRouterErrors(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100)})
+
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/transfer", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST" || decodeError(t, w).Code != codeMethodNotAllowed {
+		t.Errorf("expected a JSON 405 allowing POST, got %d %q %s", w.Code, w.Header().Get("Allow"), w.Body)
+	}
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("PATCH", "/v1/accounts", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, POST" {
+		t.Errorf("expected 405 allowing GET, POST, got %d %q", w.Code, w.Header().Get("Allow"))
+	}
+	for _, path := range []string{"/v1/nothing", "/v2/accounts/alice/balance", "/v1/accounts/alice/nothing"} {
+		w = httptest.NewRecorder()
+		serveAPI(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound || decodeError(t, w).Code != codeNotFound {
+			t.Errorf("%s: expected a JSON 404, got %d %s", path, w.Code, w.Body)
+		}
+	}
+}
+
+// the client package has its own copy of the wire types, this keeps
+// both sides agreeing on them
+func TestClientAgainstRouter(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	srv := httptest.NewServer(newRouter())
+	defer srv.Close()
+	c := client.New(srv.URL, client.WithRetries(0, 0))
+	ctx := context.Background()
+
+	id, err := c.Transfer(ctx, client.TransferRequest{From: "alice", To: "bob", Amount: 1050})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := c.GetBalance(ctx, "bob"); err != nil || b.Balance != 1050 || b.Status != "active" {
+		t.Errorf("expected bob to have 10.50, got %+v %v", b, err)
+	}
+	if a, err := c.GetAccount(ctx, "alice"); err != nil || a.Balance != 8950 || a.Type != accountChecking {
+		t.Errorf("expected alice to have 89.50, got %+v %v", a, err)
+	}
+	if tx, err := c.GetTransaction(ctx, id); err != nil || tx.From != "alice" || tx.Amount != 1050 || tx.Status != statusCompleted {
+		t.Errorf("unexpected transaction %+v %v", tx, err)
+	}
+	if page, err := c.ListTransactions(ctx, client.ListOptions{Account: "bob"}); err != nil || page.Total != 1 {
+		t.Errorf("expected one transaction, got %+v %v", page, err)
+	}
+	if _, err := c.Reverse(ctx, id); err != nil {
+		t.Errorf("expected the reversal to pass, got %v", err)
+	}
+	if _, err := c.Withdraw(ctx, "bob", 1); !errors.Is(err, client.ErrInsufficientFunds) {
+		t.Errorf("expected insufficient funds, got %v", err)
+	}
+	if _, err := c.GetTransaction(ctx, 999); !errors.Is(err, client.ErrTransactionNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// states a scheduled transfer moves through. running is set, and saved,
+// before a run starts so a crash mid-run never runs it twice
+const (
+	scheduleActive    = "scheduled"
+	scheduleRunning   = "running"
+	scheduleCompleted = "completed"
+	scheduleFailed    = "failed"
+	scheduleCancelled = "cancelled"
+)
+
+// a transfer made later, once at RunAt or on every time Cron matches
+type scheduledTransfer struct {
+	ID              string     `json:"id"`
+	From            string     `json:"from"`
+	To              string     `json:"to"`
+	Amount          Money      `json:"amount"`
+	Currency        string     `json:"currency,omitempty"`
+	RunAt           *time.Time `json:"run_at,omitempty"`
+	Cron            string     `json:"cron,omitempty"`
+	Status          string     `json:"status"`
+	NextRun         *time.Time `json:"next_run,omitempty"`
+	Runs            int        `json:"runs"`
+	LastRun         *time.Time `json:"last_run,omitempty"`
+	LastTransaction int64      `json:"last_transaction,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	Tenant          string     `json:"tenant,omitempty"`
+}
+
+// schedules by id. schedulesMu also covers saving them, so the file
+// always holds a state that existed in memory
+var (
+	schedulesMu  sync.Mutex
+	schedules    = map[string]*scheduledTransfer{}
+	scheduleSeq  int
+	schedulePath string
+)
+
+// models the JSON body for POST /scheduled-transfers, exactly one of
+// run_at and cron must be set
+type scheduleRequest struct {
+	From     string     `json:"from"`
+	To       string     `json:"to"`
+	Amount   Money      `json:"amount"`
+	Currency string     `json:"currency"`
+	RunAt    *time.Time `json:"run_at"`
+	Cron     string     `json:"cron"`
+}
+
+// handles GET /scheduled-transfers listing what the caller may see
+func listSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	schedulesMu.Lock()
+	list := []scheduledTransfer{}
+	for _, s := range schedules {
+		if s.Tenant == tenantOf(r.Context()) && mayRead(r.Context(), s.From) {
+			list = append(list, *s)
+		}
+	}
+	schedulesMu.Unlock()
+	slices.SortFunc(list, func(a, b scheduledTransfer) int { return scheduleNum(a.ID) - scheduleNum(b.ID) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"scheduled_transfers": list})
+}
+
+// handles POST /scheduled-transfers
+func createScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	var req scheduleRequest
+	if !decodeBody(w, r, "ScheduleRequest", &req) {
+		return
+	}
+	if err := validateTransfer(req.From, req.To, req.Amount); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if (req.RunAt == nil) == (req.Cron == "") {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "exactly one of run_at and cron is required")
+		return
+	}
+	s := &scheduledTransfer{
+		From: req.From, To: req.To, Amount: req.Amount, Currency: req.Currency,
+		Cron: req.Cron, Status: scheduleActive, CreatedAt: now(), Tenant: tenantOf(r.Context()),
+	}
+	if req.RunAt != nil {
+		if !req.RunAt.After(now()) {
+			writeError(w, http.StatusBadRequest, codeInvalidTimestamp, "run_at must be in the future")
+			return
+		}
+		at := req.RunAt.UTC()
+		s.RunAt, s.NextRun = &at, &at
+	} else {
+		spec, err := parseCron(req.Cron)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		next, ok := spec.next(now())
+		if !ok {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "cron never matches")
+			return
+		}
+		s.NextRun = &next
+	}
+	if !mayDebit(r.Context(), req.From) {
+		forbidden(w)
+		return
+	}
+	if _, err := storeFor(r.Context()).Get(req.From); errors.Is(err, ErrAccountNotFound) {
+		writeError(w, http.StatusNotFound, codeAccountNotFound, "account not found")
+		return
+	}
+	if err := checkRecipient(r.Context(), req.To); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	schedulesMu.Lock()
+	scheduleSeq++
+	s.ID = fmt.Sprintf("sched_%d", scheduleSeq)
+	schedules[s.ID] = s
+	if err := saveSchedules(); err != nil {
+		delete(schedules, s.ID)
+		schedulesMu.Unlock()
+		log.Printf("save schedules: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not save the schedule")
+		return
+	}
+	snapshot := *s
+	schedulesMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// handles GET /scheduled-transfers/{id}
+func scheduleHandler(w http.ResponseWriter, r *http.Request) {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	s, ok := schedules[r.PathValue("id")]
+	if !ok || s.Tenant != tenantOf(r.Context()) {
+		writeError(w, http.StatusNotFound, codeScheduleNotFound, "scheduled transfer not found")
+		return
+	}
+	if !mayRead(r.Context(), s.From) {
+		forbidden(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// handles DELETE /scheduled-transfers/{id} cancelling it. a run already
+// under way finishes, cancelling stops the ones after it
+func cancelScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	s, ok := schedules[r.PathValue("id")]
+	if !ok || s.Tenant != tenantOf(r.Context()) {
+		writeError(w, http.StatusNotFound, codeScheduleNotFound, "scheduled transfer not found")
+		return
+	}
+	if !mayDebit(r.Context(), s.From) && !isAdmin(r.Context()) {
+		forbidden(w)
+		return
+	}
+	if s.Status != scheduleActive && s.Status != scheduleRunning {
+		writeError(w, http.StatusConflict, codeScheduleNotActive, "scheduled transfer is "+s.Status)
+		return
+	}
+	prev, next := s.Status, s.NextRun
+	s.Status, s.NextRun = scheduleCancelled, nil
+	if err := saveSchedules(); err != nil {
+		s.Status, s.NextRun = prev, next
+		log.Printf("save schedules: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not save the schedule")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+func scheduleNum(id string) int {
+	n, _ := strconv.Atoi(strings.TrimPrefix(id, "sched_"))
+	return n
+}
+
+// starts every schedule that is due. each is claimed and saved before
+// its transfer is made, if saving fails it doesn't run at all: a missed
+// run can be made up for, a doubled one can't
+func runDueSchedules() {
+	schedulesMu.Lock()
+	var due []*scheduledTransfer
+	t := now()
+	for _, s := range schedules {
+		if s.Status == scheduleActive && s.NextRun != nil && !s.NextRun.After(t) {
+			s.Status = scheduleRunning
+			due = append(due, s)
+		}
+	}
+	if len(due) == 0 {
+		schedulesMu.Unlock()
+		return
+	}
+	if err := saveSchedules(); err != nil {
+		for _, s := range due {
+			s.Status = scheduleActive
+		}
+		schedulesMu.Unlock()
+		log.Printf("save schedules, nothing run: %v", err)
+		return
+	}
+	jobs := make([]scheduledTransfer, len(due))
+	for i, s := range due {
+		jobs[i] = *s
+	}
+	schedulesMu.Unlock()
+
+	for _, job := range jobs {
+		// each run starts a trace of its own, nobody called in for it
+		ctx, span := tracer.Start(scheduleContext(job.Tenant, job.From), "scheduled transfer",
+			trace.WithAttributes(attribute.String("schedule.id", job.ID)))
+		var e ledgerEntry
+		err := auditWork(ctx, "scheduled_transfer", apiPrefix+"/scheduled-transfers/"+job.ID, func(ctx context.Context) error {
+			var err error
+			e, err = transferFunds(ctx, transferRequest{
+				From: job.From, To: job.To, Amount: job.Amount, Currency: job.Currency,
+			})
+			return err
+		})
+		span.End()
+		finishRun(job.ID, e, err)
+	}
+}
+
+// the caller a schedule runs as, its creator was allowed to debit From
+// of tenant when it was made
+func scheduleContext(tenant, from string) context.Context {
+	p := &principal{name: "scheduler", accounts: map[string]bool{from: true}, tenant: tenant}
+	return withTenant(context.WithValue(context.Background(), principalKey{}, p), tenant)
+}
+
+// records how a run went and works out when the next one is due
+func finishRun(id string, e ledgerEntry, err error) {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	s := schedules[id]
+	t := now()
+	s.Runs++
+	s.LastRun = &t
+	s.LastTransaction = e.ID
+	s.LastError = ""
+	if err != nil {
+		s.LastError = err.Error()
+	}
+	advance(s, t)
+	if err := saveSchedules(); err != nil {
+		// the run itself is done, the next save records it
+		log.Printf("save schedules: %v", err)
+	}
+}
+
+// moves s on after a run that ended at t or never finished. a recurring
+// schedule that was down for a while runs once, not once per missed time
+func advance(s *scheduledTransfer, t time.Time) {
+	if s.Status == scheduleCancelled {
+		return
+	}
+	if s.Cron == "" {
+		s.Status, s.NextRun = scheduleCompleted, nil
+		if s.LastError != "" {
+			s.Status = scheduleFailed
+		}
+		return
+	}
+	s.Status = scheduleActive
+	spec, err := parseCron(s.Cron)
+	if err != nil {
+		s.Status, s.NextRun = scheduleFailed, nil
+		return
+	}
+	next, ok := spec.next(t)
+	if !ok {
+		s.Status, s.NextRun = scheduleCompleted, nil
+		return
+	}
+	s.NextRun = &next
+}
+
+// writes every schedule to schedulePath, through a temporary file so a
+// crash leaves either the old or the new version. schedulesMu must be held
+func saveSchedules() error {
+	if schedulePath == "" {
+		return nil
+	}
+	list := make([]*scheduledTransfer, 0, len(schedules))
+	for _, s := range schedules {
+		list = append(list, s)
+	}
+	slices.SortFunc(list, func(a, b *scheduledTransfer) int { return scheduleNum(a.ID) - scheduleNum(b.ID) })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(schedulePath), ".schedules-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), schedulePath)
+}
+
+// reads the schedules saved at path and saves there from now on. a run
+// that was under way when the process died is not retried, whether it
+// happened is for the ledger to tell
+func loadSchedules(path string) error {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	schedulePath = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []*scheduledTransfer
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, s := range list {
+		if s.Status == scheduleRunning {
+			s.LastError = "interrupted by a restart, check the ledger before retrying"
+			advance(s, now())
+		}
+		schedules[s.ID] = s
+		scheduleSeq = max(scheduleSeq, scheduleNum(s.ID))
+	}
+	return saveSchedules()
+}
+
+// runs runDueSchedules every interval until ctx is cancelled
+func runScheduler(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			runDueSchedules()
+		}
+	}
+}
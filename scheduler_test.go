@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clears every schedule, path "" keeps them in memory only
+func resetSchedules(t *testing.T, path string) {
+	t.Helper()
+	schedules = map[string]*scheduledTransfer{}
+	scheduleSeq = 0
+	schedulePath = ""
+	if path != "" {
+		if err := loadSchedules(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func schedule(t *testing.T, body string) scheduledTransfer {
+	t.Helper()
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/scheduled-transfers", strings.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("schedule failed: %d %s", w.Code, w.Body)
+	}
+	var s scheduledTransfer
+	json.NewDecoder(w.Body).Decode(&s)
+	return s
+}
+
+func getSchedule(t *testing.T, id string) scheduledTransfer {
+	t.Helper()
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/scheduled-transfers/"+id, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("get %s: %d %s", id, w.Code, w.Body)
+	}
+	var s scheduledTransfer
+	json.NewDecoder(w.Body).Decode(&s)
+	return s
+}
+
+func TestScheduledTransferOnce(t *testing.T) {
+	clock := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	resetSchedules(t, "")
+
+	s := schedule(t, `{"from":"alice","to":"bob","amount":25,"run_at":"2024-06-01T10:00:00Z"}`)
+	if s.Status != scheduleActive || !s.NextRun.Equal(clock.Add(time.Hour)) {
+		t.Fatalf("unexpected schedule %+v", s)
+	}
+
+	runDueSchedules()
+	if b := balance(t, "bob"); b != 0 {
+		t.Fatalf("expected nothing to run early, bob has %v", b)
+	}
+	clock = clock.Add(time.Hour)
+	runDueSchedules()
+	runDueSchedules()
+	if b := balance(t, "bob"); b != units(25) {
+		t.Errorf("expected exactly one run, bob has %v", b)
+	}
+	s = getSchedule(t, s.ID)
+	if s.Status != scheduleCompleted || s.Runs != 1 || s.LastTransaction == 0 || s.NextRun != nil {
+		t.Errorf("unexpected schedule after its run %+v", s)
+	}
+}
+
+func TestScheduledTransferRecurring(t *testing.T) {
+	clock := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	store = newMemoryStore(map[string]Money{"alice": units(30), "bob": 0})
+	resetLedger()
+	resetSchedules(t, "")
+
+	s := schedule(t, `{"from":"alice","to":"bob","amount":10,"cron":"0 * * * *"}`)
+	for range 4 {
+		clock = clock.Add(time.Hour)
+		runDueSchedules()
+	}
+	if b := balance(t, "bob"); b != units(30) {
+		t.Errorf("expected three runs to go through, bob has %v", b)
+	}
+	s = getSchedule(t, s.ID)
+	if s.Status != scheduleActive || s.Runs != 4 || !strings.Contains(s.LastError, "insufficient") {
+		t.Errorf("expected the fourth run to fail and the schedule to go on, got %+v", s)
+	}
+	if !s.NextRun.Equal(clock.Add(time.Hour)) {
+		t.Errorf("expected the next run in an hour, got %v", s.NextRun)
+	}
+
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("DELETE", "/v1/scheduled-transfers/"+s.ID, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body)
+	}
+	store.Credit("alice", units(10))
+	clock = clock.Add(time.Hour)
+	runDueSchedules()
+	if b := balance(t, "bob"); b != units(30) {
+		t.Errorf("expected a cancelled schedule not to run, bob has %v", b)
+	}
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("DELETE", "/v1/scheduled-transfers/"+s.ID, nil))
+	if w.Code != http.StatusConflict || decodeError(t, w).Code != codeScheduleNotActive {
+		t.Errorf("expected cancelling twice to be refused, got %d", w.Code)
+	}
+}
+
+func TestScheduleValidation(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100)})
+	resetSchedules(t, "")
+
+	for _, body := range []string{
+		`{"from":"alice","to":"bob","amount":10}`,
+		`{"from":"alice","to":"bob","amount":10,"cron":"@daily","run_at":"2999-01-01T00:00:00Z"}`,
+		`{"from":"alice","to":"bob","amount":10,"run_at":"2000-01-01T00:00:00Z"}`,
+		`{"from":"alice","to":"bob","amount":10,"cron":"61 * * * *"}`,
+		`{"from":"alice","to":"bob","amount":0,"cron":"@daily"}`,
+	} {
+		w := httptest.NewRecorder()
+		serveAPI(w, httptest.NewRequest("POST", "/v1/scheduled-transfers", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/scheduled-transfers", strings.NewReader(`{"from":"nobody","to":"bob","amount":1,"cron":"@daily"}`)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown sender to be 404, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/scheduled-transfers/sched_9", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+}
+
+func TestSchedulesSurviveRestart(t *testing.T) {
+	clock := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	path := filepath.Join(t.TempDir(), "schedules.json")
+	resetSchedules(t, path)
+
+	daily := schedule(t, `{"from":"alice","to":"bob","amount":1,"cron":"@daily"}`)
+	once := schedule(t, `{"from":"alice","to":"bob","amount":5,"run_at":"2024-06-01T09:30:00Z"}`)
+
+	// a crash after the one-off was claimed but before it finished
+	schedulesMu.Lock()
+	schedules[once.ID].Status = scheduleRunning
+	saveSchedules()
+	schedulesMu.Unlock()
+
+	resetSchedules(t, path)
+	if s := getSchedule(t, daily.ID); s.Status != scheduleActive || s.Cron != "@daily" {
+		t.Errorf("expected the daily schedule back, got %+v", s)
+	}
+	s := getSchedule(t, once.ID)
+	if s.Status != scheduleFailed || s.LastError == "" {
+		t.Errorf("expected the interrupted run to be failed, got %+v", s)
+	}
+	clock = clock.Add(time.Hour)
+	runDueSchedules()
+	if b := balance(t, "bob"); b != 0 {
+		t.Errorf("expected the interrupted run not to be retried, bob has %v", b)
+	}
+	if next := schedule(t, `{"from":"alice","to":"bob","amount":1,"cron":"@daily"}`); next.ID != "sched_3" {
+		t.Errorf("expected ids to carry on after a restart, got %s", next.ID)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// how long a client gets to send a request or read a response, generous
+// for a JSON API but enough to stop slow clients holding connections
+const (
+	readTimeout  = 10 * time.Second
+	writeTimeout = 30 * time.Second
+	idleTimeout  = 2 * time.Minute
+)
+
+// builds the server main listens with, timeouts included so a stalled
+// client can't pin a connection forever. open transaction streams end
+// when it shuts down
+func newServer(addr string, h http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: readTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	srv.RegisterOnShutdown(endStreams)
+	return srv
+}
+
+// serves on ln, over TLS when srv has a TLSConfig, until ctx is
+// cancelled, then stops accepting connections and waits up to grace for
+// in-flight requests, transfers included, to finish
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, grace time.Duration) error {
+	errc := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			errc <- srv.ServeTLS(ln, "", "")
+			return
+		}
+		errc <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	log.Println("shutting down, draining in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if serveErr := <-errc; !errors.Is(serveErr, http.ErrServerClosed) && err == nil {
+		err = serveErr
+	}
+	return err
+}
+
+// serves gRPC on ln until ctx is cancelled, then lets running calls
+// finish, giving up on them after grace
+func serveGRPC(ctx context.Context, srv *grpc.Server, ln net.Listener, grace time.Duration) error {
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(grace):
+		srv.Stop()
+	}
+	return <-errc
+}
+
+// starts the background workers until ctx is cancelled: hold expiry and
+// the supply check every sweep, the scheduler, the outbox of async
+// transfers and interest posting every tick, and the renewal of the
+// store lease. the returned func waits for them to return, a run under
+// way finishes first so the store isn't closed beneath it
+func startWorkers(ctx context.Context, sweep, tick time.Duration) (wait func()) {
+	var wg sync.WaitGroup
+	wg.Add(6)
+	go func() {
+		defer wg.Done()
+		runHoldExpiry(ctx, sweep)
+	}()
+	go func() {
+		defer wg.Done()
+		runSupplyCheck(ctx, sweep)
+	}()
+	go func() {
+		defer wg.Done()
+		runScheduler(ctx, tick)
+	}()
+	go func() {
+		defer wg.Done()
+		runOutbox(ctx, tick)
+	}()
+	go func() {
+		defer wg.Done()
+		runInterest(ctx, tick)
+	}()
+	go func() {
+		defer wg.Done()
+		runStoreLease(ctx, storeLeaseTTL/3)
+	}()
+	return wg.Wait
+}
+
+// closes the store once nothing can reach it anymore, so file backends
+// flush cleanly
+func closeStore() error {
+	if c, ok := store.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// a request running when shutdown starts must still get its response
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	store = newMemoryStore(map[string]Money{})
+	started, release := make(chan struct{}), make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, newServer(ln.Addr().String(), h), ln, 5*time.Second) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	got := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			got <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		got <- result{string(b), err}
+	}()
+
+	<-started
+	cancel()
+	// shutdown must wait for the handler, so serve can't have returned yet
+	select {
+	case err := <-served:
+		t.Fatalf("serve returned before the request finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	if r := <-got; r.err != nil || r.body != "done" {
+		t.Fatalf("expected in-flight request to complete, got %q %v", r.body, r.err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("expected clean shutdown, got %v", err)
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Error("expected listener to be closed after shutdown")
+	}
+}
+
+func TestNewServerHasTimeouts(t *testing.T) {
+	srv := newServer(":0", http.NotFoundHandler())
+	if srv.ReadTimeout == 0 || srv.WriteTimeout == 0 || srv.ReadHeaderTimeout == 0 || srv.IdleTimeout == 0 {
+		t.Errorf("expected all timeouts set, got %+v", srv)
+	}
+}
+
+// a store whose transfers wait until release is closed
+type blockingStore struct {
+	Store
+	entered, release chan struct{}
+}
+
+func (s blockingStore) TransferIf(from, to string, amount Money, version int64) error {
+	close(s.entered)
+	<-s.release
+	return s.Store.TransferIf(from, to, amount, version)
+}
+
+func TestWorkersFinishBeforeReturning(t *testing.T) {
+	clock := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	resetSchedules(t, "")
+	schedule(t, `{"from":"alice","to":"bob","amount":25,"run_at":"2024-06-01T10:00:00Z"}`)
+
+	bs := blockingStore{Store: store, entered: make(chan struct{}), release: make(chan struct{})}
+	store = bs
+	defer func() { store = bs.Store }()
+	clock = clock.Add(time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := startWorkers(ctx, time.Hour, time.Millisecond)
+	<-bs.entered
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("workers returned while a scheduled transfer was still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(bs.release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("workers didn't return once the run finished")
+	}
+	if b := balance(t, "bob"); b != units(25) {
+		t.Errorf("expected the run to complete, bob has %v", b)
+	}
+}
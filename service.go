@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// the operations both the HTTP and the gRPC API offer. they take the
+// caller from ctx, check it may do what it asks and fail with a
+// *serviceError, each transport turns that into its own status
+
+// serviceError is a failure the caller can act on, code is one of the
+// codes in errors.go
+type serviceError struct {
+	code    string
+	message string
+	details any
+}
+
+func (e *serviceError) Error() string { return e.message }
+
+func failure(code, message string) *serviceError {
+	return &serviceError{code: code, message: message}
+}
+
+var errForbidden = failure(codeForbidden, "forbidden")
+
+// the transfersFailed reason for a refusal from checkRecipient or
+// checkCredit. codes without a reason of their own count as invalid
+// requests, not as frozen accounts
+func statusReason(err error) string {
+	se, _ := err.(*serviceError)
+	switch {
+	case se != nil && se.code == codeAccountFrozen:
+		return reasonAccountFrozen
+	case se != nil && se.code == codeAccountClosed:
+		return reasonAccountClosed
+	case se != nil && se.code == codeInternal:
+		return reasonInternal
+	}
+	return reasonInvalidRequest
+}
+
+// returns account's current balance
+func getBalance(ctx context.Context, account string) (Account, error) {
+	if !mayRead(ctx, account) {
+		return Account{}, errForbidden
+	}
+	acct, err := storeFor(ctx).Get(account)
+	if errors.Is(err, ErrAccountNotFound) {
+		return Account{}, failure(codeAccountNotFound, "account not found")
+	}
+	if err != nil {
+		return Account{}, failure(codeInternal, "could not read balance")
+	}
+	return acct, nil
+}
+
+// moves req.Amount from req.From to req.To and records the attempt in the
+// ledger whether it succeeds or not. a transfer the risk checks park is
+// returned pending_review without an error
+func transferFunds(ctx context.Context, req transferRequest) (ledgerEntry, error) {
+	transfersAttempted.Inc()
+	currency, err := checkTransfer(ctx, req)
+	if err != nil {
+		return ledgerEntry{}, err
+	}
+	if f := assessRisk(ctx, req); f != nil {
+		return flagTransfer(ctx, req, currency, f)
+	}
+	return runTransfer(ctx, req, currency, 0)
+}
+
+// the checks a transfer must pass before it is attempted or, for an
+// async one, accepted. returns the currency the ledger records it in
+func checkTransfer(ctx context.Context, req transferRequest) (string, error) {
+	// HTTP validated the shape against the schema already, gRPC didn't
+	if err := validateTransfer(req.From, req.To, req.Amount); err != nil {
+		transfersFailed.WithLabelValues(reasonInvalidRequest).Inc()
+		return "", err
+	}
+	if !mayDebit(ctx, req.From) {
+		transfersFailed.WithLabelValues(reasonForbidden).Inc()
+		return "", errForbidden
+	}
+
+	// the sender's currency is what the ledger records the transfer in
+	currency := req.Currency
+	if src, err := storeFor(ctx).Get(req.From); err == nil {
+		if req.Currency != "" && req.Currency != src.Currency {
+			transfersFailed.WithLabelValues(reasonCurrencyMismatch).Inc()
+			return "", failure(codeCurrencyMismatch, "currency does not match the sending account")
+		}
+		currency = src.Currency
+	}
+	if err := checkRecipient(ctx, req.To); err != nil {
+		transfersFailed.WithLabelValues(statusReason(err)).Inc()
+		return "", err
+	}
+	return currency, nil
+}
+
+// makes a checked transfer, booking it completed with its money moving.
+// id is the entry to complete, the one an async or reviewed transfer was
+// accepted with, 0 records a new one. a new transfer failing is recorded
+// failed, the caller of one with an id fails it as it sees fit
+func runTransfer(ctx context.Context, req transferRequest, currency string, id int64) (ledgerEntry, error) {
+	undo, err := reserveLimit(ctx, req.From, req.Amount)
+	if err != nil {
+		transfersFailed.WithLabelValues(reasonLimitExceeded).Inc()
+		return ledgerEntry{}, err
+	}
+	entry := ledgerEntry{From: req.From, To: req.To, Amount: req.Amount, Currency: currency, Status: statusCompleted}
+	if id != 0 {
+		entry = settled(id, statusCompleted, "")
+	}
+
+	booked, err := book(ctx, func(s Store) error {
+		return s.TransferIf(req.From, req.To, req.Amount, req.Version)
+	}, entry)
+	if err != nil {
+		undo()
+		if id == 0 {
+			// failed attempts are part of the audit trail too
+			entry.Status = statusFailed
+			record(ctx, entry)
+		}
+	}
+	if errors.Is(err, ErrInsufficientFunds) {
+		transfersFailed.WithLabelValues(reasonInsufficientFund).Inc()
+		return ledgerEntry{}, &serviceError{
+			code:    codeInsufficientFunds,
+			message: "insufficient funds",
+			details: map[string]any{"account": req.From, "amount": req.Amount},
+		}
+	}
+	if errors.Is(err, ErrAccountFrozen) {
+		transfersFailed.WithLabelValues(reasonAccountFrozen).Inc()
+		return ledgerEntry{}, frozen(req.From)
+	}
+	if errors.Is(err, ErrAccountClosed) {
+		// checkTransfer vetted the recipient, so it's the sender
+		transfersFailed.WithLabelValues(reasonAccountClosed).Inc()
+		return ledgerEntry{}, closed(req.From)
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		transfersFailed.WithLabelValues(reasonVersionMismatch).Inc()
+		return ledgerEntry{}, versionMismatch(ctx, req.From)
+	}
+	if errors.Is(err, ErrCurrencyMismatch) {
+		transfersFailed.WithLabelValues(reasonCurrencyMismatch).Inc()
+		return ledgerEntry{}, failure(codeCurrencyMismatch, "accounts hold different currencies, use /convert")
+	}
+	if err != nil {
+		transfersFailed.WithLabelValues(reasonInternal).Inc()
+		return ledgerEntry{}, failure(codeInternal, "transfer failed")
+	}
+	transfersSucceeded.Inc()
+	return booked[0], nil
+}
+
+// returns the ledger entries touching account, or every entry when
+// account is empty which only admins may see
+func listTransactions(ctx context.Context, account string) ([]ledgerEntry, error) {
+	if account == "" && !isAdmin(ctx) || account != "" && !mayRead(ctx, account) {
+		return nil, errForbidden
+	}
+	return entries(ctx, account), nil
+}
+
+// cuts one page out of list, limit must already be clamped
+func paginate(list []ledgerEntry, limit, offset int) transactionPage {
+	page := transactionPage{Transactions: []ledgerEntry{}, Total: len(list)}
+	if offset < len(list) {
+		end := min(offset+limit, len(list))
+		page.Transactions = list[offset:end]
+		if end < len(list) {
+			page.NextOffset = &end
+		}
+	}
+	return page
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// a snapshot is everything worth keeping in one JSON document: every
+// account as the store has it, the ledger and the opening it started
+// from. POST /admin/snapshot takes one and POST /admin/restore puts one
+// back in place of the current state, whatever the backend, so one taken
+// from a sqlite server can seed a memory one for testing. holds don't
+// survive a restore, what they reserved is freed like on a restart, and
+// schedules and webhooks have files of their own and are left alone.
+//
+// a snapshot goes to and comes from either a file in SNAPSHOT_DIR, named
+// without any directory, or an http(s) URL it is PUT to and fetched from
+// with GET, which is how S3 compatible stores take it with a presigned URL
+
+// bumped whenever snapshot changes in a way older servers can't read
+const snapshotFormat = 1
+
+type snapshot struct {
+	Format   int           `json:"format"`
+	TakenAt  time.Time     `json:"taken_at"`
+	Accounts []Account     `json:"accounts"`
+	Opening  ledgerOpening `json:"opening"`
+	Ledger   []ledgerEntry `json:"ledger"`
+}
+
+// restorer is implemented by every store backend, Restore replaces all
+// accounts by those of s and, for a ledgerStore, the ledger kept by s'
+// one, all at once or not at all
+type restorer interface {
+	Restore(s snapshot) error
+}
+
+// directory snapshots named by a file name are kept in, empty refuses them
+var snapshotDir string
+
+var snapshotClient = &http.Client{Timeout: time.Minute}
+
+// how often takeSnapshot looks again when a transfer landed while it was
+// copying, it gives up after
+const snapshotTries = 5
+
+// copies the accounts and then the ledger. a booking reaches the ledger
+// in memory a moment after the store, so a transfer in flight can make
+// the two disagree, the copy is taken again until they match
+func takeSnapshot() (snapshot, error) {
+	for try := 1; ; try++ {
+		accts, err := store.All()
+		if err != nil {
+			return snapshot{}, err
+		}
+		ledgerMu.Lock()
+		s := snapshot{Format: snapshotFormat, TakenAt: now().UTC(), Accounts: accts, Opening: openedFrom, Ledger: slices.Clone(ledger)}
+		ledgerMu.Unlock()
+		err = s.check()
+		if err == nil {
+			return s, nil
+		}
+		if try == snapshotTries {
+			return snapshot{}, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// reports whether s can be restored: its ledger is in id order and every
+// account holds what the opening and the ledger's postings add up to
+func (s snapshot) check() error {
+	if s.Format != snapshotFormat {
+		return fmt.Errorf("unknown snapshot format %d", s.Format)
+	}
+	if s.Opening.At.IsZero() {
+		return errors.New("snapshot has no ledger opening")
+	}
+	posted := map[string]Money{}
+	for _, a := range s.Opening.Accounts {
+		posted[a.ID] += a.Balance
+	}
+	for i, e := range s.Ledger {
+		if e.ID != int64(i+1) {
+			return fmt.Errorf("ledger entry %d is out of order", e.ID)
+		}
+		for _, p := range e.Postings {
+			posted[p.Account] += p.Credit - p.Debit
+		}
+	}
+	for _, a := range s.Accounts {
+		if posted[a.ID] != a.Balance {
+			return fmt.Errorf("account %s holds %s but its ledger adds up to %s", a.ID, a.Balance, posted[a.ID])
+		}
+	}
+	return nil
+}
+
+// replaces the accounts and the ledger by s'. it is meant for a quiet
+// server: requests racing with it land either before and are lost, or
+// after on top of s. transfers that were processing when s was taken
+// can't be finished, they are failed like after a restart
+func restoreSnapshot(s snapshot) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	r, ok := store.(restorer)
+	if !ok {
+		return errors.New("the store can't be restored")
+	}
+	s.Accounts = slices.Clone(s.Accounts)
+	for i := range s.Accounts {
+		s.Accounts[i].Held = 0
+	}
+	s.Ledger = slices.Clone(s.Ledger)
+	for i := range s.Ledger {
+		if e := &s.Ledger[i]; e.Status == statusProcessing {
+			e.Status, e.Error = statusFailed, "interrupted by a snapshot before its money moved"
+		}
+	}
+
+	holdsMu.Lock()
+	defer holdsMu.Unlock()
+	bookMu.Lock()
+	defer bookMu.Unlock()
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+	if err := r.Restore(s); err != nil {
+		return err
+	}
+	startLedger(s.Opening)
+	ledger = s.Ledger
+	clear(holds)
+	return nil
+}
+
+func isURL(target string) bool {
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
+
+// the file a snapshot named name is kept in, refusing anything that
+// would end up outside snapshotDir
+func snapshotPath(name string) (string, error) {
+	if snapshotDir == "" {
+		return "", errors.New("SNAPSHOT_DIR is not set, only URLs can be used")
+	}
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("%q must be a file name or an http(s) URL", name)
+	}
+	return filepath.Join(snapshotDir, name), nil
+}
+
+// writes s to target, a file is replaced whole so a crash never leaves
+// half a snapshot behind
+func saveSnapshot(ctx context.Context, target string, s snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if isURL(target) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req, end := traceOutgoing(req, "put snapshot")
+		resp, err := snapshotClient.Do(req)
+		end(resp, err)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("object store answered %s", resp.Status)
+		}
+		return nil
+	}
+	path, err := snapshotPath(target)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(snapshotDir, ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// reads the snapshot at source, a file name or a URL like saveSnapshot's
+func loadSnapshot(ctx context.Context, source string) (snapshot, error) {
+	var s snapshot
+	var r io.Reader
+	if isURL(source) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return s, err
+		}
+		req, end := traceOutgoing(req, "get snapshot")
+		resp, err := snapshotClient.Do(req)
+		end(resp, err)
+		if err != nil {
+			return s, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return s, fmt.Errorf("object store answered %s", resp.Status)
+		}
+		r = resp.Body
+	} else {
+		path, err := snapshotPath(source)
+		if err != nil {
+			return s, err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return s, err
+		}
+		defer f.Close()
+		r = f
+	}
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return s, fmt.Errorf("decode snapshot: %w", err)
+	}
+	return s, nil
+}
+
+// what POST /admin/snapshot and /admin/restore answer with once a
+// snapshot was written or restored
+type snapshotSummary struct {
+	Target   string    `json:"target,omitempty"`
+	Source   string    `json:"source,omitempty"`
+	TakenAt  time.Time `json:"taken_at"`
+	Accounts int       `json:"accounts"`
+	Entries  int       `json:"entries"`
+}
+
+// serves POST /admin/snapshot, to admins of the whole deployment as it
+// holds every tenant. without a target the snapshot is the response
+// itself, otherwise it is written there
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if !isOperator(r.Context()) {
+		forbidden(w)
+		return
+	}
+	var req struct {
+		Target string `json:"target"`
+	}
+	if r.ContentLength != 0 && !decodeBody(w, r, "SnapshotRequest", &req) {
+		return
+	}
+	if req.Target != "" && !isURL(req.Target) {
+		if _, err := snapshotPath(req.Target); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+	}
+	s, err := takeSnapshot()
+	if err != nil {
+		log.Printf("take snapshot: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not take a consistent snapshot, try again")
+		return
+	}
+	if req.Target == "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+		return
+	}
+	if err := saveSnapshot(r.Context(), req.Target, s); err != nil {
+		writeError(w, http.StatusBadGateway, codeSnapshotStorage, "could not write snapshot: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(snapshotSummary{Target: req.Target, TakenAt: s.TakenAt, Accounts: len(s.Accounts), Entries: len(s.Ledger)})
+}
+
+// serves POST /admin/restore, from a source to read like a target of
+// POST /admin/snapshot or from the snapshot given in the body
+func restoreHandler(w http.ResponseWriter, r *http.Request) {
+	if !isOperator(r.Context()) {
+		forbidden(w)
+		return
+	}
+	var req struct {
+		Source   string    `json:"source"`
+		Snapshot *snapshot `json:"snapshot"`
+	}
+	if !decodeBody(w, r, "RestoreRequest", &req) {
+		return
+	}
+	if (req.Source == "") == (req.Snapshot == nil) {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "give either source or snapshot")
+		return
+	}
+	s := req.Snapshot
+	if s == nil {
+		loaded, err := loadSnapshot(r.Context(), req.Source)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, codeSnapshotStorage, "could not read snapshot: "+err.Error())
+			return
+		}
+		s = &loaded
+	}
+	if err := s.check(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidSnapshot, err.Error())
+		return
+	}
+	if err := restoreSnapshot(*s); err != nil {
+		log.Printf("restore snapshot: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not restore snapshot")
+		return
+	}
+	log.Printf("restored snapshot taken at %s, %d accounts and %d ledger entries", s.TakenAt.Format(time.RFC3339), len(s.Accounts), len(s.Ledger))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshotSummary{Source: req.Source, TakenAt: s.TakenAt, Accounts: len(s.Accounts), Entries: len(s.Ledger)})
+}
+
+// writes a snapshot to target once nothing moves money anymore
+func snapshotOnExit(ctx context.Context, target string) error {
+	s, err := takeSnapshot()
+	if err != nil {
+		return err
+	}
+	return saveSnapshot(ctx, target, s)
+}
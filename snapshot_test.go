@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func postAdmin(path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+	return w
+}
+
+// takes a snapshot into a file of the snapshot dir, then restores it
+// into every backend in turn
+func TestSnapshotRoundTrip(t *testing.T) {
+	defer func() { store = newMemoryStore(seedBalances()) }()
+	snapshotDir = t.TempDir()
+	defer func() { snapshotDir = "" }()
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	transferID(t, `{"from":"alice","to":"bob","amount":30}`)
+	placeTestHold(t, `{"from":"alice","to":"bob","amount":10}`)
+
+	w := postAdmin("/v1/admin/snapshot", `{"target":"backup.json"}`)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"entries":1`) {
+		t.Fatalf("expected the snapshot written, got %d %s", w.Code, w.Body)
+	}
+
+	for _, kind := range []string{"memory", "sqlite", "eventlog"} {
+		t.Run(kind, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), kind)
+			s, err := openStore(kind, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store = s
+			defer closeStore()
+			if err := loadLedger(); err != nil {
+				t.Fatal(err)
+			}
+			if w := postAdmin("/v1/admin/restore", `{"source":"backup.json"}`); w.Code != http.StatusOK {
+				t.Fatalf("expected the restore to go through, got %d %s", w.Code, w.Body)
+			}
+			if len(holds) != 0 {
+				t.Errorf("expected the holds dropped, got %d", len(holds))
+			}
+			check := func() {
+				t.Helper()
+				a, err := store.Get("alice")
+				if err != nil || a.Balance != units(70) || a.Held != 0 {
+					t.Errorf("expected alice back at 70 with nothing held, got %+v %v", a, err)
+				}
+				if list := entries(t.Context(), ""); len(list) != 1 || list[0].Amount != units(30) {
+					t.Errorf("expected the ledger back, got %+v", list)
+				}
+				if tb := buildTrialBalance(t.Context(), mustAll(t)); !tb.Balanced {
+					t.Errorf("expected the restored ledger to balance, got %+v", tb)
+				}
+			}
+			check()
+			if kind == "memory" {
+				return
+			}
+
+			// what a file backend opens with after a restart too
+			closeStore()
+			ledgerMu.Lock()
+			ledger, openedAt = nil, time.Time{}
+			ledgerMu.Unlock()
+			if store, err = openStore(kind, path); err != nil {
+				t.Fatal(err)
+			}
+			if err := loadLedger(); err != nil {
+				t.Fatal(err)
+			}
+			check()
+		})
+	}
+}
+
+func TestSnapshotInline(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	transferID(t, `{"from":"alice","to":"bob","amount":30}`)
+
+	w := postAdmin("/v1/admin/snapshot", ``)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the snapshot in the response, got %d %s", w.Code, w.Body)
+	}
+	snap := w.Body.String()
+	transferID(t, `{"from":"alice","to":"bob","amount":50}`)
+
+	if w := postAdmin("/v1/admin/restore", `{"snapshot":`+snap+`}`); w.Code != http.StatusOK {
+		t.Fatalf("expected the restore to go through, got %d %s", w.Code, w.Body)
+	}
+	if a := balance(t, "alice"); a != units(70) {
+		t.Errorf("expected alice back at 70, got %v", a)
+	}
+	if len(entries(t.Context(), "")) != 1 {
+		t.Errorf("expected the later transfer gone, got %+v", entries(t.Context(), ""))
+	}
+
+	// a snapshot whose balances don't add up is refused as a whole
+	tampered := strings.Replace(snap, `"balance":70.00`, `"balance":700.00`, 1)
+	w = postAdmin("/v1/admin/restore", `{"snapshot":`+tampered+`}`)
+	if w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeInvalidSnapshot {
+		t.Errorf("expected %s, got %d %s", codeInvalidSnapshot, w.Code, w.Body)
+	}
+	if a := balance(t, "alice"); a != units(70) {
+		t.Errorf("expected nothing restored, alice has %v", a)
+	}
+}
+
+func TestSnapshotURL(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	transferID(t, `{"from":"alice","to":"bob","amount":30}`)
+
+	// an object store taking PUTs and serving them back
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case "PUT":
+			objects[r.URL.Path], _ = io.ReadAll(r.Body)
+		case "GET":
+			b, ok := objects[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(b)
+		}
+	}))
+	defer srv.Close()
+
+	if w := postAdmin("/v1/admin/snapshot", `{"target":"`+srv.URL+`/bucket/snap.json"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected the snapshot uploaded, got %d %s", w.Code, w.Body)
+	}
+	var s snapshot
+	if err := json.Unmarshal(objects["/bucket/snap.json"], &s); err != nil || len(s.Ledger) != 1 {
+		t.Fatalf("expected the snapshot in the bucket, got %+v %v", s, err)
+	}
+	transferID(t, `{"from":"alice","to":"bob","amount":50}`)
+	if w := postAdmin("/v1/admin/restore", `{"source":"`+srv.URL+`/bucket/snap.json"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected the restore to go through, got %d %s", w.Code, w.Body)
+	}
+	if a := balance(t, "alice"); a != units(70) {
+		t.Errorf("expected alice back at 70, got %v", a)
+	}
+	w := postAdmin("/v1/admin/restore", `{"source":"`+srv.URL+`/bucket/missing.json"}`)
+	if w.Code != http.StatusBadGateway || decodeError(t, w).Code != codeSnapshotStorage {
+		t.Errorf("expected %s, got %d %s", codeSnapshotStorage, w.Code, w.Body)
+	}
+}
+
+func TestSnapshotRequests(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100)})
+	resetLedger()
+	for _, c := range []struct{ path, body string }{
+		// no SNAPSHOT_DIR
+		{"/v1/admin/snapshot", `{"target":"backup.json"}`},
+		{"/v1/admin/restore", `{}`},
+		{"/v1/admin/restore", `{"source":"a.json","snapshot":{"format":1,"opening":{},"accounts":[],"ledger":[]}}`},
+	} {
+		if w := postAdmin(c.path, c.body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: expected 400, got %d %s", c.path, c.body, w.Code, w.Body)
+		}
+	}
+	snapshotDir = t.TempDir()
+	defer func() { snapshotDir = "" }()
+	for _, target := range []string{"../backup.json", "dir/backup.json", ".."} {
+		if w := postAdmin("/v1/admin/snapshot", `{"target":"`+target+`"}`); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected the path to be refused, got %d %s", target, w.Code, w.Body)
+		}
+	}
+
+	keys, _ := parseAPIKeys("k1=alice:user:alice")
+	apiKeys = keys
+	defer func() { apiKeys = nil }()
+	for _, path := range []string{"/v1/admin/snapshot", "/v1/admin/restore"} {
+		req := httptest.NewRequest("POST", path, strings.NewReader(`{}`))
+		req.Header.Set("X-API-Key", "k1")
+		w := httptest.NewRecorder()
+		serveAPI(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected 403 for a user, got %d", path, w.Code)
+		}
+	}
+}
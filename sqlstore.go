@@ -0,0 +1,730 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqlSchema = `CREATE TABLE IF NOT EXISTS accounts (
+	id      TEXT PRIMARY KEY,
+	balance INTEGER NOT NULL -- minor units
+)`
+
+// the ledger kept next to the balances. entries are stored as JSON and
+// rewritten whole when an async or reviewed transfer finishes, reversed_by
+// also has a column of its own. ledger_opening has a single row with
+// where the ledger started. leases has who holds each lease, see lease.go
+var sqlLedgerSchema = []string{
+	`CREATE TABLE IF NOT EXISTS ledger (
+	id          INTEGER PRIMARY KEY,
+	entry       TEXT NOT NULL,
+	reversed_by INTEGER NOT NULL DEFAULT 0
+)`,
+	`CREATE TABLE IF NOT EXISTS ledger_opening (
+	id      INTEGER PRIMARY KEY CHECK (id = 1),
+	opening TEXT NOT NULL
+)`,
+	`CREATE TABLE IF NOT EXISTS leases (
+	name       TEXT PRIMARY KEY,
+	holder     TEXT NOT NULL,
+	expires_at INTEGER NOT NULL -- unix nanoseconds
+)`,
+}
+
+// columns added after the first release, applied to older databases on
+// open. each is only ever appended to
+var sqlMigrations = []struct{ column, def string }{
+	{"currency", "TEXT NOT NULL DEFAULT '" + defaultCurrency + "'"},
+	{"version", "INTEGER NOT NULL DEFAULT 1"},
+	{"status", "TEXT NOT NULL DEFAULT '" + accountActive + "'"},
+	{"overdraft", "INTEGER NOT NULL DEFAULT 0"},
+	{"held", "INTEGER NOT NULL DEFAULT 0"},
+	{"type", "TEXT NOT NULL DEFAULT '" + accountChecking + "'"},
+}
+
+// the columns scanAccount expects, in order
+const accountColumns = `id, balance, currency, version, status, overdraft, held, type`
+
+// sqlStore keeps balances, and the ledger next to them, in SQLite so
+// they survive restarts. ctx is the request the account calls of the
+// store storeFor hands out run for, a query or transaction still going
+// once it is done is cancelled and rolled back. tx is set on the view a
+// booking makes its change through, everything it does is part of the
+// transaction the entries are written in
+type sqlStore struct {
+	db  *sql.DB
+	ctx context.Context
+	tx  *sql.Tx
+}
+
+// s as seen by a request, sharing its database. only s itself is closed
+func (s *sqlStore) withContext(ctx context.Context) Store {
+	return &sqlStore{db: s.db, ctx: ctx, tx: s.tx}
+}
+
+func (s *sqlStore) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// the database running statements under the store's ctx, or the
+// transaction of the booking the store is a view for
+func (s *sqlStore) conn() execer {
+	if s.tx != nil {
+		return s.tx
+	}
+	return ctxDB{s.context(), s.db}
+}
+
+// a transaction, or the part of one a booking view runs in its own
+type txn interface {
+	execer
+	Commit() error
+	Rollback() error
+}
+
+func (s *sqlStore) begin() (txn, error) {
+	if s.tx != nil {
+		return joined{s.tx}, nil
+	}
+	return s.db.BeginTx(s.context(), nil)
+}
+
+// the booking's transaction as one of its calls sees it, committed or
+// rolled back by Book as a whole
+type joined struct{ *sql.Tx }
+
+func (joined) Commit() error   { return nil }
+func (joined) Rollback() error { return nil }
+
+// opens (creating if needed) the SQLite database at path. seed is only
+// inserted when the database has no accounts yet
+func openSQLStore(path string, seed map[string]Money) (*sqlStore, error) {
+	if path == "" {
+		return nil, errors.New("sqlite store needs a database path")
+	}
+	// every transaction takes the write lock as it begins, what SELECT
+	// ... FOR UPDATE would lock in other databases, so what one reads in
+	// a transaction can't change before it writes. only one replica uses
+	// the file at a time (see lease.go), busy_timeout covers tools
+	// reading it meanwhile
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite allows a single writer, funnel everything through one
+	// connection instead of retrying on SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqlSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create schema: %w", err)
+	}
+	for _, q := range sqlLedgerSchema {
+		if _, err := db.Exec(q); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("create ledger schema: %w", err)
+		}
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate schema: %w", err)
+	}
+	s := &sqlStore{db: db}
+	if err := claimStore(s); err != nil {
+		db.Close()
+		return nil, err
+	}
+	// holds live in memory and are gone now, so is what they reserved
+	if _, err := db.Exec(`UPDATE accounts SET held = 0 WHERE held != 0`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("free reservations: %w", err)
+	}
+	if err := s.seed(seed); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("seed accounts: %w", err)
+	}
+	return s, nil
+}
+
+// adds any column from sqlMigrations the accounts table is missing
+func migrate(db *sql.DB) error {
+	for _, m := range sqlMigrations {
+		var n int
+		err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('accounts') WHERE name = ?`, m.column).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE accounts ADD COLUMN ` + m.column + ` ` + m.def); err != nil {
+			return fmt.Errorf("add %s: %w", m.column, err)
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) seed(balances map[string]Money) error {
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM accounts`).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	for acct, bal := range balances {
+		if err := s.Credit(acct, bal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gives the store lease up on the way, whoever opens the file next
+// needn't wait for it to run out
+func (s *sqlStore) Close() error {
+	if _, err := s.db.Exec(`DELETE FROM leases WHERE name = ? AND holder = ?`, storeLease, instanceID); err != nil {
+		s.db.Close()
+		return fmt.Errorf("release store lease: %w", err)
+	}
+	return s.db.Close()
+}
+
+func (s *sqlStore) Get(account string) (Account, error) {
+	return getAccount(s.conn(), account)
+}
+
+func (s *sqlStore) Credit(account string, amount Money) error {
+	return credit(s.conn(), account, amount)
+}
+
+func (s *sqlStore) Debit(account string, amount Money) error {
+	return s.DebitIf(account, amount, 0)
+}
+
+func (s *sqlStore) DebitIf(account string, amount Money, version int64) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	a, err := getAccount(tx, account)
+	if err != nil {
+		return err
+	}
+	if err := debitBlocked(a.Status); err != nil {
+		return err
+	}
+	if version != 0 && a.Version != version {
+		return ErrVersionMismatch
+	}
+	if err := debit(tx, account, amount); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) Transfer(from, to string, amount Money) error {
+	return s.TransferIf(from, to, amount, 0)
+}
+
+func (s *sqlStore) TransferIf(from, to string, amount Money, version int64) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	// a no-op once committed, otherwise undoes a half applied transfer
+	defer tx.Rollback()
+	if version != 0 {
+		// the transaction holds the write lock, nothing in this process
+		// or another can write between this read and the update below
+		src, err := getAccount(tx, from)
+		if err == nil && src.Version != version {
+			return ErrVersionMismatch
+		}
+	}
+	if err := transfer(tx, from, to, amount); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) TransferBatch(items []TransferItem) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i, it := range items {
+		if err := transfer(tx, it.From, it.To, it.Amount); err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) Exchange(from, to string, debitAmount, creditAmount Money) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	src, err := getAccount(tx, from)
+	if err != nil {
+		return err
+	}
+	if _, err := getAccount(tx, to); err != nil {
+		return err
+	}
+	if err := debitBlocked(src.Status); err != nil {
+		return err
+	}
+	if err := debit(tx, from, debitAmount); err != nil {
+		return err
+	}
+	if err := credit(tx, to, creditAmount); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) All() ([]Account, error) {
+	query := s.db.QueryContext
+	if s.tx != nil {
+		query = s.tx.QueryContext
+	}
+	rows, err := query(s.context(), `SELECT `+accountColumns+` FROM accounts ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Account
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) Create(acct Account) error {
+	res, err := s.conn().Exec(`INSERT INTO accounts (id, balance, currency, type) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO NOTHING`, acct.ID, acct.Balance, acct.Currency, accountType(acct.Type))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAccountExists
+	}
+	return nil
+}
+
+func (s *sqlStore) Delete(account string) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	a, err := getAccount(tx, account)
+	if err != nil {
+		return err
+	}
+	if a.Status == accountClosed {
+		return nil
+	}
+	if a.Balance != 0 || a.Held != 0 {
+		return ErrNonZeroBalance
+	}
+	if _, err := tx.Exec(`UPDATE accounts SET status = ?, version = version + 1 WHERE id = ?`, accountClosed, account); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) SetStatus(account, status string) error {
+	res, err := s.conn().Exec(`UPDATE accounts SET status = ?, version = version + 1
+		WHERE id = ? AND status != ? AND status != ?`, status, account, status, accountClosed)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	// nothing changed, already in that state, closed or not there at all
+	a, err := getAccount(s.conn(), account)
+	if err == nil && a.Status == accountClosed {
+		return ErrAccountClosed
+	}
+	return err
+}
+
+func (s *sqlStore) SetOverdraft(account string, limit Money) error {
+	res, err := s.conn().Exec(`UPDATE accounts SET overdraft = ?, version = version + 1
+		WHERE id = ? AND overdraft != ?`, limit, account, limit)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	_, err = getAccount(s.conn(), account)
+	return err
+}
+
+func (s *sqlStore) Reserve(account string, amount Money) error {
+	res, err := s.conn().Exec(`UPDATE accounts SET held = held + ?, version = version + 1
+		WHERE id = ? AND status = ? AND balance + overdraft - held >= ?`, amount, account, accountActive, amount)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	// refused, tell why
+	a, err := getAccount(s.conn(), account)
+	if err != nil {
+		return err
+	}
+	if err := debitBlocked(a.Status); err != nil {
+		return err
+	}
+	return ErrInsufficientFunds
+}
+
+func (s *sqlStore) Unreserve(account string, amount Money) error {
+	res, err := s.conn().Exec(`UPDATE accounts SET held = MAX(held - ?, 0), version = version + 1
+		WHERE id = ?`, amount, account)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	return ErrAccountNotFound
+}
+
+func (s *sqlStore) Settle(from, to string, amount Money) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	src, err := getAccount(tx, from)
+	if err != nil {
+		return err
+	}
+	if err := debitBlocked(src.Status); err != nil {
+		return err
+	}
+	if src.Held < amount {
+		return ErrInsufficientFunds
+	}
+	if err := openRecipient(tx, src, to); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE accounts SET held = held - ?, balance = balance - ?, version = version + 1
+		WHERE id = ?`, amount, amount, from); err != nil {
+		return err
+	}
+	if err := credit(tx, to, amount); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) LoadLedger() (ledgerOpening, []ledgerEntry, error) {
+	var o ledgerOpening
+	var raw string
+	err := s.db.QueryRow(`SELECT opening FROM ledger_opening WHERE id = 1`).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return o, nil, nil
+	}
+	if err != nil {
+		return o, nil, err
+	}
+	if err := json.Unmarshal([]byte(raw), &o); err != nil {
+		return o, nil, fmt.Errorf("ledger opening: %w", err)
+	}
+	rows, err := s.db.Query(`SELECT id, entry, reversed_by FROM ledger ORDER BY id`)
+	if err != nil {
+		return o, nil, err
+	}
+	defer rows.Close()
+	var out []ledgerEntry
+	for rows.Next() {
+		var id, reversedBy int64
+		if err := rows.Scan(&id, &raw, &reversedBy); err != nil {
+			return o, nil, err
+		}
+		var e ledgerEntry
+		if err := json.Unmarshal([]byte(raw), &e); err != nil {
+			return o, nil, fmt.Errorf("ledger entry %d: %w", id, err)
+		}
+		e.ID, e.ReversedBy = id, reversedBy
+		out = append(out, e)
+	}
+	return o, out, rows.Err()
+}
+
+func (s *sqlStore) OpenLedger(o ledgerOpening) error {
+	raw, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM ledger`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO ledger_opening (id, opening) VALUES (1, ?)
+		ON CONFLICT (id) DO UPDATE SET opening = excluded.opening`, string(raw)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// makes op's change and keeps entries in one transaction. new entries
+// are numbered inside it, by the database, so the ids follow what is
+// in the file rather than what this process has seen of it
+func (s *sqlStore) Book(ctx context.Context, op func(Store) error, entries []ledgerEntry) ([]ledgerEntry, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if op != nil {
+		if err := op(&sqlStore{db: s.db, ctx: ctx, tx: tx}); err != nil {
+			return nil, err
+		}
+	}
+	out := slices.Clone(entries)
+	for i := range out {
+		e := &out[i]
+		if e.ID != 0 {
+			if err := rewriteEntry(tx, *e); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := tx.QueryRow(`SELECT COALESCE(MAX(id), 0) + 1 FROM ledger`).Scan(&e.ID); err != nil {
+			return nil, err
+		}
+		e.Timestamp = now()
+		raw, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`INSERT INTO ledger (id, entry, reversed_by) VALUES (?, ?, ?)`, e.ID, string(raw), e.ReversedBy); err != nil {
+			return nil, err
+		}
+		if e.Reverses != 0 && e.Status == statusCompleted {
+			if _, err := tx.Exec(`UPDATE ledger SET reversed_by = ? WHERE id = ?`, e.ID, e.Reverses); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, tx.Commit()
+}
+
+// replaces the kept entry with e's ID by e
+func rewriteEntry(tx execer, e ledgerEntry) error {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(`UPDATE ledger SET entry = ?, reversed_by = ? WHERE id = ?`, string(raw), e.ReversedBy, e.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return err
+	}
+	return fmt.Errorf("ledger entry %d isn't kept", e.ID)
+}
+
+// takes name for holder, or renews it, unless another holder's lease
+// hasn't expired yet. the replicas' clocks must roughly agree
+func (s *sqlStore) Lease(name, holder string, ttl time.Duration) (bool, error) {
+	at := now()
+	res, err := s.db.Exec(`INSERT INTO leases (name, holder, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE holder = excluded.holder OR expires_at <= ?`, name, holder, at.Add(ttl).UnixNano(), at.UnixNano())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// ctxDB is an execer whose statements run under ctx
+type ctxDB struct {
+	ctx context.Context
+	db  *sql.DB
+}
+
+func (c ctxDB) Exec(query string, args ...any) (sql.Result, error) {
+	return c.db.ExecContext(c.ctx, query, args...)
+}
+
+func (c ctxDB) QueryRow(query string, args ...any) *sql.Row {
+	return c.db.QueryRowContext(c.ctx, query, args...)
+}
+
+// satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAccount(row scanner) (Account, error) {
+	var a Account
+	err := row.Scan(&a.ID, &a.Balance, &a.Currency, &a.Version, &a.Status, &a.Overdraft, &a.Held, &a.Type)
+	return a, err
+}
+
+func getAccount(db execer, account string) (Account, error) {
+	a, err := scanAccount(db.QueryRow(`SELECT `+accountColumns+` FROM accounts WHERE id = ?`, account))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Account{}, ErrAccountNotFound
+	}
+	return a, err
+}
+
+// pays into account, opening it when needed. the update is skipped for
+// a closed account, which is how that is noticed
+func credit(db execer, account string, amount Money) error {
+	res, err := db.Exec(`INSERT INTO accounts (id, balance) VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET balance = balance + excluded.balance, version = version + 1
+		WHERE status != ?`, account, amount, accountClosed)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAccountClosed
+	}
+	return nil
+}
+
+// moves amount inside tx, opening the recipient in the sender's currency
+func transfer(tx execer, from, to string, amount Money) error {
+	src, err := getAccount(tx, from)
+	if errors.Is(err, ErrAccountNotFound) {
+		return ErrInsufficientFunds
+	}
+	if err != nil {
+		return err
+	}
+	if err := debitBlocked(src.Status); err != nil {
+		return err
+	}
+	if err := openRecipient(tx, src, to); err != nil {
+		return err
+	}
+	if err := debit(tx, from, amount); err != nil {
+		return err
+	}
+	return credit(tx, to, amount)
+}
+
+// makes sure to exists and can be paid from src, opening it in src's
+// currency when it doesn't
+func openRecipient(tx execer, src Account, to string) error {
+	dst, err := getAccount(tx, to)
+	switch {
+	case errors.Is(err, ErrAccountNotFound):
+		// version 0 so the credit after makes it 1 like any new account
+		_, err := tx.Exec(`INSERT INTO accounts (id, balance, currency, version) VALUES (?, 0, ?, 0)`, to, src.Currency)
+		return err
+	case err != nil:
+		return err
+	case dst.Status == accountClosed:
+		return ErrAccountClosed
+	case dst.Currency != src.Currency:
+		return ErrCurrencyMismatch
+	}
+	return nil
+}
+
+// the balance check and the update happen in one statement so
+// concurrent debits can't both pass the check
+func debit(db execer, account string, amount Money) error {
+	res, err := db.Exec(`UPDATE accounts SET balance = balance - ?, version = version + 1
+		WHERE id = ? AND balance + overdraft - held >= ?`, amount, account, amount)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInsufficientFunds
+	}
+	return nil
+}
+
+// replaces the accounts, the ledger and its opening in one transaction
+func (s *sqlStore) Restore(snap snapshot) error {
+	opening, err := json.Marshal(snap.Opening)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, q := range []string{`DELETE FROM accounts`, `DELETE FROM ledger`} {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	for _, a := range snap.Accounts {
+		if _, err := tx.Exec(`INSERT INTO accounts (`+accountColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			a.ID, a.Balance, a.Currency, a.Version, a.Status, a.Overdraft, a.Held, accountType(a.Type)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO ledger_opening (id, opening) VALUES (1, ?)
+		ON CONFLICT (id) DO UPDATE SET opening = excluded.opening`, string(opening)); err != nil {
+		return err
+	}
+	for _, e := range snap.Ledger {
+		raw, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO ledger (id, entry, reversed_by) VALUES (?, ?, ?)`, e.ID, string(raw), e.ReversedBy); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
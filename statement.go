@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// one line of a statement, Amount is signed from the account's point of
+// view and Balance is the running balance after it
+type statementLine struct {
+	ID           int64     `json:"id"`
+	Timestamp    time.Time `json:"timestamp"`
+	Counterparty string    `json:"counterparty"`
+	Description  string    `json:"description"`
+	Amount       Money     `json:"amount"`
+	Balance      Money     `json:"balance"`
+}
+
+// models the JSON statement. everything a rendered statement needs is
+// precomputed so a PDF template only has to lay it out
+type statement struct {
+	Account        string          `json:"account"`
+	Currency       string          `json:"currency"`
+	From           time.Time       `json:"from"`
+	To             time.Time       `json:"to"`
+	OpeningBalance Money           `json:"opening_balance"`
+	TotalCredits   Money           `json:"total_credits"`
+	TotalDebits    Money           `json:"total_debits"`
+	ClosingBalance Money           `json:"closing_balance"`
+	Lines          []statementLine `json:"lines"`
+}
+
+// serves GET /accounts/{account}/statement?from=&to=&format=csv|json. from
+// and to are RFC 3339 times or dates, a date as to includes that day.
+// they default to when the ledger started and now
+func statementHandler(w http.ResponseWriter, r *http.Request) {
+	account := r.PathValue("account")
+	if !mayRead(r.Context(), account) {
+		forbidden(w)
+		return
+	}
+	q := r.URL.Query()
+	from, err := parseStatementTime(q.Get("from"), false)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidTimestamp, "from must be an RFC3339 timestamp or a date")
+		return
+	}
+	to, err := parseStatementTime(q.Get("to"), true)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidTimestamp, "to must be an RFC3339 timestamp or a date")
+		return
+	}
+	if to.IsZero() {
+		to = now()
+	}
+	if from.After(to) {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "from must not be after to")
+		return
+	}
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "format must be csv or json")
+		return
+	}
+
+	currency := defaultCurrency
+	acct, err := storeFor(r.Context()).Get(account)
+	switch {
+	case err == nil:
+		currency = acct.Currency
+	case !errors.Is(err, ErrAccountNotFound):
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not read account")
+		return
+	}
+	st, existed := buildStatement(r.Context(), account, from, to)
+	if !existed && err != nil {
+		writeError(w, http.StatusNotFound, codeAccountNotFound, "account not found")
+		return
+	}
+	st.Currency = currency
+
+	if format == "csv" {
+		writeStatementCSV(w, st)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// parses a statement bound, end moves a bare date to the end of that day
+func parseStatementTime(s string, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+// replays the ledger for account, entries before from make up the
+// opening balance and the completed ones up to to become lines. existed
+// is false when the account never showed up in the ledger
+func buildStatement(ctx context.Context, account string, from, to time.Time) (statement, bool) {
+	id := qualify(tenantOf(ctx), account)
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+	if from.Before(openedAt) {
+		from = openedAt
+	}
+	st := statement{Account: account, From: from, To: to, Lines: []statementLine{}}
+	bal, existed := openingBalances[id]
+	st.OpeningBalance = bal
+	for _, e := range ledger {
+		if e.Timestamp.After(to) {
+			break
+		}
+		if e.Status != statusCompleted || !e.touches(id) {
+			continue
+		}
+		e = e.local()
+		existed = true
+		delta := e.net(account)
+		counterparty := e.To
+		if e.To == account {
+			counterparty = e.From
+		}
+		bal += delta
+		if e.Timestamp.Before(from) {
+			st.OpeningBalance = bal
+			continue
+		}
+		if delta > 0 {
+			st.TotalCredits += delta
+		} else {
+			st.TotalDebits -= delta
+		}
+		st.Lines = append(st.Lines, statementLine{
+			ID:           e.ID,
+			Timestamp:    e.Timestamp,
+			Counterparty: counterparty,
+			Description:  describe(e, account),
+			Amount:       delta,
+			Balance:      bal,
+		})
+	}
+	st.ClosingBalance = bal
+	return st, existed
+}
+
+// a human readable line for a statement
+func describe(e ledgerEntry, account string) string {
+	switch {
+	case e.Reverses != 0:
+		return fmt.Sprintf("reversal of #%d", e.Reverses)
+	case e.HoldID != "" && e.From == account:
+		return "hold " + e.HoldID + " captured"
+	case e.HoldID != "":
+		return "hold " + e.HoldID + " settled"
+	case e.From == "":
+		return "deposit"
+	case e.To == "":
+		return "withdrawal"
+	case e.ToCurrency != "" && e.From == account:
+		return fmt.Sprintf("conversion to %s", e.ToCurrency)
+	case e.ToCurrency != "":
+		return fmt.Sprintf("conversion from %s", e.Currency)
+	case e.From == account:
+		return "transfer to " + e.To
+	}
+	return "transfer from " + e.From
+}
+
+// writes st as CSV, the opening and closing balance are rows of their
+// own so the file reconciles on its own
+func writeStatementCSV(w http.ResponseWriter, st statement) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	name := fmt.Sprintf("statement-%s-%s-%s.csv", st.Account, st.From.Format(time.DateOnly), st.To.Format(time.DateOnly))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "timestamp", "counterparty", "description", "amount", "balance", "currency"})
+	cw.Write([]string{"", st.From.Format(time.RFC3339), "", "opening balance", "", st.OpeningBalance.String(), st.Currency})
+	for _, l := range st.Lines {
+		cw.Write([]string{
+			strconv.FormatInt(l.ID, 10),
+			l.Timestamp.Format(time.RFC3339Nano),
+			csvSafe(l.Counterparty),
+			csvSafe(l.Description),
+			l.Amount.String(),
+			l.Balance.String(),
+			st.Currency,
+		})
+	}
+	cw.Write([]string{"", st.To.Format(time.RFC3339), "", "closing balance", "", st.ClosingBalance.String(), st.Currency})
+	cw.Flush()
+}
+
+// account ids are picked by clients, keep spreadsheets from running one
+// that looks like a formula
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
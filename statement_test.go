@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getStatement(query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/accounts/alice/statement"+query, nil))
+	return w
+}
+
+func TestStatement(t *testing.T) {
+	clock := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+
+	// one transfer on each of three days, bob pays some back on the second
+	for _, body := range []string{
+		`{"from":"alice","to":"bob","amount":10}`,
+		`{"from":"alice","to":"bob","amount":20}`,
+		`{"from":"alice","to":"bob","amount":30}`,
+	} {
+		clock = clock.AddDate(0, 0, 1)
+		transferID(t, body)
+		if clock.Day() == 3 {
+			transferID(t, `{"from":"bob","to":"alice","amount":5}`)
+		}
+	}
+
+	w := getStatement("?from=2024-06-03&to=2024-06-03")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body)
+	}
+	var st statement
+	json.NewDecoder(w.Body).Decode(&st)
+	if st.OpeningBalance != units(90) || st.ClosingBalance != units(75) {
+		t.Errorf("expected opening 90 and closing 75, got %v %v", st.OpeningBalance, st.ClosingBalance)
+	}
+	if st.TotalDebits != units(20) || st.TotalCredits != units(5) {
+		t.Errorf("expected debits 20 and credits 5, got %v %v", st.TotalDebits, st.TotalCredits)
+	}
+	if len(st.Lines) != 2 {
+		t.Fatalf("expected 2 lines, got %+v", st.Lines)
+	}
+	if l := st.Lines[0]; l.Amount != -units(20) || l.Balance != units(70) || l.Counterparty != "bob" || l.Description != "transfer to bob" {
+		t.Errorf("unexpected first line %+v", l)
+	}
+	if l := st.Lines[1]; l.Amount != units(5) || l.Balance != units(75) || l.Description != "transfer from bob" {
+		t.Errorf("unexpected second line %+v", l)
+	}
+
+	// no bounds covers the whole ledger
+	w = getStatement("")
+	json.NewDecoder(w.Body).Decode(&st)
+	if st.OpeningBalance != units(100) || st.ClosingBalance != units(45) || len(st.Lines) != 4 {
+		t.Errorf("expected 100 to 45 over 4 lines, got %+v", st)
+	}
+}
+
+func TestStatementCSV(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	transferID(t, `{"from":"alice","to":"bob","amount":12.5}`)
+
+	w := getStatement("?format=csv")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("expected text/csv, got %q", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment") {
+		t.Errorf("expected an attachment, got %q", cd)
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("expected header, opening, one line and closing, got %v", rows)
+	}
+	if rows[1][3] != "opening balance" || rows[1][5] != "100.00" {
+		t.Errorf("unexpected opening row %v", rows[1])
+	}
+	if rows[2][4] != "-12.50" || rows[2][5] != "87.50" || rows[2][6] != "USD" {
+		t.Errorf("unexpected line %v", rows[2])
+	}
+	if rows[3][3] != "closing balance" || rows[3][5] != "87.50" {
+		t.Errorf("unexpected closing row %v", rows[3])
+	}
+}
+
+func TestStatementErrors(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100)})
+	resetLedger()
+
+	for _, query := range []string{"?format=pdf", "?from=yesterday", "?from=2024-06-02&to=2024-06-01"} {
+		if w := getStatement(query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/accounts/nobody/statement", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown account, got %d", w.Code)
+	}
+}
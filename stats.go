@@ -0,0 +1,281 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long each bucket of GET /admin/stats?bucket= spans
+var statsBuckets = map[string]func(time.Time) time.Time{
+	"hour":  func(t time.Time) time.Time { return t.Add(time.Hour) },
+	"day":   func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+	"month": func(t time.Time) time.Time { return t.AddDate(0, 1, 0) },
+}
+
+// the most buckets and top accounts GET /admin/stats answers with
+const (
+	maxStatsBuckets = 400
+	maxTopAccounts  = 100
+)
+
+type supplyTotal struct {
+	Currency string `json:"currency"`
+	Total    Money  `json:"total"`
+}
+
+// transfers between accounts that completed within one bucket, their
+// amounts added up per currency
+type volumeBucket struct {
+	Start     time.Time        `json:"start"`
+	Transfers int              `json:"transfers"`
+	Volume    map[string]Money `json:"volume"`
+}
+
+// an account and how much went through it
+type accountActivity struct {
+	Account      string `json:"account"`
+	Transactions int    `json:"transactions"`
+	Volume       Money  `json:"volume"`
+}
+
+// models the JSON response for GET /admin/stats
+type systemStats struct {
+	Supply   []supplyTotal     `json:"supply"`
+	Accounts accountCounts     `json:"accounts"`
+	Bucket   string            `json:"bucket"`
+	Volume   []volumeBucket    `json:"volume"`
+	Top      []accountActivity `json:"top_accounts"`
+}
+
+type accountCounts struct {
+	Total    int            `json:"total"`
+	ByStatus map[string]int `json:"by_status"`
+	ByType   map[string]int `json:"by_type"`
+}
+
+// the start of the bucket t falls in, UTC
+func bucketStart(bucket string, t time.Time) time.Time {
+	t = t.UTC()
+	switch bucket {
+	case "hour":
+		return t.Truncate(time.Hour)
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return startOfDay(t)
+}
+
+// works out the stats of accts and list, the ledger, with n buckets the
+// last of which holds at, and the top busiest accounts
+func buildStats(accts []Account, list []ledgerEntry, bucket string, n, top int, at time.Time) systemStats {
+	st := systemStats{
+		Supply:   []supplyTotal{},
+		Accounts: accountCounts{Total: len(accts), ByStatus: map[string]int{}, ByType: map[string]int{}},
+		Bucket:   bucket,
+		Volume:   make([]volumeBucket, n),
+		Top:      []accountActivity{},
+	}
+	supply := map[string]Money{}
+	for _, a := range accts {
+		supply[a.Currency] += a.Balance
+		st.Accounts.ByStatus[a.Status]++
+		st.Accounts.ByType[a.Type]++
+	}
+	for cur, total := range supply {
+		st.Supply = append(st.Supply, supplyTotal{cur, total})
+	}
+	slices.SortFunc(st.Supply, func(a, b supplyTotal) int { return strings.Compare(a.Currency, b.Currency) })
+
+	// buckets oldest first, the last one holding at
+	start := bucketStart(bucket, at)
+	for i := n - 1; i >= 0; i-- {
+		st.Volume[i] = volumeBucket{Start: start, Volume: map[string]Money{}}
+		start = bucketStart(bucket, start.Add(-time.Nanosecond))
+	}
+	activity := map[string]*accountActivity{}
+	touch := func(account string, amount Money) {
+		if account == "" {
+			return
+		}
+		a, ok := activity[account]
+		if !ok {
+			a = &accountActivity{Account: account}
+			activity[account] = a
+		}
+		a.Transactions++
+		a.Volume += amount
+	}
+	for _, e := range list {
+		if e.Status != statusCompleted {
+			continue
+		}
+		touch(e.From, e.Amount)
+		touch(e.To, e.Amount)
+		if e.From == "" || e.To == "" {
+			continue
+		}
+		i, found := slices.BinarySearchFunc(st.Volume, e.Timestamp, func(b volumeBucket, t time.Time) int { return b.Start.Compare(t) })
+		if !found {
+			// the bucket before the first one starting later
+			i--
+		}
+		if i < 0 || !e.Timestamp.Before(statsBuckets[bucket](st.Volume[i].Start)) {
+			continue
+		}
+		st.Volume[i].Transfers++
+		st.Volume[i].Volume[e.Currency] += e.Amount
+	}
+	for _, a := range activity {
+		st.Top = append(st.Top, *a)
+	}
+	slices.SortFunc(st.Top, func(a, b accountActivity) int {
+		if c := cmp.Compare(b.Transactions, a.Transactions); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.Volume, a.Volume); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Account, b.Account)
+	})
+	st.Top = st.Top[:min(top, len(st.Top))]
+	return st
+}
+
+// handles GET /admin/stats?bucket=hour|day|month&buckets=&top=, admins
+// only. the supply and accounts are those of the caller's tenant, volume
+// is of the last buckets of transfers between its accounts, 30 days by
+// default, and top the 10 accounts in the most completed entries
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r.Context()) {
+		forbidden(w)
+		return
+	}
+	q := r.URL.Query()
+	bucket := q.Get("bucket")
+	if bucket == "" {
+		bucket = "day"
+	}
+	if _, ok := statsBuckets[bucket]; !ok {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "bucket must be hour, day or month")
+		return
+	}
+	n, top := 30, 10
+	for _, p := range []struct {
+		name string
+		dst  *int
+		max  int
+	}{{"buckets", &n, maxStatsBuckets}, {"top", &top, maxTopAccounts}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil || i <= 0 || i > p.max {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, p.name+" must be a positive integer of at most "+strconv.Itoa(p.max))
+			return
+		}
+		*p.dst = i
+	}
+	accts, err := storeFor(r.Context()).All()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "could not list accounts")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildStats(accts, entries(r.Context(), ""), bucket, n, top, now()))
+}
+
+// the supply of each currency, the balances of every account in every
+// tenant, should only change by money entering or leaving the system:
+// deposits, withdrawals, interest and the opening balances, posted
+// against the cash accounts, and conversions, posted against the fx
+// ones. supply that doesn't add up to those postings is drift, money
+// made or lost by a bug or someone writing to the store behind the API.
+// a deposit between reading the store and the ledger looks like drift
+// for a moment, so only drift seen twice in a row is alerted on
+
+// the drift per currency found by the last check
+var (
+	supplyMu    sync.Mutex
+	supplyDrift = map[string]Money{}
+)
+
+// the supply per currency the postings to cash and fx accounts account
+// for, and what the store holds
+func supplyCheck() (expected, actual map[string]Money, err error) {
+	accts, err := store.All()
+	if err != nil {
+		return nil, nil, err
+	}
+	actual = map[string]Money{}
+	for _, a := range accts {
+		actual[a.Currency] += a.Balance
+	}
+	expected = map[string]Money{}
+	external := func(p posting) {
+		_, account := splitTenant(p.Account)
+		if strings.HasPrefix(account, cashPrefix) || strings.HasPrefix(account, fxPrefix) {
+			// what the outside gave is what its accounts owe
+			expected[p.Currency] += p.Debit - p.Credit
+		}
+	}
+	ledgerMu.Lock()
+	for _, p := range openingPostings {
+		external(p)
+	}
+	for _, e := range ledger {
+		for _, p := range e.Postings {
+			external(p)
+		}
+	}
+	ledgerMu.Unlock()
+	return expected, actual, nil
+}
+
+// checks the supply once, alerting on drift it also found last time
+func checkSupply() {
+	expected, actual, err := supplyCheck()
+	if err != nil {
+		slog.Error("supply check", "err", err)
+		return
+	}
+	drift := map[string]Money{}
+	for _, totals := range []map[string]Money{actual, expected} {
+		for cur := range totals {
+			drift[cur] = actual[cur] - expected[cur]
+		}
+	}
+	supplyMu.Lock()
+	defer supplyMu.Unlock()
+	for cur, d := range drift {
+		supplyDriftGauge.WithLabelValues(cur).Set(float64(d) / minorUnits)
+		if d != 0 && supplyDrift[cur] != 0 {
+			supplyAlerts.WithLabelValues(cur).Inc()
+			slog.Error("money supply drifted from what entered and left the system",
+				"currency", cur, "expected", expected[cur].String(), "actual", actual[cur].String(), "drift", d.String())
+		}
+	}
+	supplyDrift = drift
+}
+
+// checks the supply every interval until ctx is cancelled
+func runSupplyCheck(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			checkSupply()
+		}
+	}
+}
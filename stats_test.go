@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAdminStats(t *testing.T) {
+	clock := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0, "carol": 0})
+	resetLedger()
+	transferID(t, `{"from":"alice","to":"bob","amount":10}`)
+	clock = clock.Add(21 * time.Hour)
+	transferID(t, `{"from":"alice","to":"carol","amount":5}`)
+	transferID(t, `{"from":"bob","to":"carol","amount":2}`)
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/accounts/bob/deposit", strings.NewReader(`{"amount":1}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("deposit: %d %s", w.Code, w.Body)
+	}
+
+	var st systemStats
+	getPage(t, "/v1/admin/stats?buckets=3&top=2", &st)
+	if len(st.Supply) != 1 || st.Supply[0] != (supplyTotal{"USD", units(101)}) {
+		t.Errorf("expected a supply of 101.00 USD, got %+v", st.Supply)
+	}
+	if st.Accounts.Total != 3 || st.Accounts.ByStatus[accountActive] != 3 || st.Accounts.ByType[accountChecking] != 3 {
+		t.Errorf("expected 3 active checking accounts, got %+v", st.Accounts)
+	}
+	// the deposit isn't a transfer between accounts
+	want := []struct {
+		day       int
+		transfers int
+		volume    Money
+	}{{9, 0, 0}, {10, 1, units(10)}, {11, 2, units(7)}}
+	if len(st.Volume) != len(want) {
+		t.Fatalf("expected %d buckets, got %+v", len(want), st.Volume)
+	}
+	for i, b := range want {
+		v := st.Volume[i]
+		if v.Start.Day() != b.day || v.Transfers != b.transfers || v.Volume["USD"] != b.volume {
+			t.Errorf("bucket %d: expected March %d with %d transfers of %v, got %+v", i, b.day, b.transfers, b.volume, v)
+		}
+	}
+	// bob is in 3 entries, alice in 2 moving more than carol's 2
+	if len(st.Top) != 2 || st.Top[0] != (accountActivity{"bob", 3, units(13)}) || st.Top[1] != (accountActivity{"alice", 2, units(15)}) {
+		t.Errorf("expected bob then alice, got %+v", st.Top)
+	}
+
+	for _, q := range []string{"bucket=week", "buckets=0", "top=1000"} {
+		w := httptest.NewRecorder()
+		serveAPI(w, httptest.NewRequest("GET", "/v1/admin/stats?"+q, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", q, w.Code)
+		}
+	}
+}
+
+func TestSupplyCheck(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	supplyDrift = map[string]Money{}
+	alerts := testutil.ToFloat64(supplyAlerts.WithLabelValues("USD"))
+
+	transferID(t, `{"from":"alice","to":"bob","amount":10}`)
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/accounts/bob/withdraw", strings.NewReader(`{"amount":3}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("withdraw: %d %s", w.Code, w.Body)
+	}
+	checkSupply()
+	checkSupply()
+	if got := testutil.ToFloat64(supplyAlerts.WithLabelValues("USD")); got != alerts {
+		t.Errorf("expected no alert for money moved through the API, got %v", got-alerts)
+	}
+
+	// money made behind the ledger's back, alerted once seen twice
+	store.Credit("alice", units(5))
+	checkSupply()
+	if got := testutil.ToFloat64(supplyAlerts.WithLabelValues("USD")); got != alerts {
+		t.Errorf("expected drift seen once to wait for the next check, got %v alerts", got-alerts)
+	}
+	checkSupply()
+	if got := testutil.ToFloat64(supplyAlerts.WithLabelValues("USD")); got != alerts+1 {
+		t.Errorf("expected an alert, got %v", got-alerts)
+	}
+	if d := testutil.ToFloat64(supplyDriftGauge.WithLabelValues("USD")); d != 5 {
+		t.Errorf("expected a drift of 5, got %v", d)
+	}
+}
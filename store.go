@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+)
+
+var (
+	ErrAccountNotFound   = errors.New("account not found")
+	ErrAccountExists     = errors.New("account already exists")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrNonZeroBalance    = errors.New("account balance is not zero")
+	ErrCurrencyMismatch  = errors.New("accounts hold different currencies")
+	ErrVersionMismatch   = errors.New("account version has changed")
+	ErrAccountFrozen     = errors.New("account is frozen")
+	ErrAccountClosed     = errors.New("account is closed")
+)
+
+// currency of accounts opened without naming one
+const defaultCurrency = "USD"
+
+// states an account can be in. nothing leaves a frozen account, whether
+// money may still come in is up to the API (see frozenAcceptsCredits).
+// a closed account is kept with its history but nothing moves in or out
+// of it any more
+const (
+	accountActive = "active"
+	accountFrozen = "frozen"
+	accountClosed = "closed"
+)
+
+// kinds of account. savings accounts earn interest (see interest.go),
+// checking ones don't and are what an account is unless asked otherwise
+const (
+	accountChecking = "checking"
+	accountSavings  = "savings"
+)
+
+// the type of an account recorded with typ, those from before there
+// were types are checking accounts
+func accountType(typ string) string {
+	if typ == "" {
+		return accountChecking
+	}
+	return typ
+}
+
+// the error for taking money out of an account in status, nil if it may
+func debitBlocked(status string) error {
+	switch status {
+	case accountFrozen:
+		return ErrAccountFrozen
+	case accountClosed:
+		return ErrAccountClosed
+	}
+	return nil
+}
+
+// Account is a single account as kept by a Store. Version starts at 1
+// and goes up by one with every change to the account. Overdraft is how
+// far below zero Balance may go, Held is the part of Balance reserved by
+// holds
+type Account struct {
+	ID        string `json:"id"`
+	Balance   Money  `json:"balance"`
+	Currency  string `json:"currency"`
+	Version   int64  `json:"version"`
+	Status    string `json:"status"`
+	Overdraft Money  `json:"overdraft"`
+	Held      Money  `json:"held"`
+	Type      string `json:"type"`
+}
+
+// what can still be taken out of the account, the book balance plus
+// whatever is left of the overdraft, less what holds reserved
+func (a Account) Available() Money {
+	return a.Balance + a.Overdraft - a.Held
+}
+
+// TransferItem is one transfer within a batch
+type TransferItem struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount Money  `json:"amount"`
+}
+
+// BatchError reports which item of a batch made the whole batch fail
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch item %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Store keeps account balances. Implementations must apply each call
+// atomically, Transfer in particular must never debit without crediting.
+type Store interface {
+	// Get returns account or ErrAccountNotFound
+	Get(account string) (Account, error)
+	// Credit adds amount to account, opening it in the default
+	// currency if needed. every call paying into a closed account fails
+	// with ErrAccountClosed
+	Credit(account string, amount Money) error
+	// Debit removes amount from account or fails with ErrInsufficientFunds
+	// if that would take it beyond its overdraft
+	Debit(account string, amount Money) error
+	// DebitIf is Debit that only goes ahead while account is still at
+	// version, otherwise it fails with ErrVersionMismatch. version 0
+	// skips the check
+	DebitIf(account string, amount Money, version int64) error
+	// Transfer moves amount from one account to another, opening the
+	// recipient in the sender's currency if needed. accounts holding
+	// different currencies fail with ErrCurrencyMismatch
+	Transfer(from, to string, amount Money) error
+	// TransferIf is Transfer with the same condition as DebitIf on from
+	TransferIf(from, to string, amount Money, version int64) error
+	// TransferBatch applies every transfer in order or none of them,
+	// failures are reported as a *BatchError
+	TransferBatch(items []TransferItem) error
+	// Exchange takes debit from one account and adds credit to another
+	// that must already exist, used for currency conversion
+	Exchange(from, to string, debit, credit Money) error
+	// All returns a copy of every account ordered by ID
+	All() ([]Account, error)
+	// Create opens an account or fails with ErrAccountExists
+	Create(acct Account) error
+	// Delete closes account, only allowed once its balance is zero and
+	// nothing is held. the account stays, marked closed, closing it
+	// again does nothing
+	Delete(account string) error
+	// SetStatus freezes or unfreezes account. every call that takes
+	// money out of a frozen account fails with ErrAccountFrozen, a
+	// closed account can't change status and fails with ErrAccountClosed
+	SetStatus(account, status string) error
+	// SetOverdraft sets how far below zero account may go. an account
+	// already below a new, lower limit keeps its balance but can't be
+	// debited until it is back within it
+	SetOverdraft(account string, limit Money) error
+	// Reserve sets amount of account's available funds aside for a
+	// hold, it stays in the balance but can't be spent. fails like
+	// Debit when there isn't that much available
+	Reserve(account string, amount Money) error
+	// Unreserve makes amount reserved on account available again
+	Unreserve(account string, amount Money) error
+	// Settle pays amount reserved on from to to, opening the recipient
+	// like Transfer does. ErrInsufficientFunds when less is reserved
+	Settle(from, to string, amount Money) error
+}
+
+// contextStore is implemented by backends whose calls can be given up on,
+// the store withContext returns runs them for ctx and stops once it is
+// done, failing with its error. a call that fails that way moved no money
+type contextStore interface {
+	withContext(ctx context.Context) Store
+}
+
+// the accounts of SEED_ACCOUNTS, fresh stores start out with them
+// instead of alice and bob when set
+var seedAccounts map[string]Money
+
+// accounts every fresh store starts out with, in the default currency
+func seedBalances() map[string]Money {
+	if seedAccounts != nil {
+		return maps.Clone(seedAccounts)
+	}
+	return map[string]Money{
+		"alice": units(100),
+		"bob":   units(50),
+	}
+}
+
+// parses a SEED_ACCOUNTS setting, comma separated id=balance pairs
+func parseSeedAccounts(s string) (map[string]Money, error) {
+	seed := map[string]Money{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, val, ok := strings.Cut(entry, "=")
+		balance, err := ParseMoney(val)
+		if !ok || err != nil || balance < 0 {
+			return nil, fmt.Errorf("malformed account %q", entry)
+		}
+		if err := checkAccountID(id); err != nil {
+			return nil, fmt.Errorf("account %q: %w", id, err)
+		}
+		seed[id] = balance
+	}
+	return seed, nil
+}
+
+// opens the store backend selected by kind, dsn is backend specific
+// (the database file for sqlite, the log file for eventlog, ignored for
+// memory)
+func openStore(kind, dsn string) (Store, error) {
+	switch kind {
+	case "", "memory":
+		return newMemoryStore(seedBalances()), nil
+	case "sqlite":
+		return openSQLStore(dsn, seedBalances())
+	case "eventlog":
+		return openEventStore(dsn, seedBalances())
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// runs the same checks against every Store implementation
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store {
+			return newMemoryStore(map[string]Money{"alice": units(100), "bob": units(50)})
+		},
+		"sqlite": func(t *testing.T) Store {
+			s, err := openSQLStore(filepath.Join(t.TempDir(), "tx.db"), map[string]Money{"alice": units(100), "bob": units(50)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+		"eventlog": func(t *testing.T) Store {
+			s, err := openEventStore(filepath.Join(t.TempDir(), "events.log"), map[string]Money{"alice": units(100), "bob": units(50)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+	}
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+
+			if err := s.Transfer("alice", "carol", units(30)); err != nil {
+				t.Fatalf("transfer: %v", err)
+			}
+			if err := s.Transfer("bob", "alice", units(51)); !errors.Is(err, ErrInsufficientFunds) {
+				t.Errorf("expected ErrInsufficientFunds, got %v", err)
+			}
+			if err := s.Debit("alice", units(20)); err != nil {
+				t.Fatalf("debit: %v", err)
+			}
+			if err := s.Debit("dave", units(1)); !errors.Is(err, ErrAccountNotFound) {
+				t.Errorf("expected ErrAccountNotFound, got %v", err)
+			}
+			if err := s.Credit("bob", units(5)); err != nil {
+				t.Fatalf("credit: %v", err)
+			}
+			if _, err := s.Get("dave"); !errors.Is(err, ErrAccountNotFound) {
+				t.Errorf("expected ErrAccountNotFound, got %v", err)
+			}
+			if err := s.Create(Account{ID: "erin", Currency: "USD"}); err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			if err := s.Create(Account{ID: "bob", Balance: units(1), Currency: "USD"}); !errors.Is(err, ErrAccountExists) {
+				t.Errorf("expected ErrAccountExists, got %v", err)
+			}
+			if err := s.Delete("bob"); !errors.Is(err, ErrNonZeroBalance) {
+				t.Errorf("expected ErrNonZeroBalance, got %v", err)
+			}
+			if err := s.Delete("erin"); err != nil {
+				t.Fatalf("delete: %v", err)
+			}
+			if err := s.Delete("erin"); err != nil {
+				t.Errorf("expected closing again to do nothing, got %v", err)
+			}
+			if err := s.Delete("dave"); !errors.Is(err, ErrAccountNotFound) {
+				t.Errorf("expected ErrAccountNotFound, got %v", err)
+			}
+			for name, err := range map[string]error{
+				"credit":      s.Credit("erin", units(1)),
+				"debit":       s.Debit("erin", units(1)),
+				"transfer in": s.Transfer("alice", "erin", units(1)),
+				"batch in":    s.TransferBatch([]TransferItem{{"alice", "erin", units(1)}}),
+				"status":      s.SetStatus("erin", accountFrozen),
+			} {
+				if !errors.Is(err, ErrAccountClosed) {
+					t.Errorf("%s: expected ErrAccountClosed, got %v", name, err)
+				}
+			}
+			if a, err := s.Get("erin"); err != nil || a.Status != accountClosed || a.Version != 2 {
+				t.Errorf("expected erin kept as closed at version 2, got %+v %v", a, err)
+			}
+
+			if err := s.Create(Account{ID: "eur", Balance: units(10), Currency: "EUR", Type: accountSavings}); err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			if err := s.Transfer("bob", "eur", units(1)); !errors.Is(err, ErrCurrencyMismatch) {
+				t.Errorf("expected ErrCurrencyMismatch, got %v", err)
+			}
+			err := s.TransferBatch([]TransferItem{{"carol", "alice", units(30)}, {"alice", "bob", units(81)}})
+			var batchErr *BatchError
+			if !errors.As(err, &batchErr) || batchErr.Index != 1 || !errors.Is(err, ErrInsufficientFunds) {
+				t.Errorf("expected item 1 to fail with ErrInsufficientFunds, got %v", err)
+			}
+			if err := s.TransferBatch([]TransferItem{{"carol", "alice", units(30)}, {"alice", "carol", units(30)}}); err != nil {
+				t.Fatalf("batch: %v", err)
+			}
+			if err := s.Exchange("eur", "bob", units(10), units(11)); err != nil {
+				t.Fatalf("exchange: %v", err)
+			}
+
+			got, err := s.All()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []Account{
+				{"alice", units(50), "USD", 5, accountActive, 0, 0, accountChecking},
+				{"bob", units(66), "USD", 3, accountActive, 0, 0, accountChecking},
+				{"carol", units(30), "USD", 3, accountActive, 0, 0, accountChecking},
+				{"erin", 0, "USD", 2, accountClosed, 0, 0, accountChecking},
+				{"eur", 0, "EUR", 2, accountActive, 0, 0, accountSavings},
+			}
+			if len(got) != len(want) {
+				t.Fatalf("expected %v, got %v", want, got)
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("expected %v, got %v", want[i], got[i])
+				}
+			}
+
+			if err := s.DebitIf("alice", units(1), 4); !errors.Is(err, ErrVersionMismatch) {
+				t.Errorf("expected ErrVersionMismatch, got %v", err)
+			}
+			if err := s.DebitIf("alice", units(1), 5); err != nil {
+				t.Fatalf("debit at version 5: %v", err)
+			}
+			if err := s.TransferIf("alice", "bob", units(1), 5); !errors.Is(err, ErrVersionMismatch) {
+				t.Errorf("expected ErrVersionMismatch, got %v", err)
+			}
+			if err := s.TransferIf("alice", "bob", units(1), 6); err != nil {
+				t.Fatalf("transfer at version 6: %v", err)
+			}
+			if a, _ := s.Get("alice"); a.Balance != units(48) || a.Version != 7 {
+				t.Errorf("expected alice at 48 and version 7, got %+v", a)
+			}
+
+			if err := s.SetStatus("bob", accountFrozen); err != nil {
+				t.Fatalf("freeze: %v", err)
+			}
+			for name, err := range map[string]error{
+				"debit":    s.Debit("bob", units(1)),
+				"transfer": s.Transfer("bob", "alice", units(1)),
+				"batch":    s.TransferBatch([]TransferItem{{"bob", "alice", units(1)}}),
+				"exchange": s.Exchange("bob", "eur", units(1), units(1)),
+			} {
+				if !errors.Is(err, ErrAccountFrozen) {
+					t.Errorf("%s: expected ErrAccountFrozen, got %v", name, err)
+				}
+			}
+			if err := s.Transfer("alice", "bob", units(1)); err != nil {
+				t.Errorf("expected a frozen account to still be paid into, got %v", err)
+			}
+			if err := s.SetStatus("dave", accountFrozen); !errors.Is(err, ErrAccountNotFound) {
+				t.Errorf("expected ErrAccountNotFound, got %v", err)
+			}
+			if err := s.SetStatus("bob", accountActive); err != nil {
+				t.Fatalf("unfreeze: %v", err)
+			}
+			if err := s.Debit("bob", units(1)); err != nil {
+				t.Errorf("expected an unfrozen account to pay out, got %v", err)
+			}
+
+			if err := s.SetOverdraft("carol", units(20)); err != nil {
+				t.Fatalf("set overdraft: %v", err)
+			}
+			if err := s.Debit("carol", units(45)); err != nil {
+				t.Fatalf("debit into the overdraft: %v", err)
+			}
+			if err := s.Transfer("carol", "alice", units(6)); !errors.Is(err, ErrInsufficientFunds) {
+				t.Errorf("expected ErrInsufficientFunds beyond the overdraft, got %v", err)
+			}
+			if err := s.TransferBatch([]TransferItem{{"carol", "alice", units(6)}}); !errors.Is(err, ErrInsufficientFunds) {
+				t.Errorf("expected ErrInsufficientFunds beyond the overdraft, got %v", err)
+			}
+			if a, _ := s.Get("carol"); a.Balance != -units(15) || a.Available() != units(5) || a.Version != 5 {
+				t.Errorf("expected carol at -15 with 5 available and version 5, got %+v", a)
+			}
+			if err := s.SetOverdraft("carol", 0); err != nil {
+				t.Fatalf("set overdraft: %v", err)
+			}
+			if err := s.Debit("carol", units(1)); !errors.Is(err, ErrInsufficientFunds) {
+				t.Errorf("expected ErrInsufficientFunds once the overdraft is gone, got %v", err)
+			}
+			if err := s.SetOverdraft("dave", units(1)); !errors.Is(err, ErrAccountNotFound) {
+				t.Errorf("expected ErrAccountNotFound, got %v", err)
+			}
+
+			if err := s.Reserve("alice", units(40)); err != nil {
+				t.Fatalf("reserve: %v", err)
+			}
+			if err := s.Debit("alice", units(8)); !errors.Is(err, ErrInsufficientFunds) {
+				t.Errorf("expected reserved funds to be unavailable, got %v", err)
+			}
+			if err := s.Settle("alice", "bob", units(41)); !errors.Is(err, ErrInsufficientFunds) {
+				t.Errorf("expected ErrInsufficientFunds settling more than reserved, got %v", err)
+			}
+			if err := s.Settle("alice", "frank", units(30)); err != nil {
+				t.Fatalf("settle: %v", err)
+			}
+			if err := s.Unreserve("alice", units(10)); err != nil {
+				t.Fatalf("unreserve: %v", err)
+			}
+			if a, _ := s.Get("alice"); a.Balance != units(17) || a.Held != 0 || a.Available() != units(17) {
+				t.Errorf("expected alice at 17 with nothing held, got %+v", a)
+			}
+			if a, _ := s.Get("frank"); a.Balance != units(30) {
+				t.Errorf("expected frank to be paid 30, got %+v", a)
+			}
+			if err := s.Reserve("dave", units(1)); !errors.Is(err, ErrAccountNotFound) {
+				t.Errorf("expected ErrAccountNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSQLStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tx.db")
+	s, err := openSQLStore(path, map[string]Money{"alice": units(100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Transfer("alice", "bob", units(40)); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// the seed must not be applied again on an existing database
+	s, err = openSQLStore(path, map[string]Money{"alice": units(100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if a, _ := s.Get("alice"); a.Balance != units(60) {
+		t.Errorf("expected alice to have 60 after restart, got %v", a.Balance)
+	}
+	if a, _ := s.Get("bob"); a.Balance != units(40) {
+		t.Errorf("expected bob to have 40 after restart, got %v", a.Balance)
+	}
+}
+
+// transfers between disjoint account pairs from many goroutines, the
+// single-lock case is how the store behaved before it was sharded
+func BenchmarkMemoryStoreTransferParallel(b *testing.B) {
+	for _, n := range []int{1, memoryShards} {
+		b.Run(fmt.Sprintf("shards=%d", n), func(b *testing.B) {
+			balances := make(map[string]Money)
+			for i := range 1024 {
+				balances[fmt.Sprintf("acct-%d", i)] = units(1_000_000)
+			}
+			s := newShardedMemoryStore(balances, n)
+			var next atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				// each goroutine shuffles money within its own pair
+				g := next.Add(1)
+				from := fmt.Sprintf("acct-%d", (2*g)%1024)
+				to := fmt.Sprintf("acct-%d", (2*g+1)%1024)
+				for pb.Next() {
+					if err := s.Transfer(from, to, 1); err != nil {
+						b.Fatal(err)
+					}
+					from, to = to, from
+				}
+			})
+		})
+	}
+}
+
+func TestMemoryStoreConcurrentTransfers(t *testing.T) {
+	s := newMemoryStore(map[string]Money{"a": units(1000), "b": units(1000), "c": units(1000)})
+	var wg sync.WaitGroup
+	pairs := [][2]string{{"a", "b"}, {"b", "a"}, {"b", "c"}, {"c", "a"}}
+	for _, p := range pairs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				s.Transfer(p[0], p[1], 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	all, _ := s.All()
+	var total Money
+	for _, a := range all {
+		total += a.Balance
+	}
+	if total != units(3000) {
+		t.Errorf("expected money to be conserved at 3000.00, got %v", total)
+	}
+}
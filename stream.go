@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// how often an idle stream gets a comment line, keeps proxies from
+// timing the connection out. a var so tests can shorten it
+var streamKeepAlive = 15 * time.Second
+
+// entries a subscriber may fall behind by before it is cut off, it can
+// reconnect with Last-Event-ID and pick up where it stopped
+const streamBuffer = 256
+
+// one connected GET /transactions/stream client
+type subscriber struct {
+	tenant  string
+	account string
+	ch      chan ledgerEntry
+}
+
+var (
+	subscribersMu sync.Mutex
+	subscribers   = map[*subscriber]bool{}
+)
+
+// hands a completed entry, as the ledger keeps it, to every subscriber
+// of its tenant following one of its accounts. nobody is waited for, a
+// subscriber whose buffer is full is dropped
+func publish(e ledgerEntry) {
+	if e.Status != statusCompleted {
+		return
+	}
+	tenant, local := e.tenant(), e.local()
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	for s := range subscribers {
+		if s.tenant != tenant || s.account != "" && !local.touches(s.account) {
+			continue
+		}
+		select {
+		case s.ch <- local:
+		default:
+			delete(subscribers, s)
+			close(s.ch)
+		}
+	}
+}
+
+func subscribe(tenant, account string) *subscriber {
+	s := &subscriber{tenant: tenant, account: account, ch: make(chan ledgerEntry, streamBuffer)}
+	subscribersMu.Lock()
+	subscribers[s] = true
+	subscribersMu.Unlock()
+	return s
+}
+
+func unsubscribe(s *subscriber) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	if subscribers[s] {
+		delete(subscribers, s)
+		close(s.ch)
+	}
+}
+
+// ends every open stream, registered to run on server shutdown so the
+// streams don't hold up draining
+func endStreams() {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	for s := range subscribers {
+		delete(subscribers, s)
+		close(s.ch)
+	}
+}
+
+// handles GET /transactions/stream, server-sent events of every
+// completed transaction as it is recorded. ?account= follows one account
+// and is required unless the caller is an admin. a Last-Event-ID header
+// replays what was missed since that entry first
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	account := r.URL.Query().Get("account")
+	if account == "" && !isAdmin(r.Context()) || account != "" && !mayRead(r.Context(), account) {
+		forbidden(w)
+		return
+	}
+	var last int64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Last-Event-ID must be a transaction id")
+			return
+		}
+		last = n
+	}
+	rc := http.NewResponseController(w)
+	// a stream outlives any write timeout the server has
+	rc.SetWriteDeadline(time.Time{})
+
+	// subscribed before the replay so nothing recorded meanwhile is
+	// missed, whatever shows up twice is skipped by id
+	s := subscribe(tenantOf(r.Context()), account)
+	defer unsubscribe(s)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if last > 0 {
+		for _, e := range entries(r.Context(), account) {
+			if e.ID > last && e.Status == statusCompleted {
+				writeEvent(w, e)
+				last = e.ID
+			}
+		}
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-s.ch:
+			if !ok {
+				return
+			}
+			if e.ID <= last {
+				continue
+			}
+			writeEvent(w, e)
+			last = e.ID
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+// writes e as one transaction event, its id lets a client resume
+func writeEvent(w http.ResponseWriter, e ledgerEntry) {
+	data, _ := json.Marshal(e)
+	fmt.Fprintf(w, "id: %d\nevent: transaction\ndata: %s\n\n", e.ID, data)
+}
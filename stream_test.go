@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reads the next event off an SSE stream, skipping comments
+func nextEvent(t *testing.T, r *bufio.Reader) (id string, e ledgerEntry) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = line[len("id: "):]
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(line[len("data: "):]), &e); err != nil {
+				t.Fatal(err)
+			}
+		case line == "" && id != "":
+			return id, e
+		}
+	}
+}
+
+func openStream(t *testing.T, srv *httptest.Server, query, lastID string) *bufio.Reader {
+	t.Helper()
+	req, _ := http.NewRequest("GET", srv.URL+"/v1/transactions/stream"+query, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// waits until n streams are connected, so nothing is published too early
+func waitSubscribers(t *testing.T, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		subscribersMu.Lock()
+		got := len(subscribers)
+		subscribersMu.Unlock()
+		if got == n {
+			return
+		}
+	}
+	t.Fatalf("expected %d subscribers", n)
+}
+
+func TestTransactionStream(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0, "carol": 0})
+	resetLedger()
+	srv := httptest.NewServer(newRouter())
+	// registered first so it runs last, once every stream body is closed
+	t.Cleanup(func() { endStreams(); srv.Close() })
+
+	all := openStream(t, srv, "", "")
+	carol := openStream(t, srv, "?account=carol", "")
+	waitSubscribers(t, 2)
+
+	transferID(t, `{"from":"alice","to":"bob","amount":10}`)
+	transferHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"bob","to":"carol","amount":500}`)))
+	transferID(t, `{"from":"alice","to":"carol","amount":5}`)
+
+	if id, e := nextEvent(t, all); id != "1" || e.To != "bob" {
+		t.Errorf("expected entry 1 to bob first, got %s %+v", id, e)
+	}
+	// the failed attempt is not streamed
+	if id, e := nextEvent(t, all); id != "3" || e.To != "carol" || e.Amount != units(5) {
+		t.Errorf("expected entry 3 next, got %s %+v", id, e)
+	}
+	if id, _ := nextEvent(t, carol); id != "3" {
+		t.Errorf("expected carol's stream to skip entry 1, got %s", id)
+	}
+
+	// resuming replays what came after the last seen id
+	resumed := openStream(t, srv, "", "1")
+	if id, _ := nextEvent(t, resumed); id != "3" {
+		t.Errorf("expected entry 3 to be replayed, got %s", id)
+	}
+}
+
+func TestTransactionStreamAccess(t *testing.T) {
+	keys, _ := parseAPIKeys("k1=ops:admin;k2=alice:user:alice")
+	apiKeys = keys
+	defer func() { apiKeys = nil }()
+
+	for _, tt := range []struct {
+		key, query string
+		code       int
+	}{
+		{"k2", "", http.StatusForbidden},
+		{"k2", "?account=bob", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("GET", "/v1/transactions/stream"+tt.query, nil)
+		req.Header.Set("X-API-Key", tt.key)
+		w := httptest.NewRecorder()
+		serveAPI(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s %q: expected %d, got %d", tt.key, tt.query, tt.code, w.Code)
+		}
+	}
+}
+
+func TestStreamsEndOnShutdown(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100)})
+	resetLedger()
+	srv := httptest.NewServer(newRouter())
+	// registered first so it runs last, once every stream body is closed
+	t.Cleanup(func() { endStreams(); srv.Close() })
+
+	r := openStream(t, srv, "?account=alice", "")
+	waitSubscribers(t, 1)
+	endStreams()
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.ReadString('\n')
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected the stream to end")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream still open after endStreams")
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// tenants are organizations sharing one deployment without seeing each
+// other. a key named like acme/ops in API_KEYS belongs to tenant acme and
+// only ever acts in it, an admin key without a tenant operates the whole
+// deployment and picks the tenant it acts in with X-Tenant-ID, as does
+// anyone when authentication is off. requests naming no tenant act in
+// the default one, which is what a deployment without tenants runs in.
+//
+// the store and the ledger keep a tenant's accounts as tenant/account,
+// account ids can't hold a / so these never clash with the default
+// tenant's. storeFor and the ledger functions taking a ctx translate, so
+// handlers only ever see the ids of their own tenant. holds, schedules,
+// webhooks and streams remember their tenant and are invisible to others
+
+const tenantHeader = "X-Tenant-ID"
+
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+type tenantKey struct{}
+
+// returns ctx acting in tenant
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// the tenant ctx acts in, "" for the default one
+func tenantOf(ctx context.Context) string {
+	t, _ := ctx.Value(tenantKey{}).(string)
+	return t
+}
+
+// picks the tenant a request acts in from its caller and X-Tenant-ID,
+// failing when the caller may not act in the one asked for
+func requestTenant(p *principal, header string) (string, error) {
+	if header != "" && !tenantPattern.MatchString(header) {
+		return "", failure(codeInvalidRequest, tenantHeader+" must be lowercase letters, digits, - and _")
+	}
+	switch {
+	case p == nil || p.tenant == "" && p.admin:
+		return header, nil
+	case header == "" || header == p.tenant:
+		return p.tenant, nil
+	}
+	return "", errForbidden
+}
+
+// reports whether the caller runs the deployment rather than one tenant,
+// what applies to every tenant at once is left to such admins
+func isOperator(ctx context.Context) bool {
+	p := caller(ctx)
+	return isAdmin(ctx) && (p == nil || p.tenant == "")
+}
+
+// the id account of tenant has in the store and the ledger
+func qualify(tenant, account string) string {
+	if tenant == "" || account == "" {
+		return account
+	}
+	return tenant + "/" + account
+}
+
+// the tenant an id of the store or the ledger belongs to and the id
+// within it
+func splitTenant(id string) (tenant, account string) {
+	if t, a, ok := strings.Cut(id, "/"); ok {
+		return t, a
+	}
+	return "", id
+}
+
+// the tenant a ledger entry was recorded in
+func (e ledgerEntry) tenant() string {
+	id := e.From
+	if id == "" {
+		id = e.To
+	}
+	t, _ := splitTenant(id)
+	return t
+}
+
+// e with the ids of tenant, the way the ledger keeps it
+func (e ledgerEntry) qualified(tenant string) ledgerEntry {
+	if tenant == "" {
+		return e
+	}
+	e.From, e.To = qualify(tenant, e.From), qualify(tenant, e.To)
+	e.Postings = slices.Clone(e.Postings)
+	for i := range e.Postings {
+		e.Postings[i].Account = qualify(tenant, e.Postings[i].Account)
+	}
+	return e
+}
+
+// e with the postings it is made of now, against the accounts of its
+// tenant, cash and fx ones included
+func (e ledgerEntry) withPostings() ledgerEntry {
+	t := e.tenant()
+	l := e.local()
+	l.Postings = l.postings()
+	return l.qualified(t)
+}
+
+// e as its tenant sees it, the reverse of qualified
+func (e ledgerEntry) local() ledgerEntry {
+	t := e.tenant()
+	if t == "" {
+		return e
+	}
+	strip := func(id string) string { return strings.TrimPrefix(id, t+"/") }
+	e.From, e.To = strip(e.From), strip(e.To)
+	e.Postings = slices.Clone(e.Postings)
+	for i := range e.Postings {
+		e.Postings[i].Account = strip(e.Postings[i].Account)
+	}
+	return e
+}
+
+// tenantStore is the part of a Store one tenant sees
+type tenantStore struct {
+	tenant string
+	Store
+}
+
+func (s tenantStore) q(account string) string {
+	return qualify(s.tenant, account)
+}
+
+func (s tenantStore) local(a Account) Account {
+	_, a.ID = splitTenant(a.ID)
+	return a
+}
+
+func (s tenantStore) Get(account string) (Account, error) {
+	a, err := s.Store.Get(s.q(account))
+	return s.local(a), err
+}
+
+// only the tenant's accounts, all of which are tenant/ prefixed
+func (s tenantStore) All() ([]Account, error) {
+	all, err := s.Store.All()
+	if err != nil {
+		return nil, err
+	}
+	var out []Account
+	for _, a := range all {
+		if t, _ := splitTenant(a.ID); t == s.tenant {
+			out = append(out, s.local(a))
+		}
+	}
+	return out, nil
+}
+
+func (s tenantStore) Credit(account string, amount Money) error {
+	return s.Store.Credit(s.q(account), amount)
+}
+
+func (s tenantStore) Debit(account string, amount Money) error {
+	return s.Store.Debit(s.q(account), amount)
+}
+
+func (s tenantStore) DebitIf(account string, amount Money, version int64) error {
+	return s.Store.DebitIf(s.q(account), amount, version)
+}
+
+func (s tenantStore) Transfer(from, to string, amount Money) error {
+	return s.Store.Transfer(s.q(from), s.q(to), amount)
+}
+
+func (s tenantStore) TransferIf(from, to string, amount Money, version int64) error {
+	return s.Store.TransferIf(s.q(from), s.q(to), amount, version)
+}
+
+func (s tenantStore) TransferBatch(items []TransferItem) error {
+	items = slices.Clone(items)
+	for i := range items {
+		items[i].From, items[i].To = s.q(items[i].From), s.q(items[i].To)
+	}
+	return s.Store.TransferBatch(items)
+}
+
+func (s tenantStore) Exchange(from, to string, debit, credit Money) error {
+	return s.Store.Exchange(s.q(from), s.q(to), debit, credit)
+}
+
+func (s tenantStore) Create(acct Account) error {
+	acct.ID = s.q(acct.ID)
+	return s.Store.Create(acct)
+}
+
+func (s tenantStore) Delete(account string) error {
+	return s.Store.Delete(s.q(account))
+}
+
+func (s tenantStore) SetStatus(account, status string) error {
+	return s.Store.SetStatus(s.q(account), status)
+}
+
+func (s tenantStore) SetOverdraft(account string, limit Money) error {
+	return s.Store.SetOverdraft(s.q(account), limit)
+}
+
+func (s tenantStore) Reserve(account string, amount Money) error {
+	return s.Store.Reserve(s.q(account), amount)
+}
+
+func (s tenantStore) Unreserve(account string, amount Money) error {
+	return s.Store.Unreserve(s.q(account), amount)
+}
+
+func (s tenantStore) Settle(from, to string, amount Money) error {
+	return s.Store.Settle(s.q(from), s.q(to), amount)
+}
+
+// answers the request when its caller may not act in the tenant it
+// asked for, otherwise runs next acting in it
+func scopeTenant(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	tenant, err := requestTenant(caller(r.Context()), r.Header.Get(tenantHeader))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	next(w, r.WithContext(withTenant(r.Context(), tenant)))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serves a request acting in tenant, with key unless it is empty
+func inTenant(method, path, tenant, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if tenant != "" {
+		req.Header.Set(tenantHeader, tenant)
+	}
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	w := httptest.NewRecorder()
+	serveAPI(w, req)
+	return w
+}
+
+func TestTenantIsolation(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0, "acme/alice": units(50), "acme/bob": 0})
+	resetLedger()
+
+	w := inTenant("GET", "/v1/accounts/alice/balance", "acme", "", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"balance":50.00`) {
+		t.Fatalf("expected acme's alice, got %d %s", w.Code, w.Body)
+	}
+	if w := inTenant("POST", "/v1/transfer", "acme", "", `{"from":"alice","to":"bob","amount":20}`); w.Code != http.StatusOK {
+		t.Fatalf("expected the transfer to go through, got %d %s", w.Code, w.Body)
+	}
+	if a, b := balance(t, "acme/alice"), balance(t, "alice"); a != units(30) || b != units(100) {
+		t.Errorf("expected only acme's alice debited, got acme/alice=%v alice=%v", a, b)
+	}
+
+	// the entry is acme's and shows acme's ids
+	w = inTenant("GET", "/v1/transactions/1", "acme", "", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"from":"alice"`) {
+		t.Errorf("expected the entry with local ids, got %d %s", w.Code, w.Body)
+	}
+	if w := inTenant("GET", "/v1/transactions/1", "", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected another tenant's entry to be hidden, got %d %s", w.Code, w.Body)
+	}
+	if w := inTenant("GET", "/v1/transactions/1", "globex", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected another tenant's entry to be hidden, got %d %s", w.Code, w.Body)
+	}
+
+	w = inTenant("GET", "/v1/accounts", "acme", "", "")
+	if body := w.Body.String(); strings.Contains(body, "acme/") || !strings.Contains(body, `"id":"bob"`) {
+		t.Errorf("expected only acme's accounts by their own ids, got %s", body)
+	}
+	if w := inTenant("GET", "/v1/accounts/alice/balance", "globex", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected globex to have no alice, got %d %s", w.Code, w.Body)
+	}
+
+	// each tenant's ledger balances on its own
+	for _, tenant := range []string{"", "acme"} {
+		w := inTenant("GET", "/v1/ledger/trial-balance", tenant, "", "")
+		if body := w.Body.String(); !strings.Contains(body, `"balanced":true`) || strings.Contains(body, "acme/") {
+			t.Errorf("%q: expected a balanced trial balance of the tenant alone, got %s", tenant, body)
+		}
+	}
+
+	if w := inTenant("GET", "/v1/accounts", "Acme!", "", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a malformed tenant to be refused, got %d", w.Code)
+	}
+}
+
+func TestTenantKeys(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "acme/alice": units(50), "acme/bob": 0})
+	resetLedger()
+	keys, err := parseAPIKeys("k1=acme/ops:admin;k2=acme/alice:user:alice;k3=root:admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKeys = keys
+	defer func() { apiKeys = nil }()
+
+	// a key of acme acts in acme without saying so
+	w := inTenant("GET", "/v1/accounts/alice/balance", "", "k2", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"balance":50.00`) {
+		t.Errorf("expected acme's alice, got %d %s", w.Code, w.Body)
+	}
+	if w := inTenant("GET", "/v1/accounts/alice/balance", "acme", "k2", ""); w.Code != http.StatusOK {
+		t.Errorf("expected naming its own tenant to be fine, got %d %s", w.Code, w.Body)
+	}
+	for _, key := range []string{"k1", "k2"} {
+		if w := inTenant("GET", "/v1/accounts/alice/balance", "globex", key, ""); w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected 403 for another tenant, got %d", key, w.Code)
+		}
+	}
+	// an admin without a tenant picks one
+	w = inTenant("GET", "/v1/accounts/alice/balance", "acme", "k3", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"balance":50.00`) {
+		t.Errorf("expected the operator to act in acme, got %d %s", w.Code, w.Body)
+	}
+
+	// what spans every tenant is left to the operator
+	for _, path := range []string{"/v1/admin/risk-rules", "/v1/admin/snapshot"} {
+		method := "GET"
+		if path == "/v1/admin/snapshot" {
+			method = "POST"
+		}
+		if w := inTenant(method, path, "", "k1", ""); w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected 403 for a tenant's admin, got %d", path, w.Code)
+		}
+		if w := inTenant(method, path, "", "k3", ""); w.Code != http.StatusOK {
+			t.Errorf("%s: expected the operator through, got %d %s", path, w.Code, w.Body)
+		}
+	}
+
+	if _, err := parseAPIKeys("k1=Acme!/ops:admin"); err == nil {
+		t.Error("expected a malformed tenant in API_KEYS to be refused")
+	}
+}
+
+func TestTenantWebhooks(t *testing.T) {
+	defer clear(webhooks)
+	if w := inTenant("POST", "/v1/webhooks", "acme", "", `{"url":"http://example.com/hook"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected the webhook registered, got %d %s", w.Code, w.Body)
+	}
+	if w := inTenant("GET", "/v1/webhooks", "", "", ""); strings.Contains(w.Body.String(), "example.com") {
+		t.Errorf("expected acme's webhook hidden from the default tenant, got %s", w.Body)
+	}
+	if w := inTenant("GET", "/v1/webhooks", "acme", "", ""); !strings.Contains(w.Body.String(), `"tenant":"acme"`) {
+		t.Errorf("expected acme's webhook listed, got %s", w.Body)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/rkarmaka98/Transaction_APP/transaction-api/config"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// TLS_CERT and TLS_KEY serve HTTPS, and gRPC over TLS, with a certificate
+// from files, TLS_AUTOCERT gets one from Let's Encrypt for the hosts it
+// lists instead, answering its challenges on the same port. with
+// TLS_CLIENT_CA every client has to present a certificate signed by it,
+// and CLIENT_CERTS says who the common name of one is, so such clients
+// need no API key
+
+// callers by the common name of their client certificate, nil when
+// certificates don't authenticate anyone
+var clientCerts map[string]*principal
+
+// parses CLIENT_CERTS, cn=name:role[:account,...] entries like those of
+// API_KEYS with the common name of a certificate in place of the key,
+// e.g. "ops.example.com=ops:admin;alice=acme/alice:user:alice"
+func parseClientCerts(s string) (map[string]*principal, error) {
+	certs := make(map[string]*principal)
+	err := parseCallers(s, func(cn string, p *principal) {
+		certs[cn] = p
+	})
+	if err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// looks up the caller a verified client certificate belongs to by the
+// common name of the leaf
+func lookupCert(state *tls.ConnectionState) (*principal, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	p, ok := clientCerts[state.VerifiedChains[0][0].Subject.CommonName]
+	return p, ok
+}
+
+// the TLS state of a gRPC call, nil over plaintext
+func peerTLS(ctx context.Context) *tls.ConnectionState {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	return &info.State
+}
+
+// the TLS config both servers listen with, nil when c serves plaintext
+func newTLSConfig(c config.Config) (*tls.Config, error) {
+	var tc *tls.Config
+	switch {
+	case c.TLSCert != "":
+		cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("tls_cert: %w", err)
+		}
+		tc = &tls.Config{Certificates: []tls.Certificate{cert}}
+	case c.TLSAutocert != "":
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(strings.Split(c.TLSAutocert, ",")...),
+			Cache:      autocert.DirCache(c.TLSAutocertDir),
+		}
+		tc = m.TLSConfig()
+	default:
+		return nil, nil
+	}
+	tc.MinVersion = tls.VersionTLS12
+	if c.TLSClientCA == "" {
+		return tc, nil
+	}
+	pem, err := os.ReadFile(c.TLSClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("tls_client_ca: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("tls_client_ca: no PEM certificates in " + c.TLSClientCA)
+	}
+	// Let's Encrypt has no client certificate to show when it checks on
+	// us, its challenge handshakes go without
+	challenge := tc.Clone()
+	tc.ClientCAs = pool
+	tc.ClientAuth = tls.RequireAndVerifyClientCert
+	if c.TLSAutocert != "" {
+		tc.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+				return challenge, nil
+			}
+			return nil, nil
+		}
+	}
+	return tc, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rkarmaka98/Transaction_APP/transaction-api/config"
+)
+
+// issues a certificate for cn signed by parent, self-signed when parent
+// is nil, and writes it and its key as PEM into dir
+func issueCert(t *testing.T, dir, cn string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, any(key)
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	os.WriteFile(filepath.Join(dir, cn+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(filepath.Join(dir, cn+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, cn+".pem"), filepath.Join(dir, cn+".key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestMutualTLS(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "acme/alice": units(50)})
+	resetLedger()
+	dir := t.TempDir()
+	ca := issueCert(t, dir, "ca", nil)
+	issueCert(t, dir, "server", &ca)
+	ops := issueCert(t, dir, "ops", &ca)
+	alice := issueCert(t, dir, "alice", &ca)
+	stranger := issueCert(t, dir, "stranger", &ca)
+	other := issueCert(t, dir, "other-ca", nil)
+
+	certs, err := parseClientCerts("ops=ops:admin;alice=acme/alice:user:alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCerts = certs
+	defer func() { clientCerts = nil }()
+	tc, err := newTLSConfig(config.Config{
+		TLSCert:     filepath.Join(dir, "server.pem"),
+		TLSKey:      filepath.Join(dir, "server.key"),
+		TLSClientCA: filepath.Join(dir, "ca.pem"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(newRouter())
+	srv.TLS = tc
+	srv.StartTLS()
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	get := func(path string, cert *tls.Certificate) (*http.Response, error) {
+		tr := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+		if cert != nil {
+			tr.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+		}
+		defer tr.CloseIdleConnections()
+		return (&http.Client{Transport: tr}).Get(srv.URL + path)
+	}
+
+	for _, c := range []struct {
+		name string
+		cert *tls.Certificate
+		path string
+		code int
+	}{
+		{"admin", &ops, "/v1/accounts", http.StatusOK},
+		// the tenant comes with the certificate's caller
+		{"tenant user", &alice, "/v1/accounts/alice/balance", http.StatusOK},
+		{"user reading another's account", &alice, "/v1/accounts/bob/balance", http.StatusForbidden},
+		{"unknown common name", &stranger, "/v1/accounts", http.StatusUnauthorized},
+	} {
+		res, err := get(c.path, c.cert)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		res.Body.Close()
+		if res.StatusCode != c.code {
+			t.Errorf("%s: expected %d, got %d", c.name, c.code, res.StatusCode)
+		}
+	}
+	for name, cert := range map[string]*tls.Certificate{"no certificate": nil, "another CA": &other} {
+		if res, err := get("/v1/accounts", cert); err == nil {
+			res.Body.Close()
+			t.Errorf("%s: expected the handshake to fail, got %d", name, res.StatusCode)
+		}
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	if tc, err := newTLSConfig(config.Config{}); tc != nil || err != nil {
+		t.Errorf("expected plaintext without any TLS setting, got %v %v", tc, err)
+	}
+	dir := t.TempDir()
+	if _, err := newTLSConfig(config.Config{TLSCert: filepath.Join(dir, "missing.pem"), TLSKey: filepath.Join(dir, "missing.key")}); err == nil {
+		t.Error("expected a missing certificate to be an error")
+	}
+	issueCert(t, dir, "server", nil)
+	os.WriteFile(filepath.Join(dir, "empty.pem"), []byte("not a certificate"), 0o600)
+	_, err := newTLSConfig(config.Config{TLSCert: filepath.Join(dir, "server.pem"), TLSKey: filepath.Join(dir, "server.key"), TLSClientCA: filepath.Join(dir, "empty.pem")})
+	if err == nil {
+		t.Error("expected a client CA file without certificates to be an error")
+	}
+	if _, err := parseClientCerts("ops=ops:root"); err == nil {
+		t.Error("expected an unknown role in CLIENT_CERTS to be refused")
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// every span of this service is started from this tracer, it follows
+// whatever provider setupTracing installs
+var tracer = otel.Tracer("github.com/rkarmaka98/Transaction_APP/transaction-api")
+
+// header a request id is read from and echoed back in
+const requestIDHeader = "X-Request-ID"
+
+// installs the W3C trace context propagator and, when
+// OTEL_EXPORTER_OTLP_ENDPOINT (or its _TRACES_ variant) is set, a
+// provider exporting spans there over OTLP/HTTP. without one spans aren't
+// recorded but incoming trace ids still reach the logs and get passed on
+func setupTracing(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exp, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "transaction-api")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// what the access log wants to know about a request that only the
+// handlers further in find out
+type requestInfo struct {
+	id      string
+	handler string
+}
+
+type requestInfoKey struct{}
+
+// returns the id of the request ctx belongs to, "" outside of one
+func requestID(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// wraps the whole mux: every request gets an id, a span continuing the
+// caller's trace from traceparent, and one JSON access log line once done
+func observe(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{id: incomingRequestID(r)}
+		w.Header().Set(requestIDHeader, info.id)
+
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("request.id", info.id),
+			))
+		ctx = context.WithValue(ctx, requestInfoKey{}, info)
+
+		lw := &loggingWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(lw, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", lw.status))
+		if lw.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(lw.status))
+		}
+		span.End()
+
+		level := slog.LevelInfo
+		if lw.status >= 500 {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("request_id", info.id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("handler", info.handler),
+			slog.Int("status", lw.status),
+			slog.Int64("bytes", lw.bytes),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+		if sc := span.SpanContext(); sc.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+		}
+		slog.LogAttrs(ctx, level, "request", attrs...)
+	})
+}
+
+// keeps a sane X-Request-ID from the caller so logs line up across
+// services, anything else gets a fresh random one
+func incomingRequestID(r *http.Request) string {
+	id := r.Header.Get(requestIDHeader)
+	if id != "" && len(id) <= 128 {
+		ok := true
+		for _, c := range id {
+			if c < '!' || c > '~' {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return id
+		}
+	}
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// records the status and size of a response for the access log
+type loggingWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *loggingWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *loggingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// lets handlers that stream reach the Flusher underneath
+func (w *loggingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *loggingWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// starts a client span for an outgoing call and puts its trace context
+// on the request's headers, so whatever the receiver records joins our
+// trace. the returned func ends the span with the outcome
+func traceOutgoing(req *http.Request, name string) (*http.Request, func(*http.Response, error)) {
+	ctx, span := tracer.Start(req.Context(), name, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("http.request.method", req.Method), attribute.String("server.address", req.URL.Host)))
+	req = req.WithContext(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return req, func(resp *http.Response, err error) {
+		switch {
+		case err != nil:
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		default:
+			span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+			if resp.StatusCode >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, resp.Status)
+			}
+		}
+		span.End()
+	}
+}
+
+// tracedStore is the store as one request sees it, each call is a span
+// under the request's
+type tracedStore struct {
+	ctx context.Context
+	Store
+}
+
+// returns the store of ctx's tenant with its calls traced as part of ctx,
+// and cancelled with it where the backend can. in chaos mode faults are
+// injected below the tenant
+func storeFor(ctx context.Context) Store {
+	s := store
+	if cs, ok := s.(contextStore); ok {
+		s = cs.withContext(ctx)
+	}
+	return storeOver(ctx, s)
+}
+
+// s as storeFor hands it out for ctx, where s is the store itself or a
+// view of it a booking writes through
+func storeOver(ctx context.Context, s Store) Store {
+	if chaos != nil {
+		s = chaosStore{ctx: ctx, c: chaos, Store: s}
+	}
+	var ts Store = tenantStore{tenantOf(ctx), s}
+	if t := auditTrailOf(ctx); t != nil {
+		ts = auditStore{t, tenantOf(ctx), ts}
+	}
+	return tracedStore{ctx: ctx, Store: ts}
+}
+
+// starts the span of one store call, the returned func ends it with err
+func (s tracedStore) start(op string, attrs ...attribute.KeyValue) func(error) error {
+	_, span := tracer.Start(s.ctx, "store."+op, trace.WithSpanKind(trace.SpanKindInternal), trace.WithAttributes(attrs...))
+	return func(err error) error {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		return err
+	}
+}
+
+func (s tracedStore) Get(account string) (Account, error) {
+	end := s.start("Get", attribute.String("tx.account", account))
+	a, err := s.Store.Get(account)
+	return a, end(err)
+}
+
+func (s tracedStore) Credit(account string, amount Money) error {
+	end := s.start("Credit", attribute.String("tx.account", account))
+	return end(s.Store.Credit(account, amount))
+}
+
+func (s tracedStore) Debit(account string, amount Money) error {
+	end := s.start("Debit", attribute.String("tx.account", account))
+	return end(s.Store.Debit(account, amount))
+}
+
+func (s tracedStore) DebitIf(account string, amount Money, version int64) error {
+	end := s.start("DebitIf", attribute.String("tx.account", account), attribute.Int64("tx.version", version))
+	return end(s.Store.DebitIf(account, amount, version))
+}
+
+func (s tracedStore) Transfer(from, to string, amount Money) error {
+	end := s.start("Transfer", attribute.String("tx.from", from), attribute.String("tx.to", to))
+	return end(s.Store.Transfer(from, to, amount))
+}
+
+func (s tracedStore) TransferIf(from, to string, amount Money, version int64) error {
+	end := s.start("TransferIf", attribute.String("tx.from", from), attribute.String("tx.to", to), attribute.Int64("tx.version", version))
+	return end(s.Store.TransferIf(from, to, amount, version))
+}
+
+func (s tracedStore) TransferBatch(items []TransferItem) error {
+	end := s.start("TransferBatch", attribute.Int("tx.items", len(items)))
+	return end(s.Store.TransferBatch(items))
+}
+
+func (s tracedStore) Exchange(from, to string, debit, credit Money) error {
+	end := s.start("Exchange", attribute.String("tx.from", from), attribute.String("tx.to", to))
+	return end(s.Store.Exchange(from, to, debit, credit))
+}
+
+func (s tracedStore) All() ([]Account, error) {
+	end := s.start("All")
+	list, err := s.Store.All()
+	return list, end(err)
+}
+
+func (s tracedStore) Create(acct Account) error {
+	end := s.start("Create", attribute.String("tx.account", acct.ID))
+	return end(s.Store.Create(acct))
+}
+
+func (s tracedStore) Delete(account string) error {
+	end := s.start("Delete", attribute.String("tx.account", account))
+	return end(s.Store.Delete(account))
+}
+
+func (s tracedStore) SetOverdraft(account string, limit Money) error {
+	end := s.start("SetOverdraft", attribute.String("tx.account", account))
+	return end(s.Store.SetOverdraft(account, limit))
+}
+
+func (s tracedStore) SetStatus(account, status string) error {
+	end := s.start("SetStatus", attribute.String("tx.account", account), attribute.String("tx.status", status))
+	return end(s.Store.SetStatus(account, status))
+}
+
+func (s tracedStore) Reserve(account string, amount Money) error {
+	end := s.start("Reserve", attribute.String("tx.account", account))
+	return end(s.Store.Reserve(account, amount))
+}
+
+func (s tracedStore) Unreserve(account string, amount Money) error {
+	end := s.start("Unreserve", attribute.String("tx.account", account))
+	return end(s.Store.Unreserve(account, amount))
+}
+
+func (s tracedStore) Settle(from, to string, amount Money) error {
+	end := s.start("Settle", attribute.String("tx.from", from), attribute.String("tx.to", to))
+	return end(s.Store.Settle(from, to, amount))
+}
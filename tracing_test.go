@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// records spans and log lines for the rest of the test
+func captureTelemetry(t *testing.T) (*tracetest.SpanRecorder, *bytes.Buffer) {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	prevTP, prevLog := otel.GetTracerProvider(), slog.Default()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	if _, err := setupTracing(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevTP)
+		slog.SetDefault(prevLog)
+	})
+	return rec, &logs
+}
+
+func TestObserveTracesTransfer(t *testing.T) {
+	rec, logs := captureTelemetry(t)
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+
+	h := observe(instrument("transfer", transferHandler))
+	req := httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":10}`))
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	req.Header.Set(requestIDHeader, "req-42")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body)
+	}
+	if id := w.Header().Get(requestIDHeader); id != "req-42" {
+		t.Errorf("expected the request id to be echoed, got %q", id)
+	}
+
+	spans := rec.Ended()
+	var server, transfer sdktrace.ReadOnlySpan
+	for _, s := range spans {
+		switch s.Name() {
+		case "POST transfer":
+			server = s
+		case "store.TransferIf":
+			transfer = s
+		}
+	}
+	if server == nil || transfer == nil {
+		t.Fatalf("expected a server and a store span, got %d spans", len(spans))
+	}
+	if got := server.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("expected the caller's trace %s, got %s", traceID, got)
+	}
+	if transfer.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Error("expected the store span to be a child of the request's")
+	}
+
+	var line map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatalf("expected one JSON log line, got %q: %v", logs, err)
+	}
+	for k, want := range map[string]any{"msg": "request", "request_id": "req-42", "handler": "transfer", "status": float64(200), "trace_id": traceID} {
+		if line[k] != want {
+			t.Errorf("expected %s=%v in the access log, got %v", k, want, line[k])
+		}
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	captureTelemetry(t)
+	h := observe(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestID(r.Context()) == "" {
+			t.Error("expected the handler to see the request id")
+		}
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	for _, incoming := range []string{"", "has spaces", strings.Repeat("x", 200)} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(requestIDHeader, incoming)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if id := w.Header().Get(requestIDHeader); len(id) != 32 {
+			t.Errorf("%q: expected a fresh 32 character id, got %q", incoming, id)
+		}
+		if w.Code != http.StatusTeapot {
+			t.Errorf("expected the handler's status, got %d", w.Code)
+		}
+	}
+}
+
+// the rates provider and webhook receivers get our trace passed on
+func TestOutgoingCallsCarryTrace(t *testing.T) {
+	captureTelemetry(t)
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	store.Create(Account{ID: "alice-eur", Currency: "EUR"})
+	resetLedger()
+
+	seen := make(chan string, 2)
+	ratesSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Get("traceparent")
+		w.Write([]byte(`{"rate": 0.5}`))
+	}))
+	defer ratesSrv.Close()
+	rates = newHTTPRates(ratesSrv.URL)
+	defer func() { rates = staticRates{} }()
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Get("traceparent")
+	}))
+	defer receiver.Close()
+	register(t, receiver.URL, eventTransferCompleted)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	h := observe(newRouter())
+	for _, call := range []struct{ path, body string }{
+		{"/v1/convert", `{"from":"alice","to":"alice-eur","amount":10}`},
+		{"/v1/transfer", `{"from":"alice","to":"bob","amount":10}`},
+	} {
+		req := httptest.NewRequest("POST", call.path, strings.NewReader(call.body))
+		req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d %s", call.path, w.Code, w.Body)
+		}
+		select {
+		case tp := <-seen:
+			if !strings.HasPrefix(tp, "00-"+traceID+"-") {
+				t.Errorf("%s: expected the outgoing call in trace %s, got traceparent %q", call.path, traceID, tp)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no outgoing call arrived", call.path)
+		}
+	}
+}
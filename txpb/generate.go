@@ -0,0 +1,5 @@
+// Package txpb holds the protobuf schema of the gRPC API and the code
+// generated from it.
+package txpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative transaction.proto
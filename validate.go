@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+)
+
+// what every transfer request is checked for before any money moves,
+// each mistake with a code of its own:
+//
+//	INVALID_REQUEST       from or to is missing
+//	INVALID_ACCOUNT_ID    from or to isn't an account id, or is reserved
+//	SAME_ACCOUNT          from and to are the same account
+//	INVALID_AMOUNT        the amount isn't positive
+//	AMOUNT_BELOW_MINIMUM  the amount is under MIN_TRANSFER_AMOUNT
+//	RECIPIENT_NOT_FOUND   to doesn't exist
+//
+// the request's shape is checked, then whether the caller may send from
+// the account, then the recipient, so only callers allowed to send learn
+// which accounts exist. MAX_TRANSFER_AMOUNT is checked with the daily
+// limit once the transfer runs (see reserveLimit)
+
+// account ids are 1 to 64 letters, digits, '.', '_' or '-', starting
+// with a letter or digit
+var accountIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// fails unless id may name an account of the API
+func checkAccountID(id string) error {
+	if prefix, ok := reservedPrefix(id); ok {
+		return &serviceError{
+			code:    codeInvalidAccountID,
+			message: "ids starting with " + prefix + " are reserved",
+			details: map[string]any{"account": id},
+		}
+	}
+	if !accountIDPattern.MatchString(id) {
+		return &serviceError{
+			code:    codeInvalidAccountID,
+			message: "account ids are 1 to 64 letters, digits, '.', '_' or '-', starting with a letter or digit",
+			details: map[string]any{"account": id},
+		}
+	}
+	return nil
+}
+
+// the checks of a transfer from from to to that need nothing but the
+// request
+func validateTransfer(from, to string, amount Money) error {
+	if from == "" || to == "" {
+		return failure(codeInvalidRequest, "from and to are required")
+	}
+	for _, id := range []string{from, to} {
+		if err := checkAccountID(id); err != nil {
+			return err
+		}
+	}
+	if from == to {
+		return &serviceError{
+			code:    codeSameAccount,
+			message: "from and to are the same account",
+			details: map[string]any{"account": from},
+		}
+	}
+	if amount <= 0 {
+		return failure(codeInvalidAmount, "amount must be positive")
+	}
+	if minTransferAmount > 0 && amount < minTransferAmount {
+		return &serviceError{
+			code:    codeAmountBelowMinimum,
+			message: "amount is under the minimum transfer",
+			details: map[string]any{"minimum": minTransferAmount},
+		}
+	}
+	return nil
+}
+
+// fails unless account exists to be paid, after checkCredit's checks.
+// transfers used to open recipients they didn't find, a typo sent money
+// to an account nobody knew
+func checkRecipient(ctx context.Context, account string) error {
+	if err := checkCredit(ctx, account); err != nil {
+		return err
+	}
+	_, err := storeFor(ctx).Get(account)
+	if errors.Is(err, ErrAccountNotFound) {
+		return &serviceError{
+			code:    codeRecipientNotFound,
+			message: "recipient account not found",
+			details: map[string]any{"account": account},
+		}
+	}
+	if err != nil {
+		return failure(codeInternal, "could not read the recipient")
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckAccountID(t *testing.T) {
+	for id, ok := range map[string]bool{
+		"alice":                 true,
+		"acct-42":               true,
+		"Bob_2.savings":         true,
+		strings.Repeat("a", 64): true,
+		"":                      false,
+		strings.Repeat("a", 65): false,
+		"-alice":                false,
+		"a/b":                   false,
+		"john smith":            false,
+		"cash:USD":              false,
+		"ålice":                 false,
+	} {
+		if err := checkAccountID(id); (err == nil) != ok {
+			t.Errorf("%q: expected valid %v, got %v", id, ok, err)
+		}
+	}
+}
+
+func TestTransferValidation(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	resetLedger()
+	minTransferAmount = units(1)
+	defer func() { minTransferAmount = 0 }()
+
+	for _, tt := range []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{`{"from":"alice","to":"alice","amount":5}`, http.StatusUnprocessableEntity, codeSameAccount},
+		{`{"from":"alice","to":"carol","amount":5}`, http.StatusUnprocessableEntity, codeRecipientNotFound},
+		{`{"from":"alice","to":"bob","amount":0.5}`, http.StatusUnprocessableEntity, codeAmountBelowMinimum},
+		{`{"from":"alice","to":"bob smith","amount":5}`, http.StatusBadRequest, codeInvalidAccountID},
+		{`{"from":"alice","to":"cash:USD","amount":5}`, http.StatusBadRequest, codeInvalidAccountID},
+	} {
+		w := httptest.NewRecorder()
+		serveAPI(w, httptest.NewRequest("POST", "/v1/transfer", strings.NewReader(tt.body)))
+		if w.Code != tt.status || decodeError(t, w).Code != tt.code {
+			t.Errorf("%s: expected %d %s, got %d %s", tt.body, tt.status, tt.code, w.Code, w.Body)
+		}
+	}
+	if _, err := store.Get("carol"); err == nil {
+		t.Error("expected carol not to be opened by the refused transfer")
+	}
+	if a, _ := store.Get("alice"); a.Balance != units(100) {
+		t.Errorf("expected no money to move, alice has %v", a.Balance)
+	}
+	// the smallest allowed transfer still goes through
+	transferID(t, `{"from":"alice","to":"bob","amount":1}`)
+
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/transfers/batch", strings.NewReader(
+		`{"transfers":[{"from":"alice","to":"bob","amount":1},{"from":"bob","to":"dave","amount":1}]}`)))
+	if w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeRecipientNotFound {
+		t.Errorf("expected the batch refused for its unknown recipient, got %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/holds", strings.NewReader(`{"from":"alice","to":"alice","amount":1}`)))
+	if w.Code != http.StatusUnprocessableEntity || decodeError(t, w).Code != codeSameAccount {
+		t.Errorf("expected a hold to oneself refused, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestStatusReason(t *testing.T) {
+	for err, want := range map[error]string{
+		frozen("bob"):                 reasonAccountFrozen,
+		closed("bob"):                 reasonAccountClosed,
+		failure(codeInternal, "boom"): reasonInternal,
+		failure(codeRecipientNotFound, "no such"):     reasonInvalidRequest,
+		failure(codeAmountBelowMinimum, "too little"): reasonInvalidRequest,
+	} {
+		if got := statusReason(err); got != want {
+			t.Errorf("%v: expected %s, got %s", err, want, got)
+		}
+	}
+}
+
+func TestCreateAccountIDSchema(t *testing.T) {
+	store = newMemoryStore(nil)
+	resetLedger()
+	// the served schema refuses what checkAccountID refuses
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/accounts", strings.NewReader(`{"id":"-alice"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected -alice refused, got %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/accounts", strings.NewReader(`{"id":"carol.savings"}`)))
+	if w.Code != http.StatusCreated {
+		t.Errorf("expected carol.savings opened, got %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// events a webhook can subscribe to
+const (
+	eventTransferCompleted = "transfer.completed"
+	eventTransferFailed    = "transfer.failed"
+)
+
+// how deliveries are retried, a failed attempt waits webhookBackoff and
+// every further one twice as long as the one before
+var (
+	webhookAttempts = 5
+	webhookBackoff  = time.Second
+	webhookClient   = &http.Client{Timeout: 5 * time.Second}
+)
+
+// a registered receiver. the secret signs every delivery, the receiver
+// checks it the same way we check X-Signature on /callback. it only
+// hears about transfers of the tenant it was registered in
+type webhook struct {
+	ID     string   `json:"id"`
+	Tenant string   `json:"tenant,omitempty"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+// registered webhooks by id, kept in memory so they have to be
+// registered again after a restart
+var (
+	webhooksMu sync.Mutex
+	webhooks   = map[string]webhook{}
+)
+
+// models the JSON body for POST /webhooks, events defaults to all of them
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+}
+
+// what a receiver gets POSTed
+type webhookPayload struct {
+	Event       string      `json:"event"`
+	Transaction ledgerEntry `json:"transaction"`
+}
+
+// handles DELETE /webhooks/{id}, admin only
+func deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r.Context()) {
+		forbidden(w)
+		return
+	}
+	id := r.PathValue("id")
+	webhooksMu.Lock()
+	h, ok := webhooks[id]
+	ok = ok && h.Tenant == tenantOf(r.Context())
+	if ok {
+		delete(webhooks, id)
+	}
+	webhooksMu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "webhook not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handles GET /webhooks, admin only. lists the registered webhooks
+// ordered by id, secrets left out
+func listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r.Context()) {
+		forbidden(w)
+		return
+	}
+	webhooksMu.Lock()
+	list := make([]webhook, 0, len(webhooks))
+	for _, h := range webhooks {
+		if h.Tenant != tenantOf(r.Context()) {
+			continue
+		}
+		h.Secret = ""
+		list = append(list, h)
+	}
+	webhooksMu.Unlock()
+	slices.SortFunc(list, func(a, b webhook) int { return strings.Compare(a.ID, b.ID) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]webhook{"webhooks": list})
+}
+
+// handles POST /webhooks registering a receiver, admin only. the
+// response is the only time its secret is shown
+func registerWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r.Context()) {
+		forbidden(w)
+		return
+	}
+	var req webhookRequest
+	if !decodeBody(w, r, "WebhookRequest", &req) {
+		return
+	}
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "url must be an absolute http or https URL")
+		return
+	}
+	if len(req.Events) == 0 {
+		req.Events = []string{eventTransferCompleted, eventTransferFailed}
+	}
+	for _, ev := range req.Events {
+		if ev != eventTransferCompleted && ev != eventTransferFailed {
+			writeError(w, http.StatusBadRequest, codeUnsupportedEvent, "unsupported event "+strconv.Quote(ev))
+			return
+		}
+	}
+
+	h := webhook{ID: randomHex(8), Tenant: tenantOf(r.Context()), URL: req.URL, Events: req.Events, Secret: randomHex(32)}
+	webhooksMu.Lock()
+	webhooks[h.ID] = h
+	webhooksMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(h)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sends e to every webhook subscribed to it, in the background so the
+// request that moved the money doesn't wait for receivers. only
+// transfers between two accounts are announced, not deposits, and only
+// once they are done rather than while an async one waits. the
+// deliveries stay in ctx's trace but outlive its cancellation. e is as
+// the ledger keeps it, only webhooks of its tenant hear about it
+func notifyWebhooks(ctx context.Context, e ledgerEntry) {
+	if e.From == "" || e.To == "" || e.Status == statusPending || e.Status == statusProcessing || e.Status == statusPendingReview {
+		return
+	}
+	event := eventTransferCompleted
+	if e.Status != statusCompleted {
+		event = eventTransferFailed
+	}
+	tenant := e.tenant()
+	body, err := json.Marshal(webhookPayload{Event: event, Transaction: e.local()})
+	if err != nil {
+		return
+	}
+
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+	for _, h := range webhooks {
+		if h.Tenant == tenant && slices.Contains(h.Events, event) {
+			go deliver(context.WithoutCancel(ctx), h, event, body)
+		}
+	}
+}
+
+// POSTs body to h until it answers 2xx, backing off between attempts
+func deliver(ctx context.Context, h webhook, event string, body []byte) {
+	sig := "sha256=" + hex.EncodeToString(sign([]byte(h.Secret), body))
+	wait := webhookBackoff
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		err := postWebhook(ctx, h.URL, event, sig, body)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			log.Printf("webhook %s: giving up after %d attempts: %v", h.ID, attempt, err)
+			return
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// makes one delivery attempt, carrying traceparent like any outgoing call
+func postWebhook(ctx context.Context, target, event, sig string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set(signatureHeader, sig)
+	req, end := traceOutgoing(req, "webhook "+event)
+	resp, err := webhookClient.Do(req)
+	end(resp, err)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return nil
+}
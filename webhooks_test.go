@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// registers url for events through the handler and returns the webhook
+func register(t *testing.T, url string, events ...string) webhook {
+	t.Helper()
+	body, _ := json.Marshal(webhookRequest{URL: url, Events: events})
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("POST", "/v1/webhooks", strings.NewReader(string(body))))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body)
+	}
+	var h webhook
+	json.NewDecoder(w.Body).Decode(&h)
+	t.Cleanup(func() {
+		webhooksMu.Lock()
+		delete(webhooks, h.ID)
+		webhooksMu.Unlock()
+	})
+	return h
+}
+
+func TestWebhookDeliveredSignedWithRetry(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(100), "bob": 0})
+	defer func(d time.Duration) { webhookBackoff = d }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+
+	type delivery struct {
+		event, sig string
+		body       []byte
+	}
+	got := make(chan delivery, 1)
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first attempt fails so the delivery has to be retried
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		got <- delivery{r.Header.Get("X-Webhook-Event"), r.Header.Get(signatureHeader), body}
+	}))
+	defer receiver.Close()
+	h := register(t, receiver.URL, eventTransferCompleted)
+
+	w := httptest.NewRecorder()
+	transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":10}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("transfer failed: %d %s", w.Code, w.Body)
+	}
+
+	select {
+	case d := <-got:
+		if d.event != eventTransferCompleted || !validSignature([]byte(h.Secret), d.body, d.sig) {
+			t.Errorf("expected signed %s, got %q sig %q", eventTransferCompleted, d.event, d.sig)
+		}
+		var p webhookPayload
+		if err := json.Unmarshal(d.body, &p); err != nil || p.Transaction.From != "alice" || p.Transaction.Amount != units(10) {
+			t.Errorf("unexpected payload %s", d.body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook was never delivered")
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected 2 attempts, got %d", n)
+	}
+}
+
+func TestWebhookOnlyGetsSubscribedEvents(t *testing.T) {
+	store = newMemoryStore(map[string]Money{"alice": units(5), "bob": 0})
+	got := make(chan string, 2)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get("X-Webhook-Event")
+	}))
+	defer receiver.Close()
+	register(t, receiver.URL, eventTransferFailed)
+
+	for _, amount := range []string{"1", "50"} {
+		w := httptest.NewRecorder()
+		transferHandler(w, httptest.NewRequest("POST", "/transfer", strings.NewReader(`{"from":"alice","to":"bob","amount":`+amount+`}`)))
+	}
+	select {
+	case ev := <-got:
+		if ev != eventTransferFailed {
+			t.Errorf("expected only %s, got %s", eventTransferFailed, ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook was never delivered")
+	}
+	select {
+	case ev := <-got:
+		t.Errorf("unexpected second delivery %s", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRegisterWebhookValidation(t *testing.T) {
+	for _, body := range []string{
+		`{"url":"ftp://example.com"}`,
+		`{"url":"/relative"}`,
+		`{"url":"https://example.com","events":["account.opened"]}`,
+	} {
+		w := httptest.NewRecorder()
+		serveAPI(w, httptest.NewRequest("POST", "/v1/webhooks", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+
+	h := register(t, "https://example.com/hook")
+	if h.Secret == "" || len(h.Events) != 2 {
+		t.Errorf("expected a secret and both events by default, got %+v", h)
+	}
+	w := httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("GET", "/v1/webhooks", nil))
+	if strings.Contains(w.Body.String(), h.Secret) {
+		t.Error("secret must not be listed")
+	}
+	w = httptest.NewRecorder()
+	serveAPI(w, httptest.NewRequest("DELETE", "/v1/webhooks/"+h.ID, nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", w.Code)
+	}
+}